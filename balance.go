@@ -0,0 +1,69 @@
+package semseg
+
+// balancedCuts chooses where Options.Balanced splits sentences that the token or byte limit
+// forces apart. Semantic boundaries and oversized sentences divide the text into runs; a run
+// that does not fit into one chunk is partitioned into the fewest chunks within MaxTokens
+// and MaxChunkBytes (as many as the greedy fill produces), and among those partitions into
+// the one with the smallest sum of squared chunk token counts, i.e. the most even sizes.
+// The result maps the index of the last sentence before each cut to true, like
+// boundaryIndices.
+func balancedCuts(sentences []string, tokenCounts []int, boundaryIndices map[int]bool, opts Options) map[int]bool {
+	cuts := make(map[int]bool)
+	oversized := func(i int) bool {
+		return tokenCounts[i] > opts.MaxTokens || (opts.MaxChunkBytes > 0 && len(sentences[i]) > opts.MaxChunkBytes)
+	}
+	start := 0
+	for i := range sentences {
+		if oversized(i) {
+			balanceRun(sentences, tokenCounts, start, i, opts, cuts)
+			start = i + 1
+			continue
+		}
+		if boundaryIndices[i] || i == len(sentences)-1 {
+			balanceRun(sentences, tokenCounts, start, i+1, opts, cuts)
+			start = i + 1
+		}
+	}
+	return cuts
+}
+
+// balanceRun partitions sentences[start:end] as described for balancedCuts and records the
+// cuts between its chunks.
+func balanceRun(sentences []string, tokenCounts []int, start, end int, opts Options, cuts map[int]bool) {
+	n := end - start
+	if n <= 1 {
+		return
+	}
+	// best[j] is the optimal partition of the first j sentences of the run: its chunk count
+	// and sum of squared chunk sizes, with from[j] the first sentence of its last chunk.
+	type cost struct{ chunks, squares int }
+	best := make([]cost, n+1)
+	from := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = cost{chunks: -1}
+		tokens, bytes := 0, 0
+		for s := j - 1; s >= 0; s-- {
+			sentence := sentences[start+s]
+			tokens += tokenCounts[start+s]
+			bytes += len(sentence)
+			if s < j-1 {
+				bytes += separatorBytes(sentence, sentences[start+s+1], opts.ChunkJoiner)
+			}
+			if tokens > opts.MaxTokens || (opts.MaxChunkBytes > 0 && bytes > opts.MaxChunkBytes) {
+				break
+			}
+			if best[s].chunks < 0 {
+				continue
+			}
+			c := cost{best[s].chunks + 1, best[s].squares + tokens*tokens}
+			if best[j].chunks < 0 || c.chunks < best[j].chunks || (c.chunks == best[j].chunks && c.squares < best[j].squares) {
+				best[j], from[j] = c, s
+			}
+		}
+	}
+	for j := n; j > 0; j = from[j] {
+		if from[j] > 0 {
+			cuts[start+from[j]-1] = true
+		}
+	}
+}
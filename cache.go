@@ -3,18 +3,143 @@
 package semseg
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"math"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
 
+	"github.com/cmsdko/semseg/internal/text"
 	"github.com/cmsdko/semseg/internal/tfidf"
 )
 
+// Character n-gram sizes used for cache keys, by script and language.
+const (
+	cacheKeyMinNgram = 3 // Latin, Cyrillic and other alphabets
+	cacheKeyMaxNgram = 5
+
+	cacheKeyMinNgramCJK = 2 // Chinese, Japanese and Korean, where one character carries meaning
+	cacheKeyMaxNgramCJK = 3
+
+	cacheKeyMinNgramAgglutinative = 4 // long words built from many affixes
+	cacheKeyMaxNgramAgglutinative = 6
+)
+
+// agglutinativeLanguages are the languages (as in Options.Language) whose cache keys use
+// longer n-grams, since their words chain affixes and short n-grams mostly match those.
+var agglutinativeLanguages = map[string]bool{
+	"basque": true, "estonian": true, "finnish": true, "hungarian": true, "turkish": true,
+}
+
+// VectorizeForCache builds the cache key for a text: the normalized frequencies of its
+// character n-grams, of 2 to 3 characters for text mostly written in CJK scripts (Han,
+// Hiragana, Katakana, Hangul) and of 3 to 5 characters otherwise. Keys depend only on the
+// text itself, not on the batch it was seen in, so a key built here matches the keys
+// Segment uses for the same sentence unless Options.Language or CacheKeyMinNgram change
+// the n-gram range.
+//
+// Together with an EmbeddingCache such as InMemoryCache, it can be used on its own as a
+// near-duplicate text store: Set(VectorizeForCache(text), value, threshold) and
+// Find(VectorizeForCache(query), threshold).
+func VectorizeForCache(s string) map[string]float64 {
+	return cacheKey(s, Options{})
+}
+
+// cacheKey builds the cache key of s for opts: the n-gram frequencies of VectorizeForCache,
+// with the range of cacheKeyNgramRange, filtered by opts.CacheKeyFilter.
+func cacheKey(s string, opts Options) map[string]float64 {
+	minN, maxN := cacheKeyNgramRange(s, opts)
+	return opts.CacheKeyFilter.filter(tfidf.TermFrequencies(text.GenerateCharNgrams(s, opts.Language, minN, maxN)))
+}
+
+// cacheKeyNgramRange returns the n-gram sizes of the cache key of s: CacheKeyMinNgram and
+// CacheKeyMaxNgram if set, otherwise the CJK range for text mostly written in CJK scripts,
+// the agglutinative range if Options.Language names such a language, and the default range.
+func cacheKeyNgramRange(s string, opts Options) (int, int) {
+	switch {
+	case opts.CacheKeyMinNgram > 0:
+		return opts.CacheKeyMinNgram, opts.CacheKeyMaxNgram
+	case mostlyCJK(s):
+		return cacheKeyMinNgramCJK, cacheKeyMaxNgramCJK
+	case agglutinativeLanguages[opts.Language]:
+		return cacheKeyMinNgramAgglutinative, cacheKeyMaxNgramAgglutinative
+	default:
+		return cacheKeyMinNgram, cacheKeyMaxNgram
+	}
+}
+
+// mostlyCJK reports whether at least half of the letters of s belong to CJK scripts.
+func mostlyCJK(s string) bool {
+	letters, cjk := 0, 0
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if isUnspacedScript(r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		}
+	}
+	return cjk > 0 && 2*cjk >= letters
+}
+
+// CacheKeyFilter removes n-grams from cache keys by their document frequency (the fraction
+// of texts containing them) in a reference corpus. It is safe for concurrent use.
+type CacheKeyFilter struct {
+	docFreq      map[string]int
+	numDocs      int
+	minDF, maxDF float64
+}
+
+// NewCacheKeyFilter counts the cache-key n-grams of every text in corpus, a sample of the
+// texts to be cached. Keys built with the filter drop the n-grams found in more than maxDF
+// of the corpus texts (ubiquitous n-grams such as "the" or "ing", which inflate the
+// similarity of unrelated texts and cause false hits) and those found in fewer than minDF
+// of them (n-grams too rare to be informative; note that this drops every n-gram absent
+// from the corpus, so it needs a large, representative one). Both are fractions in the
+// range 0.0 to 1.0, and 0 disables the bound.
+func NewCacheKeyFilter(corpus []string, minDF, maxDF float64) *CacheKeyFilter {
+	docFreq := make(map[string]int)
+	for _, s := range corpus {
+		for ngram := range VectorizeForCache(s) {
+			docFreq[ngram]++
+		}
+	}
+	return &CacheKeyFilter{docFreq: docFreq, numDocs: len(corpus), minDF: minDF, maxDF: maxDF}
+}
+
+// Vectorize builds the cache key of s like VectorizeForCache, without the n-grams removed
+// by the filter. A nil filter removes nothing.
+func (f *CacheKeyFilter) Vectorize(s string) map[string]float64 {
+	return f.filter(VectorizeForCache(s))
+}
+
+// filter removes the n-grams outside the document frequency bounds from key and returns it.
+// A nil filter returns key unchanged.
+func (f *CacheKeyFilter) filter(key map[string]float64) map[string]float64 {
+	if f == nil || f.numDocs == 0 {
+		return key
+	}
+	for ngram := range key {
+		df := float64(f.docFreq[ngram]) / float64(f.numDocs)
+		if (f.maxDF > 0 && df > f.maxDF) || (f.minDF > 0 && df < f.minDF) {
+			delete(key, ngram)
+		}
+	}
+	return key
+}
+
 // EmbeddingCache defines the interface for a semantic cache.
 type EmbeddingCache interface {
-	Find(key map[string]float64, threshold float64) (embedding []float64, found bool)
+	// Find returns the embedding stored under the most similar key reaching threshold.
+	// found is false on a miss; err is non-nil when the lookup itself failed (e.g. a remote
+	// cache is unreachable), which Options.CacheErrorPolicy handles.
+	Find(key map[string]float64, threshold float64) (embedding []float64, found bool, err error)
 	Set(key map[string]float64, embedding []float64, similarityThreshold float64) // Добавили threshold для инкрементального анализа
 	AnalyzeSimilarity(threshold float64) int
 	Close()
@@ -27,6 +152,57 @@ type AdaptiveCacheManager interface {
 	Start(similarityThreshold float64, activationThreshold int)
 	IsActivated() bool
 	QueueSet(key map[string]float64, embedding []float64)
+	Metrics() AdaptiveCacheMetrics
+}
+
+// Constants for AdaptiveCacheOptions.OverflowPolicy: what QueueSet does when the
+// population queue is full.
+const (
+	// OverflowDropNewest discards the entry being queued. QueueSet never waits. This is the default.
+	OverflowDropNewest = "drop_newest"
+	// OverflowDropOldest discards the oldest queued entry to make room (ring buffer), so the
+	// cache is populated with the most recent entries. QueueSet never waits.
+	OverflowDropOldest = "drop_oldest"
+	// OverflowBlock waits up to BlockTimeout for room in the queue and drops the entry only
+	// if none frees up, trading latency for completeness.
+	OverflowBlock = "block"
+)
+
+const (
+	defaultAdaptiveQueueSize    = 1024
+	defaultAdaptiveBlockTimeout = 50 * time.Millisecond
+	defaultAdaptiveWriters      = 1
+)
+
+// AdaptiveCacheOptions configures the population queue of an adaptive cache manager.
+type AdaptiveCacheOptions struct {
+	// QueueSize is the capacity of the asynchronous population queue. Default: 1024.
+	QueueSize int
+
+	// OverflowPolicy selects the behavior when the queue is full: OverflowDropNewest,
+	// OverflowDropOldest or OverflowBlock. Default: OverflowDropNewest.
+	OverflowPolicy string
+
+	// BlockTimeout is the longest QueueSet waits for room under OverflowBlock. Default: 50ms.
+	BlockTimeout time.Duration
+
+	// Writers is the number of goroutines draining the queue into the cache. More writers
+	// absorb a higher insert rate with fewer dropped entries when cache writes are slow; the
+	// cache's Set must be safe for concurrent use (InMemoryCache's is). Default: 1.
+	Writers int
+}
+
+// AdaptiveCacheMetrics is a snapshot of an adaptive cache manager's population queue.
+type AdaptiveCacheMetrics struct {
+	OverflowPolicy string
+	QueueLength    int
+	QueueCapacity  int
+	Writers        int
+	// Enqueued counts entries accepted into the queue; Dropped counts entries discarded
+	// because the queue was full (the new entry, or an evicted old one under OverflowDropOldest).
+	Enqueued  uint64
+	Dropped   uint64
+	Activated bool
 }
 
 type adaptiveCacheEntry struct {
@@ -37,27 +213,68 @@ type adaptiveCacheEntry struct {
 type adaptiveCacheManager struct {
 	cache               EmbeddingCache
 	isActivated         atomic.Bool
+	started             atomic.Bool
 	startOnce           sync.Once
 	setQueue            chan adaptiveCacheEntry
 	tickerStop          chan struct{}
 	activationThreshold int
 	similarityThreshold float64
+
+	overflowPolicy string
+	blockTimeout   time.Duration
+	writers        int
+	enqueued       atomic.Uint64
+	dropped        atomic.Uint64
+
+	// closeMu guards setQueue against sends after Close: population goroutines started by
+	// Segment may still be queueing entries when the manager is closed.
+	closeMu sync.RWMutex
+	closed  bool
 }
 
 func NewAdaptiveCacheManager(cache EmbeddingCache) AdaptiveCacheManager {
+	return NewAdaptiveCacheManagerWithOptions(cache, AdaptiveCacheOptions{})
+}
+
+// NewAdaptiveCacheManagerWithOptions creates an adaptive cache manager whose population
+// queue is configured by opts.
+func NewAdaptiveCacheManagerWithOptions(cache EmbeddingCache, opts AdaptiveCacheOptions) AdaptiveCacheManager {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultAdaptiveQueueSize
+	}
+	if opts.OverflowPolicy == "" {
+		opts.OverflowPolicy = OverflowDropNewest
+	}
+	if opts.BlockTimeout <= 0 {
+		opts.BlockTimeout = defaultAdaptiveBlockTimeout
+	}
+	if opts.Writers <= 0 {
+		opts.Writers = defaultAdaptiveWriters
+	}
 	return &adaptiveCacheManager{
-		cache:      cache,
-		setQueue:   make(chan adaptiveCacheEntry, 1024),
-		tickerStop: make(chan struct{}),
+		cache:          cache,
+		setQueue:       make(chan adaptiveCacheEntry, opts.QueueSize),
+		tickerStop:     make(chan struct{}),
+		overflowPolicy: opts.OverflowPolicy,
+		blockTimeout:   opts.BlockTimeout,
+		writers:        opts.Writers,
 	}
 }
 
 func (m *adaptiveCacheManager) Start(similarityThreshold float64, activationThreshold int) {
 	m.startOnce.Do(func() {
 		log.Println("Starting adaptive cache manager...")
+		if m.isActivated.Load() && (similarityThreshold != m.similarityThreshold || activationThreshold != m.activationThreshold) {
+			// A restored activation only holds for the thresholds it was reached with.
+			log.Println("Restored adaptive cache activation used other thresholds, deactivating.")
+			m.isActivated.Store(false)
+		}
 		m.similarityThreshold = similarityThreshold
 		m.activationThreshold = activationThreshold
-		go m.asyncWriter()
+		m.started.Store(true)
+		for i := 0; i < m.writers; i++ {
+			go m.asyncWriter()
+		}
 		go m.activationTicker()
 	})
 }
@@ -67,14 +284,63 @@ func (m *adaptiveCacheManager) IsActivated() bool {
 }
 
 func (m *adaptiveCacheManager) QueueSet(key map[string]float64, embedding []float64) {
+	m.closeMu.RLock()
+	defer m.closeMu.RUnlock()
+	if m.closed {
+		return // Entries queued after Close are discarded.
+	}
+
+	entry := adaptiveCacheEntry{key: key, embedding: embedding}
 	select {
-	case m.setQueue <- adaptiveCacheEntry{key: key, embedding: embedding}:
+	case m.setQueue <- entry:
+		m.enqueued.Add(1)
+		return
 	default:
-		log.Println("Adaptive cache queue is full, dropping entry.")
+	}
+
+	switch m.overflowPolicy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case m.setQueue <- entry:
+				m.enqueued.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-m.setQueue:
+				m.dropped.Add(1)
+			default:
+			}
+		}
+	case OverflowBlock:
+		timer := time.NewTimer(m.blockTimeout)
+		defer timer.Stop()
+		select {
+		case m.setQueue <- entry:
+			m.enqueued.Add(1)
+			return
+		case <-timer.C:
+		}
+	}
+	m.dropped.Add(1)
+	log.Println("Adaptive cache queue is full, dropping entry.")
+}
+
+// Metrics returns a snapshot of the population queue state and counters.
+func (m *adaptiveCacheManager) Metrics() AdaptiveCacheMetrics {
+	return AdaptiveCacheMetrics{
+		OverflowPolicy: m.overflowPolicy,
+		QueueLength:    len(m.setQueue),
+		QueueCapacity:  cap(m.setQueue),
+		Writers:        m.writers,
+		Enqueued:       m.enqueued.Load(),
+		Dropped:        m.dropped.Load(),
+		Activated:      m.IsActivated(),
 	}
 }
 
-func (m *adaptiveCacheManager) Find(key map[string]float64, threshold float64) ([]float64, bool) {
+func (m *adaptiveCacheManager) Find(key map[string]float64, threshold float64) ([]float64, bool, error) {
 	return m.cache.Find(key, threshold)
 }
 
@@ -91,7 +357,10 @@ func (m *adaptiveCacheManager) AnalyzeSimilarity(threshold float64) int {
 func (m *adaptiveCacheManager) Close() {
 	m.cache.Close()
 	m.startOnce.Do(func() {})
+	m.closeMu.Lock()
+	m.closed = true
 	close(m.setQueue)
+	m.closeMu.Unlock()
 	select {
 	case <-m.tickerStop:
 	default:
@@ -99,6 +368,53 @@ func (m *adaptiveCacheManager) Close() {
 	}
 }
 
+// AdaptiveCacheState is the activation state of an adaptive cache manager, saved with
+// SaveAdaptiveCacheState next to the cache contents so a warmed cache does not have to
+// re-learn its activation after a restart.
+type AdaptiveCacheState struct {
+	Activated           bool    `json:"activated"`
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	ActivationThreshold int     `json:"activation_threshold"`
+}
+
+// SaveAdaptiveCacheState writes the activation state and thresholds of m as JSON to w. m
+// must have been created by NewAdaptiveCacheManager or NewAdaptiveCacheManagerWithOptions.
+func SaveAdaptiveCacheState(m AdaptiveCacheManager, w io.Writer) error {
+	manager, ok := m.(*adaptiveCacheManager)
+	if !ok {
+		return errors.New("SaveAdaptiveCacheState requires a manager created by NewAdaptiveCacheManager")
+	}
+	state := AdaptiveCacheState{Activated: manager.IsActivated()}
+	if manager.started.Load() {
+		state.SimilarityThreshold = manager.similarityThreshold
+		state.ActivationThreshold = manager.activationThreshold
+	}
+	return json.NewEncoder(w).Encode(state)
+}
+
+// LoadAdaptiveCacheState restores a state written by SaveAdaptiveCacheState into m, which
+// must not have been started yet (i.e. not used by any segmentation). An activated state
+// makes m resume in 'force' mode as soon as it is started, provided it is started with the
+// same thresholds (CacheSimilarityThreshold and AdaptiveCacheActivationThreshold);
+// otherwise the activation is discarded and re-learned.
+func LoadAdaptiveCacheState(m AdaptiveCacheManager, r io.Reader) error {
+	manager, ok := m.(*adaptiveCacheManager)
+	if !ok {
+		return errors.New("LoadAdaptiveCacheState requires a manager created by NewAdaptiveCacheManager")
+	}
+	if manager.started.Load() {
+		return errors.New("adaptive cache state must be loaded before the manager is started")
+	}
+	var state AdaptiveCacheState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	manager.similarityThreshold = state.SimilarityThreshold
+	manager.activationThreshold = state.ActivationThreshold
+	manager.isActivated.Store(state.Activated)
+	return nil
+}
+
 func (m *adaptiveCacheManager) asyncWriter() {
 	for entry := range m.setQueue {
 		// Передаем threshold в Set для инкрементального анализа
@@ -154,16 +470,200 @@ type l1Segment struct {
 	index   map[string][]int
 }
 
+// SimilarityFunc scores how similar two sparse vectors are, in the range 0.0 to 1.0: cache
+// keys (n-gram vectors), where a cache hit requires a score at or above the lookup
+// threshold, or TF-IDF sentence vectors (see Options.TFIDFSimilarity).
+type SimilarityFunc func(a, b map[string]float64) float64
+
+// CosineSimilarity is the default cache SimilarityFunc: the cosine of the angle between
+// the two weighted n-gram vectors.
+func CosineSimilarity(a, b map[string]float64) float64 {
+	return tfidf.CosineSimilarity(a, b)
+}
+
+// JaccardSimilarity is a SimilarityFunc that ignores weights and compares the sets of
+// n-grams present in both keys (|A ∩ B| / |A ∪ B|). It is cheaper than cosine and often
+// good enough for matching cache keys.
+func JaccardSimilarity(a, b map[string]float64) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	intersection := 0
+	for term := range a {
+		if _, ok := b[term]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// JensenShannonSimilarity is a SimilarityFunc that treats both vectors as probability
+// distributions over their terms (each normalized to sum to 1; negative weights count as 0)
+// and returns 1 - JSD, where JSD is their Jensen-Shannon divergence in bits, which lies
+// between 0 and 1. Identical distributions score 1, vectors without a common term score 0,
+// and an empty vector scores 0. Unlike cosine, it weighs the shared share of probability
+// mass rather than the angle, which can separate topics better for short sentences with
+// few terms.
+func JensenShannonSimilarity(a, b map[string]float64) float64 {
+	sumA, sumB := positiveSum(a), positiveSum(b)
+	if sumA == 0 || sumB == 0 {
+		return 0
+	}
+	var divergence float64
+	addTerm := func(p, q float64) {
+		m := (p + q) / 2
+		if p > 0 {
+			divergence += p / 2 * math.Log2(p/m)
+		}
+		if q > 0 {
+			divergence += q / 2 * math.Log2(q/m)
+		}
+	}
+	for term, w := range a {
+		addTerm(math.Max(w, 0)/sumA, math.Max(b[term], 0)/sumB)
+	}
+	for term, w := range b {
+		if _, ok := a[term]; !ok {
+			addTerm(0, math.Max(w, 0)/sumB)
+		}
+	}
+	return math.Min(1, math.Max(0, 1-divergence))
+}
+
+// positiveSum returns the sum of the positive weights of v.
+func positiveSum(v map[string]float64) float64 {
+	var sum float64
+	for _, w := range v {
+		if w > 0 {
+			sum += w
+		}
+	}
+	return sum
+}
+
+// CachePair is a pair of texts labeled as near-duplicates (should share a cache entry) or
+// distinct (should not), used to calibrate a cache threshold.
+type CachePair struct {
+	A, B      string
+	Duplicate bool
+}
+
+// calibrationMargin is subtracted from calibrated thresholds to absorb floating-point noise.
+const calibrationMargin = 1e-9
+
+// CalibrateCacheThreshold picks a CacheSimilarityThreshold from labeled sample pairs. Every
+// pair is scored with similarity (CosineSimilarity if nil) on VectorizeForCache keys, and the
+// lowest threshold is returned at which the pairs scoring at or above it are duplicates with
+// at least targetPrecision (range 0.0 to 1.0]. The lowest such threshold keeps the most hits.
+//
+// Character n-gram scores are distributed very differently from word-level TF-IDF scores:
+// texts differing only in case or punctuation score 1.0, reordered clauses typically 0.8-0.9,
+// a single substituted word 0.6-0.75 (often a different meaning, e.g. "Monday at 10am" vs
+// "Tuesday at 3pm"), and unrelated sentences below 0.25. The default of 0.9 therefore
+// matches little beyond near-verbatim repeats; calibrating on pairs from the target domain
+// is more reliable than guessing.
+func CalibrateCacheThreshold(pairs []CachePair, targetPrecision float64, similarity SimilarityFunc) (float64, error) {
+	if targetPrecision <= 0 || targetPrecision > 1 {
+		return 0, errors.New("targetPrecision must be in the range (0.0, 1.0]")
+	}
+	if similarity == nil {
+		similarity = CosineSimilarity
+	}
+
+	type scoredPair struct {
+		score     float64
+		duplicate bool
+	}
+	scored := make([]scoredPair, len(pairs))
+	duplicates := 0
+	for i, p := range pairs {
+		scored[i] = scoredPair{similarity(VectorizeForCache(p.A), VectorizeForCache(p.B)), p.Duplicate}
+		if p.Duplicate {
+			duplicates++
+		}
+	}
+	if duplicates == 0 {
+		return 0, errors.New("calibration requires at least one duplicate pair")
+	}
+	sort.Slice(scored, func(i, j int) bool { return scored[i].score > scored[j].score })
+
+	// Lower the threshold one distinct score at a time; pairs with equal scores are all hits
+	// or all misses at any threshold, so precision is only checked after the last of them.
+	threshold, found := 0.0, false
+	hits, correct := 0, 0
+	for i, p := range scored {
+		hits++
+		if p.duplicate {
+			correct++
+		}
+		if i+1 < len(scored) && scored[i+1].score == p.score {
+			continue
+		}
+		if float64(correct)/float64(hits) >= targetPrecision {
+			threshold, found = p.score, true
+		}
+	}
+	if !found {
+		return 0, errors.New("no threshold reaches the target precision on these pairs")
+	}
+	// Cosine sums over map entries in random order, so a later score for the same pair can
+	// differ in the last bits; a small margin keeps the pair at the threshold a hit.
+	return math.Max(threshold-calibrationMargin, 0), nil
+}
+
+// Constants for CacheOptions.FindPreference.
+const (
+	// FindNewestFirst returns the first match, searching the most recently added entries
+	// first (the unindexed L0 region, then L1 segments from newest to oldest). This is the default.
+	FindNewestFirst = "newest_first"
+	// FindOldestFirst returns the first match, searching the oldest, most compacted L1
+	// segments first and the most recent L0 entries last.
+	FindOldestFirst = "oldest_first"
+	// FindBest searches all entries and returns the match with the highest similarity.
+	// It is the most accurate and the slowest option.
+	FindBest = "best"
+)
+
+// CacheOptions configures an InMemoryCache.
+type CacheOptions struct {
+	// SimilarityFunc compares cache keys in Find and in Set's neighbor check.
+	// Default: CosineSimilarity.
+	SimilarityFunc SimilarityFunc
+
+	// FindPreference selects which match Find returns when several entries qualify:
+	// FindNewestFirst, FindOldestFirst or FindBest. Any other value, including a typo, is
+	// treated as FindNewestFirst. Default: FindNewestFirst.
+	FindPreference string
+
+	// Debug enables the routine maintenance log lines (L0 flushes and L1 compactions),
+	// which are frequent under heavy writes. Default: false (quiet).
+	Debug bool
+
+	// Logger receives the log lines enabled by Debug. Default: nil (the standard logger).
+	Logger *log.Logger
+}
+
 type InMemoryCache struct {
 	mu sync.RWMutex
 
+	// maintenanceMu serializes flushes and compactions, which both rebuild l1Segments
+	// outside of mu and would otherwise lose each other's segments.
+	maintenanceMu sync.Mutex
+
 	l0Entries  []cacheEntry
 	l1Segments []*l1Segment
 
 	// Новый счетчик для инкрементального анализа
 	itemsWithNeighbors atomic.Int64
 
-	topK int
+	topK       int
+	similarity SimilarityFunc
+	preference string
+	debug      bool
+	logger     *log.Logger
 
 	flushTrigger      chan struct{}
 	compactionTrigger chan struct{}
@@ -171,10 +671,30 @@ type InMemoryCache struct {
 }
 
 func NewInMemoryCache() *InMemoryCache {
+	return NewInMemoryCacheWithOptions(CacheOptions{})
+}
+
+// NewInMemoryCacheWithOptions creates an InMemoryCache configured by opts.
+func NewInMemoryCacheWithOptions(opts CacheOptions) *InMemoryCache {
+	if opts.SimilarityFunc == nil {
+		opts.SimilarityFunc = CosineSimilarity
+	}
+	switch opts.FindPreference {
+	case FindNewestFirst, FindOldestFirst, FindBest:
+	default:
+		opts.FindPreference = FindNewestFirst
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
 	c := &InMemoryCache{
 		l0Entries:         make([]cacheEntry, 0, l0FlushThreshold),
 		l1Segments:        make([]*l1Segment, 0),
 		topK:              defaultTopK,
+		similarity:        opts.SimilarityFunc,
+		preference:        opts.FindPreference,
+		debug:             opts.Debug,
+		logger:            opts.Logger,
 		flushTrigger:      make(chan struct{}, 1),
 		compactionTrigger: make(chan struct{}, 1),
 		closeWorker:       make(chan struct{}),
@@ -187,13 +707,66 @@ func (c *InMemoryCache) Close() {
 	close(c.closeWorker)
 }
 
+// Flush synchronously moves all current L0 entries into a new indexed L1 segment.
+// Call it after warming or bulk-loading the cache so that lookups use the index
+// immediately instead of waiting for the L0 flush threshold. It is safe to call
+// concurrently with Set and Find.
+func (c *InMemoryCache) Flush() {
+	c.flushL0()
+}
+
+// inMemoryCacheFile is the JSON layout written by SaveInMemoryCache.
+type inMemoryCacheFile struct {
+	Entries []inMemoryCacheFileEntry `json:"entries"`
+}
+
+type inMemoryCacheFileEntry struct {
+	Key       map[string]float64 `json:"key"`
+	Embedding []float64          `json:"embedding"`
+}
+
+// SaveInMemoryCache writes every entry of c (cache key and embedding) as JSON to w, e.g. to
+// persist a cache built offline with BuildCache. It is safe to call concurrently with Set
+// and Find; entries added meanwhile may or may not be included.
+func SaveInMemoryCache(c *InMemoryCache, w io.Writer) error {
+	var file inMemoryCacheFile
+	c.mu.RLock()
+	for _, segment := range c.l1Segments {
+		for _, entry := range segment.entries {
+			file.Entries = append(file.Entries, inMemoryCacheFileEntry{Key: entry.tfidfVector, Embedding: entry.denseEmbedding})
+		}
+	}
+	for _, entry := range c.l0Entries {
+		file.Entries = append(file.Entries, inMemoryCacheFileEntry{Key: entry.tfidfVector, Embedding: entry.denseEmbedding})
+	}
+	c.mu.RUnlock()
+	return json.NewEncoder(w).Encode(file)
+}
+
+// LoadInMemoryCache creates an InMemoryCache configured by opts holding the entries written
+// by SaveInMemoryCache, already flushed to an indexed L1 segment.
+func LoadInMemoryCache(r io.Reader, opts CacheOptions) (*InMemoryCache, error) {
+	var file inMemoryCacheFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	c := NewInMemoryCacheWithOptions(opts)
+	c.mu.Lock()
+	for _, entry := range file.Entries {
+		c.l0Entries = append(c.l0Entries, cacheEntry{tfidfVector: entry.Key, denseEmbedding: entry.Embedding})
+	}
+	c.mu.Unlock()
+	c.Flush()
+	return c, nil
+}
+
 func (c *InMemoryCache) Set(key map[string]float64, embedding []float64, similarityThreshold float64) {
 	c.mu.Lock()
 
 	// Инкрементальный анализ: ищем соседей для нового элемента только в L0
 	isNewNeighborFound := false
 	for _, entry := range c.l0Entries {
-		if tfidf.CosineSimilarity(key, entry.tfidfVector) >= similarityThreshold {
+		if c.similarity(key, entry.tfidfVector) >= similarityThreshold {
 			isNewNeighborFound = true
 			break
 		}
@@ -225,39 +798,104 @@ func (c *InMemoryCache) AnalyzeSimilarity(threshold float64) int {
 	return int(c.itemsWithNeighbors.Load())
 }
 
-func (c *InMemoryCache) Find(key map[string]float64, threshold float64) ([]float64, bool) {
+// Find implements EmbeddingCache. Lookups in memory never fail, so err is always nil.
+func (c *InMemoryCache) Find(key map[string]float64, threshold float64) ([]float64, bool, error) {
+	embedding, found := c.find(key, threshold)
+	return embedding, found, nil
+}
+
+func (c *InMemoryCache) find(key map[string]float64, threshold float64) ([]float64, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// 1. Поиск в L0 (линейный)
-	for _, entry := range c.l0Entries {
-		if tfidf.CosineSimilarity(key, entry.tfidfVector) >= threshold {
+	topTerms := getTopK(key, c.topK)
+	switch c.preference {
+	case FindOldestFirst:
+		// Oldest L1 segments first, the unindexed L0 (the newest entries) last.
+		for _, segment := range c.l1Segments {
+			if entry, _, ok := c.findInSegment(key, topTerms, segment, threshold, false); ok {
+				return copyEmbedding(entry.denseEmbedding), true
+			}
+		}
+		if entry, _, ok := c.findInL0(key, threshold, false); ok {
+			return copyEmbedding(entry.denseEmbedding), true
+		}
+	case FindBest:
+		var best *cacheEntry
+		bestScore := -1.0
+		if entry, score, ok := c.findInL0(key, threshold, true); ok {
+			best, bestScore = entry, score
+		}
+		for _, segment := range c.l1Segments {
+			if entry, score, ok := c.findInSegment(key, topTerms, segment, threshold, true); ok && score > bestScore {
+				best, bestScore = entry, score
+			}
+		}
+		if best != nil {
+			return copyEmbedding(best.denseEmbedding), true
+		}
+	case FindNewestFirst:
+		// 1. Поиск в L0 (линейный)
+		if entry, _, ok := c.findInL0(key, threshold, false); ok {
 			return copyEmbedding(entry.denseEmbedding), true
 		}
+		// 2. Поиск во всех L1 сегментах (по индексу), начиная с самых новых.
+		for i := len(c.l1Segments) - 1; i >= 0; i-- {
+			if entry, _, ok := c.findInSegment(key, topTerms, c.l1Segments[i], threshold, false); ok {
+				return copyEmbedding(entry.denseEmbedding), true
+			}
+		}
 	}
 
-	// 2. Поиск во всех L1 сегментах (по индексу), начиная с самых новых.
-	topTerms := getTopK(key, c.topK)
-	for i := len(c.l1Segments) - 1; i >= 0; i-- {
-		segment := c.l1Segments[i]
-		candidates := make(map[int]struct{})
-		for _, term := range topTerms {
-			if indices, ok := segment.index[term]; ok {
-				for _, idx := range indices {
-					candidates[idx] = struct{}{}
-				}
+	return nil, false
+}
+
+// findInL0 scans L0 linearly for an entry at or above threshold. With best set it returns
+// the highest-scoring entry instead of the first match. Callers must hold c.mu.
+func (c *InMemoryCache) findInL0(key map[string]float64, threshold float64, best bool) (*cacheEntry, float64, bool) {
+	var found *cacheEntry
+	foundScore := -1.0
+	for i := range c.l0Entries {
+		score := c.similarity(key, c.l0Entries[i].tfidfVector)
+		if score >= threshold && score > foundScore {
+			found, foundScore = &c.l0Entries[i], score
+			if !best {
+				break
 			}
 		}
+	}
+	return found, foundScore, found != nil
+}
 
-		for idx := range candidates {
-			entry := segment.entries[idx]
-			if tfidf.CosineSimilarity(key, entry.tfidfVector) >= threshold {
-				return copyEmbedding(entry.denseEmbedding), true
+// findInSegment looks up an L1 segment through its index, comparing only the entries that
+// share one of the key's top terms. With best set it returns the highest-scoring candidate
+// (ties go to the earliest entry) instead of any match. Callers must hold c.mu.
+func (c *InMemoryCache) findInSegment(key map[string]float64, topTerms []string, segment *l1Segment, threshold float64, best bool) (*cacheEntry, float64, bool) {
+	candidates := make(map[int]struct{})
+	for _, term := range topTerms {
+		if indices, ok := segment.index[term]; ok {
+			for _, idx := range indices {
+				candidates[idx] = struct{}{}
 			}
 		}
 	}
 
-	return nil, false
+	var found *cacheEntry
+	foundIdx := -1
+	foundScore := -1.0
+	for idx := range candidates {
+		score := c.similarity(key, segment.entries[idx].tfidfVector)
+		if score < threshold {
+			continue
+		}
+		if !best {
+			return &segment.entries[idx], score, true
+		}
+		if score > foundScore || (score == foundScore && idx < foundIdx) {
+			found, foundIdx, foundScore = &segment.entries[idx], idx, score
+		}
+	}
+	return found, foundScore, found != nil
 }
 
 // --- Фоновые процессы (без изменений) ---
@@ -276,6 +914,9 @@ func (c *InMemoryCache) backgroundWorker() {
 }
 
 func (c *InMemoryCache) flushL0() {
+	c.maintenanceMu.Lock()
+	defer c.maintenanceMu.Unlock()
+
 	c.mu.Lock()
 	if len(c.l0Entries) == 0 {
 		c.mu.Unlock()
@@ -285,7 +926,7 @@ func (c *InMemoryCache) flushL0() {
 	c.l0Entries = make([]cacheEntry, 0, l0FlushThreshold)
 	c.mu.Unlock()
 
-	log.Printf("Flushing L0 with %d items to a new L1 segment...", len(entriesToFlush))
+	c.debugf("Flushing L0 with %d items to a new L1 segment...", len(entriesToFlush))
 	newIndex := buildIndex(entriesToFlush, c.topK)
 	newSegment := &l1Segment{
 		entries: entriesToFlush,
@@ -306,6 +947,9 @@ func (c *InMemoryCache) flushL0() {
 }
 
 func (c *InMemoryCache) compactL1() {
+	c.maintenanceMu.Lock()
+	defer c.maintenanceMu.Unlock()
+
 	c.mu.Lock()
 	if len(c.l1Segments) < l1CompactionTrigger {
 		c.mu.Unlock()
@@ -315,7 +959,7 @@ func (c *InMemoryCache) compactL1() {
 	remainingSegments := c.l1Segments[l1CompactionTargetCount:]
 	c.mu.Unlock()
 
-	log.Printf("Compacting %d L1 segments...", len(segmentsToCompact))
+	c.debugf("Compacting %d L1 segments...", len(segmentsToCompact))
 	var mergedEntries []cacheEntry
 	for _, seg := range segmentsToCompact {
 		mergedEntries = append(mergedEntries, seg.entries...)
@@ -329,8 +973,16 @@ func (c *InMemoryCache) compactL1() {
 
 	c.mu.Lock()
 	c.l1Segments = append([]*l1Segment{compactedSegment}, remainingSegments...)
+	numSegments := len(c.l1Segments)
 	c.mu.Unlock()
-	log.Printf("Compaction finished. New segment has %d items. Total L1 segments: %d", len(mergedEntries), len(c.l1Segments))
+	c.debugf("Compaction finished. New segment has %d items. Total L1 segments: %d", len(mergedEntries), numSegments)
+}
+
+// debugf logs a routine maintenance message if CacheOptions.Debug is set.
+func (c *InMemoryCache) debugf(format string, args ...any) {
+	if c.debug {
+		c.logger.Printf(format, args...)
+	}
 }
 
 // --- Вспомогательные функции (без изменений) ---
@@ -346,7 +998,12 @@ func buildIndex(entries []cacheEntry, k int) map[string][]int {
 	return index
 }
 
+// getTopK returns the k highest-weighted terms of vector, or all of them if it has at most
+// k terms. It returns an empty slice for k <= 0.
 func getTopK(vector map[string]float64, k int) []string {
+	if k <= 0 {
+		return []string{}
+	}
 	if len(vector) <= k {
 		terms := make([]string, 0, len(vector))
 		for term := range vector {
@@ -360,10 +1017,16 @@ func getTopK(vector map[string]float64, k int) []string {
 		scores = append(scores, termScore{term, score})
 	}
 
+	// Ties are broken by term so that identical vectors always get the same top terms,
+	// otherwise an entry could be indexed under terms its own key does not look up.
 	sort.Slice(scores, func(i, j int) bool {
-		return scores[i].score > scores[j].score
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+		return scores[i].term < scores[j].term
 	})
 
+	k = min(k, len(scores))
 	topTerms := make([]string, k)
 	for i := 0; i < k; i++ {
 		topTerms[i] = scores[i].term
@@ -0,0 +1,595 @@
+package semseg
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestInMemoryCacheFlush verifies that an explicit Flush moves L0 entries into an indexed
+// L1 segment and that lookups are then served through the index.
+func TestInMemoryCacheFlush(t *testing.T) {
+	c := NewInMemoryCache()
+	defer c.Close()
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		key := map[string]float64{fmt.Sprintf("term%d", i): 1, "shared": 0.1}
+		c.Set(key, []float64{float64(i)}, 0.9)
+	}
+
+	c.Flush()
+
+	c.mu.RLock()
+	l0Len, segments := len(c.l0Entries), c.l1Segments
+	c.mu.RUnlock()
+	if l0Len != 0 {
+		t.Fatalf("Expected empty L0 after Flush, got %d entries", l0Len)
+	}
+	if len(segments) != 1 || len(segments[0].entries) != n {
+		t.Fatalf("Expected one L1 segment with %d entries, got %d segments", n, len(segments))
+	}
+	if len(segments[0].index["term3"]) != 1 {
+		t.Fatalf("Expected term3 to be indexed, got index %v", segments[0].index)
+	}
+
+	embedding, found, _ := c.Find(map[string]float64{"term3": 1, "shared": 0.1}, 0.9)
+	if !found || len(embedding) != 1 || embedding[0] != 3 {
+		t.Fatalf("Expected indexed lookup to return [3], got %v (found=%v)", embedding, found)
+	}
+
+	// Flushing an empty L0 must not create an empty segment.
+	c.Flush()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.l1Segments) != 1 {
+		t.Fatalf("Expected Flush on empty L0 to be a no-op, got %d segments", len(c.l1Segments))
+	}
+}
+
+// TestInMemoryCacheDebugLogging checks that flushes and compactions log nothing by default
+// and log through CacheOptions.Logger when Debug is set.
+func TestInMemoryCacheDebugLogging(t *testing.T) {
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	maintain := func(c *InMemoryCache) {
+		defer c.Close()
+		for i := 0; i <= l1CompactionTrigger; i++ {
+			c.Set(map[string]float64{fmt.Sprintf("term%d", i): 1}, []float64{1}, 0.9)
+			c.Flush()
+		}
+		c.compactL1()
+	}
+
+	maintain(NewInMemoryCache())
+	if std.Len() != 0 {
+		t.Errorf("Expected no log output by default, got %q", std.String())
+	}
+
+	var debug bytes.Buffer
+	maintain(NewInMemoryCacheWithOptions(CacheOptions{Debug: true, Logger: log.New(&debug, "", 0)}))
+	if !strings.Contains(debug.String(), "Flushing L0 with 1 items") || !strings.Contains(debug.String(), "Compaction finished") {
+		t.Errorf("Expected flush and compaction log lines with Debug, got %q", debug.String())
+	}
+	if std.Len() != 0 {
+		t.Errorf("Expected the debug lines to go to the configured logger only, got %q", std.String())
+	}
+}
+
+// TestInMemoryCacheFlushConcurrent runs Flush alongside Set and Find; run with -race.
+func TestInMemoryCacheFlushConcurrent(t *testing.T) {
+	c := NewInMemoryCache()
+	defer c.Close()
+
+	const writers, perWriter = 4, 300
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := map[string]float64{fmt.Sprintf("w%d-%d", w, i): 1}
+				c.Set(key, []float64{1}, 0.9)
+				c.Find(key, 0.9)
+				if i%50 == 0 {
+					c.Flush()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	c.Flush()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	total := len(c.l0Entries)
+	for _, seg := range c.l1Segments {
+		total += len(seg.entries)
+	}
+	if total != writers*perWriter {
+		t.Fatalf("Expected %d cached entries, got %d", writers*perWriter, total)
+	}
+}
+
+// TestInMemoryCacheSimilarityFunc verifies that a custom metric drives both Find (in L0 and
+// in indexed L1 segments) and Set's neighbor analysis.
+func TestInMemoryCacheSimilarityFunc(t *testing.T) {
+	var calls atomic.Int64
+	// sharesFirstLetter treats keys as identical when any of their terms share a first letter.
+	sharesFirstLetter := func(a, b map[string]float64) float64 {
+		calls.Add(1)
+		for ta := range a {
+			for tb := range b {
+				if ta[0] == tb[0] {
+					return 1
+				}
+			}
+		}
+		return 0
+	}
+	c := NewInMemoryCacheWithOptions(CacheOptions{SimilarityFunc: sharesFirstLetter})
+	defer c.Close()
+
+	c.Set(map[string]float64{"apple": 1}, []float64{1}, 0.5)
+	c.Set(map[string]float64{"avocado": 1}, []float64{2}, 0.5)
+	if got := c.AnalyzeSimilarity(0.5); got != 1 {
+		t.Fatalf("Expected 1 item with neighbors under the custom metric, got %d", got)
+	}
+
+	if emb, found, _ := c.Find(map[string]float64{"apricot": 1}, 0.5); !found || emb[0] != 1 {
+		t.Fatalf("Expected L0 hit [1] under the custom metric, got %v (found=%v)", emb, found)
+	}
+	if _, found, _ := c.Find(map[string]float64{"banana": 1}, 0.5); found {
+		t.Fatalf("Expected miss for an unrelated key")
+	}
+
+	c.Flush()
+	if emb, found, _ := c.Find(map[string]float64{"avocado": 1, "x": 0.1}, 0.5); !found || emb[0] != 2 {
+		t.Fatalf("Expected L1 hit [2] under the custom metric, got %v (found=%v)", emb, found)
+	}
+	if calls.Load() == 0 {
+		t.Fatalf("Expected the custom metric to be called")
+	}
+}
+
+func TestJaccardSimilarity(t *testing.T) {
+	testCases := []struct {
+		a, b     map[string]float64
+		expected float64
+	}{
+		{map[string]float64{"a": 1, "b": 5}, map[string]float64{"a": 0.1, "b": 0.2}, 1},
+		{map[string]float64{"a": 1, "b": 1}, map[string]float64{"b": 1, "c": 1}, 1.0 / 3},
+		{map[string]float64{"a": 1}, map[string]float64{"b": 1}, 0},
+		{map[string]float64{}, map[string]float64{}, 0},
+	}
+	for _, tc := range testCases {
+		if got := JaccardSimilarity(tc.a, tc.b); got != tc.expected {
+			t.Fatalf("JaccardSimilarity(%v, %v) = %v, expected %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestJensenShannonSimilarity(t *testing.T) {
+	testCases := []struct {
+		a, b     map[string]float64
+		expected float64
+	}{
+		{map[string]float64{"a": 1, "b": 3}, map[string]float64{"a": 2, "b": 6}, 1},
+		{map[string]float64{"x": 3, "a": 1}, map[string]float64{"x": 3, "b": 1}, 0.75},
+		{map[string]float64{"a": 1}, map[string]float64{"b": 1}, 0},
+		{map[string]float64{}, map[string]float64{"a": 1}, 0},
+	}
+	for _, tc := range testCases {
+		if got := JensenShannonSimilarity(tc.a, tc.b); math.Abs(got-tc.expected) > 1e-12 {
+			t.Fatalf("JensenShannonSimilarity(%v, %v) = %v, expected %v", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+// TestGetTopK covers k of zero or less, larger than, equal to and smaller than the number
+// of terms.
+func TestGetTopK(t *testing.T) {
+	vector := map[string]float64{"a": 0.5, "b": 0.9, "c": 0.1}
+	testCases := []struct {
+		name     string
+		k        int
+		expected []string
+	}{
+		{"Zero", 0, []string{}},
+		{"Negative", -1, []string{}},
+		{"Fewer than terms", 2, []string{"a", "b"}},
+		{"Equal to terms", 3, []string{"a", "b", "c"}},
+		{"More than terms", 10, []string{"a", "b", "c"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := getTopK(vector, tc.k)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+	if got := getTopK(map[string]float64{}, 3); len(got) != 0 {
+		t.Errorf("Expected no terms for an empty vector, got %v", got)
+	}
+}
+
+// TestInMemoryCacheFindPreference verifies which entry wins when an old L1 segment, a newer
+// L1 segment and L0 all hold a match for the same key.
+func TestInMemoryCacheFindPreference(t *testing.T) {
+	// Similarities to the query: old segment ≈ 0.71, newer segment ≈ 0.94, L0 ≈ 0.67.
+	query := map[string]float64{"x": 1, "b": 1, "a": 0.5}
+	testCases := []struct {
+		preference string
+		expected   float64
+	}{
+		{"", 3}, // default is newest first
+		{FindNewestFirst, 3},
+		{FindOldestFirst, 1},
+		{FindBest, 2},
+		{"best_match", 3}, // unknown values are newest first
+	}
+
+	for _, tc := range testCases {
+		t.Run("preference="+tc.preference, func(t *testing.T) {
+			c := NewInMemoryCacheWithOptions(CacheOptions{FindPreference: tc.preference})
+			defer c.Close()
+			c.Set(map[string]float64{"x": 1, "a": 1}, []float64{1}, 0.5)
+			c.Flush()
+			c.Set(map[string]float64{"x": 1, "b": 1}, []float64{2}, 0.5)
+			c.Flush()
+			c.Set(map[string]float64{"x": 1}, []float64{3}, 0.5)
+
+			emb, found, _ := c.Find(query, 0.5)
+			if !found || emb[0] != tc.expected {
+				t.Fatalf("Expected [%v], got %v (found=%v)", tc.expected, emb, found)
+			}
+			if _, found, _ := c.Find(map[string]float64{"z": 1}, 0.5); found {
+				t.Fatalf("Expected miss for an unrelated key")
+			}
+		})
+	}
+}
+
+// TestBuildCacheKeysParallel verifies that parallel key generation preserves order and
+// matches the sequential result.
+func TestBuildCacheKeysParallel(t *testing.T) {
+	sentences := benchmarkSentences(500)
+	sequential := buildCacheKeys(sentences, Options{CacheKeyWorkers: 1})
+	parallel := buildCacheKeys(sentences, Options{CacheKeyWorkers: 8})
+	if !reflect.DeepEqual(sequential, parallel) {
+		t.Fatalf("Parallel cache keys differ from sequential ones")
+	}
+}
+
+// TestCacheKeyNgramRange verifies that the cache-key n-gram range follows the script of the
+// text and the configured language, and that explicit sizes take precedence.
+func TestCacheKeyNgramRange(t *testing.T) {
+	testCases := []struct {
+		name       string
+		text       string
+		opts       Options
+		minN, maxN int
+	}{
+		{"Latin", "The ocean covers most of the Earth.", Options{}, 3, 5},
+		{"Cyrillic", "Океан покрывает большую часть Земли.", Options{}, 3, 5},
+		{"Chinese", "海洋覆盖了地球的大部分。", Options{}, 2, 3},
+		{"Japanese", "海は地球の大部分を覆っている。", Options{}, 2, 3},
+		{"Korean", "바다는 지구의 대부분을 덮고 있다.", Options{}, 2, 3},
+		{"Mostly Latin with a CJK word", "The word 海 means ocean.", Options{}, 3, 5},
+		{"Agglutinative language", "Evlerinizden geliyorum.", Options{Language: "turkish"}, 4, 6},
+		{"CJK text with an agglutinative language", "海洋覆盖了地球。", Options{Language: "turkish"}, 2, 3},
+		{"Explicit sizes", "海洋覆盖了地球。", Options{CacheKeyMinNgram: 1, CacheKeyMaxNgram: 2}, 1, 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if minN, maxN := cacheKeyNgramRange(tc.text, tc.opts); minN != tc.minN || maxN != tc.maxN {
+				t.Errorf("Expected n-grams of %d to %d characters, got %d to %d", tc.minN, tc.maxN, minN, maxN)
+			}
+		})
+	}
+
+	// Two-character words are only matched by the CJK range.
+	key := VectorizeForCache("海洋")
+	if _, ok := key["海洋"]; !ok || len(key) != 1 {
+		t.Errorf("Expected the CJK key to hold the bigram, got %v", key)
+	}
+	if _, err := Segment("Cats purr.", Options{MaxTokens: 10, CacheKeyMinNgram: 3}); err == nil {
+		t.Error("Expected an error for a CacheKeyMinNgram without CacheKeyMaxNgram")
+	}
+}
+
+// BenchmarkBuildCacheKeys compares sequential and GOMAXPROCS-bounded cache key generation
+// on a large batch; run with -bench BuildCacheKeys.
+func BenchmarkBuildCacheKeys(b *testing.B) {
+	sentences := benchmarkSentences(5000)
+	for _, workers := range []int{1, 0} {
+		name := "sequential"
+		if workers == 0 {
+			name = "gomaxprocs"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buildCacheKeys(sentences, Options{CacheKeyWorkers: workers})
+			}
+		})
+	}
+}
+
+func benchmarkSentences(n int) []string {
+	words := []string{"ocean", "market", "planet", "investor", "current", "harbor", "forecast", "signal"}
+	sentences := make([]string, n)
+	for i := range sentences {
+		sentences[i] = fmt.Sprintf("The %s and the %s report number %d for the %s.",
+			words[i%len(words)], words[(i/3)%len(words)], i, words[(i/7)%len(words)])
+	}
+	return sentences
+}
+
+// TestCacheKeyFilter shows a false hit on boilerplate: two tickets about different topics
+// share only the template's n-grams yet score above 0.9, until n-grams found in most
+// texts of the reference corpus are dropped from the keys.
+func TestCacheKeyFilter(t *testing.T) {
+	template := "Thank you for contacting customer support, your ticket about %s has been received."
+	var corpus []string
+	for _, topic := range []string{"passwords", "refunds", "accounts", "invoices", "returns", "coupons"} {
+		corpus = append(corpus, fmt.Sprintf(template, topic))
+	}
+	corpus = append(corpus, "The ocean is deep.", "Markets fell sharply today.")
+	fees, pets := fmt.Sprintf(template, "fees"), fmt.Sprintf(template, "pets")
+
+	hits := func(filter *CacheKeyFilter) bool {
+		cache := NewInMemoryCache()
+		defer cache.Close()
+		cache.Set(filter.Vectorize(fees), []float64{1}, 0.9)
+		_, found, _ := cache.Find(filter.Vectorize(pets), 0.9)
+		return found
+	}
+	if !hits(nil) {
+		t.Fatal("Expected the unfiltered keys to produce a false hit")
+	}
+	filter := NewCacheKeyFilter(corpus, 0, 0.5)
+	if hits(filter) {
+		t.Error("Expected the filtered keys to miss")
+	}
+	if key := filter.Vectorize(fees); key["fee"] == 0 || key["tha"] != 0 {
+		t.Errorf("Expected the filtered key to keep the topic and drop the template, got %v", key)
+	}
+
+	// minDF drops n-grams absent from the corpus, such as those of the topic word.
+	if key := NewCacheKeyFilter(corpus, 0.1, 0).Vectorize(pets); key["pet"] != 0 || key["tha"] == 0 {
+		t.Errorf("Expected minDF to keep only n-grams seen in the corpus, got %v", key)
+	}
+}
+
+// TestAdaptiveCacheOverflowPolicy fills a queue with no writer running and checks which
+// entries survive under each overflow policy.
+func TestAdaptiveCacheOverflowPolicy(t *testing.T) {
+	testCases := []struct {
+		policy   string
+		expected []float64
+	}{
+		{"", []float64{1, 2}}, // default is drop newest
+		{OverflowDropNewest, []float64{1, 2}},
+		{OverflowDropOldest, []float64{2, 3}},
+		{OverflowBlock, []float64{1, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run("policy="+tc.policy, func(t *testing.T) {
+			m := NewAdaptiveCacheManagerWithOptions(NewInMemoryCache(), AdaptiveCacheOptions{
+				QueueSize:      2,
+				OverflowPolicy: tc.policy,
+				BlockTimeout:   10 * time.Millisecond,
+			}).(*adaptiveCacheManager)
+
+			start := time.Now()
+			for i := 1; i <= 3; i++ {
+				m.QueueSet(map[string]float64{"k": 1}, []float64{float64(i)})
+			}
+			if tc.policy == OverflowBlock && time.Since(start) < 10*time.Millisecond {
+				t.Fatalf("Expected the block policy to wait for BlockTimeout before dropping")
+			}
+
+			metrics := m.Metrics()
+			expectedPolicy := tc.policy
+			if expectedPolicy == "" {
+				expectedPolicy = OverflowDropNewest
+			}
+			if metrics.OverflowPolicy != expectedPolicy || metrics.QueueLength != 2 || metrics.QueueCapacity != 2 || metrics.Dropped != 1 {
+				t.Fatalf("Unexpected metrics: %+v", metrics)
+			}
+
+			var got []float64
+			for len(m.setQueue) > 0 {
+				got = append(got, (<-m.setQueue).embedding[0])
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("Expected queued entries %v, got %v", tc.expected, got)
+			}
+			m.Close()
+		})
+	}
+}
+
+// TestAdaptiveCacheBlockPolicyWaits verifies that the block policy enqueues an entry once a
+// consumer frees room within BlockTimeout.
+func TestAdaptiveCacheBlockPolicyWaits(t *testing.T) {
+	m := NewAdaptiveCacheManagerWithOptions(NewInMemoryCache(), AdaptiveCacheOptions{
+		QueueSize:      1,
+		OverflowPolicy: OverflowBlock,
+		BlockTimeout:   5 * time.Second,
+	}).(*adaptiveCacheManager)
+	defer m.Close()
+
+	m.QueueSet(map[string]float64{"a": 1}, []float64{1})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-m.setQueue
+	}()
+	m.QueueSet(map[string]float64{"b": 1}, []float64{2})
+
+	if metrics := m.Metrics(); metrics.Enqueued != 2 || metrics.Dropped != 0 {
+		t.Fatalf("Expected both entries enqueued, got %+v", metrics)
+	}
+}
+
+// slowSetCache is an InMemoryCache whose writes take a fixed delay, as a remote cache's would.
+type slowSetCache struct {
+	*InMemoryCache
+	delay time.Duration
+}
+
+func (c slowSetCache) Set(key map[string]float64, embedding []float64, threshold float64) {
+	time.Sleep(c.delay)
+	c.InMemoryCache.Set(key, embedding, threshold)
+}
+
+// TestAdaptiveCacheWriters queues entries faster than one writer can store them and checks
+// that a pool of writers drops fewer of them.
+func TestAdaptiveCacheWriters(t *testing.T) {
+	dropped := func(writers int) uint64 {
+		m := NewAdaptiveCacheManagerWithOptions(slowSetCache{NewInMemoryCache(), 5 * time.Millisecond}, AdaptiveCacheOptions{
+			QueueSize: 4,
+			Writers:   writers,
+		}).(*adaptiveCacheManager)
+		defer m.Close()
+		m.Start(0.9, 1)
+		for i := 0; i < 200; i++ {
+			m.QueueSet(map[string]float64{fmt.Sprint(i): 1}, []float64{float64(i)})
+			time.Sleep(time.Millisecond)
+		}
+		metrics := m.Metrics()
+		if metrics.Writers != writers {
+			t.Errorf("Expected %d writers in the metrics, got %d", writers, metrics.Writers)
+		}
+		return metrics.Dropped
+	}
+
+	single, pool := dropped(1), dropped(16)
+	if single == 0 || pool >= single {
+		t.Errorf("Expected 16 writers to drop fewer entries than one, got %d with one and %d with 16", single, pool)
+	}
+	if writers := NewAdaptiveCacheManager(NewInMemoryCache()).Metrics().Writers; writers != 1 {
+		t.Errorf("Expected one writer by default, got %d", writers)
+	}
+}
+
+// TestAdaptiveCacheQueueSetAfterClose verifies that entries queued after Close, e.g. by a
+// population goroutine still running, are discarded instead of panicking.
+func TestAdaptiveCacheQueueSetAfterClose(t *testing.T) {
+	m := NewAdaptiveCacheManager(NewInMemoryCache())
+	m.Close()
+	m.QueueSet(map[string]float64{"a": 1}, []float64{1})
+	if metrics := m.Metrics(); metrics.Enqueued != 0 {
+		t.Fatalf("Expected no entry enqueued after Close, got %+v", metrics)
+	}
+}
+
+// TestAdaptiveCacheStateRoundTrip saves an activated manager and checks that a new manager
+// loading the state resumes activated, unless it is started with other thresholds.
+func TestAdaptiveCacheStateRoundTrip(t *testing.T) {
+	m := NewAdaptiveCacheManager(NewInMemoryCache())
+	m.Start(0.9, 100)
+	m.(*adaptiveCacheManager).isActivated.Store(true)
+	var saved bytes.Buffer
+	if err := SaveAdaptiveCacheState(m, &saved); err != nil {
+		t.Fatalf("SaveAdaptiveCacheState() error: %v", err)
+	}
+	if err := LoadAdaptiveCacheState(m, bytes.NewReader(saved.Bytes())); err == nil {
+		t.Error("Expected an error when loading into a started manager")
+	}
+	m.Close()
+
+	restored := NewAdaptiveCacheManager(NewInMemoryCache())
+	defer restored.Close()
+	if err := LoadAdaptiveCacheState(restored, bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatalf("LoadAdaptiveCacheState() error: %v", err)
+	}
+	restored.Start(0.9, 100)
+	if !restored.IsActivated() {
+		t.Fatal("Expected the restored manager to resume activated")
+	}
+
+	mismatched := NewAdaptiveCacheManager(NewInMemoryCache())
+	defer mismatched.Close()
+	if err := LoadAdaptiveCacheState(mismatched, bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatalf("LoadAdaptiveCacheState() error: %v", err)
+	}
+	mismatched.Start(0.8, 100)
+	if mismatched.IsActivated() {
+		t.Fatal("Expected the activation to be discarded for other thresholds")
+	}
+
+	if err := SaveAdaptiveCacheState(activatedCacheManager{restored}, &saved); err == nil {
+		t.Error("Expected an error for a manager of another type")
+	}
+}
+
+// TestCalibrateCacheThreshold calibrates on labeled pairs: one-word substitutions score
+// around 0.6-0.7 on n-gram keys yet change the meaning, so full precision needs a threshold
+// above them, while a lower target precision admits the closest of them.
+func TestCalibrateCacheThreshold(t *testing.T) {
+	pairs := []CachePair{
+		{"The quarterly report is attached to this email.", "The quarterly report is attached to this e-mail.", true},
+		{"Click here to reset your password.", "Click here to reset your password!", true},
+		{"The ocean covers most of the Earth's surface.", "The ocean covers most of the surface of the Earth.", true},
+		{"The quarterly report is attached to this email.", "Please find the quarterly report attached.", true},
+		{"The quarterly report is attached to this email.", "The annual report is attached to this email.", false},
+		{"The meeting is scheduled for Monday at 10am.", "The meeting is scheduled for Tuesday at 3pm.", false},
+		{"The cat sat on the mat.", "The dog sat on the mat.", false},
+		{"The quarterly report is attached to this email.", "Lunch is served in the cafeteria at noon.", false},
+	}
+	score := func(i int) float64 {
+		return CosineSimilarity(VectorizeForCache(pairs[i].A), VectorizeForCache(pairs[i].B))
+	}
+
+	threshold, err := CalibrateCacheThreshold(pairs, 1, nil)
+	if err != nil {
+		t.Fatalf("CalibrateCacheThreshold() error = %v", err)
+	}
+	if math.Abs(threshold-score(2)) > 1e-6 {
+		t.Errorf("Expected threshold %.3f (lowest duplicate above all distinct pairs), got %.3f", score(2), threshold)
+	}
+
+	threshold, err = CalibrateCacheThreshold(pairs, 0.75, nil)
+	if err != nil {
+		t.Fatalf("CalibrateCacheThreshold() error = %v", err)
+	}
+	if math.Abs(threshold-score(4)) > 1e-6 {
+		t.Errorf("Expected threshold %.3f (admitting one distinct pair), got %.3f", score(4), threshold)
+	}
+
+	if _, err := CalibrateCacheThreshold(pairs, 1, JaccardSimilarity); err != nil {
+		t.Errorf("CalibrateCacheThreshold(Jaccard) error = %v", err)
+	}
+
+	errorCases := []struct {
+		name      string
+		pairs     []CachePair
+		precision float64
+	}{
+		{"Invalid precision", pairs, 1.5},
+		{"No duplicates", pairs[4:], 0.5},
+		{"Unreachable precision", []CachePair{pairs[4], pairs[3]}, 1},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := CalibrateCacheThreshold(tc.pairs, tc.precision, nil); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
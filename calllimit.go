@@ -0,0 +1,29 @@
+package semseg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Constants for Options.EmbeddingCallLimitPolicy: what happens when a document needs more
+// embedding calls than Options.MaxEmbeddingCalls.
+const (
+	// EmbeddingCallLimitError fails the call with ErrEmbeddingCallLimit. This is the default.
+	EmbeddingCallLimitError = "error"
+	// EmbeddingCallLimitFallback scores the document with the built-in TF-IDF method
+	// instead, and SegmentWithDetails reports WarningEmbeddingCallLimit.
+	EmbeddingCallLimitFallback = "tfidf"
+)
+
+// ErrEmbeddingCallLimit is returned (wrapped) when a document needs more embedding calls
+// than Options.MaxEmbeddingCalls and EmbeddingCallLimitPolicy is EmbeddingCallLimitError.
+var ErrEmbeddingCallLimit = errors.New("document needs more embedding calls than MaxEmbeddingCalls")
+
+// checkEmbeddingCalls fails with ErrEmbeddingCallLimit if calls exceeds the
+// MaxEmbeddingCalls of opts. It runs before any embedding is requested.
+func checkEmbeddingCalls(calls int, opts Options) error {
+	if opts.MaxEmbeddingCalls > 0 && calls > opts.MaxEmbeddingCalls {
+		return fmt.Errorf("%w: %d calls, limit is %d", ErrEmbeddingCallLimit, calls, opts.MaxEmbeddingCalls)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package semseg
+
+import (
+	"github.com/cmsdko/semseg/internal/lang"
+	"github.com/cmsdko/semseg/internal/tfidf"
+)
+
+// ChunkSimilarity scores how similar two chunks are, e.g. to merge or cluster chunks after
+// segmentation. If both chunks carry embeddings of the same size (see
+// Options.ChunkPooling), it returns their cosine similarity. Otherwise it builds a TF-IDF
+// model over the two chunk texts with the same preprocessing as segmentation (language,
+// stopwords, stemming or n-grams per opts) and compares the vectors with
+// opts.TFIDFSimilarity, cosine by default. Options.Language is used if set; otherwise the
+// language is detected from both texts together.
+func ChunkSimilarity(a, b Chunk, opts Options) float64 {
+	if a.Embedding != nil && len(a.Embedding) == len(b.Embedding) {
+		return cosineSimilarityDense(a.Embedding, b.Embedding)
+	}
+	setDefaultOptions(&opts)
+	language := opts.Language
+	if language == "" {
+		language = lang.DetectLanguageAmong(chunkText(a, opts.ChunkJoiner)+" "+chunkText(b, opts.ChunkJoiner), opts.CandidateLanguages)
+	}
+	tokens := [][]string{similarityTokens(chunkText(a, opts.ChunkJoiner), language, opts), similarityTokens(chunkText(b, opts.ChunkJoiner), language, opts)}
+	opts.OnProgress = nil
+	vectors, _, _ := tfidfVectors(tokens, opts)
+	return opts.TFIDFSimilarity(vectors[0], vectors[1])
+}
+
+// coalesceSimilarChunks merges each chunk into the preceding one when the TF-IDF cosine
+// similarity of their texts reaches opts.CoalesceSimilarChunks and the merged chunk still
+// fits MaxTokens (and MaxChunkBytes, if set). A similar chunk that does not fit is kept and
+// marked Duplicate. A merged chunk is compared with the next one as a whole. When gaps is
+// non-nil, the splits that were undone are cleared.
+func coalesceSimilarChunks(chunks []Chunk, language string, opts Options, gaps []GapDecision) []Chunk {
+	if opts.CoalesceSimilarChunks <= 0 || len(chunks) < 2 {
+		return chunks
+	}
+	tokens := make([][]string, len(chunks))
+	for i, chunk := range chunks {
+		tokens[i] = similarityTokens(chunkText(chunk, opts.ChunkJoiner), language, opts)
+	}
+	corpus := tfidf.NewCorpus(tokens)
+	corpus.SetIDF(opts.IDFFormula, *opts.IDFSmoothing)
+
+	out := []Chunk{chunks[0]}
+	prevVector := corpus.Vectorize(tokens[0])
+	for i := 1; i < len(chunks); i++ {
+		vector := corpus.Vectorize(tokens[i])
+		if CosineSimilarity(prevVector, vector) >= opts.CoalesceSimilarChunks {
+			prev := &out[len(out)-1]
+			merged, mergedBytes := mergeChunkPair(*prev, chunks[i], opts.ChunkJoiner, opts.ChunkOutput)
+			if merged.NumTokens <= opts.MaxTokens && (opts.MaxChunkBytes == 0 || mergedBytes <= opts.MaxChunkBytes) {
+				if gap := prev.SentenceIndices[len(prev.SentenceIndices)-1]; gaps != nil && gap < len(gaps) && chunks[i].SentenceIndices[0] == gap+1 {
+					gaps[gap].Split = false
+					gaps[gap].SplitReason = SplitReasonNone
+				}
+				*prev = merged
+				prevVector = sumSparse([]map[string]float64{prevVector, vector})
+				continue
+			}
+			chunks[i].Duplicate = true
+		}
+		out = append(out, chunks[i])
+		prevVector = vector
+	}
+	return out
+}
+
+// mergeChunkPair returns the chunk holding the sentences of a followed by those of b, with
+// Text and Sentences filled as output selects, and the length of its text in bytes.
+func mergeChunkPair(a, b Chunk, joiner *string, output string) (Chunk, int) {
+	text := joinSentences([]string{chunkText(a, joiner), chunkText(b, joiner)}, joiner)
+	merged := Chunk{
+		NumTokens:       a.NumTokens + b.NumTokens,
+		SentenceIndices: append(append([]int(nil), a.SentenceIndices...), b.SentenceIndices...),
+	}
+	if output != ChunkOutputSentences {
+		merged.Text = text
+	}
+	if output != ChunkOutputText {
+		merged.Sentences = append(append([]string(nil), a.Sentences...), b.Sentences...)
+	}
+	return merged, len(text)
+}
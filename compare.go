@@ -0,0 +1,75 @@
+package semseg
+
+import "context"
+
+// CompareMethods scores text with both the built-in TF-IDF method and the dense embedding
+// backend, to judge whether embeddings are worth their cost on a corpus. It returns the
+// semantic boundaries each method finds, as gap indices (a boundary at i splits after
+// sentence i), and their agreement: the Jaccard index of the two sets, 1 when neither
+// method finds a boundary. Cuts forced by MaxTokens or MaxChunkBytes are not boundaries, as
+// they do not depend on the method.
+//
+// Both sides use opts as Segment would, except that the TF-IDF side ignores the embedding
+// cache and the dense-only RecencyDecay, ChunkPooling, MinSentenceTokensForEmbedding and
+// IntraBatchDedupThreshold. A dense backend is required (Options.EmbeddingProvider, or
+// CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL); without one, ErrNoEmbeddingBackend is
+// returned.
+func CompareMethods(text string, opts Options) (tfidfBoundaries, denseBoundaries []int, agreement float64, err error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, nil, 0, err
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: text}
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, nil, 0, err
+	}
+	provider := embeddingProvider(opts)
+	if provider == nil {
+		return nil, nil, 0, ErrNoEmbeddingBackend
+	}
+
+	ctx, span := startSpan(callContext(context.Background(), opts), SpanSegment)
+	defer span.End()
+
+	dense, err := scoreDocumentWith(ctx, in, opts, provider)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	tfidfOpts := opts
+	tfidfOpts.EmbeddingCacheMode = CacheModeDisable
+	tfidfOpts.RecencyDecay = 0
+	tfidfOpts.ChunkPooling = ""
+	tfidfOpts.MinSentenceTokensForEmbedding = 0
+	tfidfOpts.IntraBatchDedupThreshold = 0
+	sparse, err := scoreDocumentWith(ctx, in, tfidfOpts, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	span.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(dense.sentences)})
+
+	tfidfBoundaries = sortedBoundaries(sparse.boundaries(tfidfOpts, nil))
+	denseBoundaries = sortedBoundaries(dense.boundaries(opts, nil))
+	return tfidfBoundaries, denseBoundaries, boundaryAgreement(tfidfBoundaries, denseBoundaries), nil
+}
+
+// boundaryAgreement returns the Jaccard index of two sorted boundary lists, 1 if both are
+// empty.
+func boundaryAgreement(a, b []int) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	shared := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			shared++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
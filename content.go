@@ -0,0 +1,62 @@
+package semseg
+
+import (
+	"mime"
+	"sync"
+
+	"github.com/cmsdko/semseg/internal/text"
+)
+
+// Constants for Options.ContentType, each with a built-in preprocessor.
+const (
+	// ContentTypePlain leaves the text unchanged.
+	ContentTypePlain = "text/plain"
+	// ContentTypeHTML extracts the text content of an HTML document or fragment: markup,
+	// scripts, styles and comments are removed, character references decoded, and
+	// block-level elements start a new line.
+	ContentTypeHTML = "text/html"
+	// ContentTypeMarkdown removes Markdown syntax, keeping the text it formats (see
+	// OutputFormatPlain).
+	ContentTypeMarkdown = "text/markdown"
+)
+
+// preprocessors maps media types to the cleaners applied before sentence splitting.
+var preprocessors = struct {
+	sync.RWMutex
+	m map[string]func(string) string
+}{m: map[string]func(string) string{
+	ContentTypePlain:    func(s string) string { return s },
+	ContentTypeHTML:     text.StripHTML,
+	ContentTypeMarkdown: text.StripMarkdown,
+}}
+
+// RegisterPreprocessor makes clean the preprocessor for contentType, replacing any previous
+// one (including the built-in ones). clean receives the whole input text and returns the
+// text to segment; it must be safe for concurrent use. Media type parameters and case are
+// ignored, so registering "text/csv" also covers "Text/CSV; charset=utf-8". A nil clean
+// removes the registration. It panics if contentType is not a valid media type.
+func RegisterPreprocessor(contentType string, clean func(string) string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		panic("semseg: invalid content type " + contentType + ": " + err.Error())
+	}
+	preprocessors.Lock()
+	defer preprocessors.Unlock()
+	if clean == nil {
+		delete(preprocessors.m, mediaType)
+		return
+	}
+	preprocessors.m[mediaType] = clean
+}
+
+// preprocessor returns the cleaner registered for contentType, or nil if there is none or
+// contentType is not a valid media type.
+func preprocessor(contentType string) func(string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	preprocessors.RLock()
+	defer preprocessors.RUnlock()
+	return preprocessors.m[mediaType]
+}
@@ -0,0 +1,103 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Constants for Options.DimensionMismatchPolicy: what happens when the embedding of a
+// sentence does not have the dimension of the others.
+const (
+	// DimensionMismatchError fails the call with ErrDimensionMismatch. This is the default.
+	DimensionMismatchError = "error"
+	// DimensionMismatchSkip leaves the sentence out of cohesion scoring: it takes the
+	// embedding of the preceding sentence (the following one for the first sentence), so it
+	// neither causes nor hides a boundary.
+	DimensionMismatchSkip = "skip"
+	// DimensionMismatchRetry requests the embedding of the sentence again, up to
+	// dimensionMismatchRetries times, and fails like DimensionMismatchError if it never
+	// has the expected dimension.
+	DimensionMismatchRetry = "retry"
+)
+
+// ErrDimensionMismatch is returned (wrapped) when a sentence embedding does not have the
+// dimension of the others and DimensionMismatchPolicy does not resolve it.
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// dimensionMismatchRetries is the number of additional requests made for a sentence under
+// DimensionMismatchRetry.
+const dimensionMismatchRetries = 2
+
+// resolveDimensions applies the DimensionMismatchPolicy of opts to every embedding in vectors
+// whose length differs from the most common one, replacing it in place. A nil vector is
+// left alone (see PartialResultsOnCancel).
+func resolveDimensions(ctx context.Context, sentences []string, vectors [][]float64, provider EmbeddingProvider, opts Options) error {
+	dim := embeddingDimension(vectors)
+	var fallback []float64 // the embedding a skipped sentence takes
+	for _, v := range vectors {
+		if len(v) == dim {
+			fallback = v
+			break
+		}
+	}
+	for i, v := range vectors {
+		if v != nil && len(v) != dim {
+			fixed, err := resolveDimension(ctx, i, sentences[i], v, dim, fallback, provider, opts.DimensionMismatchPolicy)
+			if err != nil {
+				return err
+			}
+			vectors[i] = fixed
+		}
+		if len(vectors[i]) == dim {
+			fallback = vectors[i]
+		}
+	}
+	return nil
+}
+
+// resolveDimension returns the embedding to use for sentence i, whose embedding has the
+// wrong dimension, according to policy: fallback for DimensionMismatchSkip, a new
+// embedding of dimension dim for DimensionMismatchRetry, or an error.
+func resolveDimension(ctx context.Context, i int, sentence string, embedding []float64, dim int, fallback []float64, provider EmbeddingProvider, policy string) ([]float64, error) {
+	switch policy {
+	case DimensionMismatchSkip:
+		return fallback, nil
+	case DimensionMismatchRetry:
+		// Retries re-embed a sentence already counted as progress.
+		ctx = withoutProgress(ctx)
+		for attempt := 0; attempt < dimensionMismatchRetries; attempt++ {
+			results, err := runOllamaWorkers(ctx, []ollamaJob{{index: i, sentence: sentence}}, provider)
+			if err != nil {
+				return nil, err
+			}
+			if embedding = results[0].embedding; len(embedding) == dim {
+				return embedding, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: sentence %d has %d dimensions, expected %d", ErrDimensionMismatch, i, len(embedding), dim)
+}
+
+// streamedDimensions applies the DimensionMismatchPolicy to embeddings consumed in sentence
+// order, as they are streamed. The first embedding sets the expected dimension.
+type streamedDimensions struct {
+	ctx       context.Context
+	sentences []string
+	provider  EmbeddingProvider
+	policy    string
+	previous  []float64
+}
+
+// resolve returns the embedding to use for sentence i given the one received.
+func (s *streamedDimensions) resolve(i int, embedding []float64) ([]float64, error) {
+	if s.previous != nil && len(embedding) != len(s.previous) {
+		var err error
+		embedding, err = resolveDimension(s.ctx, i, s.sentences[i], embedding, len(s.previous), s.previous, s.provider, s.policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.previous = embedding
+	return embedding, nil
+}
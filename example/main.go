@@ -35,6 +35,11 @@ type APIRequest struct {
 	EmbeddingCacheMode               string  `json:"embedding_cache_mode,omitempty"`
 	CacheSimilarityThreshold         float64 `json:"cache_similarity_threshold,omitempty"`
 	AdaptiveCacheActivationThreshold int     `json:"adaptive_cache_activation_threshold,omitempty"`
+
+	// Offset and Limit select a window of the chunks to return. The whole text is still
+	// segmented; a Limit of 0 returns all chunks from Offset on.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
 }
 
 // ResponseOptions reflects the settings that were actually used for segmentation.
@@ -55,6 +60,10 @@ type ResponseOptions struct {
 	EmbeddingCacheMode               string  `json:"embedding_cache_mode"`
 	CacheSimilarityThreshold         float64 `json:"cache_similarity_threshold"`
 	AdaptiveCacheActivationThreshold int     `json:"adaptive_cache_activation_threshold"`
+
+	// DetectedLanguage is the language the library actually used, which is useful when
+	// "language" is left empty and auto-detection is in effect.
+	DetectedLanguage string `json:"detected_language,omitempty"`
 }
 
 // ... (Stats, APIResponse, APIError structs remain the same) ...
@@ -66,10 +75,22 @@ type Stats struct {
 	TokensPerSecond  float64 `json:"tokens_per_second"`
 }
 
+// Pagination describes the window of chunks returned, so clients can request the next one.
+type Pagination struct {
+	Offset      int `json:"offset"`
+	Limit       int `json:"limit"`
+	TotalChunks int `json:"total_chunks"`
+}
+
 type APIResponse struct {
 	OptionsUsed ResponseOptions `json:"options_used"`
+	Pagination  Pagination      `json:"pagination"`
 	Chunks      []semseg.Chunk  `json:"chunks"`
-	Stats       Stats           `json:"stats"`
+
+	// Ranges holds the [start, end) byte offsets of each chunk in the submitted text, so
+	// clients can overlay chunk boundaries on the source.
+	Ranges [][2]int `json:"ranges"`
+	Stats  Stats    `json:"stats"`
 }
 
 type APIError struct {
@@ -124,6 +145,10 @@ func (h *APIHandler) handleSegment(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "max_tokens must be > 0", http.StatusBadRequest)
 		return
 	}
+	if req.Offset < 0 || req.Limit < 0 {
+		jsonError(w, "offset and limit must not be negative", http.StatusBadRequest)
+		return
+	}
 
 	opts := semseg.Options{
 		MaxTokens:                 req.MaxTokens,
@@ -149,7 +174,7 @@ func (h *APIHandler) handleSegment(w http.ResponseWriter, r *http.Request) {
 	responseOpts := buildResponseOptions(req)
 
 	startTime := time.Now()
-	chunks, err := semseg.Segment(req.Text, opts)
+	chunks, details, err := semseg.SegmentWithDetails(req.Text, opts)
 	duration := time.Since(startTime)
 
 	if err != nil {
@@ -157,10 +182,20 @@ func (h *APIHandler) handleSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ranges, err := semseg.ChunkRanges(req.Text, chunks)
+	if err != nil {
+		jsonError(w, "Internal server error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseOpts.DetectedLanguage = details.DetectedLanguage
 	stats := calculateStats(chunks, duration)
+	start, end := pageBounds(len(chunks), req.Offset, req.Limit)
 	response := APIResponse{
 		OptionsUsed: responseOpts,
-		Chunks:      chunks,
+		Pagination:  Pagination{Offset: req.Offset, Limit: req.Limit, TotalChunks: len(chunks)},
+		Chunks:      chunks[start:end],
+		Ranges:      ranges[start:end],
 		Stats:       stats,
 	}
 
@@ -206,6 +241,17 @@ func buildResponseOptions(req APIRequest) ResponseOptions {
 	return opts
 }
 
+// pageBounds returns the slice bounds of the window of total chunks starting at offset and
+// holding at most limit chunks (all remaining ones if limit is 0).
+func pageBounds(total, offset, limit int) (int, int) {
+	start := min(offset, total)
+	end := total
+	if limit > 0 {
+		end = min(start+limit, total)
+	}
+	return start, end
+}
+
 // ... (calculateStats, jsonError, main functions remain the same) ...
 func calculateStats(chunks []semseg.Chunk, duration time.Duration) Stats {
 	totalTokens := 0
@@ -230,6 +276,16 @@ func calculateStats(chunks []semseg.Chunk, duration time.Duration) Stats {
 	}
 }
 
+// handleHealthz reports whether the configured embedding backend is ready.
+func (h *APIHandler) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := semseg.PingEmbeddingBackend(r.Context(), semseg.Options{HTTPClient: h.ollamaClient}); err != nil {
+		jsonError(w, "Embedding backend not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"status":"ok"}`))
+}
+
 func jsonError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -240,6 +296,7 @@ func main() {
 	apiHandler := NewAPIHandler()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/segment", apiHandler.handleSegment)
+	mux.HandleFunc("/healthz", apiHandler.handleHealthz)
 	srv := &http.Server{
 		Addr:              ":8080",
 		Handler:           mux,
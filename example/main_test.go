@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSegmentRanges posts a text whose abbreviations are normalized and checks that the
+// returned ranges select the submitted source of every chunk.
+func TestSegmentRanges(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+
+	text := "The U.S.A. economy grew fast.\n\nThe U.S.A. exports rose, e.g. cars and grain.  Cats purr softly. Cats sleep all day."
+	body, _ := json.Marshal(APIRequest{Text: text, MaxTokens: 8})
+	rec := httptest.NewRecorder()
+	NewAPIHandler().handleSegment(rec, httptest.NewRequest(http.MethodPost, "/segment", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp APIResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decoding response: %v", err)
+	}
+	if len(resp.Chunks) < 2 || len(resp.Ranges) != len(resp.Chunks) {
+		t.Fatalf("Expected one range per chunk for several chunks, got %d ranges for %d chunks", len(resp.Ranges), len(resp.Chunks))
+	}
+	squash := func(s string) string {
+		return strings.Join(strings.Fields(strings.ReplaceAll(s, ".", "")), "")
+	}
+	for i, r := range resp.Ranges {
+		if r[0] < 0 || r[1] < r[0] || r[1] > len(text) {
+			t.Fatalf("Range %d is invalid: %v", i, r)
+		}
+		source := text[r[0]:r[1]]
+		if squash(source) != squash(resp.Chunks[i].Text) {
+			t.Errorf("Range %d selects %q, want the source of %q", i, source, resp.Chunks[i].Text)
+		}
+	}
+	if first := text[resp.Ranges[0][0]:resp.Ranges[0][1]]; !strings.HasPrefix(first, "The U.S.A. economy") {
+		t.Errorf("First range should keep the submitted abbreviation, got %q", first)
+	}
+}
+
+// TestSegmentPagination checks the window of chunks returned for offset and limit, and that
+// the total always counts every chunk.
+func TestSegmentPagination(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+
+	// One chunk per sentence.
+	text := "Cats purr softly. Dogs bark loudly. Birds sing sweetly. Fish swim quietly. Cows graze slowly."
+	handler := NewAPIHandler()
+	post := func(req APIRequest) *httptest.ResponseRecorder {
+		req.Text, req.MaxTokens = text, 3
+		body, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		handler.handleSegment(rec, httptest.NewRequest(http.MethodPost, "/segment", bytes.NewReader(body)))
+		return rec
+	}
+
+	testCases := []struct {
+		name          string
+		offset, limit int
+		expected      []string
+	}{
+		{"All", 0, 0, []string{"Cats purr softly.", "Dogs bark loudly.", "Birds sing sweetly.", "Fish swim quietly.", "Cows graze slowly."}},
+		{"First page", 0, 2, []string{"Cats purr softly.", "Dogs bark loudly."}},
+		{"Middle page", 2, 2, []string{"Birds sing sweetly.", "Fish swim quietly."}},
+		{"Last partial page", 4, 2, []string{"Cows graze slowly."}},
+		{"Offset without limit", 3, 0, []string{"Fish swim quietly.", "Cows graze slowly."}},
+		{"Offset past the end", 7, 2, []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := post(APIRequest{Offset: tc.offset, Limit: tc.limit})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp APIResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Decoding response: %v", err)
+			}
+			if resp.Pagination != (Pagination{Offset: tc.offset, Limit: tc.limit, TotalChunks: 5}) || resp.Stats.TotalChunks != 5 {
+				t.Errorf("Expected 5 chunks in total, got %+v and stats %+v", resp.Pagination, resp.Stats)
+			}
+			got := make([]string, len(resp.Chunks))
+			for i, chunk := range resp.Chunks {
+				got[i] = chunk.Text
+			}
+			if len(got) != len(tc.expected) || len(resp.Ranges) != len(got) || strings.Join(got, "|") != strings.Join(tc.expected, "|") {
+				t.Errorf("Expected chunks %q, got %q with %d ranges", tc.expected, got, len(resp.Ranges))
+			}
+		})
+	}
+
+	if rec := post(APIRequest{Offset: -1}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative offset, got %d", rec.Code)
+	}
+	if rec := post(APIRequest{Limit: -1}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative limit, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,39 @@
+package semseg_test
+
+import (
+	"fmt"
+
+	"github.com/cmsdko/semseg"
+)
+
+// ExampleVectorizeForCache uses the in-memory cache on its own as a near-duplicate store:
+// values are stored under the key of a text and found again for sufficiently similar texts.
+func ExampleVectorizeForCache() {
+	store := semseg.NewInMemoryCache()
+	defer store.Close()
+
+	const threshold = 0.8
+	seen := func(s string) bool {
+		_, found, _ := store.Find(semseg.VectorizeForCache(s), threshold)
+		return found
+	}
+
+	for _, s := range []string{
+		"The quarterly report is attached to this email.",
+		"The quarterly report is attached to this e-mail.",
+		"Lunch is served in the cafeteria at noon.",
+	} {
+		if seen(s) {
+			fmt.Printf("duplicate: %s\n", s)
+			continue
+		}
+		// The stored value can be anything encoded as []float64, e.g. an embedding or an ID.
+		store.Set(semseg.VectorizeForCache(s), []float64{1}, threshold)
+		fmt.Printf("new:       %s\n", s)
+	}
+
+	// Output:
+	// new:       The quarterly report is attached to this email.
+	// duplicate: The quarterly report is attached to this e-mail.
+	// new:       Lunch is served in the cafeteria at noon.
+}
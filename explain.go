@@ -0,0 +1,313 @@
+package semseg
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// Constants for GapDecision.Method: the boundary detection method that scored the gap.
+const (
+	// BoundaryMethodThreshold splits where the score falls below MinSplitSimilarity.
+	BoundaryMethodThreshold = "threshold"
+	// BoundaryMethodPercentile splits at the lowest BoundaryPercentile% of scores.
+	BoundaryMethodPercentile = "percentile"
+	// BoundaryMethodDepth splits at local minima whose dip depth reaches DepthThreshold.
+	BoundaryMethodDepth = "depth"
+)
+
+// Constants for GapDecision.SplitReason.
+const (
+	// SplitReasonNone means the sentences on both sides of the gap share a chunk.
+	SplitReasonNone = ""
+	// SplitReasonSemantic means the gap is a cohesion boundary found by the boundary method.
+	SplitReasonSemantic = "semantic"
+	// SplitReasonTopic means the text enters or leaves the TopicReference topic at the gap.
+	SplitReasonTopic = "topic"
+	// SplitReasonScriptChange means the sentences on either side are written in different
+	// scripts (see Options.SplitOnScriptChange).
+	SplitReasonScriptChange = "script_change"
+	// SplitReasonTokenLimit means the next sentence would have pushed the chunk over MaxTokens.
+	SplitReasonTokenLimit = "token_limit"
+	// SplitReasonByteLimit means the next sentence would have pushed the chunk text over
+	// MaxChunkBytes.
+	SplitReasonByteLimit = "byte_limit"
+	// SplitReasonOversizedSentence means a sentence on either side exceeds MaxTokens (or
+	// MaxChunkBytes) on its own and is therefore emitted as a separate chunk.
+	SplitReasonOversizedSentence = "oversized_sentence"
+)
+
+// Constants for Details.SingleChunkReason.
+const (
+	// SingleChunkReasonNone means the text produced no chunk or several chunks.
+	SingleChunkReasonNone = ""
+	// SingleChunkReasonOneSentence means the text has a single sentence, so there is no gap
+	// to split at.
+	SingleChunkReasonOneSentence = "one_sentence"
+	// SingleChunkReasonNoBoundary means the text fits within MaxTokens and the boundary
+	// method found no boundary: cohesion is uniform enough that no dip reached
+	// DepthThreshold (or no score fell below MinSplitSimilarity). Gaps shows the scores.
+	SingleChunkReasonNoBoundary = "no_boundary"
+	// SingleChunkReasonMaxTokensSlack means the text exceeds MaxTokens, has no boundary, and
+	// fits within MaxTokens+MaxTokensSlack, so the token-limit cut was moved to its end.
+	SingleChunkReasonMaxTokensSlack = "max_tokens_slack"
+)
+
+// Constants for Details.Warnings.
+const (
+	// WarningLowCohesionVariance means the dense cohesion scores vary less than
+	// MinDenseCohesionVariance: the embedding model likely does not suit the text (or is
+	// misconfigured), so the boundaries carry little meaning.
+	WarningLowCohesionVariance = "low_cohesion_variance"
+	// WarningEmbeddingCallLimit means the document needed more embedding calls than
+	// MaxEmbeddingCalls and was scored with TF-IDF (EmbeddingCallLimitFallback).
+	WarningEmbeddingCallLimit = "embedding_call_limit"
+)
+
+// Details holds diagnostics about a segmentation run, returned by SegmentWithDetails.
+type Details struct {
+	// DetectedLanguage is the document language used for preprocessing: Options.Language if
+	// set, otherwise the language detected according to LanguageDetectionMode. It is empty in
+	// per-sentence detection mode, and on the dense (Ollama) path unless Options.Language or
+	// LanguageDetectionTokens determined it, since dense embeddings need no language.
+	DetectedLanguage string
+
+	// Gaps explains the decision at every gap between consecutive sentences. Gaps[i] is the
+	// gap between sentence i and sentence i+1. It is empty for texts with fewer than two sentences.
+	Gaps []GapDecision
+
+	// SingleChunkReason explains why the text produced a single chunk (SingleChunkReason*).
+	// It is empty when the text produced no chunk or several chunks.
+	SingleChunkReason string
+
+	// UnknownLanguageSentences lists, in ascending order, the indices of the sentences whose
+	// language per-sentence detection could not determine. They are processed without
+	// stopword removal or stemming, which can make their cohesion with their neighbors dip;
+	// callers may reprocess, merge or flag them. It is nil unless LanguageDetectionMode is
+	// per-sentence on the TF-IDF path with Options.Language unset.
+	UnknownLanguageSentences []int
+
+	// CohesionVariance is the population variance of the gap scores (see Gaps). It is zero
+	// for texts with fewer than three sentences.
+	CohesionVariance float64
+
+	// SentenceEmbeddings holds the dense embedding of every sentence, in sentence order,
+	// for callers caching embeddings in their own storage (see EmbeddingsBySentence). It
+	// is nil on the TF-IDF path, and for single-sentence texts unless ChunkPooling is set.
+	SentenceEmbeddings []SentenceEmbedding
+
+	// Warnings lists the quality problems detected in the run (Warning*), in no particular
+	// order. It is nil when there are none.
+	Warnings []string
+}
+
+// SentenceEmbedding pairs a sentence with its dense embedding.
+type SentenceEmbedding struct {
+	// Sentence is the text sent to the embedding backend: the sentence without Markdown
+	// syntax if OutputFormat is set. With MinSentenceTokensForEmbedding, a merged short
+	// sentence carries the embedding of the group it was merged into.
+	Sentence  string
+	Embedding []float64
+}
+
+// EmbeddingsBySentence returns the SentenceEmbeddings keyed by sentence text. A sentence
+// occurring several times appears once.
+func (d *Details) EmbeddingsBySentence() map[string][]float64 {
+	m := make(map[string][]float64, len(d.SentenceEmbeddings))
+	for _, e := range d.SentenceEmbeddings {
+		m[e.Sentence] = e.Embedding
+	}
+	return m
+}
+
+// GapDecision explains why a boundary was or was not placed between two sentences.
+type GapDecision struct {
+	// Index is the position of the gap: it sits between sentence Index and sentence Index+1.
+	Index int
+
+	// Score is the cohesion score (similarity) between the two sentences.
+	Score float64
+
+	// Method is the boundary detection method that evaluated the score (BoundaryMethod*).
+	Method string
+
+	// Threshold is the value the method compared against: MinSplitSimilarity for the
+	// threshold method, DepthThreshold for the depth method, and the highest score selected
+	// as a boundary for the percentile method (-Inf if none was selected).
+	Threshold float64
+
+	// IsLocalMinimum and Depth describe the dip at this gap. They are only set by the depth
+	// method; Depth is zero unless the gap is a local minimum.
+	IsLocalMinimum bool
+	Depth          float64
+
+	// SustainedDrop reports whether the gap was detected as a step-down boundary, where the
+	// score falls and stays low (see Options.DropThreshold).
+	SustainedDrop bool
+
+	// SemanticBoundary reports whether the boundary method marked the gap as a boundary.
+	SemanticBoundary bool
+
+	// TopicBoundary reports whether the gap was additionally marked as a boundary because
+	// the text enters or leaves the TopicReference topic there.
+	TopicBoundary bool
+
+	// ScriptBoundary reports whether the gap was additionally marked as a boundary because
+	// the script changes there (see Options.SplitOnScriptChange).
+	ScriptBoundary bool
+
+	// Split reports whether the chunks were actually split at this gap, and SplitReason
+	// names the deciding factor (SplitReason*).
+	Split       bool
+	SplitReason string
+
+	// DistinguishingTerms lists, for a semantic boundary on the TF-IDF path, the terms that
+	// most set the two sides apart, strongest first (up to Options.BoundaryTermCount). It
+	// is nil for other gaps and on the dense path.
+	DistinguishingTerms []DistinguishingTerm
+}
+
+// DistinguishingTerm is a term whose weight differs between the two sides of a boundary.
+// The sides are the TF-IDF vectors compared by the cohesion score: the sentences on either
+// side of the gap, or the blocks of BlockComparisonSize sentences.
+type DistinguishingTerm struct {
+	// Term is the term as vectorized, i.e. after stemming or n-gram generation.
+	Term string
+
+	// Left and Right are the weights of the term on each side, with each side's vector
+	// normalized to unit length. The term is ranked by the difference between them.
+	Left, Right float64
+}
+
+// SegmentWithDetails is like Segment but also returns diagnostics explaining the decision
+// taken at every sentence gap, for debugging unexpected chunk boundaries.
+func SegmentWithDetails(textStr string, opts Options) ([]Chunk, *Details, error) {
+	details := &Details{}
+	chunks, err := segment(context.Background(), segmentInput{text: textStr}, opts, details)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chunks, details, nil
+}
+
+// sentenceEmbeddings pairs the embedded sentences of doc with their vectors, or returns nil
+// if the sentences were not embedded.
+func (doc *scoredDocument) sentenceEmbeddings() []SentenceEmbedding {
+	if len(doc.vectors) == 0 {
+		return nil
+	}
+	embeddings := make([]SentenceEmbedding, len(doc.vectors))
+	for i, vector := range doc.vectors {
+		embeddings[i] = SentenceEmbedding{Sentence: doc.embedded[i], Embedding: vector}
+	}
+	return embeddings
+}
+
+// explainSingleChunk sets SingleChunkReason if chunks holds a single chunk. It is a no-op
+// on nil Details (details were not requested).
+func (d *Details) explainSingleChunk(chunks []Chunk, opts Options) {
+	if d == nil || len(chunks) != 1 {
+		return
+	}
+	switch {
+	case len(chunks[0].SentenceIndices) == 1:
+		d.SingleChunkReason = SingleChunkReasonOneSentence
+	case chunks[0].NumTokens > opts.MaxTokens:
+		d.SingleChunkReason = SingleChunkReasonMaxTokensSlack
+	default:
+		d.SingleChunkReason = SingleChunkReasonNoBoundary
+	}
+}
+
+// checkCohesionVariance sets CohesionVariance from the scores of doc and warns when a
+// dense-scored text falls below opts.MinDenseCohesionVariance.
+func (d *Details) checkCohesionVariance(doc *scoredDocument, opts Options) {
+	if len(doc.scores) < 2 {
+		return
+	}
+	mean := 0.0
+	for _, score := range doc.scores {
+		mean += score
+	}
+	mean /= float64(len(doc.scores))
+	for _, score := range doc.scores {
+		d.CohesionVariance += (score - mean) * (score - mean)
+	}
+	d.CohesionVariance /= float64(len(doc.scores))
+	if doc.dense && d.CohesionVariance < opts.MinDenseCohesionVariance {
+		d.Warnings = append(d.Warnings, WarningLowCohesionVariance)
+	}
+}
+
+// explainBoundaryTerms fills DistinguishingTerms for the semantic boundaries in gaps from
+// the TF-IDF sentence vectors. It is a no-op when gaps or vectors is nil.
+func explainBoundaryTerms(gaps []GapDecision, vectors []map[string]float64, opts Options) {
+	if gaps == nil || vectors == nil {
+		return
+	}
+	for i := range gaps {
+		if !gaps[i].SemanticBoundary {
+			continue
+		}
+		left, right := vectors[i], vectors[i+1]
+		if opts.BlockComparisonSize > 1 {
+			start, end := blockWindows(i, len(vectors), opts.BlockComparisonSize)
+			left, right = sumSparse(vectors[start:i+1]), sumSparse(vectors[i+1:end])
+		}
+		gaps[i].DistinguishingTerms = distinguishingTerms(left, right, opts.BoundaryTermCount)
+	}
+}
+
+// distinguishingTerms returns the up to n terms with the largest difference between their
+// normalized weights in left and right, ties broken by term.
+func distinguishingTerms(left, right map[string]float64, n int) []DistinguishingTerm {
+	leftNorm, rightNorm := sparseNorm(left), sparseNorm(right)
+	var terms []DistinguishingTerm
+	add := func(term string) {
+		t := DistinguishingTerm{Term: term}
+		if leftNorm > 0 {
+			t.Left = left[term] / leftNorm
+		}
+		if rightNorm > 0 {
+			t.Right = right[term] / rightNorm
+		}
+		if t.Left != t.Right {
+			terms = append(terms, t)
+		}
+	}
+	for term := range left {
+		add(term)
+	}
+	for term := range right {
+		if _, ok := left[term]; !ok {
+			add(term)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		di, dj := math.Abs(terms[i].Left-terms[i].Right), math.Abs(terms[j].Left-terms[j].Right)
+		if di != dj {
+			return di > dj
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	return terms[:min(n, len(terms))]
+}
+
+// sparseNorm returns the Euclidean norm of v.
+func sparseNorm(v map[string]float64) float64 {
+	sum := 0.0
+	for _, weight := range v {
+		sum += weight * weight
+	}
+	return math.Sqrt(sum)
+}
+
+// recordSplit marks the gap at index as split for reason. It is a no-op when gaps is nil
+// (details were not requested) or the index is outside the document.
+func recordSplit(gaps []GapDecision, index int, reason string) {
+	if index < 0 || index >= len(gaps) {
+		return
+	}
+	gaps[index].Split = true
+	gaps[index].SplitReason = reason
+}
@@ -0,0 +1,113 @@
+package semseg
+
+import "sort"
+
+// BoundaryFilter refines the semantic boundaries found by the boundary method. scores[i] is
+// the cohesion score of the gap between sentence i and sentence i+1, and boundaries marks
+// the gaps currently chosen as boundaries. A filter returns the refined boundaries; it may
+// modify and return the map it was given. Filters are set with Options.BoundaryFilters.
+type BoundaryFilter func(scores []float64, boundaries map[int]bool, opts Options) map[int]bool
+
+// applyBoundaryFilters runs the filters of opts over boundaries in order.
+func applyBoundaryFilters(scores []float64, boundaries map[int]bool, opts Options) map[int]bool {
+	for _, filter := range opts.BoundaryFilters {
+		boundaries = filter(scores, boundaries, opts)
+		if boundaries == nil {
+			boundaries = make(map[int]bool)
+		}
+	}
+	return boundaries
+}
+
+// MinSegmentFilter returns a filter that removes boundaries leaving fewer than n sentences
+// between two boundaries (or a text edge). Boundaries are kept greedily from the start of
+// the text; if the final segment is too short, the last kept boundary is removed.
+func MinSegmentFilter(n int) BoundaryFilter {
+	return func(scores []float64, boundaries map[int]bool, _ Options) map[int]bool {
+		kept := make(map[int]bool)
+		last := -1 // last kept boundary
+		for _, i := range sortedBoundaries(boundaries) {
+			if i-last >= n {
+				kept[i] = true
+				last = i
+			}
+		}
+		if last >= 0 && len(scores)-last < n {
+			delete(kept, last)
+		}
+		return kept
+	}
+}
+
+// MaxSegmentFilter returns a filter that adds boundaries until no segment holds more than n
+// sentences, splitting each overlong segment at its lowest-scoring gap.
+func MaxSegmentFilter(n int) BoundaryFilter {
+	return func(scores []float64, boundaries map[int]bool, _ Options) map[int]bool {
+		if n <= 0 {
+			return boundaries
+		}
+		var split func(first, last int) // sentences first..last form one segment
+		split = func(first, last int) {
+			if last-first+1 <= n {
+				return
+			}
+			lowest := first
+			for i := first + 1; i < last; i++ {
+				if scores[i] < scores[lowest] {
+					lowest = i
+				}
+			}
+			boundaries[lowest] = true
+			split(first, lowest)
+			split(lowest+1, last)
+		}
+		first := 0
+		for _, i := range append(sortedBoundaries(boundaries), len(scores)) {
+			split(first, i)
+			first = i + 1
+		}
+		return boundaries
+	}
+}
+
+// PercentileFilter returns a filter that keeps only the boundaries whose score is among the
+// lowest percentile% of all scores, like BoundaryPercentile does for the whole text.
+func PercentileFilter(percentile float64) BoundaryFilter {
+	return func(scores []float64, boundaries map[int]bool, _ Options) map[int]bool {
+		kept := make(map[int]bool)
+		for _, i := range lowestScoreIndices(scores, percentile) {
+			if boundaries[i] {
+				kept[i] = true
+			}
+		}
+		return kept
+	}
+}
+
+// DropFilter returns a filter that adds the step-down boundaries DropThreshold detects (see
+// Options.DropThreshold), for boundary methods that lack them. A window of 0 means 3.
+func DropFilter(threshold float64, window int) BoundaryFilter {
+	if window <= 0 {
+		window = 3
+	}
+	return func(scores []float64, boundaries map[int]bool, _ Options) map[int]bool {
+		for i := range scores {
+			if isSustainedDrop(scores, i, threshold, window) {
+				boundaries[i] = true
+			}
+		}
+		return boundaries
+	}
+}
+
+// sortedBoundaries returns the boundary gaps in ascending order.
+func sortedBoundaries(boundaries map[int]bool) []int {
+	var indices []int
+	for i, ok := range boundaries {
+		if ok {
+			indices = append(indices, i)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
@@ -0,0 +1,60 @@
+package semseg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultChunkDelimiter is the marker line FormatChunks writes before each chunk when
+// FormatOptions.Delimiter is empty.
+const DefaultChunkDelimiter = "--- chunk ---"
+
+// FormatOptions configures FormatChunks.
+type FormatOptions struct {
+	// Delimiter is the marker line written before each chunk. It should not occur as a line
+	// of the text. Default: DefaultChunkDelimiter.
+	Delimiter string
+
+	// Stats appends the chunk's token count, sentence count and cohesion to its marker line,
+	// e.g. "--- chunk --- tokens=12 sentences=3 cohesion=0.871". Default: false.
+	Stats bool
+}
+
+// FormatChunks renders chunks as plain text for reviewing segmentation changes, e.g.
+// across configuration versions in code review: each chunk is a marker line followed by
+// its sentences, one per line with any SentencePunctuation restored (or its Text if
+// Sentences is nil). The marker carries no chunk number, so a moved boundary shows in a
+// diff as one moved marker line rather than as a change to every later chunk. The output
+// is deterministic for a given chunk set.
+func FormatChunks(chunks []Chunk, opts FormatOptions) string {
+	delimiter := opts.Delimiter
+	if delimiter == "" {
+		delimiter = DefaultChunkDelimiter
+	}
+	var b strings.Builder
+	for _, chunk := range chunks {
+		b.WriteString(delimiter)
+		if opts.Stats {
+			numSentences := len(chunk.Sentences)
+			if chunk.Sentences == nil {
+				numSentences = len(chunk.SentenceIndices)
+			}
+			b.WriteString(" tokens=" + strconv.Itoa(chunk.NumTokens))
+			b.WriteString(" sentences=" + strconv.Itoa(numSentences))
+			b.WriteString(" cohesion=" + strconv.FormatFloat(chunk.Cohesion, 'f', 3, 64))
+		}
+		b.WriteByte('\n')
+		lines := chunk.Sentences
+		if lines == nil {
+			lines = []string{chunk.Text}
+		}
+		for i, line := range lines {
+			b.WriteString(line)
+			if i < len(chunk.SentencePunctuation) {
+				b.WriteString(chunk.SentencePunctuation[i])
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
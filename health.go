@@ -0,0 +1,72 @@
+package semseg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// PingEmbeddingBackend checks that the configured embedding backend can serve requests: it
+// embeds a short probe text with opts.EmbeddingProvider if set, otherwise with the Ollama
+// model from CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, using opts.HTTPClient if set. It
+// returns an error describing the problem if the server is unreachable, the model is
+// missing or no embedding comes back.
+//
+// When no embedding backend is configured, Segment uses TF-IDF, which needs no external
+// service, and PingEmbeddingBackend returns nil. It is meant for readiness checks, so that
+// misconfiguration surfaces at startup rather than on the first request.
+func PingEmbeddingBackend(ctx context.Context, opts Options) error {
+	if opts.EmbeddingProvider != nil {
+		embedding, err := opts.EmbeddingProvider.Embed(ctx, "ping")
+		if err != nil {
+			return fmt.Errorf("embedding provider is unavailable: %w", err)
+		}
+		if len(embedding) == 0 {
+			return errors.New("embedding provider returned an empty embedding")
+		}
+		return nil
+	}
+
+	ollamaURL := os.Getenv("CHUNKER_OLLAMA_URL")
+	ollamaModel := os.Getenv("CHUNKER_OLLAMA_MODEL")
+	if ollamaURL == "" || ollamaModel == "" {
+		return nil
+	}
+
+	reqBody, err := json.Marshal(ollamaRequest{Model: ollamaModel, Prompt: "ping"})
+	if err != nil {
+		return fmt.Errorf("failed to marshal ollama ping request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaEmbeddingsURL(ollamaURL), bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create ollama ping request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaHTTPClient(opts).Do(req)
+	if err != nil {
+		return fmt.Errorf("ollama backend at %s is unreachable: %w", ollamaURL, err)
+	}
+	defer resp.Body.Close()
+
+	// Ollama reports a missing model as a non-200 status with an error message in the body.
+	var ollamaResp ollamaResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&ollamaResp)
+	if ollamaResp.Error != "" {
+		return fmt.Errorf("ollama model %q is not available: %s", ollamaModel, ollamaResp.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama backend returned non-200 status: %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode ollama ping response: %w", decodeErr)
+	}
+	if len(ollamaResp.Embedding) == 0 {
+		return fmt.Errorf("ollama model %q returned an empty embedding", ollamaModel)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package semseg
+
+import (
+	"context"
+	"strings"
+)
+
+// TFIDFModel is the TF-IDF model built for a text, returned by InspectTFIDF to debug
+// cohesion scores on the TF-IDF path.
+type TFIDFModel struct {
+	// Sentences are the sentences of the text, split exactly like Segment splits them.
+	Sentences []string
+
+	// Language is the document language used for preprocessing, as in
+	// Details.DetectedLanguage.
+	Language string
+
+	// Vocabulary maps every term of the corpus (the preprocessed tokens and n-grams of all
+	// sentences) to its IDF weight under Options.IDFFormula and IDFSmoothing. Stopwords
+	// kept for StopWordWeight appear like other terms.
+	Vocabulary map[string]float64
+
+	// Vectors holds the TF-IDF vector of each sentence, as used for cohesion scoring
+	// (StopWordWeight applied). It is nil unless requested.
+	Vectors []map[string]float64
+}
+
+// InspectTFIDF builds the TF-IDF model that Segment uses for text on the TF-IDF path and
+// returns its vocabulary with IDF weights and, if withVectors is set, the sentence vectors.
+// It always uses TF-IDF, even when a dense embedding backend is configured.
+func InspectTFIDF(text string, opts Options, withVectors bool) (*TFIDFModel, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: text}
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, err
+	}
+
+	textStr, sentences, globalDetectedLang := prepareSentences(callContext(context.Background(), opts), in, opts)
+	analysis := sentences
+	if opts.OutputFormat != "" {
+		analysis, sentences = markdownSentences(sentences, opts.OutputFormat)
+		textStr = strings.Join(analysis, " ")
+	}
+	opts.OnProgress = nil
+	model := &TFIDFModel{Sentences: sentences, Vocabulary: map[string]float64{}}
+	if len(sentences) == 0 {
+		return model, nil
+	}
+
+	model.Language = resolveDocumentLanguage(textStr, analysis, opts, globalDetectedLang)
+	vectors, _, vocabulary := tfidfVectors(tfidfSentenceTokens(analysis, opts, model.Language, sentenceLanguages(analysis, opts)), opts)
+	for term, idf := range vocabulary() {
+		model.Vocabulary[publicTerm(term)] = idf
+	}
+	if withVectors {
+		model.Vectors = make([]map[string]float64, len(vectors))
+		for i, v := range vectors {
+			model.Vectors[i] = make(map[string]float64, len(v))
+			for term, weight := range v {
+				model.Vectors[i][publicTerm(term)] = weight
+			}
+		}
+	}
+	return model, nil
+}
+
+// publicTerm removes the internal stopword marker (see stopWordPrefix) from a term.
+func publicTerm(term string) string {
+	return strings.TrimPrefix(term, stopWordPrefix)
+}
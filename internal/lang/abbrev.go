@@ -3,6 +3,8 @@ package lang
 import (
 	"regexp"
 	"strings"
+
+	"github.com/cmsdko/semseg/internal/text"
 )
 
 // Abbreviation/acronym normalization prior to sentence splitting.
@@ -11,6 +13,8 @@ import (
 // - Removes dots from language-specific dotted contractions (from JSON), e.g. "e.g." -> "eg", "т.е." -> "те".
 // - Removes dots from ALL-caps dotted acronyms in Latin/Cyrillic scripts, e.g. "U.S.A." -> "USA", "П.Т.О." -> "ПТО".
 // - Preserves ellipses ("...") by temporarily masking them.
+// - Strips the private-use runes reserved for internal placeholders (see text.StripSentinels),
+//   so user text cannot collide with the ellipsis mask.
 //
 // What it does NOT do:
 // - It does not touch numeric decimals (e.g. "3.14") or version/IP patterns; decimal protection is handled in text.SplitSentences.
@@ -18,6 +22,7 @@ import (
 //
 // Notes:
 // - Language-specific replacements are applied only if `langCode` is known and present in `contractionsByLang`.
+// - Extra abbreviations passed to NormalizeAbbreviationsWithExtra are merged with that list and apply for any `langCode`.
 // - Generic dotted-acronym removal is purely regex-based and language-agnostic for Latin/Cyrillic uppercase.
 // - Keep this step lightweight: the goal is to avoid false sentence splits on dotted abbreviations, not to fully normalize text.
 
@@ -32,16 +37,27 @@ var (
 // NormalizeAbbreviations removes dots from known contractions and dotted acronyms.
 // Ellipses are preserved by masking them before replacements and restoring afterwards.
 func NormalizeAbbreviations(s, langCode string) string {
+	return NormalizeAbbreviationsWithExtra(s, langCode, nil)
+}
+
+// NormalizeAbbreviationsWithExtra is like NormalizeAbbreviations but also removes the dots
+// from extra, additional dotted abbreviations (e.g. "Fig.", "Eq.") merged with the
+// language's contractions. Entries without a dot are ignored, as in the JSON data.
+func NormalizeAbbreviationsWithExtra(s, langCode string, extra []string) string {
 	if s == "" {
 		return s
 	}
 
+	// Sanitize before masking so existing sentinel runes cannot be mistaken for the mask.
+	s = text.StripSentinels(s)
+
 	// Preserve ellipses so they are not altered by replacements below.
 	s = reEllipsis.ReplaceAllString(s, ellipsisToken)
 
-	// Language-specific dotted contractions (from JSON).
-	// Applied only when langCode is known and the list is non-empty.
-	if list, ok := contractionsByLang[langCode]; ok && len(list) > 0 {
+	// Language-specific dotted contractions (from JSON) plus the caller's extra entries.
+	// The JSON list applies only when langCode is known.
+	list := append(append([]string(nil), contractionsByLang[langCode]...), extra...)
+	if len(list) > 0 {
 		repl := make([]string, 0, len(list)*2)
 		for _, c := range list {
 			if strings.Contains(c, ".") {
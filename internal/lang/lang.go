@@ -23,11 +23,19 @@ type StemmingRules struct {
 	OneShot  bool     `json:"one_shot"` // if true, stop after the first successful prefix/suffix removal
 }
 
+// TokenizationRules defines optional language-specific tokenization adjustments.
+// Languages without rules are tokenized with the shared text.Tokenize.
+type TokenizationRules struct {
+	Elisions      []string `json:"elisions"`       // elided prefixes split into their own token, e.g. "l'"
+	CompoundParts []string `json:"compound_parts"` // known compound constituents, e.g. "haus", "tür"
+}
+
 // LanguageData groups all language resources loaded from JSON.
 type LanguageData struct {
-	Stopwords    []string      `json:"stopwords"`
-	Stemming     StemmingRules `json:"stemming"`
-	Contractions []string      `json:"contractions"` // dotted contractions for abbreviation normalization
+	Stopwords    []string          `json:"stopwords"`
+	Stemming     StemmingRules     `json:"stemming"`
+	Contractions []string          `json:"contractions"` // dotted contractions for abbreviation normalization
+	Tokenization TokenizationRules `json:"tokenization"`
 }
 
 // --- EMBEDDED DATA ---
@@ -79,6 +87,9 @@ var (
 	// contractionsByLang stores dotted contractions per language (e.g., "e.g.", "т.е.").
 	contractionsByLang map[string][]string
 
+	// tokenizeOptionsByLang stores language-specific tokenization rules (only for languages that define any).
+	tokenizeOptionsByLang map[string]text.TokenizeOptions
+
 	// langsByScript lists languages mapped to a detected script (heuristic; built from stopwords).
 	// Example: "Cyrillic" -> ["russian", "ukrainian"]
 	langsByScript map[string][]string
@@ -122,6 +133,7 @@ func init() {
 	stopWordsByLang = make(map[string]map[string]struct{})
 	stemmingRulesByLang = make(map[string]StemmingRules)
 	contractionsByLang = make(map[string][]string)
+	tokenizeOptionsByLang = make(map[string]text.TokenizeOptions)
 	langsByScript = make(map[string][]string)
 
 	// Heuristically determine the primary script used by each language from its stopwords.
@@ -193,6 +205,19 @@ func init() {
 		if len(data.Contractions) > 0 {
 			contractionsByLang[lang] = append([]string(nil), data.Contractions...)
 		}
+
+		// Tokenization rules. Elisions are function words, so they are also treated as
+		// stopwords for removal (but not added to the detection index).
+		tr := data.Tokenization
+		if len(tr.Elisions) > 0 || len(tr.CompoundParts) > 0 {
+			tokenizeOptionsByLang[lang] = text.TokenizeOptions{
+				Elisions:      tr.Elisions,
+				CompoundParts: tr.CompoundParts,
+			}
+			for _, e := range tr.Elisions {
+				wordSet[e] = struct{}{}
+			}
+		}
 	}
 }
 
@@ -205,8 +230,19 @@ func init() {
 // 3) Count stopword matches per candidate language using an inverted index + bitmasks.
 // 4) If the best score < ConfidenceThreshold or there is a tie for best, return "unknown".
 func DetectLanguage(sentence string) string {
+	return DetectLanguageAmong(sentence, nil)
+}
+
+// DetectLanguageAmong is like DetectLanguage but only scores the given candidate languages
+// instead of narrowing by script. Callers that know their corpus uses a few languages avoid
+// scoring every language of the script, and ties with languages outside the set. An empty
+// candidates slice behaves like DetectLanguage.
+func DetectLanguageAmong(sentence string, candidates []string) string {
 	// 1) Narrow by script to reduce comparisons.
-	candidateLangs := getCandidateLangs(sentence)
+	candidateLangs := candidates
+	if len(candidateLangs) == 0 {
+		candidateLangs = getCandidateLangs(sentence)
+	}
 
 	// 2) Tokenize with the canonical tokenizer.
 	tokens := text.Tokenize(sentence)
@@ -252,15 +288,63 @@ func DetectLanguage(sentence string) string {
 	return bestLang
 }
 
-// RemoveStopWords removes known stopwords for the specified language.
-// If the language is unknown/unsupported, the original sentence is returned.
-func RemoveStopWords(sentence string, language string) string {
+// Tokenize tokenizes a sentence with the language's tokenization rules from the JSON data
+// (elision splitting, compound decomposition), lowercasing with the language's case rules
+// (see text.ToLower). Languages without rules, and unknown languages, are tokenized like the
+// shared text.Tokenize. keepSeparators keeps in-word separators inside tokens (see
+// text.TokenizeOptions.KeepSeparators).
+func Tokenize(sentence string, language string, keepSeparators bool) []string {
+	opts := tokenizeOptionsByLang[language]
+	opts.KeepSeparators = keepSeparators
+	opts.Language = language
+	return text.TokenizeWithOptions(sentence, opts)
+}
+
+// Script returns the script most letters of s are written in ("Latin", "Cyrillic", ...),
+// or "" if s has no letter of a known script. Han, Hiragana and Katakana count as "Han",
+// since Japanese sentences mix kanji and kana in varying proportions.
+func Script(s string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, r := range s {
+		script := runeScript(r)
+		if script == scriptHiragana || script == scriptKatakana {
+			script = scriptHan
+		}
+		if script == "" {
+			continue
+		}
+		counts[script]++
+		if counts[script] > counts[best] {
+			best = script
+		}
+	}
+	return best
+}
+
+// IsSupported reports whether language has data (stopwords and rules) in the JSON file.
+func IsSupported(language string) bool {
+	_, ok := languageMasks[language]
+	return ok
+}
+
+// IsStopWord reports whether token (lowercase, as produced by Tokenize) is a stopword of
+// the specified language. It is always false for unknown or unsupported languages.
+func IsStopWord(token string, language string) bool {
+	_, ok := stopWordsByLang[language][token]
+	return ok
+}
+
+// RemoveStopWords removes known stopwords for the specified language, tokenizing with
+// keepSeparators as Tokenize does. If the language is unknown/unsupported, the original
+// sentence is returned.
+func RemoveStopWords(sentence string, language string, keepSeparators bool) string {
 	stopWords, ok := stopWordsByLang[language]
 	if !ok || language == LangUnknown {
 		return sentence
 	}
 
-	tokens := text.Tokenize(sentence)
+	tokens := Tokenize(sentence, language, keepSeparators)
 	resultTokens := make([]string, 0, len(tokens))
 
 	for _, token := range tokens {
@@ -271,13 +355,32 @@ func RemoveStopWords(sentence string, language string) string {
 	return strings.Join(resultTokens, " ")
 }
 
+// StemOverrides replaces parts of a language's loaded stemming rules for a single call.
+// Zero values keep the rules from the JSON data.
+type StemOverrides struct {
+	MinLen  int   // replaces StemmingRules.MinLen when > 0
+	OneShot *bool // replaces StemmingRules.OneShot when non-nil
+}
+
 // StemTokens applies lightweight stemming to tokens for the given language.
 // Rules are affix-based and may over-stem in edge cases; this is by design for speed/simplicity.
 func StemTokens(tokens []string, language string) []string {
+	return StemTokensWithOverrides(tokens, language, StemOverrides{})
+}
+
+// StemTokensWithOverrides is like StemTokens but applies overrides on top of the
+// language's stemming rules.
+func StemTokensWithOverrides(tokens []string, language string, overrides StemOverrides) []string {
 	rules, ok := stemmingRulesByLang[language]
 	if !ok || (len(rules.Prefixes) == 0 && len(rules.Suffixes) == 0) {
 		return tokens
 	}
+	if overrides.MinLen > 0 {
+		rules.MinLen = overrides.MinLen
+	}
+	if overrides.OneShot != nil {
+		rules.OneShot = *overrides.OneShot
+	}
 
 	stemmedTokens := make([]string, len(tokens))
 	for i, token := range tokens {
@@ -331,29 +434,7 @@ func stemWord(word string, rules StemmingRules) string {
 // as a last resort, fall back to all loaded languages.
 func getCandidateLangs(s string) []string {
 	for _, r := range s {
-		var script string
-		switch {
-		case unicode.Is(unicode.Cyrillic, r):
-			script = scriptCyrillic
-		case unicode.Is(unicode.Arabic, r):
-			script = scriptArabic
-		case unicode.Is(unicode.Greek, r):
-			script = scriptGreek
-		case unicode.Is(unicode.Devanagari, r):
-			script = scriptDevanagari
-		case unicode.Is(unicode.Hebrew, r):
-			script = scriptHebrew
-		case unicode.Is(unicode.Han, r):
-			script = scriptHan
-		case unicode.Is(unicode.Katakana, r):
-			script = scriptKatakana
-		case unicode.Is(unicode.Hiragana, r):
-			script = scriptHiragana
-		case unicode.Is(unicode.Hangul, r):
-			script = scriptHangul
-		}
-
-		if script != "" {
+		if script := runeScript(r); script != "" && script != scriptLatin {
 			if candidates, ok := langsByScript[script]; ok {
 				return candidates
 			}
@@ -369,6 +450,34 @@ func getCandidateLangs(s string) []string {
 	return allLangsList
 }
 
+// runeScript returns the script constant of a letter, or "" for other runes and
+// letters of scripts without a constant.
+func runeScript(r rune) string {
+	switch {
+	case unicode.Is(unicode.Latin, r):
+		return scriptLatin
+	case unicode.Is(unicode.Cyrillic, r):
+		return scriptCyrillic
+	case unicode.Is(unicode.Arabic, r):
+		return scriptArabic
+	case unicode.Is(unicode.Greek, r):
+		return scriptGreek
+	case unicode.Is(unicode.Devanagari, r):
+		return scriptDevanagari
+	case unicode.Is(unicode.Hebrew, r):
+		return scriptHebrew
+	case unicode.Is(unicode.Han, r):
+		return scriptHan
+	case unicode.Is(unicode.Katakana, r):
+		return scriptKatakana
+	case unicode.Is(unicode.Hiragana, r):
+		return scriptHiragana
+	case unicode.Is(unicode.Hangul, r):
+		return scriptHangul
+	}
+	return ""
+}
+
 // isCandidate returns true if lang exists in the candidates slice.
 func isCandidate(lang string, candidates []string) bool {
 	for _, c := range candidates {
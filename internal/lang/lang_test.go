@@ -33,6 +33,25 @@ func TestDetectLanguage(t *testing.T) {
 	}
 }
 
+// TestDetectLanguageAmong checks that restricting the candidate languages resolves a tie
+// between Latin-script languages sharing stopwords, and that nil candidates keep the
+// script-based narrowing.
+func TestDetectLanguageAmong(t *testing.T) {
+	sentence := "Die Hand in der Nacht" // "die", "in" and "der" are German and Dutch stopwords
+	if got := DetectLanguage(sentence); got != LangUnknown {
+		t.Fatalf("Expected a tie without narrowing, got %q", got)
+	}
+	if got := DetectLanguageAmong(sentence, []string{"german", "english"}); got != "german" {
+		t.Errorf("Expected german among german/english, got %q", got)
+	}
+	if got := DetectLanguageAmong("Это пример предложения для определения языка.", nil); got != "russian" {
+		t.Errorf("Expected nil candidates to behave like DetectLanguage, got %q", got)
+	}
+	if !IsSupported("english") || IsSupported("klingon") {
+		t.Error("Expected IsSupported to reflect the JSON data")
+	}
+}
+
 // TestRemoveStopWords checks stopword removal for supported and unsupported languages.
 func TestRemoveStopWords(t *testing.T) {
 	testCases := []struct {
@@ -69,7 +88,7 @@ func TestRemoveStopWords(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			cleaned := RemoveStopWords(tc.sentence, tc.lang)
+			cleaned := RemoveStopWords(tc.sentence, tc.lang, false)
 			if cleaned != tc.expected {
 				t.Errorf("Expected cleaned sentence '%s', but got '%s'", tc.expected, cleaned)
 			}
@@ -77,6 +96,36 @@ func TestRemoveStopWords(t *testing.T) {
 	}
 }
 
+// TestTokenizeLanguageRules verifies JSON-driven tokenization rules:
+// French elisions are split off (and removed as stopwords), German compounds are decomposed,
+// and languages without rules fall back to the shared tokenizer.
+func TestTokenizeLanguageRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		sentence string
+		lang     string
+		expected []string
+	}{
+		{"French elisions", "L'état de l'art", "french", []string{"l'", "état", "de", "l'", "art"}},
+		{"German compounds", "Die Haustür am Bahnhof", "german", []string{"die", "haus", "tür", "am", "bahn", "hof"}},
+		{"No rules", "Don't stop", "english", []string{"don't", "stop"}},
+		{"Unknown language", "L'état", LangUnknown, []string{"l'état"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens := Tokenize(tc.sentence, tc.lang, false)
+			if !reflect.DeepEqual(tokens, tc.expected) {
+				t.Errorf("Expected tokens %v, but got %v", tc.expected, tokens)
+			}
+		})
+	}
+
+	if got := RemoveStopWords("L'état de l'art", "french", false); got != "état art" {
+		t.Errorf("Expected elisions to be removed as stopwords, got %q", got)
+	}
+}
+
 // TestStemTokens verifies stemming behavior for different languages and edge cases.
 // Uses lightweight affix-based rules defined in stopwords.json.
 func TestStemTokens(t *testing.T) {
@@ -133,3 +182,92 @@ func TestStemTokens(t *testing.T) {
 		})
 	}
 }
+
+// TestStemTokensWithOverrides checks that per-call overrides replace the JSON rules:
+// a higher MinLen protects shorter words, and OneShot can be turned off.
+func TestStemTokensWithOverrides(t *testing.T) {
+	oneShotOff := false
+	testCases := []struct {
+		name      string
+		overrides StemOverrides
+		tokens    []string
+		expected  []string
+	}{
+		{"No overrides", StemOverrides{}, []string{"running", "nationalization"}, []string{"runn", "nation"}},
+		{"Higher MinLen", StemOverrides{MinLen: 8}, []string{"running", "nationalization"}, []string{"running", "nation"}},
+		{"OneShot from rules", StemOverrides{}, []string{"housesly"}, []string{"houses"}},
+		{"OneShot disabled", StemOverrides{OneShot: &oneShotOff}, []string{"housesly"}, []string{"hou"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stemmed := StemTokensWithOverrides(tc.tokens, "english", tc.overrides)
+			if !reflect.DeepEqual(stemmed, tc.expected) {
+				t.Errorf("Expected stemmed tokens %v, but got %v", tc.expected, stemmed)
+			}
+		})
+	}
+
+	// Overrides must not leak into the shared rules.
+	if got := StemTokens([]string{"running"}, "english"); got[0] != "runn" {
+		t.Errorf("Expected shared rules to be unchanged, got %v", got)
+	}
+}
+
+// TestNormalizeAbbreviationsSentinelRunes verifies that input already containing the
+// ellipsis placeholder (or bare sentinel runes) is not corrupted on restore.
+func TestNormalizeAbbreviationsSentinelRunes(t *testing.T) {
+	input := "Wait \uE000ELLIPSIS\uE000 for the U.S.A. team... ok\uE001"
+	expected := "Wait ELLIPSIS for the USA. team... ok"
+	if got := NormalizeAbbreviations(input, "english"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// TestNormalizeAbbreviationsWithExtra checks that extra abbreviations are normalized on top
+// of the language's contractions, also for unknown languages.
+func TestNormalizeAbbreviationsWithExtra(t *testing.T) {
+	extra := []string{"Fig.", "Eq.", "noDot"}
+	testCases := []struct {
+		name     string
+		input    string
+		lang     string
+		expected string
+	}{
+		{"Extra and JSON entries", "See Fig. 3, e.g. the top.", "english", "See Fig 3, eg the top."},
+		{"Unknown language", "As in Eq. 2...", LangUnknown, "As in Eq 2..."},
+		{"Entry without dot ignored", "noDot here.", "english", "noDot here."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NormalizeAbbreviationsWithExtra(tc.input, tc.lang, extra); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	if got := NormalizeAbbreviations("See Fig. 3.", "english"); got != "See Fig. 3." {
+		t.Errorf("Expected extra entries not to leak into NormalizeAbbreviations, got %q", got)
+	}
+}
+
+// TestScript checks the dominant script of sentences, including mixed ones.
+func TestScript(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"The report covers sales.", "Latin"},
+		{"Отчёт охватывает продажи.", "Cyrillic"},
+		{"Компания Apple выпустила новый телефон.", "Cyrillic"},
+		{"Release iOS 18 für das iPhone, сказал он.", "Latin"},
+		{"私は学生です。", "Han"},
+		{"2024 — 42%!", ""},
+	}
+	for _, tc := range testCases {
+		if got := Script(tc.input); got != tc.expected {
+			t.Errorf("Script(%q) = %q, want %q", tc.input, got, tc.expected)
+		}
+	}
+}
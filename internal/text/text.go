@@ -3,9 +3,14 @@
 package text
 
 import (
+	"html"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // sentenceEndRegex detects sentence boundaries.
@@ -21,27 +26,242 @@ var sentenceEndRegex = regexp.MustCompile(`([.!?…])([”"»']*)\s+|([.!?…])(
 // - Strips other punctuation and symbols
 var tokenizeCleanRegex = regexp.MustCompile(`[^\p{L}\p{N}\s\-']`)
 
+// InWordSeparators are the characters that join words without spaces, as in "TCP/IP",
+// "R&D" or "snake_case". Tokenize drops them, fusing the words; SplitSeparators and
+// TokenizeOptions.KeepSeparators handle them instead.
+const InWordSeparators = "/&_"
+
+// tokenizeKeepRegex is tokenizeCleanRegex, additionally preserving InWordSeparators.
+var tokenizeKeepRegex = regexp.MustCompile(`[^\p{L}\p{N}\s\-'/&_]`)
+
 // Decimal dot protection.
 // Before sentence splitting, protect number patterns like "3.14"
 // so they are not mistaken for sentence boundaries.
+// Only a dot between two digits is masked. Since sentenceEndRegex requires whitespace (or
+// the end of text) after the punctuation, the mask never hides a boundary that would
+// otherwise be found: "chapter 1. Next" still splits, while "1.Next" only does with
+// SplitOptions.NumberDotCapital.
 var (
 	reDecimalDot    = regexp.MustCompile(`(\d)\.(\d)`)
-	decimalDotToken = "\uE001DECIMAL_DOT\uE001"
+	decimalDotToken = string(sentinelRune) + "DECIMAL_DOT" + string(sentinelRune)
+
+	// reNumberDotCapital matches a number followed by a dot and an uppercase letter with no
+	// space in between ("1.First"), see SplitOptions.NumberDotCapital.
+	reNumberDotCapital = regexp.MustCompile(`\d+\.\p{Lu}`)
+)
+
+// Internal placeholder tokens are delimited by private-use code points from the
+// reserved range [firstSentinelRune, lastSentinelRune]. Every rune in that range is
+// stripped from user input (see StripSentinels) so crafted text can never collide
+// with, or be restored as, a placeholder.
+const (
+	firstSentinelRune = '\uE000'
+	lastSentinelRune  = '\uE00F'
+
+	// sentinelRune delimits the decimal-dot placeholder used by SplitSentences.
+	sentinelRune = '\uE001'
 )
 
 // SplitSentences splits text into sentences based on punctuation rules.
+// - Strips the internal sentinel rune from the input
 // - Protects decimal numbers (3.14) before splitting
 // - Restores them after splitting
 // - Trims whitespace around sentences
 func SplitSentences(text string) []string {
-	// Protect decimal dots so they are not treated as boundaries.
-	protected := reDecimalDot.ReplaceAllString(text, `$1`+decimalDotToken+`$2`)
+	return SplitSentencesWithOptions(text, SplitOptions{})
+}
+
+// SplitOptions holds optional sentence splitting rules applied by SplitSentencesWithOptions.
+// The zero value reproduces SplitSentences exactly.
+type SplitOptions struct {
+	// QuoteAware keeps quoted speech intact as SplitSentencesQuoteAware does, tracking
+	// QuotePairs (DefaultQuotePairs when empty).
+	QuoteAware bool
+	QuotePairs [][2]rune
+
+	// NumberDotCapital also ends a sentence at a dot between a digit and an uppercase letter
+	// with no space in between, which the default rules (requiring whitespace after the dot)
+	// never split. The cut is after the dot ("See Section 1.Next" yields "See Section 1.",
+	// "Next"), unless the number is a list marker: it starts the text, follows a sentence end
+	// or a colon, or has a consecutive marker, as in "1.First 2.Second", which yields
+	// "1.First", "2.Second". Decimals like "3.14" are unaffected.
+	NumberDotCapital bool
+}
+
+// SplitSentencesWithOptions splits like SplitSentences and then applies the rules in opts.
+func SplitSentencesWithOptions(text string, opts SplitOptions) []string {
+	if opts.QuoteAware {
+		return splitQuoteAware(text, opts)
+	}
+	protected := protectDecimalDots(text)
+	return cutSentences(protected, boundaryEnds(protected, opts.NumberDotCapital))
+}
+
+// DefaultQuotePairs are the quotation marks tracked by SplitSentencesQuoteAware when no
+// pairs are given. Single quotes are left out since they double as apostrophes.
+var DefaultQuotePairs = [][2]rune{{'"', '"'}, {'“', '”'}, {'«', '»'}}
+
+// SplitSentencesQuoteAware splits like SplitSentences but keeps quoted speech intact:
+//   - No boundary is placed inside balanced quotation marks (pairs of opening and closing
+//     runes, which may nest and may be identical, like '"').
+//   - A boundary right after a closing quotation mark is dropped when the text continues
+//     with a lowercase letter, as in `"Go home." and left.`
+//
+// If the quotation marks of the text are unbalanced, quote tracking is unreliable and the
+// result is exactly that of SplitSentences.
+func SplitSentencesQuoteAware(text string, pairs [][2]rune) []string {
+	return splitQuoteAware(text, SplitOptions{QuoteAware: true, QuotePairs: pairs})
+}
+
+// splitQuoteAware implements SplitSentencesQuoteAware for opts.QuotePairs, placing the
+// boundaries of opts.NumberDotCapital as well.
+func splitQuoteAware(text string, opts SplitOptions) []string {
+	pairs := opts.QuotePairs
+	if len(pairs) == 0 {
+		pairs = DefaultQuotePairs
+	}
+	closerOf := make(map[rune]rune, len(pairs))
+	isCloser := make(map[rune]bool, len(pairs))
+	for _, p := range pairs {
+		closerOf[p[0]] = p[1]
+		isCloser[p[1]] = true
+	}
+
+	protected := protectDecimalDots(text)
+	var kept []int
+	var open []rune // expected closing runes, innermost last
+	balanced := true
+	pos := 0
+	scanTo := func(end int) {
+		for _, r := range protected[pos:end] {
+			switch {
+			case len(open) > 0 && r == open[len(open)-1]:
+				open = open[:len(open)-1]
+			case closerOf[r] != 0:
+				open = append(open, closerOf[r])
+			case isCloser[r]:
+				balanced = false // A closing mark without its opening mark.
+			}
+		}
+		pos = end
+	}
+
+	ends := boundaryEnds(protected, opts.NumberDotCapital)
+	for _, end := range ends {
+		scanTo(end)
+		if len(open) > 0 {
+			continue
+		}
+		last, _ := utf8.DecodeLastRuneInString(strings.TrimRightFunc(protected[:end], unicode.IsSpace))
+		next, _ := utf8.DecodeRuneInString(protected[end:])
+		if isCloser[last] && unicode.IsLower(next) {
+			continue
+		}
+		kept = append(kept, end)
+	}
+	scanTo(len(protected))
+	if !balanced || len(open) > 0 {
+		return cutSentences(protected, ends)
+	}
+	return cutSentences(protected, kept)
+}
+
+// protectDecimalDots strips sentinel runes and masks decimal dots (3.14) so they are not
+// treated as boundaries; cutSentences restores them.
+func protectDecimalDots(text string) string {
+	text = StripSentinels(text)
+	return reDecimalDot.ReplaceAllString(text, `$1`+decimalDotToken+`$2`)
+}
+
+// boundaryEnds returns the end offset of every boundary match (punctuation plus closing
+// quotes and the whitespace that follows). A run of terminal punctuation is one boundary:
+// adjacent marks ("?!", "...") form a single match, and a boundary followed only by more
+// terminal punctuation before the next one (". . ." or "?! !") is dropped, so the marks
+// stay with their sentence instead of forming punctuation-only fragments. With
+// numberDotCapital, the boundaries of SplitOptions.NumberDotCapital are merged in.
+func boundaryEnds(protected string, numberDotCapital bool) []int {
+	var ends []int
+	for _, loc := range sentenceEndRegex.FindAllStringIndex(protected, -1) {
+		if len(ends) > 0 && onlyTerminalPunctuation(protected[ends[len(ends)-1]:loc[1]]) {
+			ends[len(ends)-1] = loc[1]
+			continue
+		}
+		ends = append(ends, loc[1])
+	}
+	if !numberDotCapital {
+		return ends
+	}
+	ends = append(ends, numberDotCapitalEnds(protected)...)
+	sort.Ints(ends)
+	return slices.Compact(ends)
+}
+
+// numberDotCapitalEnds returns the boundaries of SplitOptions.NumberDotCapital. A number is
+// taken as a list marker, and the boundary placed before it, only when it starts the text,
+// follows a sentence end or a colon, or has a consecutive marker (N-1 or N+1) elsewhere in
+// the text; otherwise, as in "section 1.Next" or "in 2024.The", the boundary is after the dot.
+func numberDotCapitalEnds(protected string) []int {
+	type match struct {
+		start, dot int
+		number     int
+		standalone bool
+	}
+	var matches []match
+	standalone := make(map[int]bool)
+	for _, loc := range reNumberDotCapital.FindAllStringIndex(protected, -1) {
+		m := match{start: loc[0], dot: loc[0] + strings.IndexByte(protected[loc[0]:], '.'), number: -1}
+		prev, _ := utf8.DecodeLastRuneInString(protected[:m.start])
+		m.standalone = m.start == 0 || unicode.IsSpace(prev)
+		if n, err := strconv.Atoi(protected[m.start:m.dot]); err == nil && m.standalone {
+			m.number = n
+			standalone[n] = true
+		}
+		matches = append(matches, m)
+	}
+
+	ends := make([]int, 0, len(matches))
+	for _, m := range matches {
+		before := strings.TrimRightFunc(protected[:m.start], unicode.IsSpace)
+		last, _ := utf8.DecodeLastRuneInString(before)
+		marker := m.standalone && (before == "" || strings.ContainsRune(".!?…:", last) ||
+			(m.number >= 0 && (standalone[m.number-1] || standalone[m.number+1])))
+		if marker {
+			ends = append(ends, m.start) // A list marker starts the next sentence.
+		} else {
+			ends = append(ends, m.dot+1)
+		}
+	}
+	return ends
+}
 
-	// Insert delimiter at sentence boundaries, keeping punctuation.
-	delimited := sentenceEndRegex.ReplaceAllString(protected, "$1$2$3$4|")
-	sentencesRaw := strings.Split(delimited, "|")
+// onlyTerminalPunctuation reports whether s holds nothing but terminal punctuation, closing
+// quotes and whitespace, i.e. whether cutting it off would leave a fragment without words.
+func onlyTerminalPunctuation(s string) bool {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(".!?…”\"»'", r)
+	}) == ""
+}
+
+// SplitTerminalPunctuation cuts the trailing run of terminal punctuation off a sentence, with
+// the closing quotes and whitespace mixed into it, so that body+punctuation == sentence. A
+// sentence not ending in terminal punctuation ("Hello" or "the dogs'") is returned whole.
+func SplitTerminalPunctuation(sentence string) (body, punctuation string) {
+	body = strings.TrimRightFunc(sentence, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(".!?…”\"»'", r)
+	})
+	punctuation = sentence[len(body):]
+	if !strings.ContainsAny(punctuation, ".!?…") {
+		return sentence, ""
+	}
+	return body, punctuation
+}
+
+// cutSentences cuts protected text at the given ascending offsets, trims whitespace, drops
+// empty pieces and restores decimal dots. Cutting by index rather than inserting a
+// delimiter keeps characters like '|' in the input intact.
+func cutSentences(protected string, ends []int) []string {
 	var sentences []string
-	for _, s := range sentencesRaw {
+	appendSentence := func(s string) {
 		trimmed := strings.TrimSpace(s)
 		if trimmed != "" {
 			// Restore decimal dots.
@@ -49,9 +269,36 @@ func SplitSentences(text string) []string {
 			sentences = append(sentences, trimmed)
 		}
 	}
+	start := 0
+	for _, end := range ends {
+		appendSentence(protected[start:end])
+		start = end
+	}
+	appendSentence(protected[start:])
 	return sentences
 }
 
+// StripSentinels removes the private-use runes reserved for internal placeholder
+// tokens (U+E000 to U+E00F) from user input. Any step that masks text with a
+// placeholder must run on sanitized input, otherwise restoring the placeholder
+// could corrupt text that happened to contain it.
+func StripSentinels(s string) string {
+	if strings.IndexFunc(s, IsSentinelRune) < 0 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if IsSentinelRune(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// IsSentinelRune reports whether r is one of the runes StripSentinels removes.
+func IsSentinelRune(r rune) bool {
+	return r >= firstSentinelRune && r <= lastSentinelRune
+}
+
 // Tokenize normalizes text into a canonical token stream.
 // - Converts to lowercase
 // - Keeps letters and numbers from any script
@@ -59,14 +306,44 @@ func SplitSentences(text string) []string {
 // - Trims apostrophes/hyphens only at token edges
 // This is the single source of truth for tokenization used by lang.* and semseg.*.
 func Tokenize(text string) []string {
-	lower := strings.ToLower(text)
-	cleaned := tokenizeCleanRegex.ReplaceAllString(lower, "")
+	return tokenize(text, "", false)
+}
+
+// turkicCaseLanguages are the languages (as in semseg.Options.Language) whose dotted and
+// dotless I lowercase by Turkish rules: "İ" -> "i" and "I" -> "ı", where the default Unicode
+// mapping turns "I" into "i" and "İ" into "i" plus a combining dot.
+var turkicCaseLanguages = map[string]bool{"turkish": true, "azerbaijani": true}
+
+// ToLower lowercases s with the case rules of language, falling back to the default
+// Unicode mapping of strings.ToLower for languages without special casing.
+func ToLower(s, language string) string {
+	if turkicCaseLanguages[language] {
+		return strings.ToLowerSpecial(unicode.TurkishCase, s)
+	}
+	return strings.ToLower(s)
+}
+
+// tokenize implements Tokenize. With keepSeparators, InWordSeparators stay inside tokens
+// ("tcp/ip") and are trimmed at their edges like apostrophes and hyphens. The text is
+// lowercased with the case rules of language (see ToLower).
+func tokenize(text, language string, keepSeparators bool) []string {
+	lower := ToLower(text, language)
+	var cleaned string
+	if keepSeparators {
+		cleaned = tokenizeKeepRegex.ReplaceAllString(lower, "")
+	} else {
+		cleaned = tokenizeCleanRegex.ReplaceAllString(lower, "")
+	}
 	parts := strings.Fields(cleaned)
 	out := make([]string, 0, len(parts))
 	for _, p := range parts {
 		// Trim apostrophes and dashes only at edges.
-		p = strings.Trim(p, "'")
-		p = strings.Trim(p, "-")
+		if keepSeparators {
+			p = strings.Trim(p, "'-"+InWordSeparators)
+		} else {
+			p = strings.Trim(p, "'")
+			p = strings.Trim(p, "-")
+		}
 		if p != "" {
 			out = append(out, p)
 		}
@@ -74,16 +351,182 @@ func Tokenize(text string) []string {
 	return out
 }
 
+// SplitSeparators replaces every InWordSeparators character in s with a space, so that
+// Tokenize yields the joined words as separate tokens: "TCP/IP" -> "tcp", "ip".
+func SplitSeparators(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(InWordSeparators, r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// Runes that combine with a preceding emoji (see EmojiTokens).
+const (
+	zeroWidthJoiner    = '\u200D'
+	variationSelector  = '\uFE0F'
+	firstSkinTone      = '\U0001F3FB'
+	lastSkinTone       = '\U0001F3FF'
+	firstRegionalIndic = '\U0001F1E6'
+	lastRegionalIndic  = '\U0001F1FF'
+)
+
+// EmojiTokens returns the emoji of s in order, one token per emoji, which Tokenize drops
+// as symbols. Skin-tone modifiers and variation selectors stay with the emoji they modify,
+// zero-width-joiner sequences ("👨‍👩‍👧") form one token, and two regional indicators form
+// one flag.
+func EmojiTokens(s string) []string {
+	var tokens []string
+	var current []rune
+	joined := false // current ends with a zero-width joiner
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, strings.TrimRight(string(current), string(zeroWidthJoiner)))
+		}
+		current, joined = nil, false
+	}
+	for _, r := range s {
+		switch {
+		case len(current) > 0 && r == zeroWidthJoiner:
+			current = append(current, r)
+			joined = true
+		case len(current) > 0 && (r == variationSelector || r >= firstSkinTone && r <= lastSkinTone):
+			current = append(current, r)
+		case isEmoji(r):
+			if joined || len(current) == 1 && isRegionalIndicator(current[0]) && isRegionalIndicator(r) {
+				current = append(current, r)
+				joined = false
+				continue
+			}
+			flush()
+			current = append(current, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isEmoji reports whether r lies in one of the Unicode blocks holding emoji: pictographs,
+// emoticons, transport and map symbols, regional indicators, miscellaneous symbols,
+// dingbats and a few technical symbols and arrows.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	case r >= 0x2600 && r <= 0x27BF, r >= 0x2300 && r <= 0x23FF, r >= 0x2B00 && r <= 0x2BFF:
+		return unicode.Is(unicode.So, r)
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= firstRegionalIndic && r <= lastRegionalIndic
+}
+
+// TokenizeOptions holds optional language-specific rules applied by TokenizeWithOptions.
+// The zero value reproduces Tokenize exactly.
+type TokenizeOptions struct {
+	// Elisions are elided prefixes such as French "l'" or "qu'". A token starting with one
+	// of them is split so the elision becomes a token of its own: "l'état" -> "l'", "état".
+	Elisions []string
+
+	// CompoundParts lists known compound constituents (lowercase). A token that can be
+	// decomposed entirely into two or more of these parts is replaced by them, e.g.
+	// "haustür" -> "haus", "tür". Tokens that cannot be fully decomposed stay intact.
+	CompoundParts []string
+
+	// KeepSeparators keeps InWordSeparators inside tokens instead of dropping them, so
+	// "TCP/IP" yields the single token "tcp/ip" rather than "tcpip".
+	KeepSeparators bool
+
+	// Language selects the lowercasing rules (see ToLower), e.g. "turkish" for İ/ı.
+	Language string
+}
+
+// TokenizeWithOptions tokenizes like Tokenize and then applies the language-specific
+// rules in opts: elisions are split off first, then compounds are decomposed.
+func TokenizeWithOptions(text string, opts TokenizeOptions) []string {
+	tokens := tokenize(text, opts.Language, opts.KeepSeparators)
+	if len(opts.Elisions) == 0 && len(opts.CompoundParts) == 0 {
+		return tokens
+	}
+
+	elisions := sortedLongestFirst(opts.Elisions)
+	var parts map[string]struct{}
+	if len(opts.CompoundParts) > 0 {
+		parts = make(map[string]struct{}, len(opts.CompoundParts))
+		for _, p := range opts.CompoundParts {
+			parts[p] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		for _, e := range elisions {
+			if len(tok) > len(e) && strings.HasPrefix(tok, e) {
+				out = append(out, e)
+				tok = tok[len(e):]
+				break
+			}
+		}
+		if parts != nil {
+			if split := splitCompound(tok, parts); split != nil {
+				out = append(out, split...)
+				continue
+			}
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+// splitCompound decomposes word into the fewest known parts (at least two).
+// It returns nil if no full decomposition exists.
+func splitCompound(word string, parts map[string]struct{}) []string {
+	// best[i] holds the shortest decomposition of word[i:], or nil if there is none.
+	best := make([][]string, len(word)+1)
+	best[len(word)] = []string{}
+	for i := len(word) - 1; i >= 0; i-- {
+		for j := i + 1; j <= len(word); j++ {
+			if best[j] == nil {
+				continue
+			}
+			if _, ok := parts[word[i:j]]; !ok {
+				continue
+			}
+			if best[i] == nil || len(best[j])+1 < len(best[i]) {
+				best[i] = append([]string{word[i:j]}, best[j]...)
+			}
+		}
+	}
+	if len(best[0]) < 2 {
+		return nil
+	}
+	return best[0]
+}
+
+// sortedLongestFirst returns a copy of list ordered by descending length, so longer
+// affixes are matched before their shorter prefixes.
+func sortedLongestFirst(list []string) []string {
+	out := append([]string(nil), list...)
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i]) > len(out[j]) })
+	return out
+}
+
 // GenerateCharNgrams creates a slice of character n-grams from a string.
-// The text is pre-processed by converting to lowercase and removing all
-// non-alphanumeric characters to create a continuous character stream.
-func GenerateCharNgrams(s string, minN, maxN int) []string {
+// The text is pre-processed by converting to lowercase with the case rules of language (see
+// ToLower) and removing all non-alphanumeric characters to create a continuous character
+// stream.
+func GenerateCharNgrams(s, language string, minN, maxN int) []string {
 	if minN <= 0 || maxN < minN {
 		return []string{}
 	}
 
 	// 1. Convert to lowercase
-	lower := strings.ToLower(s)
+	lower := ToLower(s, language)
 
 	// 2. Remove all non-letter and non-number characters to create a continuous stream.
 	// This ensures that n-grams do not span across spaces or punctuation.
@@ -97,6 +540,11 @@ func GenerateCharNgrams(s string, minN, maxN int) []string {
 	// Use runes for correct Unicode handling
 	runes := []rune(cleaned)
 	numRunes := len(runes)
+	// No n-gram can be longer than the input; clamping also keeps huge maxN values
+	// from spinning through empty iterations.
+	if maxN > numRunes {
+		maxN = numRunes
+	}
 	// --- FIX APPLIED HERE ---
 	// Initialize as a non-nil, zero-length slice. This is a best practice for functions
 	// returning slices to avoid returning a nil slice, which simplifies client code
@@ -115,3 +563,71 @@ func GenerateCharNgrams(s string, minN, maxN int) []string {
 
 	return ngrams
 }
+
+// Markdown syntax removed by StripMarkdown.
+// - Line prefixes: blockquote markers, ATX headings, list and task-list markers
+// - Whole lines: code fences and thematic breaks (---, ***, ___)
+// - Inline: images and links (keeping their text), code spans, emphasis and strikethrough
+var (
+	mdLinePrefixRegex = regexp.MustCompile(`^\s*(?:>\s?)*(?:#{1,6}\s+|[-*+]\s+(?:\[[ xX]\]\s+)?|\d+[.)]\s+)?`)
+	mdRuleRegex       = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,}|(?:` + "```" + `|~~~).*)$`)
+	mdImageRegex      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRegex       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdCodeRegex       = regexp.MustCompile("`+([^`]*)`+")
+	mdStrongRegex     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEmRegex         = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*|(^|[^\p{L}\p{N}_])_([^_\s](?:[^_]*[^_\s])?)_`)
+	mdStrikeRegex     = regexp.MustCompile(`~~([^~]+)~~`)
+)
+
+// StripMarkdown removes common Markdown syntax from s, keeping the text it formats: link
+// and image texts stay while their targets are dropped, and code keeps its content.
+// Underscores inside words (snake_case) are left alone. Lines are trimmed, and lines that
+// only held syntax (fences, rules) are removed.
+func StripMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		if mdRuleRegex.MatchString(line) {
+			continue
+		}
+		line = mdLinePrefixRegex.ReplaceAllString(line, "")
+		line = mdImageRegex.ReplaceAllString(line, "$1")
+		line = mdLinkRegex.ReplaceAllString(line, "$1")
+		line = mdCodeRegex.ReplaceAllString(line, "$1")
+		line = mdStrongRegex.ReplaceAllString(line, "$1$2")
+		line = mdEmRegex.ReplaceAllString(line, "$1$2$3")
+		line = mdStrikeRegex.ReplaceAllString(line, "$1")
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// HTML markup removed by StripHTML.
+// - Elements dropped with their content: script, style, head, comments
+// - Block-level tags, which end a line of text
+// - Any other tag, removed without a trace so inline formatting does not split words
+var (
+	htmlDroppedRegex = regexp.MustCompile(`(?is)<!--.*?-->|<(script|style|head)\b[^>]*>.*?</(?:script|style|head)\s*>`)
+	htmlBlockRegex   = regexp.MustCompile(`(?i)</?(?:p|div|br|hr|li|ul|ol|h[1-6]|tr|td|th|table|blockquote|pre|section|article|header|footer|nav|aside|main|figure|figcaption|dt|dd)\b[^>]*>`)
+	htmlTagRegex     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// StripHTML returns the text content of an HTML document or fragment: markup is removed,
+// character references are decoded, block-level elements end a line, and lines are trimmed
+// with empty ones removed.
+func StripHTML(s string) string {
+	s = htmlDroppedRegex.ReplaceAllString(s, "")
+	s = htmlBlockRegex.ReplaceAllString(s, "\n")
+	s = htmlTagRegex.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -3,8 +3,12 @@
 package text
 
 import (
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"unicode"
+	"unicode/utf8"
 )
 
 // TestSplitSentences verifies that sentence boundaries are correctly detected.
@@ -18,6 +22,133 @@ func TestSplitSentences(t *testing.T) {
 	}
 }
 
+// TestSplitSentencesWithSentinelRunes verifies that user text containing the private-use
+// runes reserved for internal placeholders is neither corrupted nor split incorrectly.
+func TestSplitSentencesWithSentinelRunes(t *testing.T) {
+	text := "Pi is 3.14 here. Fake \uE001DECIMAL_DOT\uE001 and \uE000ELLIPSIS\uE000 markers!"
+	expected := []string{"Pi is 3.14 here.", "Fake DECIMAL_DOT and ELLIPSIS markers!"}
+	result := SplitSentences(text)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+// TestSplitSentencesTerminalRuns verifies that a run of terminal punctuation, adjacent or
+// spaced, ends a single sentence and never leaves a punctuation-only fragment.
+func TestSplitSentencesTerminalRuns(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected []string
+	}{
+		{"Really?! Let's go.", []string{"Really?!", "Let's go."}},
+		{"Wait... I think so.", []string{"Wait...", "I think so."}},
+		{"Wait… I think so.", []string{"Wait…", "I think so."}},
+		{"Go!!! Now?!?! Yes.", []string{"Go!!!", "Now?!?!", "Yes."}},
+		{"Wait . . . I think so.", []string{"Wait . . .", "I think so."}},
+		{"Really? ! Let's go.", []string{"Really? !", "Let's go."}},
+		{`"Stop!" ! he said.`, []string{`"Stop!" !`, "he said."}},
+		{"Done. !?", []string{"Done. !?"}},
+	}
+	for _, tc := range testCases {
+		if got := SplitSentences(tc.text); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("SplitSentences(%q) = %q, expected %q", tc.text, got, tc.expected)
+		}
+		if got := SplitSentencesQuoteAware(tc.text, nil); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("SplitSentencesQuoteAware(%q) = %q, expected %q", tc.text, got, tc.expected)
+		}
+	}
+}
+
+// TestSplitSentencesNumbers verifies that decimal-dot protection only keeps numbers like
+// 3.14 intact, and that NumberDotCapital splits unspaced numbered lists and "N.Capital"
+// typos without touching decimals.
+func TestSplitSentencesNumbers(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		opts     SplitOptions
+		expected []string
+	}{
+		{"Decimal", "Pi is 3.14 here. Next.", SplitOptions{}, []string{"Pi is 3.14 here.", "Next."}},
+		{"Numbered items", "Read chapter 1. Then read chapter 2. Version 2.0 is out.", SplitOptions{}, []string{"Read chapter 1.", "Then read chapter 2.", "Version 2.0 is out."}},
+		{"Unspaced list", "1.Open the lid. 2.Pour the water. 3.Wait.", SplitOptions{NumberDotCapital: true}, []string{"1.Open the lid.", "2.Pour the water.", "3.Wait."}},
+		{"Unspaced list without periods", "Steps: 1.Open the lid 2.Pour the water", SplitOptions{NumberDotCapital: true}, []string{"Steps:", "1.Open the lid", "2.Pour the water"}},
+		{"Section typo", "See Section 1.Next section follows.", SplitOptions{NumberDotCapital: true}, []string{"See Section 1.", "Next section follows."}},
+		{"Year typo", "We met in 2024.The rest is history.", SplitOptions{NumberDotCapital: true}, []string{"We met in 2024.", "The rest is history."}},
+		{"Marker after a sentence end", "Read this. 3.Then stop.", SplitOptions{NumberDotCapital: true}, []string{"Read this.", "3.Then stop."}},
+		{"Attached number", "Upgrade to v2.Next comes the release.", SplitOptions{NumberDotCapital: true}, []string{"Upgrade to v2.", "Next comes the release."}},
+		{"Decimals kept", "Pi is 3.14 and e is 2.71. Done.", SplitOptions{NumberDotCapital: true}, []string{"Pi is 3.14 and e is 2.71.", "Done."}},
+		{"Lowercase kept", "Use version 1.x for now.", SplitOptions{NumberDotCapital: true}, []string{"Use version 1.x for now."}},
+		{"Quote aware", `"1.Go home. 2.Rest." She agreed.`, SplitOptions{NumberDotCapital: true, QuoteAware: true}, []string{`"1.Go home. 2.Rest."`, "She agreed."}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := SplitSentencesWithOptions(tc.text, tc.opts); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+// TestSplitSentencesQuoteAware verifies that balanced (and nested) quotations are not
+// split, that a quote followed by lowercase text continues the sentence, and that
+// unbalanced quotes fall back to SplitSentences.
+func TestSplitSentencesQuoteAware(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		pairs    [][2]rune
+		expected []string
+	}{
+		{
+			name:     "Dialogue continues after the quote",
+			text:     `She said, "Go home. Now." and left. He stayed.`,
+			expected: []string{`She said, "Go home. Now." and left.`, "He stayed."},
+		},
+		{
+			name:     "Quote ends the sentence",
+			text:     `He shouted: "Stop! Wait." Nobody listened.`,
+			expected: []string{`He shouted: "Stop! Wait."`, "Nobody listened."},
+		},
+		{
+			name:     "Nested quotes",
+			text:     `“He told me “Run. Hide.” and I did. Really.” Then silence.`,
+			expected: []string{`“He told me “Run. Hide.” and I did. Really.”`, "Then silence."},
+		},
+		{
+			name:     "Mixed nested pairs",
+			text:     `«She wrote "Done. Bye." on it.» The end.`,
+			expected: []string{`«She wrote "Done. Bye." on it.»`, "The end."},
+		},
+		{
+			name:     "Unbalanced quote falls back",
+			text:     `She said, "Go home. Now. And left.`,
+			expected: []string{`She said, "Go home.`, "Now.", "And left."},
+		},
+		{
+			name:     "Stray closing quote falls back",
+			text:     `Go home.” Now. Fine.`,
+			expected: []string{"Go home.”", "Now.", "Fine."},
+		},
+		{
+			name:     "Custom pairs",
+			text:     `He said ‘Go. Now.’ and left.`,
+			pairs:    [][2]rune{{'‘', '’'}},
+			expected: []string{`He said ‘Go. Now.’ and left.`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := SplitSentencesQuoteAware(tc.text, tc.pairs)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
 // TestTokenize verifies tokenization rules.
 // - Lowercasing
 // - Removal of punctuation (, !)
@@ -31,6 +162,86 @@ func TestTokenize(t *testing.T) {
 	}
 }
 
+// TestTokenizeWithOptions verifies language-specific rules:
+// - Elisions split off as separate tokens (longest match first)
+// - Compounds decomposed only when fully covered by known parts
+// - Zero options behave exactly like Tokenize
+func TestTokenizeWithOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		opts     TokenizeOptions
+		expected []string
+	}{
+		{
+			name:     "French elisions",
+			text:     "L'état qu'il jusqu'ici",
+			opts:     TokenizeOptions{Elisions: []string{"l'", "qu'", "jusqu'"}},
+			expected: []string{"l'", "état", "qu'", "il", "jusqu'", "ici"},
+		},
+		{
+			name:     "German compounds",
+			text:     "Die Haustür am Bahnhof, Haustürschlüssel und Hausmeister.",
+			opts:     TokenizeOptions{CompoundParts: []string{"haus", "tür", "bahn", "hof", "schlüssel"}},
+			expected: []string{"die", "haus", "tür", "am", "bahn", "hof", "haus", "tür", "schlüssel", "und", "hausmeister"},
+		},
+		{
+			name:     "No rules",
+			text:     "Hello, world-123!",
+			opts:     TokenizeOptions{},
+			expected: []string{"hello", "world-123"},
+		},
+		{
+			name:     "Separators dropped",
+			text:     "TCP/IP, R&D and max_tokens",
+			opts:     TokenizeOptions{},
+			expected: []string{"tcpip", "rd", "and", "maxtokens"},
+		},
+		{
+			name:     "Separators kept",
+			text:     "TCP/IP, R&D and max_tokens / &edge_",
+			opts:     TokenizeOptions{KeepSeparators: true},
+			expected: []string{"tcp/ip", "r&d", "and", "max_tokens", "edge"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := TokenizeWithOptions(tc.text, tc.opts)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+// TestSplitSeparators verifies that in-word separators become token boundaries.
+func TestSplitSeparators(t *testing.T) {
+	expected := []string{"tcp", "ip", "r", "d", "max", "tokens"}
+	if got := Tokenize(SplitSeparators("TCP/IP R&D max_tokens")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestEmojiTokens(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{"No emoji here.", nil},
+		{"Goal⚽ what a match ⚽🔥!", []string{"⚽", "⚽", "🔥"}},
+		{"Thumbs 👍🏽 and ❤️ love", []string{"👍🏽", "❤️"}},
+		{"Family 👨‍👩‍👧 trip", []string{"👨‍👩‍👧"}},
+		{"Flags 🇫🇷🇩🇪", []string{"🇫🇷", "🇩🇪"}},
+		{"Copyright © and arrows → are not emoji", nil},
+	}
+	for _, tc := range testCases {
+		if got := EmojiTokens(tc.input); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("EmojiTokens(%q) = %q, expected %q", tc.input, got, tc.expected)
+		}
+	}
+}
+
 // TestGenerateCharNgrams verifies character n-gram generation.
 func TestGenerateCharNgrams(t *testing.T) {
 	testCases := []struct {
@@ -86,7 +297,7 @@ func TestGenerateCharNgrams(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := GenerateCharNgrams(tc.text, tc.minN, tc.maxN)
+			result := GenerateCharNgrams(tc.text, "", tc.minN, tc.maxN)
 			if !reflect.DeepEqual(result, tc.expected) {
 				// --- IMPROVED ERROR MESSAGE HERE ---
 				// This provides more diagnostic information in case of failure,
@@ -98,3 +309,164 @@ func TestGenerateCharNgrams(t *testing.T) {
 		})
 	}
 }
+
+// FuzzSplitSentences checks that sentence splitting never panics, never returns empty
+// sentences, never leaks internal placeholder tokens, and never drops content.
+func FuzzSplitSentences(f *testing.F) {
+	f.Add("Hello world. This is a test! Is it working? Yes.")
+	f.Add(`She said "stop." Then left… Pi is 3.14 or so.`)
+	f.Add("Value DECIMAL_DOT here. Next one.")
+	f.Add("a | b. c || d!")
+	f.Fuzz(func(t *testing.T, s string) {
+		sentences := SplitSentences(s)
+		var joined strings.Builder
+		for _, sentence := range sentences {
+			if strings.TrimSpace(sentence) == "" {
+				t.Fatalf("empty sentence in %q", sentences)
+			}
+			if strings.IndexFunc(sentence, IsSentinelRune) >= 0 {
+				t.Fatalf("sentinel rune leaked into %q", sentence)
+			}
+			joined.WriteString(sentence)
+		}
+		want := withoutSpace(StripSentinels(s))
+		if got := withoutSpace(joined.String()); got != want {
+			t.Fatalf("content changed: input %q, sentences %q", s, sentences)
+		}
+	})
+}
+
+// FuzzTokenize checks that tokenization never panics and never yields empty
+// tokens or tokens containing whitespace.
+func FuzzTokenize(f *testing.F) {
+	f.Add("Hello, world-123!")
+	f.Add("Don't panic… l'état -- '' - '")
+	f.Add(" ÅΣ ß İ")
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, tok := range Tokenize(s) {
+			if tok == "" {
+				t.Fatalf("empty token for %q", s)
+			}
+			if strings.IndexFunc(tok, unicode.IsSpace) >= 0 {
+				t.Fatalf("token %q contains whitespace", tok)
+			}
+		}
+	})
+}
+
+// FuzzGenerateCharNgrams checks that n-gram generation never panics or hangs (even for
+// extreme size ranges) and that every n-gram has a length within [minN, maxN].
+func FuzzGenerateCharNgrams(f *testing.F) {
+	f.Add("word", 3, 5)
+	f.Add("слово", 1, 1)
+	f.Add("", 0, 0)
+	f.Add("abc", 2, math.MaxInt)
+	f.Fuzz(func(t *testing.T, s string, minN, maxN int) {
+		ngrams := GenerateCharNgrams(s, "", minN, maxN)
+		if ngrams == nil {
+			t.Fatalf("nil result for %q [%d, %d]", s, minN, maxN)
+		}
+		for _, ng := range ngrams {
+			if n := utf8.RuneCountInString(ng); n < minN || n > maxN {
+				t.Fatalf("n-gram %q has length %d outside [%d, %d]", ng, n, minN, maxN)
+			}
+		}
+	})
+}
+
+func withoutSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func TestStripMarkdown(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Plain text", "Nothing to strip here.", "Nothing to strip here."},
+		{"Heading", "## Installation\nRun the installer.", "Installation\nRun the installer."},
+		{"Emphasis", "This is **very** important and *really* _urgent_ ~~not~~.", "This is very important and really urgent not."},
+		{"Snake case kept", "Set max_tokens and min_split to 5 * 3.", "Set max_tokens and min_split to 5 * 3."},
+		{"Links and images", "See [the docs](https://example.com) and ![a chart](chart.png).", "See the docs and a chart."},
+		{"Code", "Call `Segment()` first.\n```go\nx := 1\n```", "Call Segment() first.\nx := 1"},
+		{"Lists and quotes", "- first item\n* [x] done item\n1. numbered\n> quoted text", "first item\ndone item\nnumbered\nquoted text"},
+		{"Rule only", "---", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripMarkdown(tc.input); got != tc.expected {
+				t.Errorf("StripMarkdown(%q) = %q, expected %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Plain text", "Nothing to strip here.", "Nothing to strip here."},
+		{"Inline tags", "This is <b>very</b> <a href=\"/x\">important</a>.", "This is very important."},
+		{"Blocks", "<h1>Title</h1><p>First paragraph.</p><p>Second<br>line.</p>", "Title\nFirst paragraph.\nSecond\nline."},
+		{"Entities", "Fish &amp; chips &lt;3 &#8212; caf&eacute;", "Fish & chips <3 \u2014 caf\u00e9"},
+		{"Dropped elements", "<head><title>T</title></head><script>var x = 1;</script><style>p {}</style><!-- note -->Body.", "Body."},
+		{"Whitespace", "<ul>\n  <li>one   item</li>\n  <li>two</li>\n</ul>", "one item\ntwo"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripHTML(tc.input); got != tc.expected {
+				t.Errorf("StripHTML(%q) = %q, expected %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSplitTerminalPunctuation(t *testing.T) {
+	testCases := []struct {
+		sentence, body, punctuation string
+	}{
+		{"Cats purr.", "Cats purr", "."},
+		{"Really?!", "Really", "?!"},
+		{"Wait . . .", "Wait", " . . ."},
+		{`He said "stop."`, `He said "stop`, `."`},
+		{"Pi is 3.14", "Pi is 3.14", ""},
+		{"the dogs'", "the dogs'", ""},
+		{"...", "", "..."},
+		{"", "", ""},
+	}
+	for _, tc := range testCases {
+		body, punctuation := SplitTerminalPunctuation(tc.sentence)
+		if body != tc.body || punctuation != tc.punctuation {
+			t.Errorf("SplitTerminalPunctuation(%q) = %q, %q; want %q, %q", tc.sentence, body, punctuation, tc.body, tc.punctuation)
+		}
+		if body+punctuation != tc.sentence {
+			t.Errorf("SplitTerminalPunctuation(%q) does not round-trip: %q + %q", tc.sentence, body, punctuation)
+		}
+	}
+}
+
+// TestTurkishLowercasing checks that Turkish text keeps its dotted and dotless I apart when
+// tokenized or cut into n-grams, while other languages keep the default mapping.
+func TestTurkishLowercasing(t *testing.T) {
+	sentence := "İSTANBUL'da IRMAK ılık"
+	if got, want := TokenizeWithOptions(sentence, TokenizeOptions{Language: "turkish"}), []string{"istanbul'da", "ırmak", "ılık"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Turkish tokens: got %q, want %q", got, want)
+	}
+	if got, want := Tokenize(sentence), []string{"istanbul'da", "irmak", "ılık"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Default tokens: got %q, want %q", got, want)
+	}
+	if got, want := GenerateCharNgrams("IRMAK", "turkish", 5, 5), []string{"ırmak"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Turkish n-grams: got %q, want %q", got, want)
+	}
+	if got, want := GenerateCharNgrams("İzmir", "turkish", 5, 5), []string{"izmir"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Turkish n-grams: got %q, want %q", got, want)
+	}
+}
@@ -1,12 +1,33 @@
 package tfidf
 
-import "math"
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// IDF formulas selectable with SetIDF. In both, k is the additive smoothing constant
+// (default 1), N the number of documents and df the number of documents containing the term.
+const (
+	// IDFLogSmooth is log(1 + N/(k+df)). This is the default.
+	IDFLogSmooth = "log_smooth"
+	// IDFSklearn is log((N+k)/(df+k)) + 1, which matches scikit-learn's TfidfVectorizer
+	// with smooth_idf=True when k is 1.
+	IDFSklearn = "sklearn"
+)
+
+// DefaultIDFSmoothing is the additive smoothing constant k used unless SetIDF changes it.
+const DefaultIDFSmoothing = 1.0
 
 // corpus stores document frequencies for terms across a collection.
 // Used to compute IDF values for TF-IDF vectors.
 type corpus struct {
 	docFrequencies map[string]int
 	numDocs        int
+	idfFormula     string
+	idfSmoothing   float64
+	termWeight     func(term string) float64
+	limited        bool // Vectorize ignores terms outside docFrequencies (see LimitVocabulary)
 }
 
 // NewCorpus builds a corpus representation from a slice of tokenized documents.
@@ -25,20 +46,119 @@ func NewCorpus(documents [][]string) *corpus {
 	return &corpus{
 		docFrequencies: docFrequencies,
 		numDocs:        len(documents),
+		idfFormula:     IDFLogSmooth,
+		idfSmoothing:   DefaultIDFSmoothing,
+	}
+}
+
+// SetIDF selects the IDF formula (IDFLogSmooth or IDFSklearn) and its smoothing constant,
+// which must be positive so terms absent from the corpus get a finite weight.
+func (c *corpus) SetIDF(formula string, smoothing float64) {
+	c.idfFormula = formula
+	c.idfSmoothing = smoothing
+}
+
+// SetTermWeights makes Vectorize multiply each term's TF-IDF value by weight(term), e.g. to
+// down-weight stopwords instead of removing them. nil restores unweighted vectors.
+func (c *corpus) SetTermWeights(weight func(term string) float64) {
+	c.termWeight = weight
+}
+
+// LimitVocabulary keeps only the n terms with the highest document frequency (ties broken
+// alphabetically) and makes Vectorize ignore all other terms. Terms found in a single
+// document cannot make two documents similar, so they are dropped first. n <= 0, or a
+// vocabulary of at most n terms, leaves the corpus unchanged.
+func (c *corpus) LimitVocabulary(n int) {
+	if n <= 0 || len(c.docFrequencies) <= n {
+		return
+	}
+	terms := make([]string, 0, len(c.docFrequencies))
+	for term := range c.docFrequencies {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		di, dj := c.docFrequencies[terms[i]], c.docFrequencies[terms[j]]
+		if di != dj {
+			return di > dj
+		}
+		return terms[i] < terms[j]
+	})
+	kept := make(map[string]int, n)
+	for _, term := range terms[:n] {
+		kept[term] = c.docFrequencies[term]
+	}
+	c.docFrequencies = kept
+	c.limited = true
+}
+
+// IDF returns the inverse document frequency of a term under the selected formula.
+func (c *corpus) IDF(term string) float64 {
+	n, df, k := float64(c.numDocs), float64(c.docFrequencies[term]), c.idfSmoothing
+	if c.idfFormula == IDFSklearn {
+		return math.Log((n+k)/(df+k)) + 1
+	}
+	return math.Log(1 + n/(k+df))
+}
+
+// Vocabulary returns every term of the corpus with its IDF under the selected formula.
+func (c *corpus) Vocabulary() map[string]float64 {
+	vocabulary := make(map[string]float64, len(c.docFrequencies))
+	for term := range c.docFrequencies {
+		vocabulary[term] = c.IDF(term)
+	}
+	return vocabulary
+}
+
+// NewCorpusDeduplicated is like NewCorpus but counts each distinct document (identical
+// token sequence) only once, so repeated documents do not inflate document frequencies.
+func NewCorpusDeduplicated(documents [][]string) *corpus {
+	seen := make(map[string]bool, len(documents))
+	unique := make([][]string, 0, len(documents))
+	for _, doc := range documents {
+		key := strings.Join(doc, "\x00")
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, doc)
+		}
 	}
+	return NewCorpus(unique)
 }
 
 // Vectorize converts a list of tokens into a TF-IDF weighted vector.
 //   - TF: normalized term frequency within this token list.
-//   - IDF: log-scaled inverse document frequency with smoothing.
-//     Formula: log(1 + N / (1 + df))
+//   - IDF: log-scaled inverse document frequency with smoothing (see IDF).
+//     Default formula: log(1 + N / (1 + df))
 //     where N = total docs, df = docs containing the token.
+//   - Optional per-term weights (see SetTermWeights).
+//
+// After LimitVocabulary, terms outside the vocabulary are left out of the vector.
 func (c *corpus) Vectorize(tokens []string) map[string]float64 {
 	if len(tokens) == 0 {
 		return make(map[string]float64)
 	}
 
 	// Term Frequency (TF)
+	tf := TermFrequencies(tokens)
+
+	// TF-IDF
+	vector := make(map[string]float64)
+	for token, termFreq := range tf {
+		if c.limited {
+			if _, ok := c.docFrequencies[token]; !ok {
+				continue
+			}
+		}
+		vector[token] = termFreq * c.IDF(token)
+		if c.termWeight != nil {
+			vector[token] *= c.termWeight(token)
+		}
+	}
+	return vector
+}
+
+// TermFrequencies returns the normalized frequency of each token (count / number of tokens).
+// Unlike Vectorize, the result depends only on the tokens themselves, not on a corpus.
+func TermFrequencies(tokens []string) map[string]float64 {
 	tf := make(map[string]float64)
 	for _, token := range tokens {
 		tf[token]++
@@ -47,14 +167,7 @@ func (c *corpus) Vectorize(tokens []string) map[string]float64 {
 	for token, count := range tf {
 		tf[token] = count / numTokens
 	}
-
-	// TF-IDF
-	vector := make(map[string]float64)
-	for token, termFreq := range tf {
-		idf := math.Log(1 + (float64(c.numDocs) / (1 + float64(c.docFrequencies[token]))))
-		vector[token] = termFreq * idf
-	}
-	return vector
+	return tf
 }
 
 // CosineSimilarity computes cosine similarity between two sparse vectors.
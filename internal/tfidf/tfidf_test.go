@@ -2,6 +2,7 @@ package tfidf
 
 import (
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -65,3 +66,112 @@ func TestCorpusVectorize(t *testing.T) {
 		t.Errorf("Wrong TF-IDF score for 'sun'")
 	}
 }
+
+// TestNewCorpusDeduplicated checks that identical duplicate documents leave IDF weights
+// unchanged, while a plain corpus counts them again.
+func TestNewCorpusDeduplicated(t *testing.T) {
+	docs := [][]string{
+		{"sun", "is", "hot"},
+		{"moon", "is", "cold"},
+	}
+	withDuplicates := append(docs, []string{"sun", "is", "hot"}, []string{"sun", "is", "hot"})
+	query := []string{"sun", "is", "hot"}
+
+	expected := NewCorpus(docs).Vectorize(query)
+	if got := NewCorpusDeduplicated(withDuplicates).Vectorize(query); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected deduplicated weights %v, got %v", expected, got)
+	}
+	if got := NewCorpus(withDuplicates).Vectorize(query); reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected duplicates to change weights in a plain corpus")
+	}
+}
+
+// TestIDFFormulas checks each IDF formula against its documented values on a corpus of
+// N = 4 documents where "common" occurs in all of them and "rare" in one.
+func TestIDFFormulas(t *testing.T) {
+	docs := [][]string{{"common", "rare"}, {"common"}, {"common"}, {"common"}}
+	testCases := []struct {
+		name      string
+		formula   string
+		smoothing float64
+		common    float64
+		rare      float64
+		unseen    float64
+	}{
+		{"Default", "", 0, math.Log(1 + 4.0/5), math.Log(1 + 4.0/2), math.Log(1 + 4.0/1)},
+		{"Log smooth k=2", IDFLogSmooth, 2, math.Log(1 + 4.0/6), math.Log(1 + 4.0/3), math.Log(1 + 4.0/2)},
+		{"Sklearn", IDFSklearn, 1, 1, math.Log(5.0/2) + 1, math.Log(5.0) + 1},
+		{"Sklearn k=0.5", IDFSklearn, 0.5, 1, math.Log(4.5/1.5) + 1, math.Log(4.5/0.5) + 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			corpus := NewCorpus(docs)
+			if tc.formula != "" {
+				corpus.SetIDF(tc.formula, tc.smoothing)
+			}
+			for term, expected := range map[string]float64{"common": tc.common, "rare": tc.rare, "unseen": tc.unseen} {
+				if got := corpus.IDF(term); math.Abs(got-expected) > 1e-12 {
+					t.Errorf("IDF(%q) = %f, expected %f", term, got, expected)
+				}
+			}
+			vec := corpus.Vectorize([]string{"common", "rare"})
+			if math.Abs(vec["rare"]-0.5*tc.rare) > 1e-12 {
+				t.Errorf("Expected Vectorize to weight 'rare' by its IDF, got %f", vec["rare"])
+			}
+		})
+	}
+}
+
+// TestSetTermWeights checks that per-term weights scale the TF-IDF values.
+func TestSetTermWeights(t *testing.T) {
+	corpus := NewCorpus([][]string{{"the", "sun"}, {"the", "moon"}})
+	plain := corpus.Vectorize([]string{"the", "sun"})
+	corpus.SetTermWeights(func(term string) float64 {
+		if term == "the" {
+			return 0.5
+		}
+		return 1
+	})
+	weighted := corpus.Vectorize([]string{"the", "sun"})
+	if math.Abs(weighted["the"]-0.5*plain["the"]) > 1e-12 || weighted["sun"] != plain["sun"] {
+		t.Errorf("Expected only 'the' to be halved: plain %v, weighted %v", plain, weighted)
+	}
+}
+
+// TestLimitVocabulary checks that the most widespread terms are kept and the others are
+// left out of vectors.
+func TestLimitVocabulary(t *testing.T) {
+	c := NewCorpus([][]string{{"a", "b", "c"}, {"a", "b"}, {"a", "d"}})
+	c.LimitVocabulary(2)
+	vocabulary := c.Vocabulary()
+	if len(vocabulary) != 2 || vocabulary["a"] == 0 || vocabulary["b"] == 0 {
+		t.Fatalf("Expected the vocabulary {a, b}, got %v", vocabulary)
+	}
+	vector := c.Vectorize([]string{"a", "c", "e"})
+	if len(vector) != 1 || vector["a"] == 0 {
+		t.Errorf("Expected only 'a' in the vector, got %v", vector)
+	}
+
+	c.LimitVocabulary(0)
+	if len(c.Vocabulary()) != 2 {
+		t.Errorf("Expected LimitVocabulary(0) to keep the vocabulary, got %v", c.Vocabulary())
+	}
+}
+
+// TestCorpusVocabulary checks that the vocabulary lists every corpus term with its IDF.
+func TestCorpusVocabulary(t *testing.T) {
+	c := NewCorpus([][]string{{"a", "b"}, {"a"}, {"c"}})
+	vocabulary := c.Vocabulary()
+	if len(vocabulary) != 3 {
+		t.Fatalf("Expected 3 terms, got %v", vocabulary)
+	}
+	for term, idf := range vocabulary {
+		if idf != c.IDF(term) {
+			t.Errorf("Term %q: expected IDF %f, got %f", term, c.IDF(term), idf)
+		}
+	}
+	if math.Abs(vocabulary["a"]-math.Log(2)) > 1e-9 {
+		t.Errorf("Expected IDF log(1 + 3/3) for a term in two documents, got %f", vocabulary["a"])
+	}
+}
@@ -0,0 +1,86 @@
+package semseg
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// OllamaGlobalMaxWorkersEnvVar caps the number of concurrent Ollama embedding requests across
+// all segmentation calls in the process that do not set Options.EmbeddingLimiter.
+const OllamaGlobalMaxWorkersEnvVar = "CHUNKER_OLLAMA_GLOBAL_MAX_WORKERS"
+
+// EmbeddingLimiter caps the number of embedding requests in flight at once across every
+// call that shares it, independently of the per-call worker count
+// (CHUNKER_OLLAMA_MAX_WORKERS). Workers beyond the cap wait for a free slot, which protects
+// a shared embedding backend when many Segment calls run concurrently. It is safe for
+// concurrent use.
+type EmbeddingLimiter struct {
+	slots chan struct{}
+}
+
+// NewEmbeddingLimiter returns a limiter allowing at most n concurrent embedding requests.
+// n must be positive.
+func NewEmbeddingLimiter(n int) *EmbeddingLimiter {
+	return &EmbeddingLimiter{slots: make(chan struct{}, n)}
+}
+
+// Capacity returns the maximum number of concurrent requests.
+func (l *EmbeddingLimiter) Capacity() int {
+	return cap(l.slots)
+}
+
+// acquire waits for a free slot or for ctx to be done.
+func (l *EmbeddingLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *EmbeddingLimiter) release() {
+	<-l.slots
+}
+
+var (
+	envLimiterMu sync.Mutex
+	envLimiter   *EmbeddingLimiter
+)
+
+// envEmbeddingLimiter returns the process-wide limiter configured by
+// OllamaGlobalMaxWorkersEnvVar, or nil if the variable is unset or invalid.
+func envEmbeddingLimiter() *EmbeddingLimiter {
+	n, err := strconv.Atoi(os.Getenv(OllamaGlobalMaxWorkersEnvVar))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	envLimiterMu.Lock()
+	defer envLimiterMu.Unlock()
+	if envLimiter == nil || envLimiter.Capacity() != n {
+		envLimiter = NewEmbeddingLimiter(n)
+	}
+	return envLimiter
+}
+
+type limiterKey struct{}
+
+// withEmbeddingLimiter returns a context carrying the limiter used by the Ollama workers:
+// l if set, otherwise the one configured by the environment (if any).
+func withEmbeddingLimiter(ctx context.Context, l *EmbeddingLimiter) context.Context {
+	if l == nil {
+		l = envEmbeddingLimiter()
+	}
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// embeddingLimiterFrom returns the limiter carried by ctx, or nil.
+func embeddingLimiterFrom(ctx context.Context) *EmbeddingLimiter {
+	l, _ := ctx.Value(limiterKey{}).(*EmbeddingLimiter)
+	return l
+}
@@ -0,0 +1,69 @@
+package semseg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SimilarityMatrix splits text into sentences exactly like Segment and returns them along
+// with the full N×N matrix of pairwise sentence similarities, computed with the configured
+// method (dense embeddings if an Ollama backend is set, TF-IDF otherwise). matrix[i][j] is
+// the similarity between sentences i and j; the matrix is symmetric with a unit diagonal.
+//
+// It is intended for visualizing a document's topical structure (e.g. as a heatmap) and for
+// tuning options. Computing it costs O(N²) similarity computations, so it is never done
+// as part of Segment.
+func SimilarityMatrix(textStr string, opts Options) ([]string, [][]float64, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, nil, err
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: textStr}
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, nil, err
+	}
+
+	provider := embeddingProvider(opts)
+	useOllama := provider != nil
+	if err := checkCacheBackend(opts, useOllama); err != nil {
+		return nil, nil, err
+	}
+
+	ctx := callContext(context.Background(), opts)
+	textStr, sentences, globalDetectedLang := prepareSentences(ctx, in, opts)
+	analysis := sentences
+	if opts.OutputFormat != "" {
+		analysis, sentences = markdownSentences(sentences, opts.OutputFormat)
+		textStr = strings.Join(analysis, " ")
+	}
+	ctx = withProgress(ctx, opts.OnProgress, len(sentences))
+	if len(sentences) == 0 {
+		return sentences, [][]float64{}, nil
+	}
+
+	var similarity func(i, j int) float64
+	if useOllama {
+		vectors, err := getOllamaEmbeddings(ctx, analysis, provider, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+		}
+		similarity = func(i, j int) float64 { return cosineSimilarityDense(vectors[i], vectors[j]) }
+	} else {
+		globalDetectedLang = resolveDocumentLanguage(textStr, analysis, opts, globalDetectedLang)
+		vectors, _ := buildTFIDFVectors(textStr, analysis, opts, globalDetectedLang, sentenceLanguages(analysis, opts))
+		similarity = func(i, j int) float64 { return opts.TFIDFSimilarity(vectors[i], vectors[j]) }
+	}
+
+	matrix := make([][]float64, len(sentences))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(sentences))
+		// By definition, even for sentences that vectorize to nothing (e.g. only stopwords).
+		matrix[i][i] = 1
+		for j := 0; j < i; j++ {
+			matrix[i][j] = similarity(i, j)
+			matrix[j][i] = matrix[i][j]
+		}
+	}
+	return sentences, matrix, nil
+}
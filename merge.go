@@ -0,0 +1,77 @@
+package semseg
+
+import "context"
+
+// segmentMergedWithOllama is the dense path for MinSentenceTokensForEmbedding: it embeds and
+// scores groups of sentences (see mergeShortSentences), then maps the results back onto the
+// sentences. Sentences of one group share the group's embedding and topic similarity, and
+// the gaps inside a group score 1, so they are never boundaries. With BlockComparisonSize or
+// MaxLookback, blocks and lookback are counted in groups.
+func segmentMergedWithOllama(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([]float64, []float64, [][]float64, error) {
+	texts, groupOf := mergeShortSentences(sentences, opts.MinSentenceTokensForEmbedding, opts.TokenCountMethod, opts.CJKTokenMode == CJKTokenModeChars)
+	// Group scores are not sentence scores; scoreDocument reports the mapped ones.
+	opts.OnCohesionScore = nil
+	scores, topicSims, vectors, err := segmentSentencesWithOllama(ctx, texts, provider, opts)
+	if scores == nil {
+		return nil, nil, nil, err
+	}
+
+	// On a partial result, only the sentences of the completed groups are covered.
+	covered := len(sentences)
+	if err != nil && vectors != nil {
+		covered = 0
+		for covered < len(sentences) && groupOf[covered] < len(vectors) {
+			covered++
+		}
+	}
+	sentenceScores := []float64{}
+	if covered > 1 {
+		sentenceScores = make([]float64, covered-1)
+	}
+	for i := range sentenceScores {
+		if groupOf[i] == groupOf[i+1] {
+			sentenceScores[i] = 1
+		} else {
+			sentenceScores[i] = scores[groupOf[i]]
+		}
+	}
+	if err == nil {
+		// Sentences merged into a group were counted with it.
+		progressFrom(ctx).add(len(sentences) - len(texts))
+	}
+	return sentenceScores, expandGroups(topicSims, groupOf[:covered]), expandGroups(vectors, groupOf[:covered]), err
+}
+
+// mergeShortSentences groups the sentences for embedding: a sentence with fewer than
+// minTokens tokens joins the group of the preceding sentence, and the first group takes in
+// following sentences until it reaches minTokens. It returns the text of each group and,
+// for each sentence, the index of its group. Groups are contiguous and in order.
+func mergeShortSentences(sentences []string, minTokens int, method string, cjkChars bool) ([]string, []int) {
+	groupOf := make([]int, len(sentences))
+	var texts []string
+	groupTokens := 0
+	for i, s := range sentences {
+		n := countTokens(s, method, cjkChars)
+		if len(texts) == 0 || (n >= minTokens && groupTokens >= minTokens) {
+			texts = append(texts, s)
+			groupTokens = n
+		} else {
+			texts[len(texts)-1] += " " + s
+			groupTokens += n
+		}
+		groupOf[i] = len(texts) - 1
+	}
+	return texts, groupOf
+}
+
+// expandGroups returns the value of each sentence's group, or nil if values is nil.
+func expandGroups[T any](values []T, groupOf []int) []T {
+	if values == nil {
+		return nil
+	}
+	out := make([]T, len(groupOf))
+	for i, g := range groupOf {
+		out[i] = values[g]
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+)
+
+// SegmentMulti segments text once for each of the given DepthThreshold values, e.g. to
+// produce coarse and fine chunk sets together. Sentence splitting, vectorization and
+// cohesion scoring (including any embedding calls) run only once; only boundary detection
+// and chunk assembly are repeated. The result maps each threshold to its chunks, as Segment
+// would return them with opts.DepthThreshold set to that value.
+//
+// The thresholds replace opts.DepthThreshold, so MinSplitSimilarity,
+// DenseMinSplitSimilarity and BoundaryPercentile must not be set.
+func SegmentMulti(text string, opts Options, thresholds []float64) (map[float64][]Chunk, error) {
+	if opts.MinSplitSimilarity > 0 || opts.DenseMinSplitSimilarity > 0 || opts.BoundaryPercentile > 0 {
+		return nil, errors.New("SegmentMulti varies DepthThreshold and cannot be combined with MinSplitSimilarity, DenseMinSplitSimilarity or BoundaryPercentile")
+	}
+	for _, threshold := range thresholds {
+		if threshold < 0 {
+			return nil, errors.New("SegmentMulti thresholds must not be negative")
+		}
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: text}
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(callContext(context.Background(), opts), SpanSegment)
+	defer span.End()
+
+	doc, err := scoreDocument(ctx, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(doc.sentences)})
+
+	result := make(map[float64][]Chunk, len(thresholds))
+	for _, threshold := range thresholds {
+		thresholdOpts := opts
+		thresholdOpts.DepthThreshold = threshold
+		result[threshold] = doc.chunks(ctx, thresholdOpts, nil)
+	}
+	return result, nil
+}
+
+// SweepResult describes the chunks produced by one MinSplitSimilarity value in a
+// ThresholdSweep.
+type SweepResult struct {
+	// Threshold is the MinSplitSimilarity value used.
+	Threshold float64
+
+	// NumChunks is the number of chunks, including those forced by MaxTokens.
+	NumChunks int
+
+	// AverageChunkTokens is the mean NumTokens of the chunks, 0 for an empty text.
+	AverageChunkTokens float64
+}
+
+// ThresholdSweep reports the chunk count and average chunk size that each MinSplitSimilarity
+// value in thresholds would produce for text, to help pick a threshold from a single call.
+// Like SegmentMulti, it splits, vectorizes and scores the text only once. The results are in
+// the order of thresholds, ready to plot; a higher threshold never yields fewer semantic
+// boundaries.
+//
+// The thresholds replace opts.MinSplitSimilarity (and DenseMinSplitSimilarity, on the dense
+// path) and must be positive. With RecencyDecay, whose centroid restarts at the threshold
+// boundaries, the cohesion scores of each threshold are recomputed from the sentence
+// embeddings, without embedding the text again, and the boundaries need not grow with the
+// threshold. This is not supported with EmbeddingMemoryBudget or
+// MinSentenceTokensForEmbedding, which keep no sentence embeddings to rescore.
+func ThresholdSweep(text string, opts Options, thresholds []float64) ([]SweepResult, error) {
+	for _, threshold := range thresholds {
+		if threshold <= 0 {
+			return nil, errors.New("ThresholdSweep thresholds must be positive")
+		}
+	}
+	validated := opts
+	if len(thresholds) > 0 {
+		// The thresholds replace MinSplitSimilarity, which RecencyDecay requires.
+		validated.MinSplitSimilarity = thresholds[0]
+	}
+	if err := validateOptions(validated); err != nil {
+		return nil, err
+	}
+	if opts.RecencyDecay > 0 && (opts.EmbeddingMemoryBudget > 0 || opts.MinSentenceTokensForEmbedding > 0) {
+		return nil, errors.New("ThresholdSweep with RecencyDecay cannot be combined with EmbeddingMemoryBudget or MinSentenceTokensForEmbedding")
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: text}
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(callContext(context.Background(), opts), SpanSegment)
+	defer span.End()
+
+	doc, err := scoreDocument(ctx, in, opts)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(doc.sentences)})
+
+	results := make([]SweepResult, len(thresholds))
+	for i, threshold := range thresholds {
+		thresholdOpts := opts
+		thresholdOpts.MinSplitSimilarity = threshold
+		thresholdOpts.DenseMinSplitSimilarity = 0
+		if opts.RecencyDecay > 0 {
+			doc.scores = calculateCohesionDense(doc.vectors, opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, threshold)
+		}
+		chunks := doc.chunks(ctx, thresholdOpts, nil)
+		results[i] = SweepResult{Threshold: threshold, NumChunks: len(chunks)}
+		if len(chunks) > 0 {
+			total := 0
+			for _, chunk := range chunks {
+				total += chunk.NumTokens
+			}
+			results[i].AverageChunkTokens = float64(total) / float64(len(chunks))
+		}
+	}
+	return results, nil
+}
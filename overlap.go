@@ -0,0 +1,52 @@
+package semseg
+
+// addOverlap prepends to each chunk up to opts.OverlapSentences of the sentences before
+// it that belong to the previous chunk, so the context around a boundary reaches both
+// chunks. The chunks are final at this point: the overlap never moves a boundary. A
+// sentence over MaxTokens or MaxChunkBytes is never repeated, and the overlap stops
+// before it, so the pieces of a sentence cut by SplitOversizedSentences do not repeat
+// each other. With OverlapPolicyFit, the earliest overlap sentences are dropped until the
+// chunk fits MaxTokens (and MaxChunkBytes, if set); Chunk.OverlapSentences records what
+// was kept.
+func addOverlap(chunks []Chunk, sentences []string, tokenCounts []int, opts Options) []Chunk {
+	if opts.OverlapSentences <= 0 || len(chunks) < 2 {
+		return chunks
+	}
+	oversized := func(i int) bool {
+		return tokenCounts[i] > opts.MaxTokens || (opts.MaxChunkBytes > 0 && len(sentences[i]) > opts.MaxChunkBytes)
+	}
+	starts := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		starts[i] = chunk.SentenceIndices[0]
+	}
+	for i := 1; i < len(chunks); i++ {
+		first := starts[i]
+		from := first
+		for from > starts[i-1] && first-from < opts.OverlapSentences && !oversized(from-1) {
+			from--
+		}
+		for ; from < first; from++ {
+			merged, mergedBytes := withOverlap(chunks[i], sentences[from:first], from, tokenCounts, opts)
+			fits := merged.NumTokens <= opts.MaxTokens && (opts.MaxChunkBytes == 0 || mergedBytes <= opts.MaxChunkBytes)
+			if opts.OverlapPolicy != OverlapPolicyFit || fits {
+				chunks[i] = merged
+				break
+			}
+		}
+	}
+	return chunks
+}
+
+// withOverlap returns chunk with the sentences overlap, which start at index first, put
+// in front of it, and the length of its text in bytes.
+func withOverlap(chunk Chunk, overlap []string, first int, tokenCounts []int, opts Options) (Chunk, int) {
+	tokens := 0
+	for i := range overlap {
+		tokens += tokenCounts[first+i]
+	}
+	prefix := makeChunk(append([]string(nil), overlap...), first, tokens, opts.ChunkJoiner, opts.ChunkOutput)
+	merged, mergedBytes := mergeChunkPair(prefix, chunk, opts.ChunkJoiner, opts.ChunkOutput)
+	merged.Duplicate = chunk.Duplicate
+	merged.OverlapSentences = len(overlap)
+	return merged, mergedBytes
+}
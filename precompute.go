@@ -0,0 +1,71 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoEmbeddingBackend is returned by BuildCache when no dense embedding backend is
+// configured.
+var ErrNoEmbeddingBackend = errors.New("no embedding backend is configured (set Options.EmbeddingProvider, or CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL)")
+
+// BuildCache precomputes the embeddings of every sentence in texts offline and returns an
+// InMemoryCache holding them, flushed to its index, so that a serving path segmenting the
+// same sentences with EmbeddingCacheMode CacheModeForce (or an activated adaptive cache)
+// is served from the cache. Sentences are split, grouped (MinSentenceTokensForEmbedding)
+// and keyed exactly as Segment does with opts, so the serving path must use the same
+// splitting and cache key options. Identical sentences are embedded once; embeddings of an
+// unexpected dimension are not cached. opts.EmbeddingCache and EmbeddingCacheMode are
+// ignored. Persist the result with SaveInMemoryCache and restore it with
+// LoadInMemoryCache.
+func BuildCache(ctx context.Context, texts []string, opts Options) (*InMemoryCache, error) {
+	opts.EmbeddingCache = nil
+	opts.EmbeddingCacheMode = CacheModeDisable
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	setDefaultOptions(&opts)
+	provider := embeddingProvider(opts)
+	if provider == nil {
+		return nil, ErrNoEmbeddingBackend
+	}
+	ctx = callContext(ctx, opts)
+
+	var unique []string
+	seen := make(map[string]bool)
+	for _, t := range texts {
+		in := segmentInput{text: t}
+		if err := checkInputSize(in, opts); err != nil {
+			return nil, err
+		}
+		_, sentences, _ := prepareSentences(ctx, in, opts)
+		if opts.OutputFormat != "" {
+			sentences, _ = markdownSentences(sentences, opts.OutputFormat)
+		}
+		if opts.MinSentenceTokensForEmbedding > 0 {
+			sentences, _ = mergeShortSentences(sentences, opts.MinSentenceTokensForEmbedding, opts.TokenCountMethod, opts.CJKTokenMode == CJKTokenModeChars)
+		}
+		for _, s := range sentences {
+			if !seen[s] {
+				seen[s] = true
+				unique = append(unique, s)
+			}
+		}
+	}
+
+	vectors, err := getOllamaEmbeddingsDirect(ctx, unique, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embeddings: %w", err)
+	}
+	cache := NewInMemoryCache()
+	keys := buildCacheKeys(unique, opts)
+	dim := embeddingDimension(vectors)
+	for i, embedding := range vectors {
+		if cacheableEmbedding(embedding, dim) {
+			cache.Set(keys[i], embedding, opts.CacheSimilarityThreshold)
+		}
+	}
+	cache.Flush()
+	return cache, nil
+}
@@ -0,0 +1,47 @@
+package semseg
+
+import "context"
+
+// progressTracker reports the number of sentences whose vectors are ready to
+// Options.OnProgress. It is only used from the goroutine running the segmentation call (the
+// Ollama workers hand their results back to it), so it needs no locking.
+type progressTracker struct {
+	fn          func(done, total int)
+	done, total int
+}
+
+// add records n more vectorized sentences and reports the new count. It is a no-op on a nil
+// tracker or for n <= 0.
+func (p *progressTracker) add(n int) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.done += n
+	p.fn(p.done, p.total)
+}
+
+type progressKey struct{}
+
+// withProgress returns a context carrying a tracker that reports to fn for a call
+// vectorizing total sentences. A nil fn leaves ctx unchanged.
+func withProgress(ctx context.Context, fn func(done, total int), total int) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressTracker{fn: fn, total: total})
+}
+
+// withoutProgress returns a context whose embedding requests are not counted as progress,
+// for embeddings that do not belong to a sentence (e.g. the topic reference).
+func withoutProgress(ctx context.Context) context.Context {
+	if progressFrom(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, (*progressTracker)(nil))
+}
+
+// progressFrom returns the tracker carried by ctx, or nil.
+func progressFrom(ctx context.Context) *progressTracker {
+	p, _ := ctx.Value(progressKey{}).(*progressTracker)
+	return p
+}
@@ -0,0 +1,82 @@
+package semseg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// EmbeddingProvider computes dense sentence embeddings. Set Options.EmbeddingProvider to use
+// a backend other than Ollama, or a deterministic fake in tests. Embed is called from
+// several worker goroutines at once, so implementations must be safe for concurrent use.
+type EmbeddingProvider interface {
+	// Embed returns the embedding of text. It should stop and return an error once ctx is
+	// done. All embeddings of a call must have the same dimension.
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+// EmbeddingProviderFunc adapts an ordinary function to the EmbeddingProvider interface.
+type EmbeddingProviderFunc func(ctx context.Context, text string) ([]float64, error)
+
+// Embed calls f(ctx, text).
+func (f EmbeddingProviderFunc) Embed(ctx context.Context, text string) ([]float64, error) {
+	return f(ctx, text)
+}
+
+// embeddingProvider returns the dense backend for opts: Options.EmbeddingProvider if set,
+// otherwise Ollama if CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL are set, otherwise nil
+// (TF-IDF).
+func embeddingProvider(opts Options) EmbeddingProvider {
+	if opts.EmbeddingProvider != nil {
+		return opts.EmbeddingProvider
+	}
+	ollamaURL := os.Getenv("CHUNKER_OLLAMA_URL")
+	ollamaModel := os.Getenv("CHUNKER_OLLAMA_MODEL")
+	if ollamaURL == "" || ollamaModel == "" {
+		return nil
+	}
+	return &ollamaProvider{client: ollamaHTTPClient(opts), url: ollamaEmbeddingsURL(ollamaURL), model: ollamaModel}
+}
+
+// ollamaProvider embeds texts with the /api/embeddings endpoint of an Ollama server.
+type ollamaProvider struct {
+	client *http.Client
+	url    string // embeddings endpoint
+	model  string
+}
+
+func (p *ollamaProvider) Embed(ctx context.Context, text string) ([]float64, error) {
+	reqBody, err := json.Marshal(ollamaRequest{Model: p.model, Prompt: text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ollama request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call ollama api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama api returned non-200 status: %s", resp.Status)
+	}
+
+	var ollamaResp ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("ollama api returned error: %s", ollamaResp.Error)
+	}
+	return ollamaResp.Embedding, nil
+}
@@ -0,0 +1,98 @@
+package semseg
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cmsdko/semseg/internal/text"
+)
+
+// ErrRangesUnavailable is returned by ChunkRanges when a chunk cannot be located in the
+// text, as happens when the chunks come from another text or when ContentType or
+// OutputFormatPlain rewrote it.
+var ErrRangesUnavailable = errors.New("chunk text not found in the input")
+
+// ChunkRanges returns, for each chunk, the byte offsets [start, end) of its source in text,
+// the exact string the chunks were segmented from, so that text[start:end] is the submitted
+// span the chunk was built from. Chunks must be in order, as returned by Segment.
+//
+// Chunk text differs from the input where sentences were trimmed or joined and where
+// PreNormalizeAbbreviations removed dots ("U.S.A." becomes "USA."), so chunks are matched
+// against text ignoring whitespace, dots and internal placeholder runes. A range extends
+// over the dots directly after its chunk, which covers a trailing dot the chunk lost
+// ("e.g." cut after "eg") and the extra dots of a long ellipsis. Text between ranges holds
+// only whitespace and dots. Punctuation separated into Chunk.SentencePunctuation is
+// matched as part of its sentence.
+//
+// The range of a chunk with Chunk.OverlapSentences starts within that of the previous
+// chunk, at its first repeated sentence. Locating the repeated sentences requires
+// Chunk.Sentences, so such chunks built with ChunkOutputText yield ErrRangesUnavailable.
+func ChunkRanges(input string, chunks []Chunk) ([][2]int, error) {
+	ranges := make([][2]int, len(chunks))
+	pos := 0
+	var sentenceStarts []int // offset of each sentence of the previous chunk
+	for i, chunk := range chunks {
+		sentences := chunk.Sentences
+		if sentences == nil {
+			sentences = []string{chunk.Text}
+		}
+		if k := chunk.OverlapSentences; k > 0 {
+			if chunk.Sentences == nil || k > len(sentenceStarts) || sentenceStarts[len(sentenceStarts)-k] < 0 {
+				return nil, ErrRangesUnavailable
+			}
+			pos = sentenceStarts[len(sentenceStarts)-k]
+		}
+		sentenceStarts = sentenceStarts[:0]
+		start, end := -1, pos
+		for j, sentence := range sentences {
+			if j < len(chunk.SentencePunctuation) {
+				sentence += chunk.SentencePunctuation[j]
+			}
+			sentenceStarts = append(sentenceStarts, -1)
+			for _, r := range sentence {
+				if unicode.IsSpace(r) {
+					continue
+				}
+				at, next, ok := matchRune(input, pos, r)
+				if !ok {
+					return nil, ErrRangesUnavailable
+				}
+				if start < 0 {
+					start = at
+				}
+				if sentenceStarts[j] < 0 {
+					sentenceStarts[j] = at
+				}
+				pos, end = next, next
+			}
+		}
+		if start < 0 {
+			start = end
+		}
+		if start < end {
+			for end < len(input) && input[end] == '.' {
+				end++
+			}
+			pos = end
+		}
+		ranges[i] = [2]int{start, end}
+	}
+	return ranges, nil
+}
+
+// matchRune finds r in input at or after pos, skipping only runes the pipeline may have
+// dropped, and returns the offsets of the match and just past it.
+func matchRune(input string, pos int, r rune) (at, next int, ok bool) {
+	for pos < len(input) {
+		c, size := utf8.DecodeRuneInString(input[pos:])
+		if c == r {
+			return pos, pos + size, true
+		}
+		if !unicode.IsSpace(c) && c != '.' && !text.IsSentinelRune(c) {
+			return 0, 0, false
+		}
+		pos += size
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,345 @@
+// Package rediscache provides a semseg.EmbeddingCache backed by Redis, so that cached
+// embeddings are shared between replicas of a service.
+//
+// It speaks the Redis protocol (RESP) directly over TCP and has no third-party
+// dependencies. Keys are matched exactly: an n-gram key vector is hashed, and Find only hits
+// when an identical vector was stored (approximate matching is left to in-process caches
+// such as semseg.InMemoryCache, which can be layered in front of it).
+package rediscache
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPrefix      = "semseg:"
+	defaultDialTimeout = 2 * time.Second
+	defaultIOTimeout   = 2 * time.Second
+	defaultPoolSize    = 8
+)
+
+// Options configures a Cache.
+type Options struct {
+	// Prefix is prepended to every Redis key. Default: "semseg:".
+	Prefix string
+
+	// Password and DB select the credentials and logical database used after connecting.
+	Password string
+	DB       int
+
+	// TTL expires stored embeddings after this duration. Default: 0 (no expiry).
+	TTL time.Duration
+
+	// DialTimeout bounds connection attempts and IOTimeout bounds each command.
+	// Defaults: 2s each.
+	DialTimeout time.Duration
+	IOTimeout   time.Duration
+
+	// PoolSize bounds the connections open at once, so that concurrent commands (from the
+	// embedding workers of one call, or from calls sharing the cache) do not queue behind
+	// one round trip; a command waits when all of them are busy. Default: 8.
+	PoolSize int
+
+	// Logger receives the failures that Set and Find cannot return: dropped entries, failed
+	// neighbor counts and malformed entries. Default: nil (silent).
+	Logger *log.Logger
+}
+
+// Cache is a semseg.EmbeddingCache stored in Redis. It is safe for concurrent use.
+//
+// On connection failures Find returns an error, which segmentation treats as a miss unless
+// semseg.Options.CacheErrorPolicy is "fail_closed"; Set drops the entry, and the
+// connection is re-established on the next call.
+type Cache struct {
+	addr string
+	opts Options
+
+	slots chan struct{} // one token per open connection, at most PoolSize
+	idle  chan *conn    // connections not in use
+
+	mu     sync.Mutex // guards closed, and idle against Close
+	closed bool
+}
+
+// conn is one pooled connection to the server.
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// New returns a Cache for the Redis server at addr ("host:port"). Connections are opened
+// lazily, as concurrent commands need them, and kept for reuse.
+func New(addr string, opts Options) *Cache {
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = defaultIOTimeout
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = defaultPoolSize
+	}
+	return &Cache{
+		addr:  addr,
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+		idle:  make(chan *conn, opts.PoolSize),
+	}
+}
+
+// Find returns the embedding stored under exactly this key vector. The threshold is
+// accepted for interface compatibility; only identical keys match. A failed GET is
+// returned as an error, which the segmenter treats according to
+// semseg.Options.CacheErrorPolicy; a malformed entry is logged to Options.Logger and
+// reported as a miss.
+func (c *Cache) Find(key map[string]float64, threshold float64) ([]float64, bool, error) {
+	reply, err := c.do("GET", c.entryKey(key))
+	if err != nil {
+		return nil, false, fmt.Errorf("rediscache: GET failed: %w", err)
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false, nil
+	}
+	embedding, err := decodeEmbedding(data)
+	if err != nil {
+		c.logf("rediscache: ignoring malformed entry: %v", err)
+		return nil, false, nil
+	}
+	return embedding, true, nil
+}
+
+// Set stores the embedding under the key vector. If an identical key is already stored,
+// the existing entry is kept and counted as an item with a neighbor (see AnalyzeSimilarity).
+func (c *Cache) Set(key map[string]float64, embedding []float64, similarityThreshold float64) {
+	args := []string{"SET", c.entryKey(key), string(encodeEmbedding(embedding)), "NX"}
+	if c.opts.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.opts.TTL.Milliseconds(), 10))
+	}
+	reply, err := c.do(args...)
+	if err != nil {
+		c.logf("rediscache: SET failed, dropping entry: %v", err)
+		return
+	}
+	if reply == nil { // NX: the key already existed
+		if _, err := c.do("INCR", c.opts.Prefix+"neighbors"); err != nil {
+			c.logf("rediscache: INCR failed: %v", err)
+		}
+	}
+}
+
+// AnalyzeSimilarity returns the number of Set calls, across all replicas sharing the
+// prefix, whose key was already stored. The threshold is ignored since keys match exactly.
+func (c *Cache) AnalyzeSimilarity(threshold float64) int {
+	reply, err := c.do("GET", c.opts.Prefix+"neighbors")
+	if err != nil {
+		return 0
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Close closes the connections. Later calls behave like calls against an unreachable
+// server; commands in flight complete and then close their connection.
+func (c *Cache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// logf logs to Options.Logger, if set.
+func (c *Cache) logf(format string, args ...interface{}) {
+	if c.opts.Logger != nil {
+		c.opts.Logger.Printf(format, args...)
+	}
+}
+
+// entryKey returns the Redis key for a key vector: the prefix plus a SHA-256 hash of the
+// vector's terms and weights in sorted order.
+func (c *Cache) entryKey(key map[string]float64) string {
+	terms := make([]string, 0, len(key))
+	for term := range key {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	h := sha256.New()
+	var buf [8]byte
+	for _, term := range terms {
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(term)))
+		h.Write(buf[:])
+		h.Write([]byte(term))
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(key[term]))
+		h.Write(buf[:])
+	}
+	return c.opts.Prefix + "e:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// do sends one command on a pooled connection and returns its reply: []byte for bulk
+// strings (nil if missing), string for simple strings and int64 for integers. On any I/O
+// error, the connection is dropped so a later call reconnects.
+func (c *Cache) do(args ...string) (interface{}, error) {
+	c.slots <- struct{}{}
+	defer func() { <-c.slots }()
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, errors.New("cache is closed")
+	}
+
+	var cn *conn
+	select {
+	case cn = <-c.idle:
+	default:
+		var err error
+		if cn, err = c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := cn.roundTrip(args, c.opts.IOTimeout)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.Close()
+	} else {
+		c.idle <- cn // never blocks: slots bound the connections to the capacity of idle
+	}
+	return reply, nil
+}
+
+func (c *Cache) connect() (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", c.addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if c.opts.Password != "" {
+		if _, err := cn.roundTrip([]string{"AUTH", c.opts.Password}, c.opts.IOTimeout); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("AUTH: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.roundTrip([]string{"SELECT", strconv.Itoa(c.opts.DB)}, c.opts.IOTimeout); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("SELECT: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+func (cn *conn) roundTrip(args []string, timeout time.Duration) (interface{}, error) {
+	if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := cn.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// encodeCommand encodes a command as a RESP array of bulk strings.
+func encodeCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readReply reads a single RESP reply. Error replies are returned as errors.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, errors.New(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	default:
+		return nil, fmt.Errorf("unsupported reply type %q", line[0])
+	}
+}
+
+// encodeEmbedding serializes an embedding as little-endian float64 values.
+func encodeEmbedding(embedding []float64) []byte {
+	data := make([]byte, 8*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return data
+}
+
+func decodeEmbedding(data []byte) ([]float64, error) {
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("entry length %d is not a multiple of 8", len(data))
+	}
+	embedding := make([]float64, len(data)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+	}
+	return embedding, nil
+}
@@ -0,0 +1,230 @@
+package rediscache
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmsdko/semseg"
+)
+
+var _ semseg.EmbeddingCache = (*Cache)(nil)
+
+// fakeRedis is an in-process server speaking the subset of RESP used by Cache
+// (GET, SET [NX] [PX], INCR, AUTH, SELECT).
+type fakeRedis struct {
+	listener net.Listener
+	delay    time.Duration // added to every reply, as network latency
+	mu       sync.Mutex
+	data     map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeRedis{listener: l, data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeRedis) addr() string { return s.listener.Addr().String() }
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		time.Sleep(s.delay)
+		conn.Write([]byte(s.exec(args)))
+	}
+}
+
+func (s *fakeRedis) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "SET":
+		if _, exists := s.data[args[1]]; exists && len(args) > 3 && strings.ToUpper(args[3]) == "NX" {
+			return "$-1\r\n"
+		}
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "INCR":
+		n, _ := strconv.Atoi(s.data[args[1]])
+		s.data[args[1]] = strconv.Itoa(n + 1)
+		return ":" + strconv.Itoa(n+1) + "\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// TestCacheRoundTrip checks that an embedding stored by one Cache is found by another
+// (as by a second replica), that only identical keys match, and that repeated keys are counted.
+func TestCacheRoundTrip(t *testing.T) {
+	server := newFakeRedis(t)
+	writer := New(server.addr(), Options{Password: "secret", DB: 2})
+	defer writer.Close()
+	reader := New(server.addr(), Options{})
+	defer reader.Close()
+
+	key := semseg.VectorizeForCache("The ocean covers most of the Earth.")
+	embedding := []float64{0.25, -1.5, 3}
+	writer.Set(key, embedding, 0.9)
+
+	got, ok, err := reader.Find(semseg.VectorizeForCache("The ocean covers most of the Earth."), 0.9)
+	if err != nil || !ok || !reflect.DeepEqual(got, embedding) {
+		t.Fatalf("Expected hit with %v, got %v (ok=%v, err=%v)", embedding, got, ok, err)
+	}
+	if _, ok, err := reader.Find(semseg.VectorizeForCache("The ocean covers most of the planet."), 0.5); ok || err != nil {
+		t.Errorf("Expected a miss for a different key, got ok=%v err=%v", ok, err)
+	}
+
+	if n := reader.AnalyzeSimilarity(0.9); n != 0 {
+		t.Errorf("Expected 0 repeated keys, got %d", n)
+	}
+	writer.Set(key, []float64{9, 9, 9}, 0.9)
+	if n := reader.AnalyzeSimilarity(0.9); n != 1 {
+		t.Errorf("Expected 1 repeated key, got %d", n)
+	}
+	if got, _, _ := reader.Find(key, 0.9); !reflect.DeepEqual(got, embedding) {
+		t.Errorf("Expected the first entry to be kept, got %v", got)
+	}
+}
+
+// TestCacheUnavailable checks that lookups on an unreachable server fail with an error
+// rather than a miss, and that the cache recovers once the server is back.
+func TestCacheUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cache := New(addr, Options{DialTimeout: 100 * time.Millisecond})
+	defer cache.Close()
+	key := semseg.VectorizeForCache("Mars is red.")
+	cache.Set(key, []float64{1}, 0.9)
+	if _, ok, err := cache.Find(key, 0.9); ok || err == nil {
+		t.Errorf("Expected an error while the server is down, got ok=%v err=%v", ok, err)
+	}
+	if n := cache.AnalyzeSimilarity(0.9); n != 0 {
+		t.Errorf("Expected 0 while the server is down, got %d", n)
+	}
+
+	server := newFakeRedis(t)
+	cache.addr = server.addr()
+	cache.Set(key, []float64{1}, 0.9)
+	if _, ok, err := cache.Find(key, 0.9); !ok || err != nil {
+		t.Errorf("Expected a hit after the server came back, got ok=%v err=%v", ok, err)
+	}
+
+	cache.Close()
+	if _, ok, err := cache.Find(key, 0.9); ok || err == nil {
+		t.Errorf("Expected an error after Close, got ok=%v err=%v", ok, err)
+	}
+}
+
+// TestCachePool checks that concurrent lookups use separate connections instead of queuing
+// behind one round trip, and that PoolSize bounds them.
+func TestCachePool(t *testing.T) {
+	server := newFakeRedis(t)
+	server.delay = 50 * time.Millisecond
+	key := semseg.VectorizeForCache("The ocean is deep.")
+
+	elapsed := func(poolSize int) time.Duration {
+		cache := New(server.addr(), Options{PoolSize: poolSize})
+		defer cache.Close()
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := cache.Find(key, 0.9); err != nil {
+					t.Errorf("Find() error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+
+	if d := elapsed(4); d >= 150*time.Millisecond {
+		t.Errorf("Expected 4 lookups on 4 connections to overlap, took %v", d)
+	}
+	if d := elapsed(1); d < 200*time.Millisecond {
+		t.Errorf("Expected 4 lookups on 1 connection to run one after another, took %v", d)
+	}
+}
+
+// TestCacheLogger checks that dropped entries are reported to Options.Logger.
+func TestCacheLogger(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var buf bytes.Buffer
+	cache := New(addr, Options{DialTimeout: 100 * time.Millisecond, Logger: log.New(&buf, "", 0)})
+	defer cache.Close()
+	cache.Set(semseg.VectorizeForCache("Mars is red."), []float64{1}, 0.9)
+	if !strings.Contains(buf.String(), "SET failed") {
+		t.Errorf("Expected the dropped entry to be logged, got %q", buf.String())
+	}
+}
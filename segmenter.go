@@ -0,0 +1,64 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSegmenterClosed is returned by Segmenter methods called after Close.
+var ErrSegmenterClosed = errors.New("segmenter is closed")
+
+// Segmenter is a long-lived segmentation service: it validates its Options once, resolves
+// the embedding backend (Options.EmbeddingProvider, or the Ollama server from
+// CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, with its HTTP client) and owns the embedding
+// cache, which Close shuts down. Use it instead of the package-level functions when many
+// calls share a cache or backend; Segment remains the simplest choice for one-shot use.
+//
+// A Segmenter is safe for concurrent use.
+type Segmenter struct {
+	opts Options
+
+	mu     sync.RWMutex // held for reading by calls in flight, for writing by Close
+	closed bool
+}
+
+// NewSegmenter validates opts and returns a Segmenter using them for every call. The
+// embedding backend is resolved now, so later changes to the CHUNKER_OLLAMA_* environment
+// variables do not affect it. The Segmenter takes ownership of opts.EmbeddingCache, if any.
+func NewSegmenter(opts Options) (*Segmenter, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	setDefaultOptions(&opts)
+	opts.EmbeddingProvider = embeddingProvider(opts)
+	if err := checkCacheBackend(opts, opts.EmbeddingProvider != nil); err != nil {
+		return nil, err
+	}
+	return &Segmenter{opts: opts}, nil
+}
+
+// Segment splits text into semantic chunks like SegmentContext, using the Segmenter's
+// options.
+func (s *Segmenter) Segment(ctx context.Context, text string) ([]Chunk, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrSegmenterClosed
+	}
+	return segment(ctx, segmentInput{text: text}, s.opts, nil)
+}
+
+// Close waits for the calls in flight to finish, then closes the embedding cache. Later
+// calls fail with ErrSegmenterClosed. Close is idempotent.
+func (s *Segmenter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.opts.EmbeddingCache != nil {
+		s.opts.EmbeddingCache.Close()
+	}
+}
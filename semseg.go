@@ -4,17 +4,23 @@
 package semseg
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"os"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/cmsdko/semseg/internal/lang"
 	"github.com/cmsdko/semseg/internal/text"
@@ -38,21 +44,191 @@ const (
 	CacheModeForce = "force"
 	// CacheModeAdaptive starts with caching disabled for lookups but asynchronously populates the cache
 	// in the background. Once the cache contains enough similar items (see AdaptiveCacheActivationThreshold),
-	// it automatically switches to 'force' mode for all subsequent requests.
+	// it automatically switches to 'force' mode for all subsequent requests. A request during
+	// which activation happens still fetches its embeddings directly, but writes them to the
+	// cache before returning, so the next request can use them.
 	CacheModeAdaptive = "adaptive"
 )
 
+// Constants for Options.CacheErrorPolicy.
+const (
+	// CacheErrorFailOpen treats a failed cache lookup as a miss: the sentence is embedded by
+	// the provider. This is the default.
+	CacheErrorFailOpen = "fail_open"
+	// CacheErrorFailClosed aborts the call with an error wrapping ErrCacheLookup when a
+	// lookup fails, so an unavailable cache does not turn into extra embedding calls.
+	CacheErrorFailClosed = "fail_closed"
+)
+
+// Constants for Options.TokenCountMethod.
+const (
+	// TokenCountWords counts the words kept by the tokenizer, ignoring punctuation. This is
+	// the default.
+	TokenCountWords = "words"
+	// TokenCountWordsAndPunctuation counts the words plus every punctuation or symbol
+	// character, which is closer to LLM tokenizers that emit punctuation as separate tokens.
+	TokenCountWordsAndPunctuation = "words_and_punctuation"
+	// TokenCountChars estimates one token per four characters, whitespace and punctuation
+	// included (rounded up), a common rule of thumb for subword tokenizers.
+	TokenCountChars = "chars"
+)
+
+// Constants for Options.CJKTokenMode.
+const (
+	// CJKTokenModeWords counts Chinese and Japanese text like any other text, by
+	// whitespace-separated words. This is the default.
+	CJKTokenModeWords = "words"
+	// CJKTokenModeChars counts every Han, Hiragana or Katakana character as one token.
+	CJKTokenModeChars = "chars"
+)
+
+// Constants for Options.IDFFormula.
+const (
+	IDFLogSmooth = tfidf.IDFLogSmooth
+	IDFSklearn   = tfidf.IDFSklearn
+)
+
+// Constants for Options.ChunkPooling.
+const (
+	// ChunkPoolingMean averages the sentence embeddings of a chunk.
+	ChunkPoolingMean = "mean"
+	// ChunkPoolingLengthWeighted averages the sentence embeddings weighted by each
+	// sentence's token count, so short sentences do not skew the chunk vector.
+	ChunkPoolingLengthWeighted = "length_weighted"
+	// ChunkPoolingMax takes the element-wise maximum of the sentence embeddings.
+	ChunkPoolingMax = "max"
+)
+
+// Constants for Options.ChunkHash.
+const (
+	// ChunkHashNone leaves Chunk.Hash empty. This is the default.
+	ChunkHashNone = "none"
+	// ChunkHashNormalized hashes the chunk's tokens (lowercased, punctuation stripped), so
+	// chunks differing only in case, punctuation or whitespace share a hash.
+	ChunkHashNormalized = "normalized"
+	// ChunkHashRaw hashes Chunk.Text verbatim.
+	ChunkHashRaw = "raw"
+)
+
+// Constants for Options.ChunkOutput.
+const (
+	// ChunkOutputBoth fills both Chunk.Text and Chunk.Sentences. This is the default.
+	ChunkOutputBoth = "both"
+	// ChunkOutputText fills only Chunk.Text; Chunk.Sentences is nil.
+	ChunkOutputText = "text"
+	// ChunkOutputSentences fills only Chunk.Sentences; Chunk.Text is empty.
+	ChunkOutputSentences = "sentences"
+)
+
+// Constants for Options.OverlapPolicy.
+const (
+	// OverlapPolicyExceed decides the boundaries without the overlap and then adds it, so a
+	// chunk may exceed MaxTokens (and MaxChunkBytes) by its overlap. This is the default.
+	OverlapPolicyExceed = "exceed"
+	// OverlapPolicyFit drops the earliest overlap sentences of a chunk until it fits
+	// MaxTokens (and MaxChunkBytes), possibly all of them.
+	OverlapPolicyFit = "fit"
+)
+
+// Constants for Options.OutputFormat.
+const (
+	// OutputFormatOriginal returns chunk text with its Markdown syntax, as in the input.
+	OutputFormatOriginal = "original"
+	// OutputFormatPlain returns chunk text with Markdown syntax removed.
+	OutputFormatPlain = "plain"
+)
+
+// Constants for Options.TokenSeparators.
+const (
+	// TokenSeparatorsJoin drops in-word separators, fusing the words: "TCP/IP" -> "tcpip".
+	TokenSeparatorsJoin = "join"
+	// TokenSeparatorsSplit splits at in-word separators: "TCP/IP" -> "tcp", "ip".
+	TokenSeparatorsSplit = "split"
+	// TokenSeparatorsKeep keeps in-word separators inside the token: "TCP/IP" -> "tcp/ip".
+	TokenSeparatorsKeep = "keep"
+)
+
 // Constants for Ollama worker pool
 const (
 	OllamaMaxWorkersEnvVar = "CHUNKER_OLLAMA_MAX_WORKERS"
 	DefaultOllamaWorkers   = 4
 )
 
+// ErrCacheWithoutEmbeddingBackend is returned when EmbeddingCacheMode enables the cache but
+// no embedding backend is configured: the cache only stores dense embeddings, so it would
+// silently go unused on the TF-IDF path.
+var ErrCacheWithoutEmbeddingBackend = errors.New("EmbeddingCacheMode is enabled but no embedding backend is configured (set CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, or disable the cache)")
+
+// ErrRecencyDecayWithoutEmbeddingBackend is returned when RecencyDecay is set but no
+// embedding backend is configured: the recency-weighted centroid is only computed from dense
+// embeddings, so on the TF-IDF path the option would silently have no effect.
+var ErrRecencyDecayWithoutEmbeddingBackend = errors.New("RecencyDecay is set but no embedding backend is configured (set CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, or unset RecencyDecay)")
+
+// ErrChunkPoolingWithoutEmbeddingBackend is returned when ChunkPooling is set but no
+// embedding backend is configured: TF-IDF vectors are not pooled, so every Chunk.Embedding
+// would silently be nil.
+var ErrChunkPoolingWithoutEmbeddingBackend = errors.New("ChunkPooling is set but no embedding backend is configured (set CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, or unset ChunkPooling)")
+
+// ErrMinSentenceTokensWithoutEmbeddingBackend is returned when MinSentenceTokensForEmbedding
+// is set but no embedding backend is configured: only embeddings are merged, so the option
+// would silently have no effect on the TF-IDF path.
+var ErrMinSentenceTokensWithoutEmbeddingBackend = errors.New("MinSentenceTokensForEmbedding is set but no embedding backend is configured (set CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, or unset MinSentenceTokensForEmbedding)")
+
+// ErrIntraBatchDedupWithoutEmbeddingBackend is returned when IntraBatchDedupThreshold is set
+// but no embedding backend is configured: only embedding calls are deduplicated, so the
+// option would silently have no effect on the TF-IDF path.
+var ErrIntraBatchDedupWithoutEmbeddingBackend = errors.New("IntraBatchDedupThreshold is set but no embedding backend is configured (set CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, or unset IntraBatchDedupThreshold)")
+
+// ErrCacheLookup is returned (wrapped, along with the cache's error) when a lookup in
+// Options.EmbeddingCache fails and CacheErrorPolicy is CacheErrorFailClosed.
+var ErrCacheLookup = errors.New("embedding cache lookup failed")
+
+// ErrInputTooLarge is returned when the input exceeds Options.MaxInputBytes.
+var ErrInputTooLarge = errors.New("input exceeds MaxInputBytes")
+
+// ErrBatchDeadlineExceeded is returned (wrapped) when a batch of embedding requests runs
+// longer than Options.OllamaBatchDeadline.
+var ErrBatchDeadlineExceeded = errors.New("embedding batch exceeded OllamaBatchDeadline")
+
 // ... (Chunk struct remains the same) ...
 type Chunk struct {
 	Text      string
 	Sentences []string
 	NumTokens int
+
+	// SentenceIndices holds the position of each chunk sentence in the document's sentence
+	// list, so chunks can be mapped back to other sentence-aligned data. Indices are contiguous.
+	SentenceIndices []int
+
+	// Embedding is the chunk vector pooled from its sentence embeddings as selected by
+	// Options.ChunkPooling. It is nil unless ChunkPooling is set and a dense embedding
+	// backend is used.
+	Embedding []float64 `json:",omitempty"`
+
+	// Hash is the hex-encoded SHA-256 of the chunk's content as selected by
+	// Options.ChunkHash. It is stable across runs and versions of the input that only
+	// differ outside the chunk, so downstream systems can skip re-indexing unchanged chunks.
+	// It is empty unless ChunkHash is set.
+	Hash string `json:",omitempty"`
+
+	// Duplicate reports that the chunk is near-identical to the preceding chunk (see
+	// Options.CoalesceSimilarChunks) but could not be coalesced with it within MaxTokens.
+	Duplicate bool `json:",omitempty"`
+
+	// SentencePunctuation holds, with Options.SeparateTerminalPunctuation, the terminal
+	// punctuation cut off each entry of Sentences, so Sentences[i]+SentencePunctuation[i] is
+	// the original sentence. An entry is empty for a sentence without terminal punctuation.
+	SentencePunctuation []string `json:",omitempty"`
+
+	// Cohesion is the mean cohesion score of the gaps between the chunk's sentences, a
+	// measure of how tight the chunk is that callers can use to down-rank loose chunks. It is
+	// 1 for a single-sentence chunk, which has no inner gap.
+	Cohesion float64
+
+	// OverlapSentences is the number of leading entries of SentenceIndices (and Sentences)
+	// repeated from the end of the previous chunk (see Options.OverlapSentences). They are
+	// included in Text and NumTokens.
+	OverlapSentences int `json:",omitempty"`
 }
 
 // Options configures the segmentation process.
@@ -71,37 +247,994 @@ type Options struct {
 	TfidfMaxNgramSize         int
 	HTTPClient                *http.Client
 
+	// CandidateLanguages restricts language detection to these languages (e.g.
+	// []string{"english", "french", "german"}) instead of every language of the text's
+	// script. Callers who know their corpus avoid scoring dozens of Latin-script languages,
+	// which is faster and avoids ties with unrelated languages. Default: nil (all languages
+	// of the detected script).
+	CandidateLanguages []string
+
+	// SplitOnScriptChange places a boundary wherever the script most letters of a sentence
+	// are written in (Latin, Cyrillic, Greek, ...) differs from that of the previous
+	// sentence with letters, so a chunk never mixes, e.g., Latin and Cyrillic text when
+	// cohesion happens to stay high across the switch. Script detection is cheaper and more
+	// reliable than language detection for this, and works in every detection mode.
+	// Sentences without letters never cause a boundary. Default: false.
+	SplitOnScriptChange bool
+
+	// LanguageDetectionSkipFirst ignores the first K sentences in the "first_ten_sentences"
+	// and "full_text" detection modes (the ten sentences then start after them). Opening
+	// sentences such as titles, bylines or datelines are often atypical and can mislead
+	// detection. If the text has no more than K sentences, none are skipped. Default: 0.
+	LanguageDetectionSkipFirst int
+
+	// StemmingMinLen and StemmingOneShot override the stemming rules loaded for the
+	// language: words shorter than StemmingMinLen are not stemmed, and StemmingOneShot
+	// controls whether stemming stops after the first stripped prefix/suffix. Raise
+	// StemmingMinLen to make stemming less aggressive (e.g. keep "running" intact).
+	// Defaults: 0 and nil, which keep the values from the language data.
+	StemmingMinLen  int
+	StemmingOneShot *bool
+
+	// MaxInputBytes rejects inputs longer than this many bytes with ErrInputTooLarge before
+	// any normalization or sentence splitting, bounding the allocations of the regex passes
+	// on pathological inputs. For SegmentTokenized, the sentence lengths are summed.
+	// Default: 0 (no limit).
+	MaxInputBytes int
+
+	// StopWordWeight keeps stopwords for TF-IDF similarity instead of removing them, with
+	// their TF-IDF values multiplied by this weight (range 0.0 to 1.0). This avoids near-zero
+	// vectors for short sentences made mostly of stopwords, which otherwise score zero
+	// cohesion and attract spurious boundaries. Only used when EnableStopWordRemoval is
+	// true. Default: 0 (stopwords are removed).
+	StopWordWeight float64
+
+	// TokenSeparators selects how the TF-IDF similarity tokens treat the in-word separators
+	// slash, ampersand and underscore ("TCP/IP", "R&D", "snake_case"), which the tokenizer
+	// would otherwise fuse with the surrounding words: TokenSeparatorsJoin,
+	// TokenSeparatorsSplit or TokenSeparatorsKeep. Separators at the edges of a word are
+	// always dropped. Not used in n-gram mode or for token counting. Default:
+	// TokenSeparatorsJoin.
+	TokenSeparators string
+
+	// KeepEmoji adds every emoji of a sentence to its TF-IDF similarity tokens (one token
+	// per emoji, skin tones and joined sequences included), which the tokenizer otherwise
+	// drops as symbols. In social-media text emoji carry topical signal, and a change of
+	// emoji often marks a boundary. Not used in n-gram mode. Default: false.
+	KeepEmoji bool
+
+	// IDFFormula selects how TF-IDF weights rare terms, with N sentences, df the number of
+	// sentences containing the term and k = IDFSmoothing:
+	//   - IDFLogSmooth ("log_smooth", default): log(1 + N/(k+df))
+	//   - IDFSklearn ("sklearn"): log((N+k)/(df+k)) + 1, scikit-learn's smooth_idf formula
+	//     (for k = 1), for reproducing results computed with TfidfVectorizer.
+	// IDFSmoothing must be positive; a larger k flattens the weights of rare terms.
+	// Default: 1.
+	IDFFormula   string
+	IDFSmoothing *float64
+
+	// TFIDFSimilarity compares TF-IDF sentence vectors (or, with BlockComparisonSize, block
+	// centroids) to score cohesion on the TF-IDF path. JensenShannonSimilarity treats the
+	// vectors as term distributions and can separate topics better for short sentences; its
+	// scores are on a different scale than cosine, so MinSplitSimilarity may need retuning.
+	// TopicReference similarities always use cosine. Default: CosineSimilarity.
+	TFIDFSimilarity SimilarityFunc
+
+	// TfidfMaxVocab caps the TF-IDF vocabulary at this many terms, keeping those found in the
+	// most sentences and ignoring the rest when vectorizing. Terms of a single sentence never
+	// link two sentences, so they go first. In n-gram mode on large documents the vocabulary
+	// can reach hundreds of thousands of n-grams; the cap bounds the memory held by the
+	// corpus and the sentence vectors at a small quality cost. Default: 0 (no cap).
+	TfidfMaxVocab int
+
+	// DeduplicateCorpus counts identical sentences (after preprocessing) only once when
+	// computing TF-IDF document frequencies, so repeated boilerplate does not lower the IDF of
+	// its terms. It only affects the weights: chunks still contain every original sentence.
+	// Default: false.
+	DeduplicateCorpus bool
+
+	// Abbreviations lists extra dotted abbreviations (e.g. "Fig.", "Eq.", "Ref.") that are
+	// normalized like the contractions of the language data: their dots are removed before
+	// sentence splitting, so "see Fig. 3" is not split after "Fig.". They are merged with the
+	// detected language's list and apply to every language, including unknown ones. Entries
+	// are matched case-sensitively and must contain a dot. Only used when
+	// PreNormalizeAbbreviations is true. Default: nil.
+	Abbreviations []string
+
+	// QuoteAwareSplitting keeps quoted speech in one sentence: no sentence boundary is placed
+	// inside balanced quotation marks, and a closing quote followed by lowercase text does not
+	// end the sentence (`She said, "Go home. Now." and left.` stays whole). If the quotes of
+	// the text are unbalanced, splitting falls back to the default rules. QuotePairs lists
+	// the tracked pairs as two-rune strings of opening and closing mark (e.g. `“”`, `""`);
+	// when empty, `""`, `“”` and `«»` are used. Default: false.
+	QuoteAwareSplitting bool
+	QuotePairs          []string
+
+	// SplitNumberDotCapital also ends a sentence at a dot between a digit and an uppercase
+	// letter with no space in between, as in the typo "See Section 1.Next section" or the
+	// numbered list "1.First 2.Second", which the default rules keep whole. The cut is after
+	// the dot ("See Section 1.", "Next section"), unless the number is a list marker: it
+	// starts the text, follows a sentence end or a colon, or has a consecutive marker (N-1 or
+	// N+1); a marker starts the new sentence ("1.First", "2.Second"). Decimals like "3.14"
+	// are never split. Default: false.
+	SplitNumberDotCapital bool
+
+	// ChunkJoiner is placed between sentences when building Chunk.Text (e.g. "\n" to keep
+	// one sentence per line, or "" for scripts without word spacing). When nil, a single
+	// space is used, except between two sentences in Chinese/Japanese script, which are
+	// joined without a separator.
+	ChunkJoiner *string
+
+	// OutputFormat marks the input as Markdown. Boundaries are then computed on sentences
+	// with Markdown syntax removed (headings, list markers, emphasis, code marks, link
+	// targets), so formatting does not affect similarity, and OutputFormat selects the text
+	// of the returned chunks: OutputFormatOriginal keeps the Markdown, OutputFormatPlain
+	// returns the stripped text (sentences holding only syntax, such as a lone code fence,
+	// are dropped). Token counts are taken from the returned text. Ignored by
+	// SegmentTokenized. Default: "" (the input is not treated as Markdown).
+	OutputFormat string
+
+	// ContentType declares the media type of the input, such as ContentTypeHTML, and
+	// selects the preprocessor that cleans the text before it is split into sentences.
+	// ContentTypePlain, ContentTypeHTML and ContentTypeMarkdown are built in; others can be
+	// added with RegisterPreprocessor. Parameters such as "; charset=utf-8" are ignored.
+	// Unlike OutputFormat, the returned chunks hold the cleaned text. Ignored by
+	// SegmentTokenized. Default: "" (no preprocessing).
+	ContentType string
+
+	// BoundaryPercentile (range 0 to 100) places boundaries at the lowest P% of cohesion
+	// scores in the document (rounded to the nearest whole gap), e.g. 20 turns the bottom
+	// 20% of sentence gaps into boundaries. This adapts to each document's score scale,
+	// which differs between TF-IDF and dense embeddings.
+	// Precedence: MinSplitSimilarity (if > 0) wins over BoundaryPercentile, which in turn
+	// replaces local-minima detection with DepthThreshold. Default: 0 (disabled).
+	BoundaryPercentile float64
+
+	// DenseMinSplitSimilarity replaces MinSplitSimilarity when a dense embedding backend
+	// scores the text. MinSplitSimilarity suits TF-IDF cosines, which span the whole range,
+	// while dense cosines cluster high (often 0.6 to 0.9 between unrelated sentences), so
+	// the same value would never split. Set both to configure each path; the TF-IDF path
+	// always uses MinSplitSimilarity. Precedence is as for MinSplitSimilarity.
+	// Default: 0 (MinSplitSimilarity on both paths).
+	DenseMinSplitSimilarity float64
+
+	// MinDenseCohesionVariance flags low-quality dense embeddings: when the variance of the
+	// cohesion scores of a text scored by a dense backend is below this value,
+	// SegmentWithDetails reports WarningLowCohesionVariance. A model unsuited to the text
+	// (another domain or language) scores every gap alike, e.g. all near 0.99 or all near
+	// 0, so no boundary is meaningful. Values around 1e-4 catch such degenerate scores.
+	// Texts with fewer than three sentences are not checked. Default: 0 (disabled).
+	MinDenseCohesionVariance float64
+
+	// SplitOversizedSentences splits a sentence longer than MaxTokens into consecutive chunks
+	// of at most MaxTokens tokens, cut at word boundaries, instead of emitting it as a single
+	// oversized chunk. Each piece becomes its own chunk whose Sentences holds the piece and
+	// whose SentenceIndices repeats the index of the original sentence. Default: false.
+	SplitOversizedSentences bool
+
+	// TokenCountMethod selects how NumTokens and the MaxTokens limit count tokens:
+	// TokenCountWords, TokenCountWordsAndPunctuation or TokenCountChars. The latter two
+	// give a safer estimate for LLM context budgets. Ignored by SegmentTokenized, which
+	// counts the given tokens. Default: TokenCountWords.
+	TokenCountMethod string
+
+	// CJKTokenMode selects how Chinese and Japanese text (Han, Hiragana and Katakana
+	// characters), which has no spaces between words, is counted by the word-based
+	// TokenCountMethods. With the default CJKTokenModeWords, a whole CJK sentence often
+	// counts as a single token, so MaxTokens has no effect. CJKTokenModeChars counts each
+	// CJK character as one token, and lets SplitOversizedSentences cut between CJK
+	// characters. Other scripts in the same text are counted as before. Not used with
+	// TokenCountChars. Default: CJKTokenModeWords.
+	CJKTokenMode string
+
+	// MaxTokensSlack lets a chunk exceed MaxTokens by up to this many tokens when that
+	// moves a token-limit cut onto a nearby semantic boundary (or the end of the text):
+	// instead of splitting as soon as the next sentence does not fit, the following sentences
+	// up to the boundary are kept in the chunk if the total stays within MaxTokens+slack.
+	// Sentences longer than MaxTokens still stand alone. Default: 0 (strict limit).
+	MaxTokensSlack int
+
+	// Balanced evens out the sizes of the chunks that MaxTokens (or MaxChunkBytes) forces
+	// apart. Instead of filling each chunk greedily, which often leaves a tiny last chunk
+	// before a semantic boundary, the sentences between two boundaries are distributed
+	// into the same number of chunks with the token counts as equal as possible. Semantic
+	// boundaries and the limits are respected as before; MaxTokensSlack is not applied.
+	// Default: false.
+	Balanced bool
+
+	// MaxChunkBytes caps the length of Chunk.Text in bytes, for stores and APIs that limit
+	// payload size rather than tokens. It is enforced together with MaxTokens: a chunk is
+	// split when either limit would be exceeded, counting the bytes of the joiner between
+	// sentences. MaxTokensSlack never lets a chunk exceed it. A sentence longer than
+	// MaxChunkBytes is treated like one longer than MaxTokens: it stands alone, or is cut at
+	// word boundaries with SplitOversizedSentences. Default: 0 (no byte limit).
+	MaxChunkBytes int
+
+	// OverlapSentences repeats up to this many sentences from the end of each chunk at the
+	// start of the next one, so the context around a boundary reaches both chunks. The
+	// overlap is added once the chunks are built: it never moves a boundary, and the
+	// repeated sentences are included in Text, Sentences, SentenceIndices and NumTokens
+	// (see Chunk.OverlapSentences). A sentence longer than MaxTokens or MaxChunkBytes is
+	// never repeated. OverlapPolicy decides whether the overlap may push a chunk over the
+	// limits. Default: 0 (no overlap).
+	OverlapSentences int
+
+	// OverlapPolicy selects how OverlapSentences counts against MaxTokens and MaxChunkBytes:
+	// OverlapPolicyExceed or OverlapPolicyFit. Default: "" (OverlapPolicyExceed).
+	OverlapPolicy string
+
+	// CoalesceSimilarChunks merges adjacent chunks whose TF-IDF cosine similarity reaches
+	// this value (between 0 and 1) after chunks are built, e.g. repeated boilerplate cut in
+	// two by a token-limit split, so retrieval does not return the same content twice. Chunks
+	// are only merged if the result fits MaxTokens and MaxChunkBytes; a similar chunk that
+	// does not fit is marked Chunk.Duplicate instead. Default: 0 (disabled).
+	CoalesceSimilarChunks float64
+
+	// AdaptiveDepth scales DepthThreshold with the number of sentences n, since cohesion
+	// dips are more pronounced in short documents and shallower in long ones, where a fixed
+	// threshold over-splits and under-splits respectively. The threshold applied is
+	//
+	//	DepthThreshold × (AdaptiveDepthReference / n) ^ AdaptiveDepthExponent
+	//
+	// so it equals DepthThreshold for a document of AdaptiveDepthReference sentences, is
+	// higher for shorter documents and lower for longer ones. GapDecision.Threshold reports
+	// the scaled value. Only used by the DepthThreshold method. Default: false.
+	AdaptiveDepth bool
+
+	// AdaptiveDepthReference is the sentence count at which AdaptiveDepth applies
+	// DepthThreshold unchanged. Default: 20.
+	AdaptiveDepthReference int
+
+	// AdaptiveDepthExponent controls how strongly AdaptiveDepth reacts to the sentence
+	// count: 0 disables scaling, 1 scales the threshold inversely with it. Default: 0.5.
+	AdaptiveDepthExponent float64
+
+	// DepthFunc computes the depth of a local minimum from the scores of the gaps before
+	// (left), at (center) and after (right) it, for comparison with DepthThreshold. It lets
+	// alternative measures be tried, such as the larger of left and right minus center. It
+	// is only called for local minima (center below both neighbors) and its result is
+	// reported in GapDecision.Depth. Only used by the DepthThreshold method.
+	// Default: AverageDepth.
+	DepthFunc func(left, center, right float64) float64
+
+	// DropThreshold adds step-down boundaries to local-minima detection: a gap becomes a
+	// boundary when its score is at least DropThreshold below the average of the preceding
+	// DropWindow scores and none of the following DropWindow scores recovers half of that
+	// drop. This catches the start of a long new topic, where cohesion falls and stays low
+	// instead of forming a V-shaped dip. Only used by the DepthThreshold method.
+	// Default: 0 (disabled).
+	DropThreshold float64
+
+	// DropWindow is the number of scores averaged before, and checked after, a candidate
+	// drop. Default: 3 when DropThreshold is set.
+	DropWindow int
+
+	// BoundaryFilters refine the semantic boundaries found by the boundary method, in
+	// order, before chunks are assembled: each receives the cohesion scores and the
+	// boundaries left by the previous one. MinSegmentFilter, MaxSegmentFilter,
+	// PercentileFilter and DropFilter provide common refinements; any BoundaryFilter can be
+	// added. GapDecision.SemanticBoundary reports the filtered result. Topic boundaries
+	// (TopicReference) are added after the filters. Default: none.
+	BoundaryFilters []BoundaryFilter
+
+	// BoundaryTermCount is the number of terms reported in GapDecision.DistinguishingTerms
+	// for each semantic boundary found on the TF-IDF path, i.e. the terms that most set the
+	// two sides apart. Only used by SegmentWithDetails. Default: 5.
+	BoundaryTermCount int
+
+	// BlockComparisonSize (K) switches cohesion scoring from comparing adjacent sentences to
+	// block comparison, as in TextTiling: the gap after sentence i is scored by comparing the
+	// centroid of sentences i-K+1..i with the centroid of sentences i+1..i+K (windows are
+	// truncated at the text edges). This is more robust for texts with interleaved topic
+	// sentences. Values of 0 or 1 compare adjacent sentences. Default: 0.
+	BlockComparisonSize int
+
+	// MaxLookback (K) scores the gap before sentence i+1 by its highest similarity to any of
+	// the previous K sentences (i-K+1..i) instead of sentence i alone, so a boundary only
+	// forms where a sentence is dissimilar to all recent ones. This suits lists and bullet
+	// points, whose related items need not be adjacent. Values of 0 or 1 compare adjacent
+	// sentences. Cannot be combined with BlockComparisonSize. Default: 0.
+	MaxLookback int
+
+	// RecencyDecay (0 to 1) scores the gap before sentence i+1 by its similarity to a
+	// recency-weighted centroid of the current chunk's sentences up to i, where a sentence k
+	// positions back weighs RecencyDecay^k, instead of sentence i alone. A sentence that
+	// strays from the topic then moves the centroid only partly, so it and the sentences
+	// after it can still score as continuing the topic, which steadies boundaries on
+	// streamed text (see OnCohesionScore). The centroid restarts after each gap scoring
+	// below the split threshold, so a new topic is not compared with the old one; since
+	// percentile and local minima boundaries are only known once all gaps are scored,
+	// RecencyDecay requires a fixed threshold (MinSplitSimilarity or
+	// DenseMinSplitSimilarity). Requires a dense backend
+	// (ErrRecencyDecayWithoutEmbeddingBackend otherwise); the "tfidf" EmbeddingCallLimitPolicy
+	// fallback ignores it. Cannot be combined with BlockComparisonSize or MaxLookback.
+	// Default: 0 (adjacent sentences).
+	RecencyDecay float64
+
+	// --- Dense Embedding Backend ---
+
+	// EmbeddingProvider, when set, computes the dense sentence embeddings instead of the
+	// Ollama server configured by CHUNKER_OLLAMA_URL and CHUNKER_OLLAMA_MODEL, and enables
+	// the dense path even when those are unset. Worker count, caching, limits and
+	// streaming apply as for Ollama. Default: nil.
+	EmbeddingProvider EmbeddingProvider
+
+	// DimensionMismatchPolicy specifies what happens when a sentence embedding does not have
+	// the dimension of the others, as some models return for inputs in another script or
+	// nearly empty inputs (its cosine with any other embedding would be 0): "error" fails
+	// the call with ErrDimensionMismatch, "skip" gives the sentence the embedding of its
+	// neighbor, and "retry" requests it again before failing. The expected dimension is the
+	// most common one, or that of the first sentence when embeddings are streamed
+	// (OnCohesionScore, EmbeddingMemoryBudget). Default: "error".
+	DimensionMismatchPolicy string
+
+	// MaxEmbeddingCalls caps the sentence embeddings requested for one document, so a single
+	// huge document cannot blow the embedding budget. Calls are counted before any is made:
+	// the cache misses in 'force' mode (and in 'adaptive' mode once activated), every
+	// sentence otherwise. Default: 0 (no limit).
+	MaxEmbeddingCalls int
+
+	// EmbeddingCallLimitPolicy specifies what happens when a document exceeds
+	// MaxEmbeddingCalls: "error" fails the call with ErrEmbeddingCallLimit, "tfidf" scores
+	// the document with TF-IDF instead. Default: "error".
+	EmbeddingCallLimitPolicy string
+
 	// --- Semantic Caching for Dense Embeddings ---
 
 	// EmbeddingCacheMode specifies the caching strategy: "disable", "force", or "adaptive".
-	// Default: "disable".
+	// The cache only applies to dense embeddings, so enabling it without an Ollama backend
+	// fails with ErrCacheWithoutEmbeddingBackend. Default: "disable".
 	EmbeddingCacheMode string
 
 	// EmbeddingCache is an instance of a cache that stores mappings from a sentence's
-	// TF-IDF n-gram vector to its dense embedding. This allows reusing embeddings for
+	// character n-gram vector (see VectorizeForCache) to its dense embedding. This allows reusing embeddings for
 	// semantically similar sentences, reducing API calls to heavy models.
 	// A default in-memory cache can be created with NewInMemoryCache() or NewAdaptiveCacheManager().
 	EmbeddingCache EmbeddingCache
 
+	// CacheErrorPolicy specifies what happens when EmbeddingCache.Find returns an error, as
+	// a remote cache does when its backend is unreachable: "fail_open" treats it as a miss
+	// and embeds the sentence, "fail_closed" fails the call with ErrCacheLookup. Default:
+	// "fail_open".
+	CacheErrorPolicy string
+
 	// CacheSimilarityThreshold (range 0.0 to 1.0) is the cosine similarity
-	// threshold used to determine a cache hit. Default: 0.9.
+	// threshold used to determine a cache hit. Default: 0.9. Character n-gram scores run
+	// lower than word-level ones, so 0.9 only matches near-verbatim repeats; see
+	// CalibrateCacheThreshold to derive a value from labeled sample pairs.
 	CacheSimilarityThreshold float64
 
 	// AdaptiveCacheActivationThreshold is the number of items in the cache that must have at least one
 	// semantically similar neighbor (defined by CacheSimilarityThreshold) before an 'adaptive' cache
 	// switches to 'force' mode. Only used when EmbeddingCacheMode is "adaptive". Default: 100.
 	AdaptiveCacheActivationThreshold int
+
+	// IntraBatchDedupThreshold (range 0.0 to 1.0) groups near-duplicate sentences within a
+	// batch before the cache is consulted: sentences whose cache keys are at least this
+	// similar share one lookup and one embedding call, made for the first of them. Only used
+	// in "force" mode (and "adaptive" once activated). Requires a dense embedding backend
+	// (ErrIntraBatchDedupWithoutEmbeddingBackend otherwise). Default: 0 (disabled).
+	IntraBatchDedupThreshold float64
+
+	// CacheKeyWorkers bounds the number of goroutines that build the n-gram cache keys of a
+	// batch in "force" and "adaptive" modes. Default: 0 (GOMAXPROCS).
+	CacheKeyWorkers int
+
+	// CacheKeyFilter, when set, drops n-grams from the cache keys by their document
+	// frequency in a reference corpus (see NewCacheKeyFilter), so ubiquitous n-grams do not
+	// make unrelated sentences look similar. Keys built with different filters do not
+	// match, so every call sharing a cache must use the same filter. Default: nil.
+	CacheKeyFilter *CacheKeyFilter
+
+	// CacheKeyMinNgram and CacheKeyMaxNgram set the character n-gram sizes of the cache keys.
+	// By default they are chosen per sentence: 2 to 3 for text mostly written in CJK scripts,
+	// where single characters carry meaning, 4 to 6 when Language names an agglutinative
+	// language (Turkish, Finnish, Hungarian, Estonian, Basque), and 3 to 5 otherwise (see
+	// VectorizeForCache). Keys built with different ranges do not match, and a CacheKeyFilter
+	// counts the default n-grams. Set both, or neither. Default: 0 (chosen per sentence).
+	CacheKeyMinNgram int
+	CacheKeyMaxNgram int
+
+	// EmbeddingMemoryBudget (in bytes) bounds the memory used for sentence embeddings in
+	// Ollama mode. When all embeddings together (sentences × dimensions × 8 bytes) would exceed
+	// the budget, they are fetched in a streaming fashion instead: cohesion is computed as
+	// consecutive vectors arrive in order and each vector is released once compared with its
+	// successor, so only a small window (about twice the worker count, plus twice
+	// BlockComparisonSize) is held at any time.
+	// Only applies when EmbeddingCacheMode is "disable"; the cache modes always keep all
+	// embeddings. Default: 0 (no budget, all embeddings are held).
+	EmbeddingMemoryBudget int
+
+	// MinSentenceTokensForEmbedding merges sentences with fewer tokens (e.g. "Ok." or
+	// "Right!") into the preceding sentence (or the following one at the start of the text)
+	// before embedding. Embeddings of near-empty inputs are unreliable and create spurious
+	// boundaries; merged sentences share one embedding, so no boundary is placed between
+	// them and fewer embedding calls are made. Chunks still list every original sentence.
+	// Requires a dense embedding backend (ErrMinSentenceTokensWithoutEmbeddingBackend
+	// otherwise); the "tfidf" EmbeddingCallLimitPolicy fallback ignores it. Default: 0 (every
+	// sentence is embedded).
+	MinSentenceTokensForEmbedding int
+
+	// ChunkPooling fills Chunk.Embedding by pooling the sentence embeddings of each chunk:
+	// ChunkPoolingMean, ChunkPoolingLengthWeighted or ChunkPoolingMax. TF-IDF vectors are
+	// not exposed, so it requires a dense embedding backend
+	// (ErrChunkPoolingWithoutEmbeddingBackend otherwise) and is dropped by the "tfidf"
+	// EmbeddingCallLimitPolicy fallback. Cannot be combined with EmbeddingMemoryBudget, which
+	// does not retain all embeddings. Default: "" (disabled).
+	ChunkPooling string
+
+	// ChunkHash fills Chunk.Hash: ChunkHashNormalized hashes the normalized tokens of the
+	// chunk, ChunkHashRaw its exact text. Default: "" (ChunkHashNone, no hash).
+	ChunkHash string
+
+	// ChunkOutput selects which of Chunk.Text and Chunk.Sentences are filled:
+	// ChunkOutputBoth, ChunkOutputText or ChunkOutputSentences. Leaving one out saves the
+	// allocations of joining the text or of keeping a sentence list per chunk, which adds
+	// up over millions of chunks. Chunk.Hash is computed from the chunk text either way.
+	// Default: "" (ChunkOutputBoth).
+	ChunkOutput string
+
+	// SeparateTerminalPunctuation strips the trailing terminal punctuation (with any closing
+	// quotes mixed into it) from each entry of Chunk.Sentences and records it in
+	// Chunk.SentencePunctuation instead. Chunk.Text and Chunk.Hash keep the punctuation; with
+	// ChunkOutputText there are no sentences to strip. Default: false (punctuation attached).
+	SeparateTerminalPunctuation bool
+
+	// PartialResultsOnCancel makes SegmentContext return the chunks of the sentence prefix
+	// whose embeddings completed, along with ctx.Err(), when ctx is canceled during the
+	// Ollama embedding phase. Not supported on the streaming EmbeddingMemoryBudget path.
+	// Default: false (no chunks on cancellation).
+	PartialResultsOnCancel bool
+
+	// EmbeddingLimiter caps concurrent Ollama embedding requests across all calls sharing
+	// it (see NewEmbeddingLimiter), on top of the per-call worker count. When nil, the
+	// process-wide cap from CHUNKER_OLLAMA_GLOBAL_MAX_WORKERS applies, if set.
+	// Default: nil.
+	EmbeddingLimiter *EmbeddingLimiter
+
+	// OllamaMaxWorkers is the number of concurrent embedding workers for this call. When
+	// > 0 it takes precedence over CHUNKER_OLLAMA_MAX_WORKERS, so calls can use different
+	// parallelism (e.g. high for batch jobs, low for interactive requests).
+	// Default: 0 (the environment variable, or DefaultOllamaWorkers).
+	OllamaMaxWorkers int
+
+	// OllamaBatchDeadline bounds the total time of each embedding batch (all the requests
+	// of a segmentation call, run by the worker pool), independently of the per-request
+	// HTTPClient timeout. Once it passes, the remaining requests are canceled and the call
+	// fails with an error wrapping ErrBatchDeadlineExceeded. To bound a whole call and keep
+	// the chunks embedded so far, use SegmentContext with a context deadline and
+	// PartialResultsOnCancel instead. Default: 0 (no deadline).
+	OllamaBatchDeadline time.Duration
+
+	// --- Topic-Focused Segmentation ---
+
+	// TopicReference, when set, compares every sentence with a reference topic and places an
+	// additional boundary wherever the text enters or leaves that topic. These boundaries are
+	// layered on top of the regular cohesion-based ones. See TopicReference for details.
+	TopicReference *TopicReference
+
+	// --- Instrumentation ---
+
+	// Tracer, when set, receives spans around sentence splitting, vectorization, each
+	// embedding batch, cache lookups and chunk building, with attributes such as the sentence
+	// count, cache hit ratio and embedding latency. Default: nil (no tracing).
+	Tracer Tracer
+
+	// OnProgress, when set, is called as sentences are vectorized, with the number of
+	// sentences done so far and the total: after each TF-IDF vector, and after each embedding
+	// returned by Ollama (cache hits and in-batch near-duplicates count as done). The last
+	// call of a successful run reports done == total. It is always called from the goroutine
+	// running the segmentation call, never concurrently, so it should return quickly.
+	// Default: nil.
+	OnProgress func(done, total int)
+
+	// OnCohesionScore, when set, is called with the cohesion score of every sentence gap
+	// (gap i lies between sentence i and sentence i+1), in gap order. With a dense embedding
+	// backend and EmbeddingCacheMode "disable", embeddings are then consumed in sentence
+	// order as they arrive and each score is reported as soon as the embeddings it compares
+	// are available, instead of after the last embedding returns; this lowers the latency to
+	// the first scores but only helps callers that act on them as they come. On other paths
+	// (TF-IDF, cache modes, MinSentenceTokensForEmbedding), all scores are reported once
+	// computed. Like OnProgress, it is called from the goroutine running the segmentation
+	// call. Default: nil.
+	OnCohesionScore func(gap int, score float64)
+}
+
+// Clone returns a copy of o that shares no mutable settings with it, so per-request variants
+// can be derived from a base Options without affecting it: the *bool, *float64 and *string
+// fields point to new values, and the slices and TopicReference are copied. Resources and
+// callbacks are intentionally shared, as they are meant to serve many calls: HTTPClient,
+// EmbeddingProvider, EmbeddingCache, CacheKeyFilter, EmbeddingLimiter, Tracer, the
+// functions (TFIDFSimilarity, DepthFunc, OnProgress, OnCohesionScore) and the
+// BoundaryFilters themselves.
+func (o Options) Clone() Options {
+	o.PreNormalizeAbbreviations = clonePointer(o.PreNormalizeAbbreviations)
+	o.EnableStopWordRemoval = clonePointer(o.EnableStopWordRemoval)
+	o.EnableStemming = clonePointer(o.EnableStemming)
+	o.StemmingOneShot = clonePointer(o.StemmingOneShot)
+	o.IDFSmoothing = clonePointer(o.IDFSmoothing)
+	o.ChunkJoiner = clonePointer(o.ChunkJoiner)
+	o.CandidateLanguages = slices.Clone(o.CandidateLanguages)
+	o.Abbreviations = slices.Clone(o.Abbreviations)
+	o.QuotePairs = slices.Clone(o.QuotePairs)
+	o.BoundaryFilters = slices.Clone(o.BoundaryFilters)
+	if o.TopicReference != nil {
+		ref := *o.TopicReference
+		ref.Vector = slices.Clone(ref.Vector)
+		o.TopicReference = &ref
+	}
+	return o
+}
+
+// clonePointer returns a pointer to a copy of *p, or nil if p is nil.
+func clonePointer[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
 }
 
 // Segment splits a given text into semantic chunks based on the provided options.
 // It acts as an orchestrator, handling preprocessing and then dispatching to either
 // the Ollama or TF-IDF implementation to get similarity scores.
 func Segment(textStr string, opts Options) ([]Chunk, error) {
+	return segment(context.Background(), segmentInput{text: textStr}, opts, nil)
+}
+
+// SegmentContext is like Segment but stops when ctx is canceled or its deadline passes,
+// aborting in-flight embedding requests, and returns ctx.Err().
+//
+// With Options.PartialResultsOnCancel, a cancellation during the Ollama embedding phase
+// returns the chunks for the longest prefix of sentences whose embeddings had completed,
+// together with ctx.Err(). Only that prefix is covered: it is segmented as if the text ended
+// there, so its last chunk may end earlier than in a complete run, and topic boundaries are
+// not applied. Otherwise, and on the TF-IDF path, no chunks are returned on cancellation.
+func SegmentContext(ctx context.Context, textStr string, opts Options) ([]Chunk, error) {
+	return segment(ctx, segmentInput{text: textStr}, opts, nil)
+}
+
+// SegmentTokenized segments text that the caller has already split into sentences and
+// tokenized, e.g. with spaCy or MeCab. tokens[i] holds the tokens of sentences[i].
+//
+// On the TF-IDF path the given tokens are used as-is for the similarity vectors: internal
+// tokenization, stopword removal, stemming and n-gram generation are skipped, as are
+// abbreviation normalization and sentence splitting. With a dense embedding backend, the
+// sentences are embedded as usual. In both cases the token count of each sentence, used
+// for MaxTokens and Chunk.NumTokens, is len(tokens[i]). TopicReference.Text is not
+// supported on the TF-IDF path, since the reference cannot be tokenized the same way.
+func SegmentTokenized(sentences []string, tokens [][]string, opts Options) ([]Chunk, error) {
+	if len(sentences) != len(tokens) {
+		return nil, fmt.Errorf("got %d sentences but %d token lists", len(sentences), len(tokens))
+	}
+	return segment(context.Background(), segmentInput{sentences: sentences, tokens: tokens}, opts, nil)
+}
+
+// segmentInput is the text handed to segment: raw text, or sentences with caller-provided
+// tokens (SegmentTokenized).
+type segmentInput struct {
+	text      string
+	sentences []string
+	tokens    [][]string
+}
+
+// segment implements Segment. When details is non-nil, it is filled with the diagnostics
+// returned by SegmentWithDetails.
+func segment(ctx context.Context, in segmentInput, opts Options, details *Details) ([]Chunk, error) {
 	if err := validateOptions(opts); err != nil {
 		return nil, err
 	}
 	setDefaultOptions(&opts)
 
+	if err := checkInputSize(in, opts); err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(callContext(ctx, opts), SpanSegment)
+	defer span.End()
+
+	// On cancellation with PartialResultsOnCancel, doc holds the completed prefix and err
+	// the context error; both are returned.
+	doc, err := scoreDocument(ctx, in, opts)
+	if doc == nil {
+		return nil, err
+	}
+	span.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(doc.sentences)})
+	chunks := doc.chunks(ctx, opts, details)
+	span.SetAttributes(Attribute{Key: AttrChunkCount, Value: len(chunks)})
+	return chunks, err
+}
+
+// scoredDocument is the output of the expensive part of segmentation (sentence splitting,
+// vectorization and cohesion scoring). Chunks can be built from it for any boundary settings.
+type scoredDocument struct {
+	sentences   []string
+	tokenCounts []int
+	scores      []float64 // nil for fewer than two sentences
+	topicSims   []float64
+	vectors     [][]float64          // dense sentence embeddings (dense path only)
+	embedded    []string             // the sentence texts embedded into vectors
+	sparse      []map[string]float64 // TF-IDF sentence vectors (TF-IDF path only)
+	dense       bool                 // scored with dense embeddings rather than TF-IDF
+	language    string               // detected or explicit document language, if known
+	languages   []string             // per-sentence languages, see sentenceLanguages
+	callLimited bool                 // scored with TF-IDF for exceeding MaxEmbeddingCalls
+}
+
+// scoreDocument splits the input into sentences and computes their cohesion scores with
+// the configured method. opts must already be validated and defaulted. If ctx is canceled
+// during embedding with PartialResultsOnCancel set, it returns the document truncated to
+// the completed prefix together with ctx.Err().
+func scoreDocument(ctx context.Context, in segmentInput, opts Options) (*scoredDocument, error) {
+	doc, err := scoreDocumentWith(ctx, in, opts, embeddingProvider(opts))
+	if errors.Is(err, ErrEmbeddingCallLimit) && opts.EmbeddingCallLimitPolicy == EmbeddingCallLimitFallback {
+		opts.EmbeddingCacheMode = CacheModeDisable
+		opts.RecencyDecay = 0
+		opts.ChunkPooling = ""
+		opts.MinSentenceTokensForEmbedding = 0
+		opts.IntraBatchDedupThreshold = 0
+		doc, err = scoreDocumentWith(ctx, in, opts, nil)
+		if doc != nil {
+			doc.callLimited = true
+		}
+	}
+	return doc, err
+}
+
+// scoreDocumentWith is scoreDocument with the embedding backend resolved by the caller; a
+// nil provider selects the TF-IDF path.
+func scoreDocumentWith(ctx context.Context, in segmentInput, opts Options, provider EmbeddingProvider) (*scoredDocument, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	useOllama := provider != nil
+	if err := checkCacheBackend(opts, useOllama); err != nil {
+		return nil, err
+	}
+	if opts.RecencyDecay > 0 && !useOllama {
+		return nil, ErrRecencyDecayWithoutEmbeddingBackend
+	}
+	if opts.ChunkPooling != "" && !useOllama {
+		return nil, ErrChunkPoolingWithoutEmbeddingBackend
+	}
+	if opts.MinSentenceTokensForEmbedding > 0 && !useOllama {
+		return nil, ErrMinSentenceTokensWithoutEmbeddingBackend
+	}
+	if opts.IntraBatchDedupThreshold > 0 && !useOllama {
+		return nil, ErrIntraBatchDedupWithoutEmbeddingBackend
+	}
+
+	textStr, sentences, globalDetectedLang := prepareSentences(ctx, in, opts)
+	analysis := sentences // the sentences that are vectorized
+	if opts.OutputFormat != "" && in.tokens == nil {
+		analysis, sentences = markdownSentences(sentences, opts.OutputFormat)
+		textStr = strings.Join(analysis, " ")
+	}
+	ctx = withProgress(ctx, opts.OnProgress, len(sentences))
+
+	tokenCounts := make([]int, len(sentences))
+	for i, s := range sentences {
+		if in.tokens != nil {
+			tokenCounts[i] = len(in.tokens[i])
+		} else {
+			tokenCounts[i] = countTokens(s, opts.TokenCountMethod, opts.CJKTokenMode == CJKTokenModeChars)
+		}
+	}
+	doc := &scoredDocument{sentences: sentences, tokenCounts: tokenCounts, dense: useOllama, language: globalDetectedLang}
+
+	// Handle edge cases.
+	if len(sentences) < 2 {
+		if len(sentences) == 1 && !useOllama && in.tokens == nil {
+			doc.language = resolveDocumentLanguage(textStr, analysis, opts, globalDetectedLang)
+			doc.languages = sentenceLanguages(analysis, opts)
+		}
+		if len(sentences) == 1 && useOllama && opts.ChunkPooling != "" {
+			vectors, err := getOllamaEmbeddings(ctx, analysis, provider, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+			}
+			doc.vectors, doc.embedded = vectors, analysis
+		}
+		return doc, nil
+	}
+
+	// --- 4. Calculate cohesion scores using the appropriate method (Ollama or TF-IDF) ---
+	var err error
+	vecCtx, vecSpan := startSpan(ctx, SpanVectorize)
+	if useOllama {
+		// PATH A: Use modern embeddings via Ollama for higher accuracy.
+		vecSpan.SetAttributes(Attribute{Key: AttrMethod, Value: "dense"})
+		doc.scores, doc.topicSims, doc.vectors, err = segmentWithOllama(vecCtx, analysis, provider, opts)
+		if err != nil && opts.PartialResultsOnCancel && ctx.Err() != nil && doc.scores != nil {
+			doc.sentences = sentences[:len(doc.vectors)]
+			doc.tokenCounts = tokenCounts[:len(doc.vectors)]
+		}
+		doc.embedded = analysis[:len(doc.vectors)]
+	} else {
+		// PATH B: Use the lightweight, built-in TF-IDF method.
+		vecSpan.SetAttributes(Attribute{Key: AttrMethod, Value: "tfidf"})
+		if in.tokens == nil {
+			doc.language = resolveDocumentLanguage(textStr, analysis, opts, globalDetectedLang)
+			doc.languages = sentenceLanguages(analysis, opts)
+		}
+		doc.scores, doc.topicSims, doc.sparse, err = segmentWithTFIDF(textStr, analysis, in.tokens, opts, doc.language, doc.languages)
+	}
+	vecSpan.End()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+		if doc.scores == nil {
+			return nil, err
+		}
+		return doc, err // The completed prefix (PartialResultsOnCancel).
+	}
+	if err != nil {
+		return nil, err // Propagate errors from Ollama API calls or option checks.
+	}
+	if opts.OnCohesionScore != nil && !streamsCohesionScores(opts, useOllama) {
+		for i, score := range doc.scores {
+			opts.OnCohesionScore(i, score)
+		}
+	}
+	return doc, nil
+}
+
+// streamsCohesionScores reports whether the dense path reports scores to OnCohesionScore
+// itself, as embeddings arrive (see segmentWithOllamaStreaming).
+func streamsCohesionScores(opts Options, useOllama bool) bool {
+	return useOllama && opts.EmbeddingCacheMode == CacheModeDisable && opts.MinSentenceTokensForEmbedding == 0
+}
+
+// chunks finds the boundaries for opts and builds the final chunks. When details is
+// non-nil, it is filled as for SegmentWithDetails.
+func (doc *scoredDocument) chunks(ctx context.Context, opts Options, details *Details) []Chunk {
+	if details != nil {
+		details.DetectedLanguage = doc.language
+		details.UnknownLanguageSentences = unknownLanguageSentences(doc.languages)
+		details.SentenceEmbeddings = doc.sentenceEmbeddings()
+		if doc.callLimited {
+			details.Warnings = append(details.Warnings, WarningEmbeddingCallLimit)
+		}
+	}
+	if len(doc.sentences) == 0 {
+		return []Chunk{}
+	}
+	if doc.scores == nil {
+		// Go through buildChunks so MaxTokens is handled exactly as for longer texts.
+		chunks := hashChunks(doc.pool(doc.cohesion(buildChunks(doc.sentences, doc.tokenCounts, nil, opts, nil)), opts.ChunkPooling), opts.ChunkHash, opts.ChunkJoiner)
+		chunks = separateTerminalPunctuation(chunks, opts.SeparateTerminalPunctuation)
+		details.explainSingleChunk(chunks, opts)
+		return chunks
+	}
+
+	// --- 5. Find split boundaries and build the final chunks ---
+	var gaps []GapDecision
+	if details != nil {
+		gaps = make([]GapDecision, len(doc.scores))
+	}
+	boundaryIndices := doc.boundaries(opts, gaps)
+	_, buildSpan := startSpan(ctx, SpanBuildChunks)
+	chunks := buildChunks(doc.sentences, doc.tokenCounts, boundaryIndices, opts, gaps)
+	chunks = coalesceSimilarChunks(chunks, doc.language, opts, gaps)
+	chunks = addOverlap(chunks, doc.sentences, doc.tokenCounts, opts)
+	chunks = doc.pool(doc.cohesion(chunks), opts.ChunkPooling)
+	chunks = hashChunks(chunks, opts.ChunkHash, opts.ChunkJoiner)
+	chunks = separateTerminalPunctuation(chunks, opts.SeparateTerminalPunctuation)
+	if details != nil {
+		details.Gaps = gaps
+		details.checkCohesionVariance(doc, opts)
+		explainBoundaryTerms(gaps, doc.sparse, opts)
+	}
+	details.explainSingleChunk(chunks, opts)
+	buildSpan.SetAttributes(Attribute{Key: AttrChunkCount, Value: len(chunks)})
+	buildSpan.End()
+	return chunks
+}
+
+// boundaries returns the semantic and topic boundaries for opts. When gaps is non-nil (one
+// entry per score), it also records the decision for every gap.
+func (doc *scoredDocument) boundaries(opts Options, gaps []GapDecision) map[int]bool {
+	if doc.dense && opts.DenseMinSplitSimilarity > 0 {
+		opts.MinSplitSimilarity = opts.DenseMinSplitSimilarity
+	}
+	boundaryIndices := findBoundaries(doc.scores, opts, gaps)
+	if len(opts.BoundaryFilters) > 0 {
+		boundaryIndices = applyBoundaryFilters(doc.scores, boundaryIndices, opts)
+		for i := range gaps {
+			gaps[i].SemanticBoundary = boundaryIndices[i]
+		}
+	}
+	if doc.topicSims != nil {
+		addTopicBoundaries(boundaryIndices, doc.topicSims, opts.TopicReference.Threshold)
+		for i := range gaps {
+			gaps[i].TopicBoundary = boundaryIndices[i] && !gaps[i].SemanticBoundary
+		}
+	}
+	if opts.SplitOnScriptChange {
+		for _, i := range scriptChanges(doc.sentences) {
+			if !boundaryIndices[i] && gaps != nil {
+				gaps[i].ScriptBoundary = true
+			}
+			boundaryIndices[i] = true
+		}
+	}
+	return boundaryIndices
+}
+
+// cohesion fills the Cohesion of each chunk with the mean score of its inner gaps.
+func (doc *scoredDocument) cohesion(chunks []Chunk) []Chunk {
+	for i := range chunks {
+		indices := chunks[i].SentenceIndices
+		chunks[i].Cohesion = 1
+		if len(indices) < 2 {
+			continue
+		}
+		sum := 0.0
+		for _, idx := range indices[:len(indices)-1] {
+			sum += doc.scores[idx]
+		}
+		chunks[i].Cohesion = sum / float64(len(indices)-1)
+	}
+	return chunks
+}
+
+// scriptChanges returns the gaps at which the dominant script of the sentences changes (see
+// lang.Script), skipping sentences without letters: the gap before the first sentence
+// whose script differs from that of the last sentence with letters.
+func scriptChanges(sentences []string) []int {
+	var changes []int
+	previous := ""
+	for i, s := range sentences {
+		script := lang.Script(s)
+		if script == "" {
+			continue
+		}
+		if previous != "" && script != previous {
+			changes = append(changes, i-1)
+		}
+		previous = script
+	}
+	return changes
+}
+
+// pool fills the Embedding of each chunk from the sentence embeddings with method, if the
+// sentences were embedded and method is set.
+func (doc *scoredDocument) pool(chunks []Chunk, method string) []Chunk {
+	if doc.vectors == nil || method == "" {
+		return chunks
+	}
+	for i := range chunks {
+		indices := chunks[i].SentenceIndices
+		vectors := make([][]float64, len(indices))
+		weights := make([]float64, len(indices))
+		for j, idx := range indices {
+			vectors[j] = doc.vectors[idx]
+			weights[j] = float64(doc.tokenCounts[idx])
+		}
+		chunks[i].Embedding = poolEmbeddings(vectors, weights, method)
+	}
+	return chunks
+}
+
+// hashChunks fills the Hash of each chunk according to the ChunkHash mode.
+func hashChunks(chunks []Chunk, mode string, joiner *string) []Chunk {
+	if mode == "" || mode == ChunkHashNone {
+		return chunks
+	}
+	for i := range chunks {
+		content := chunkText(chunks[i], joiner)
+		if mode == ChunkHashNormalized {
+			// Tokens never contain spaces, so joining them is unambiguous.
+			content = strings.Join(text.Tokenize(content), " ")
+		}
+		sum := sha256.Sum256([]byte(content))
+		chunks[i].Hash = hex.EncodeToString(sum[:])
+	}
+	return chunks
+}
+
+// separateTerminalPunctuation moves the terminal punctuation of every chunk sentence into
+// SentencePunctuation, if enabled. It runs after hashChunks, which needs the full sentences.
+func separateTerminalPunctuation(chunks []Chunk, enabled bool) []Chunk {
+	if !enabled {
+		return chunks
+	}
+	for i := range chunks {
+		if chunks[i].Sentences == nil {
+			continue
+		}
+		// Sentences may share its array with the document's sentence list; do not write to it.
+		sentences := chunks[i].Sentences
+		chunks[i].Sentences = make([]string, len(sentences))
+		chunks[i].SentencePunctuation = make([]string, len(sentences))
+		for j, sentence := range sentences {
+			chunks[i].Sentences[j], chunks[i].SentencePunctuation[j] = text.SplitTerminalPunctuation(sentence)
+		}
+	}
+	return chunks
+}
+
+// poolEmbeddings combines sentence embeddings into one vector with the given ChunkPooling
+// method. weights (token counts) are only used for ChunkPoolingLengthWeighted; if they are
+// all zero, the plain mean is returned.
+func poolEmbeddings(vectors [][]float64, weights []float64, method string) []float64 {
+	pooled := make([]float64, len(vectors[0]))
+	if method == ChunkPoolingMax {
+		copy(pooled, vectors[0])
+		for _, v := range vectors[1:] {
+			for j := range pooled {
+				if j < len(v) && v[j] > pooled[j] {
+					pooled[j] = v[j]
+				}
+			}
+		}
+		return pooled
+	}
+
+	total := 0.0
+	if method == ChunkPoolingLengthWeighted {
+		for _, w := range weights {
+			total += w
+		}
+	}
+	for i, v := range vectors {
+		w := 1.0
+		if total > 0 {
+			w = weights[i]
+		}
+		for j := range pooled {
+			if j < len(v) {
+				pooled[j] += w * v[j]
+			}
+		}
+	}
+	if total == 0 {
+		total = float64(len(vectors))
+	}
+	for j := range pooled {
+		pooled[j] /= total
+	}
+	return pooled
+}
+
+// checkCacheBackend rejects an enabled EmbeddingCacheMode without a dense backend.
+func checkCacheBackend(opts Options, useOllama bool) error {
+	if opts.EmbeddingCacheMode != CacheModeDisable && !useOllama {
+		return ErrCacheWithoutEmbeddingBackend
+	}
+	return nil
+}
+
+// checkInputSize enforces Options.MaxInputBytes.
+func checkInputSize(in segmentInput, opts Options) error {
+	if opts.MaxInputBytes <= 0 {
+		return nil
+	}
+	size := len(in.text)
+	for _, s := range in.sentences {
+		size += len(s)
+	}
+	if size > opts.MaxInputBytes {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrInputTooLarge, size, opts.MaxInputBytes)
+	}
+	return nil
+}
+
+// prepareSentences preprocesses the input and splits it into sentences. It returns the
+// normalized text, the sentences, and the document language if it is already known at this
+// point (explicit or detected from the first LanguageDetectionTokens tokens), "" otherwise.
+// Pre-tokenized input is used as-is.
+func prepareSentences(ctx context.Context, in segmentInput, opts Options) (string, []string, string) {
+	if in.tokens != nil {
+		// Pre-split, pre-tokenized input: the caller's sentences are used as-is.
+		return strings.Join(in.sentences, " "), in.sentences, opts.Language
+	}
+
+	// Drop private-use runes reserved for internal placeholders before any masking step.
+	textStr := text.StripSentinels(in.text)
+	if clean := preprocessor(opts.ContentType); clean != nil {
+		textStr = clean(textStr)
+	}
+
 	// --- 1. Early language selection (explicit or by first N tokens) before any normalization/splitting ---
 	var globalDetectedLang string
 	if opts.Language != "" {
@@ -113,127 +1246,312 @@ func Segment(textStr string, opts Options) ([]Chunk, error) {
 			n = len(toks)
 		}
 		// Reuse string-based detector for simplicity.
-		globalDetectedLang = lang.DetectLanguage(strings.Join(toks[:n], " "))
+		globalDetectedLang = lang.DetectLanguageAmong(strings.Join(toks[:n], " "), opts.CandidateLanguages)
 	}
 
 	// --- 2. Optional abbreviation normalization before sentence splitting ---
 	if *opts.PreNormalizeAbbreviations {
-		textStr = lang.NormalizeAbbreviations(textStr, globalDetectedLang)
+		textStr = lang.NormalizeAbbreviationsWithExtra(textStr, globalDetectedLang, opts.Abbreviations)
 	}
 
-	// --- 3. Split into sentences and handle edge cases ---
-	sentences := text.SplitSentences(textStr)
-	if len(sentences) == 0 {
-		return []Chunk{}, nil
+	// --- 3. Split into sentences ---
+	_, splitSpan := startSpan(ctx, SpanSplitSentences)
+	sentences := text.SplitSentencesWithOptions(textStr, text.SplitOptions{
+		QuoteAware:       opts.QuoteAwareSplitting,
+		QuotePairs:       quotePairs(opts.QuotePairs),
+		NumberDotCapital: opts.SplitNumberDotCapital,
+	})
+	splitSpan.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(sentences)})
+	splitSpan.End()
+	return textStr, sentences, globalDetectedLang
+}
+
+// markdownSentences strips the Markdown syntax of sentences for analysis and returns the
+// sentences to output for format. A sentence holding only syntax is analyzed as is under
+// OutputFormatOriginal and dropped under OutputFormatPlain.
+func markdownSentences(sentences []string, format string) (analysis, output []string) {
+	analysis = make([]string, 0, len(sentences))
+	output = make([]string, 0, len(sentences))
+	for _, s := range sentences {
+		stripped := text.StripMarkdown(s)
+		if format == OutputFormatPlain {
+			if stripped != "" {
+				analysis = append(analysis, stripped)
+				output = append(output, stripped)
+			}
+			continue
+		}
+		if stripped == "" {
+			stripped = s
+		}
+		analysis = append(analysis, stripped)
+		output = append(output, s)
 	}
-	if len(sentences) == 1 {
-		tokens := text.Tokenize(sentences[0])
-		return []Chunk{makeChunk(sentences, len(tokens))}, nil
+	return analysis, output
+}
+
+// quotePairs converts Options.QuotePairs (validated two-rune strings) to rune pairs.
+func quotePairs(pairs []string) [][2]rune {
+	converted := make([][2]rune, len(pairs))
+	for i, p := range pairs {
+		runes := []rune(p)
+		converted[i] = [2]rune{runes[0], runes[1]}
 	}
+	return converted
+}
 
-	tokenCounts := make([]int, len(sentences))
-	for i, s := range sentences {
-		tokenCounts[i] = len(text.Tokenize(s))
+// segmentWithOllama handles the logic for vectorizing sentences using an Ollama model
+// and calculating cohesion scores between them. When a TopicReference is configured, it
+// also returns each sentence's similarity to the reference (nil otherwise). The sentence
+// embeddings are returned too, except on the streaming EmbeddingMemoryBudget path.
+//
+// With MinSentenceTokensForEmbedding, short sentences are embedded together with a
+// neighbor (see segmentMergedWithOllama).
+func segmentWithOllama(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([]float64, []float64, [][]float64, error) {
+	if opts.MinSentenceTokensForEmbedding > 0 {
+		return segmentMergedWithOllama(ctx, sentences, provider, opts)
 	}
+	return segmentSentencesWithOllama(ctx, sentences, provider, opts)
+}
 
-	// --- 4. Calculate cohesion scores using the appropriate method (Ollama or TF-IDF) ---
-	var scores []float64
-	var err error
+// segmentSentencesWithOllama embeds and scores every sentence on its own.
+func segmentSentencesWithOllama(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([]float64, []float64, [][]float64, error) {
+	if opts.EmbeddingCacheMode == CacheModeDisable {
+		if err := checkEmbeddingCalls(len(sentences), opts); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if opts.EmbeddingMemoryBudget > 0 && opts.EmbeddingCacheMode == CacheModeDisable {
+		scores, topicSims, err := segmentWithOllamaBudget(ctx, sentences, provider, opts)
+		return scores, topicSims, nil, err
+	}
+	if opts.OnCohesionScore != nil && opts.EmbeddingCacheMode == CacheModeDisable {
+		return segmentWithOllamaStreaming(ctx, sentences, provider, opts)
+	}
 
-	ollamaURL := os.Getenv("CHUNKER_OLLAMA_URL")
-	ollamaModel := os.Getenv("CHUNKER_OLLAMA_MODEL")
+	vectors, err := getOllamaEmbeddings(ctx, sentences, provider, opts)
+	if err != nil {
+		if opts.PartialResultsOnCancel && ctx.Err() != nil {
+			prefix := completedPrefix(vectors)
+			return calculateCohesionDense(vectors[:prefix], opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts)), nil, vectors[:prefix], ctx.Err()
+		}
+		return nil, nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+	}
+	if err := resolveDimensions(ctx, sentences, vectors, provider, opts); err != nil {
+		return nil, nil, nil, err
+	}
 
-	if ollamaURL != "" && ollamaModel != "" {
-		// PATH A: Use modern embeddings via Ollama for higher accuracy.
-		scores, err = segmentWithOllama(sentences, ollamaURL, ollamaModel, opts)
+	var topicSims []float64
+	if opts.TopicReference != nil {
+		refVector, err := topicReferenceDense(ctx, opts.TopicReference, provider)
 		if err != nil {
-			return nil, err // Propagate errors from Ollama API calls.
+			return nil, nil, nil, fmt.Errorf("failed to embed topic reference: %w", err)
 		}
-	} else {
-		// PATH B: Use the lightweight, built-in TF-IDF method.
-		scores = segmentWithTFIDF(textStr, sentences, opts, globalDetectedLang)
+		topicSims = topicSimilaritiesDense(vectors, refVector)
 	}
 
-	// --- 5. Find split boundaries and build the final chunks ---
-	boundaryIndices := findBoundaries(scores, opts)
-	return buildChunks(sentences, tokenCounts, boundaryIndices, opts.MaxTokens), nil
+	return calculateCohesionDense(vectors, opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts)), topicSims, vectors, nil
 }
 
-// segmentWithOllama handles the logic for vectorizing sentences using an Ollama model
-// and calculating cohesion scores between them.
-func segmentWithOllama(sentences []string, ollamaURL, ollamaModel string, opts Options) ([]float64, error) {
-	client := opts.HTTPClient
-	if client == nil {
-		client = &http.Client{Timeout: 60 * time.Second}
+// completedPrefix returns the number of leading sentences whose embeddings are available.
+func completedPrefix(vectors [][]float64) int {
+	for i, v := range vectors {
+		if v == nil {
+			return i
+		}
 	}
+	return len(vectors)
+}
 
-	vectors, err := getOllamaEmbeddings(sentences, ollamaURL, ollamaModel, client, opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+// segmentWithTFIDF scores sentence cohesion with the built-in TF-IDF method. When a
+// TopicReference is configured, it also returns each sentence's similarity to the
+// reference text (nil otherwise). languages holds the per-sentence languages, if any (see
+// sentenceLanguages).
+func segmentWithTFIDF(textStr string, sentences []string, tokens [][]string, opts Options, globalDetectedLang string, languages []string) ([]float64, []float64, []map[string]float64, error) {
+	if opts.TopicReference != nil && opts.TopicReference.Text == "" {
+		return nil, nil, nil, errors.New("TopicReference.Text is required for TF-IDF segmentation; TopicReference.Vector needs a dense embedding backend")
+	}
+	if opts.TopicReference != nil && tokens != nil {
+		return nil, nil, nil, errors.New("TopicReference is not supported for pre-tokenized TF-IDF segmentation")
+	}
+
+	var vectors []map[string]float64
+	var vectorize func(string) map[string]float64
+	if tokens != nil {
+		vectors, _, _ = tfidfVectors(tokens, opts)
+	} else {
+		vectors, vectorize = buildTFIDFVectors(textStr, sentences, opts, globalDetectedLang, languages)
+	}
+
+	var topicSims []float64
+	if opts.TopicReference != nil {
+		topicSims = topicSimilaritiesSparse(vectors, vectorize(opts.TopicReference.Text))
 	}
 
-	return calculateCohesionDense(vectors), nil
+	return calculateCohesion(vectors, opts.BlockComparisonSize, opts.MaxLookback, opts.TFIDFSimilarity), topicSims, vectors, nil
 }
 
-// ... (segmentWithTFIDF remains the same) ...
-func segmentWithTFIDF(textStr string, sentences []string, opts Options, globalDetectedLang string) []float64 {
-	// If language wasn't detected early, detect it now based on the specified mode.
-	if globalDetectedLang == "" && opts.LanguageDetectionMode != LangDetectModePerSentence {
-		switch opts.LanguageDetectionMode {
-		case LangDetectModeFirstSentence:
-			globalDetectedLang = lang.DetectLanguage(sentences[0])
-		case LangDetectModeFirstTenSentences:
-			end := 10
-			if len(sentences) < 10 {
-				end = len(sentences)
-			}
-			textForDetection := strings.Join(sentences[:end], " ")
-			globalDetectedLang = lang.DetectLanguage(textForDetection)
-		case LangDetectModeFullText:
-			globalDetectedLang = lang.DetectLanguage(textStr)
-		default:
-			globalDetectedLang = lang.DetectLanguage(sentences[0]) // Fallback to default
-		}
+// buildTFIDFVectors preprocesses and vectorizes every sentence with TF-IDF. It also returns
+// a vectorizer that maps additional text into the same vector space, using the document's
+// language and corpus statistics.
+func buildTFIDFVectors(textStr string, sentences []string, opts Options, globalDetectedLang string, languages []string) ([]map[string]float64, func(string) map[string]float64) {
+	globalDetectedLang = resolveDocumentLanguage(textStr, sentences, opts, globalDetectedLang)
+	tokenizedSentences := tfidfSentenceTokens(sentences, opts, globalDetectedLang, languages)
+
+	// Vectorize sentences using TF-IDF.
+	vectors, vectorizeTokens, _ := tfidfVectors(tokenizedSentences, opts)
+
+	vectorize := func(s string) map[string]float64 {
+		return vectorizeTokens(similarityTokens(s, globalDetectedLang, opts))
 	}
+	return vectors, vectorize
+}
 
-	// Pre-process and tokenize each sentence based on options.
+// tfidfSentenceTokens pre-processes and tokenizes each sentence based on options, in the
+// document language or, in per-sentence mode, the sentence's own language from languages.
+func tfidfSentenceTokens(sentences []string, opts Options, globalDetectedLang string, languages []string) [][]string {
 	tokenizedSentences := make([][]string, len(sentences))
 	for i, s := range sentences {
-		var detectedLang string
-		if opts.LanguageDetectionMode == LangDetectModePerSentence && opts.Language == "" {
-			detectedLang = lang.DetectLanguage(s)
-		} else {
-			detectedLang = globalDetectedLang
+		detectedLang := globalDetectedLang
+		if languages != nil {
+			detectedLang = languages[i]
 		}
+		tokenizedSentences[i] = similarityTokens(s, detectedLang, opts)
+	}
+	return tokenizedSentences
+}
 
-		var tokens []string
-		if opts.TfidfMinNgramSize > 0 && opts.TfidfMaxNgramSize >= opts.TfidfMinNgramSize {
-			// N-gram mode: stemming and stop words are not applied.
-			tokens = text.GenerateCharNgrams(s, opts.TfidfMinNgramSize, opts.TfidfMaxNgramSize)
-		} else {
-			// Standard word tokenization mode with optional preprocessing.
-			sentenceForSimilarity := s
-			if *opts.EnableStopWordRemoval {
-				sentenceForSimilarity = lang.RemoveStopWords(sentenceForSimilarity, detectedLang)
-			}
-			tokens = text.Tokenize(sentenceForSimilarity)
-			if *opts.EnableStemming {
-				tokens = lang.StemTokens(tokens, detectedLang)
-			}
+// sentenceLanguages detects the language of each sentence in per-sentence detection mode.
+// It returns nil in the other modes and when Options.Language is set.
+func sentenceLanguages(sentences []string, opts Options) []string {
+	if opts.LanguageDetectionMode != LangDetectModePerSentence || opts.Language != "" {
+		return nil
+	}
+	languages := make([]string, len(sentences))
+	for i, s := range sentences {
+		languages[i] = lang.DetectLanguageAmong(s, opts.CandidateLanguages)
+	}
+	return languages
+}
+
+// unknownLanguageSentences returns the indices of the sentences whose language could not be
+// detected, or nil if there are none.
+func unknownLanguageSentences(languages []string) []int {
+	var indices []int
+	for i, language := range languages {
+		if language == lang.LangUnknown {
+			indices = append(indices, i)
 		}
-		tokenizedSentences[i] = tokens
 	}
+	return indices
+}
 
-	// Vectorize sentences using TF-IDF and calculate similarity scores.
-	corpus := tfidf.NewCorpus(tokenizedSentences)
-	vectors := make([]map[string]float64, len(sentences))
+// tfidfVectors builds a corpus from the tokenized sentences (counting duplicate sentences
+// once if DeduplicateCorpus is set) and returns their TF-IDF vectors, together with a
+// function vectorizing further tokens against the same corpus and one returning the
+// corpus vocabulary with IDF weights.
+func tfidfVectors(tokenizedSentences [][]string, opts Options) ([]map[string]float64, func([]string) map[string]float64, func() map[string]float64) {
+	newCorpus := tfidf.NewCorpus
+	if opts.DeduplicateCorpus {
+		newCorpus = tfidf.NewCorpusDeduplicated
+	}
+	corpus := newCorpus(tokenizedSentences)
+	corpus.SetIDF(opts.IDFFormula, *opts.IDFSmoothing)
+	corpus.LimitVocabulary(opts.TfidfMaxVocab)
+	if opts.StopWordWeight > 0 {
+		corpus.SetTermWeights(func(term string) float64 {
+			if strings.HasPrefix(term, stopWordPrefix) {
+				return opts.StopWordWeight
+			}
+			return 1
+		})
+	}
+	vectors := make([]map[string]float64, len(tokenizedSentences))
 	for i, ts := range tokenizedSentences {
 		vectors[i] = corpus.Vectorize(ts)
+		if opts.OnProgress != nil {
+			opts.OnProgress(i+1, len(tokenizedSentences))
+		}
 	}
+	return vectors, corpus.Vectorize, corpus.Vocabulary
+}
 
-	return calculateCohesion(vectors)
+// resolveDocumentLanguage returns the language used for the whole document: the explicit
+// or early-detected language if known, otherwise a detection according to
+// LanguageDetectionMode. It returns "" in per-sentence mode, where each sentence is
+// detected separately.
+func resolveDocumentLanguage(textStr string, sentences []string, opts Options, globalDetectedLang string) string {
+	if globalDetectedLang != "" || opts.LanguageDetectionMode == LangDetectModePerSentence {
+		return globalDetectedLang
+	}
+	skip := opts.LanguageDetectionSkipFirst
+	if skip >= len(sentences) {
+		skip = 0 // Nothing would be left to detect from.
+	}
+	switch opts.LanguageDetectionMode {
+	case LangDetectModeFirstSentence:
+		return lang.DetectLanguageAmong(sentences[0], opts.CandidateLanguages)
+	case LangDetectModeFirstTenSentences:
+		window := sentences[skip:]
+		if len(window) > 10 {
+			window = window[:10]
+		}
+		textForDetection := strings.Join(window, " ")
+		return lang.DetectLanguageAmong(textForDetection, opts.CandidateLanguages)
+	case LangDetectModeFullText:
+		if skip > 0 {
+			return lang.DetectLanguageAmong(strings.Join(sentences[skip:], " "), opts.CandidateLanguages)
+		}
+		return lang.DetectLanguageAmong(textStr, opts.CandidateLanguages)
+	default:
+		return lang.DetectLanguageAmong(sentences[0], opts.CandidateLanguages) // Fallback to default
+	}
 }
 
+// similarityTokens turns a sentence into the tokens used for TF-IDF similarity,
+// applying n-gram generation or stopword removal and stemming according to opts.
+func similarityTokens(s, detectedLang string, opts Options) []string {
+	if opts.TfidfMinNgramSize > 0 && opts.TfidfMaxNgramSize >= opts.TfidfMinNgramSize {
+		// N-gram mode: stemming and stop words are not applied.
+		return text.GenerateCharNgrams(s, detectedLang, opts.TfidfMinNgramSize, opts.TfidfMaxNgramSize)
+	}
+
+	// Standard word tokenization mode with optional preprocessing.
+	sentenceForSimilarity := s
+	if opts.TokenSeparators == TokenSeparatorsSplit {
+		sentenceForSimilarity = text.SplitSeparators(sentenceForSimilarity)
+	}
+	keepSeparators := opts.TokenSeparators == TokenSeparatorsKeep
+	downWeightStopWords := *opts.EnableStopWordRemoval && opts.StopWordWeight > 0
+	if *opts.EnableStopWordRemoval && !downWeightStopWords {
+		sentenceForSimilarity = lang.RemoveStopWords(sentenceForSimilarity, detectedLang, keepSeparators)
+	}
+	tokens := lang.Tokenize(sentenceForSimilarity, detectedLang, keepSeparators)
+	original := tokens
+	if *opts.EnableStemming {
+		tokens = lang.StemTokensWithOverrides(tokens, detectedLang, lang.StemOverrides{
+			MinLen:  opts.StemmingMinLen,
+			OneShot: opts.StemmingOneShot,
+		})
+	}
+	if downWeightStopWords {
+		// Kept stopwords are marked (and left unstemmed) so tfidfVectors can weight them.
+		for i, token := range original {
+			if lang.IsStopWord(token, detectedLang) {
+				tokens[i] = stopWordPrefix + token
+			}
+		}
+	}
+	if opts.KeepEmoji {
+		tokens = append(tokens, text.EmojiTokens(s)...)
+	}
+	return tokens
+}
+
+// stopWordPrefix marks stopword terms kept for StopWordWeight. It is a reserved sentinel
+// rune, which is stripped from all input, so no real token can start with it.
+const stopWordPrefix = "\uE00F"
+
 // ... (ollama structs remain the same) ...
 type ollamaRequest struct {
 	Model  string `json:"model"`
@@ -257,69 +1575,169 @@ type ollamaResult struct {
 }
 
 // getOllamaEmbeddings fetches embeddings for all sentences, dispatching to the correct caching strategy.
-func getOllamaEmbeddings(sentences []string, ollamaURL, ollamaModel string, client *http.Client, opts Options) ([][]float64, error) {
+// On error, the returned vectors hold the embeddings completed so far (nil for the others).
+func getOllamaEmbeddings(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([][]float64, error) {
 	if len(sentences) == 0 {
 		return [][]float64{}, nil
 	}
 
 	switch opts.EmbeddingCacheMode {
 	case CacheModeForce:
-		return getOllamaEmbeddingsWithCache(sentences, ollamaURL, ollamaModel, client, opts)
+		return getOllamaEmbeddingsWithCache(ctx, sentences, provider, opts)
 	case CacheModeAdaptive:
-		return getOllamaEmbeddingsAdaptive(sentences, ollamaURL, ollamaModel, client, opts)
+		return getOllamaEmbeddingsAdaptive(ctx, sentences, provider, opts)
 	default: // CacheModeDisable or empty
-		return getOllamaEmbeddingsDirect(sentences, ollamaURL, ollamaModel, client)
+		return getOllamaEmbeddingsDirect(ctx, sentences, provider)
 	}
 }
 
 // getOllamaEmbeddingsWithCache is the 'force' mode implementation.
-func getOllamaEmbeddingsWithCache(sentences []string, ollamaURL, ollamaModel string, client *http.Client, opts Options) ([][]float64, error) {
+func getOllamaEmbeddingsWithCache(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([][]float64, error) {
 	numSentences := len(sentences)
 	vectors := make([][]float64, numSentences)
 
-	// 1. Pre-calculate all TF-IDF n-gram vectors (cache keys).
-	ngramSentences := make([][]string, numSentences)
-	for i, s := range sentences {
-		ngramSentences[i] = text.GenerateCharNgrams(s, 3, 5)
-	}
-	corpus := tfidf.NewCorpus(ngramSentences)
-	keyVectors := make([]map[string]float64, numSentences)
-	for i, ns := range ngramSentences {
-		keyVectors[i] = corpus.Vectorize(ns)
-	}
+	// 1. Pre-calculate all n-gram vectors (cache keys) and group near-duplicates in the batch.
+	keyVectors := buildCacheKeys(sentences, opts)
+	representatives, representativeOf := clusterCacheKeys(keyVectors, opts.IntraBatchDedupThreshold)
 
-	// 2. Identify cache hits and misses.
+	// 2. Identify cache hits and misses (one lookup per representative).
+	_, lookupSpan := startSpan(ctx, SpanCacheLookup)
 	jobsToRun := make([]ollamaJob, 0)
-	for i, key := range keyVectors {
-		embedding, found := opts.EmbeddingCache.Find(key, opts.CacheSimilarityThreshold)
+	lookupErrors := 0
+	for _, i := range representatives {
+		embedding, found, err := opts.EmbeddingCache.Find(keyVectors[i], opts.CacheSimilarityThreshold)
+		if err != nil {
+			lookupErrors++
+			if opts.CacheErrorPolicy == CacheErrorFailClosed {
+				lookupSpan.SetAttributes(Attribute{Key: AttrCacheErrors, Value: lookupErrors})
+				lookupSpan.End()
+				return vectors, fmt.Errorf("%w: %w", ErrCacheLookup, err)
+			}
+			found = false
+		}
 		if found {
 			vectors[i] = embedding
 		} else {
 			jobsToRun = append(jobsToRun, ollamaJob{index: i, sentence: sentences[i]})
 		}
 	}
-
-	if len(jobsToRun) == 0 {
-		return vectors, nil
-	}
-
-	// 3. Run Ollama workers for cache misses.
-	results, err := runOllamaWorkers(jobsToRun, ollamaURL, ollamaModel, client)
-	if err != nil {
-		return nil, err
+	hits := len(representatives) - len(jobsToRun)
+	if err := checkEmbeddingCalls(len(jobsToRun), opts); err != nil {
+		lookupSpan.End()
+		return vectors, err
 	}
-
-	// 4. Collect results and update the cache.
+	// Cache hits and their near-duplicates are done; the workers report each miss.
+	progress := progressFrom(ctx)
+	resolvedByCache := countResolved(vectors, representativeOf)
+	progress.add(resolvedByCache)
+	lookupSpan.SetAttributes(
+		Attribute{Key: AttrCacheLookups, Value: len(representatives)},
+		Attribute{Key: AttrCacheHits, Value: hits},
+		Attribute{Key: AttrCacheHitRatio, Value: float64(hits) / float64(len(representatives))},
+		Attribute{Key: AttrCacheErrors, Value: lookupErrors},
+	)
+	lookupSpan.End()
+
+	// 3. Run Ollama workers for cache misses. On error, the embeddings completed so far
+	// are still cached and returned along with it.
+	results, err := runOllamaWorkers(ctx, jobsToRun, provider)
+
+	// 4. Collect results and update the cache. Suspect embeddings are returned but not
+	// cached, so they are not served to similar sentences later.
 	for _, result := range results {
 		vectors[result.index] = result.embedding
+	}
+	dim := embeddingDimension(vectors)
+	for _, result := range results {
+		if !cacheableEmbedding(result.embedding, dim) {
+			continue
+		}
 		// Передаем threshold, который используется для инкрементального анализа
 		opts.EmbeddingCache.Set(keyVectors[result.index], result.embedding, opts.CacheSimilarityThreshold)
 	}
-	return vectors, nil
+
+	// 5. Near-duplicates reuse their representative's embedding. Those of misses have not
+	// been reported as progress yet.
+	progress.add(countResolved(vectors, representativeOf) - resolvedByCache - len(results))
+	for i, rep := range representativeOf {
+		if rep != i {
+			vectors[i] = vectors[rep]
+		}
+	}
+	return vectors, err
+}
+
+// embeddingDimension returns the most common length of the non-empty vectors (the
+// dimension of the model that produced them), or 0 if there are none.
+func embeddingDimension(vectors [][]float64) int {
+	counts := make(map[int]int)
+	dim := 0
+	for _, v := range vectors {
+		if len(v) == 0 {
+			continue
+		}
+		counts[len(v)]++
+		if counts[len(v)] > counts[dim] || (counts[len(v)] == counts[dim] && len(v) > dim) {
+			dim = len(v)
+		}
+	}
+	return dim
 }
 
-// getOllamaEmbeddingsAdaptive handles the 'adaptive' mode logic.
-func getOllamaEmbeddingsAdaptive(sentences []string, ollamaURL, ollamaModel string, client *http.Client, opts Options) ([][]float64, error) {
+// cacheableEmbedding reports whether embedding is fit for the cache: it has dimension dim
+// and a finite, non-zero norm. Degraded responses (empty, truncated or all-zero vectors)
+// fail the check.
+func cacheableEmbedding(embedding []float64, dim int) bool {
+	if len(embedding) == 0 || len(embedding) != dim {
+		return false
+	}
+	var norm float64
+	for _, x := range embedding {
+		norm += x * x
+	}
+	return norm > 0 && !math.IsInf(norm, 0) && !math.IsNaN(norm)
+}
+
+// countResolved counts the sentences whose representative has an embedding in vectors.
+func countResolved(vectors [][]float64, representativeOf []int) int {
+	n := 0
+	for _, rep := range representativeOf {
+		if vectors[rep] != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// clusterCacheKeys groups near-duplicate sentences of a batch: each key is assigned to the
+// first earlier representative whose key is at least threshold similar, or becomes a new
+// representative. It returns the representatives in order and, for every key, the index of
+// its representative (itself for representatives). A threshold <= 0 disables grouping.
+func clusterCacheKeys(keys []map[string]float64, threshold float64) (representatives, representativeOf []int) {
+	representativeOf = make([]int, len(keys))
+	for i, key := range keys {
+		representativeOf[i] = i
+		if threshold > 0 {
+			for _, rep := range representatives {
+				if tfidf.CosineSimilarity(key, keys[rep]) >= threshold {
+					representativeOf[i] = rep
+					break
+				}
+			}
+		}
+		if representativeOf[i] == i {
+			representatives = append(representatives, i)
+		}
+	}
+	return representatives, representativeOf
+}
+
+// getOllamaEmbeddingsAdaptive handles the 'adaptive' mode logic. Activation is checked
+// before and after fetching the batch. A request that starts before activation fetches
+// every embedding directly; its entries are queued for asynchronous population, unless the
+// cache was activated in the meantime, in which case they are written before returning.
+// Requests starting after activation use the cache like 'force' mode.
+func getOllamaEmbeddingsAdaptive(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([][]float64, error) {
 	manager, ok := opts.EmbeddingCache.(AdaptiveCacheManager)
 	if !ok {
 		return nil, errors.New("adaptive cache mode requires an EmbeddingCache that implements AdaptiveCacheManager")
@@ -329,77 +1747,133 @@ func getOllamaEmbeddingsAdaptive(sentences []string, ollamaURL, ollamaModel stri
 
 	if manager.IsActivated() {
 		// Once activated, it behaves identically to 'force' mode.
-		return getOllamaEmbeddingsWithCache(sentences, ollamaURL, ollamaModel, client, opts)
+		return getOllamaEmbeddingsWithCache(ctx, sentences, provider, opts)
 	}
 
 	// --- Pre-activation: Get embeddings directly and queue for async caching ---
 	// 1. Get all embeddings directly from Ollama.
-	vectors, err := getOllamaEmbeddingsDirect(sentences, ollamaURL, ollamaModel, client)
-	if err != nil {
+	if err := checkEmbeddingCalls(len(sentences), opts); err != nil {
 		return nil, err
 	}
+	vectors, err := getOllamaEmbeddingsDirect(ctx, sentences, provider)
+	if err != nil {
+		return vectors, err
+	}
 
-	// 2. Asynchronously populate the cache.
+	// 2. If the cache was activated while this batch was fetched, write the batch
+	// synchronously: the next request consults the cache right away, so it must not depend
+	// on the population queue having drained.
+	if manager.IsActivated() {
+		dim := embeddingDimension(vectors)
+		for i, keyVector := range buildCacheKeys(sentences, opts) {
+			if cacheableEmbedding(vectors[i], dim) {
+				manager.Set(keyVector, vectors[i], opts.CacheSimilarityThreshold)
+			}
+		}
+		return vectors, nil
+	}
+
+	// 3. Asynchronously populate the cache.
 	// This part does not block the return to the user.
 	go func() {
-		ngramSentences := make([][]string, len(sentences))
-		for i, s := range sentences {
-			ngramSentences[i] = text.GenerateCharNgrams(s, 3, 5)
-		}
-		corpus := tfidf.NewCorpus(ngramSentences)
-		for i, ns := range ngramSentences {
-			keyVector := corpus.Vectorize(ns)
-			manager.QueueSet(keyVector, vectors[i])
+		dim := embeddingDimension(vectors)
+		for i, keyVector := range buildCacheKeys(sentences, opts) {
+			if cacheableEmbedding(vectors[i], dim) {
+				manager.QueueSet(keyVector, vectors[i])
+			}
 		}
 	}()
 
 	return vectors, nil
 }
 
+// buildCacheKeys computes the cache key of every sentence for opts (n-gram range and
+// CacheKeyFilter). Keys are independent of each other, so they are built on up to
+// CacheKeyWorkers goroutines (GOMAXPROCS when <= 0); keys[i] always belongs to sentences[i].
+func buildCacheKeys(sentences []string, opts Options) []map[string]float64 {
+	workers := opts.CacheKeyWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	keys := make([]map[string]float64, len(sentences))
+	parallelRange(len(sentences), workers, func(i int) {
+		keys[i] = cacheKey(sentences[i], opts)
+	})
+	return keys
+}
+
+// parallelRange calls fn for every index in [0, n), splitting the range into contiguous
+// blocks processed by up to workers goroutines, and returns when all calls are done.
+func parallelRange(n, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	blockSize := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += blockSize {
+		end := start + blockSize
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
 // getOllamaEmbeddingsDirect is the 'disable' mode implementation (no caching).
-func getOllamaEmbeddingsDirect(sentences []string, ollamaURL, ollamaModel string, client *http.Client) ([][]float64, error) {
+func getOllamaEmbeddingsDirect(ctx context.Context, sentences []string, provider EmbeddingProvider) ([][]float64, error) {
 	jobsToRun := make([]ollamaJob, len(sentences))
 	for i, s := range sentences {
 		jobsToRun[i] = ollamaJob{index: i, sentence: s}
 	}
 
-	results, err := runOllamaWorkers(jobsToRun, ollamaURL, ollamaModel, client)
-	if err != nil {
-		return nil, err
-	}
-
+	// On error, the embeddings completed so far are returned along with it.
+	results, err := runOllamaWorkers(ctx, jobsToRun, provider)
 	vectors := make([][]float64, len(sentences))
 	for _, result := range results {
 		vectors[result.index] = result.embedding
 	}
-
-	return vectors, nil
+	return vectors, err
 }
 
-// runOllamaWorkers manages the worker pool for fetching embeddings.
-func runOllamaWorkers(jobsToRun []ollamaJob, ollamaURL, ollamaModel string, client *http.Client) ([]ollamaResult, error) {
+// runOllamaWorkers manages the worker pool for fetching embeddings. If any job fails, it
+// returns the first error together with the results of the jobs that succeeded.
+func runOllamaWorkers(ctx context.Context, jobsToRun []ollamaJob, provider EmbeddingProvider) ([]ollamaResult, error) {
 	numJobs := len(jobsToRun)
 	if numJobs == 0 {
 		return []ollamaResult{}, nil
 	}
 
-	numWorkersStr := os.Getenv(OllamaMaxWorkersEnvVar)
-	numWorkers, err := strconv.Atoi(numWorkersStr)
-	if err != nil || numWorkers <= 0 {
-		numWorkers = DefaultOllamaWorkers
-	}
-	if numWorkers > numJobs {
-		numWorkers = numJobs
-	}
+	_, span := startSpan(ctx, SpanEmbeddingBatch)
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		span.SetAttributes(Attribute{Key: AttrBatchSize, Value: numJobs}, latencyAttr(start))
+	}()
+
+	ctx, cancel, batchErr := withBatchDeadline(ctx)
+	defer cancel()
+	numWorkers := ollamaWorkerCount(ctx, numJobs)
 
 	jobs := make(chan ollamaJob, numJobs)
 	resultsChan := make(chan ollamaResult, numJobs)
-	url := strings.TrimSuffix(ollamaURL, "/") + "/api/embeddings"
 
 	var wg sync.WaitGroup
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go ollamaWorker(&wg, client, jobs, resultsChan, url, ollamaModel)
+		go ollamaWorker(ctx, &wg, provider, jobs, resultsChan)
 	}
 
 	for _, job := range jobsToRun {
@@ -407,63 +1881,125 @@ func runOllamaWorkers(jobsToRun []ollamaJob, ollamaURL, ollamaModel string, clie
 	}
 	close(jobs)
 
-	wg.Wait()
-	close(resultsChan)
-
+	// Every job yields exactly one result; collecting them here as they arrive lets progress
+	// be reported from this goroutine only.
+	var err error
+	progress := progressFrom(ctx)
 	results := make([]ollamaResult, 0, numJobs)
-	for result := range resultsChan {
+	for i := 0; i < numJobs; i++ {
+		result := <-resultsChan
 		if result.err != nil {
-			return nil, result.err // Fail fast
+			if err == nil {
+				err = result.err
+			}
+			continue
 		}
 		results = append(results, result)
+		progress.add(1)
 	}
-	return results, nil
+	wg.Wait()
+	return results, batchErr(err)
 }
 
-// ... (ollamaWorker, cosineSimilarityDense, etc. remain the same) ...
-func ollamaWorker(wg *sync.WaitGroup, client *http.Client, jobs <-chan ollamaJob, results chan<- ollamaResult, url, model string) {
-	defer wg.Done()
-	for job := range jobs {
-		reqBody, err := json.Marshal(ollamaRequest{Model: model, Prompt: job.sentence})
-		if err != nil {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("failed to marshal ollama request for sentence %d: %w", job.index, err)}
-			continue
+// ollamaWorkerCount returns the number of Ollama workers to use for numJobs jobs: the
+// Options.OllamaMaxWorkers value carried by ctx, else the CHUNKER_OLLAMA_MAX_WORKERS value
+// (or DefaultOllamaWorkers), capped at numJobs.
+func ollamaWorkerCount(ctx context.Context, numJobs int) int {
+	numWorkers, _ := ctx.Value(maxWorkersKey{}).(int)
+	if numWorkers <= 0 {
+		var err error
+		numWorkers, err = strconv.Atoi(os.Getenv(OllamaMaxWorkersEnvVar))
+		if err != nil || numWorkers <= 0 {
+			numWorkers = DefaultOllamaWorkers
 		}
+	}
+	if numWorkers > numJobs {
+		numWorkers = numJobs
+	}
+	return numWorkers
+}
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
-		if err != nil {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("failed to create http request for sentence %d: %w", job.index, err)}
-			continue
-		}
-		req.Header.Set("Content-Type", "application/json")
+type (
+	maxWorkersKey    struct{}
+	batchDeadlineKey struct{}
+)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("failed to call ollama api for sentence %d: %w", job.index, err)}
-			continue
-		}
+// callContext prepares the context of a segmentation call: it carries the tracer, the
+// embedding limiter, and the per-call worker count and batch deadline used by the Ollama
+// workers.
+func callContext(ctx context.Context, opts Options) context.Context {
+	ctx = withEmbeddingLimiter(withTracer(ctx, opts.Tracer), opts.EmbeddingLimiter)
+	if opts.OllamaMaxWorkers > 0 {
+		ctx = context.WithValue(ctx, maxWorkersKey{}, opts.OllamaMaxWorkers)
+	}
+	if opts.OllamaBatchDeadline > 0 {
+		ctx = context.WithValue(ctx, batchDeadlineKey{}, opts.OllamaBatchDeadline)
+	}
+	return ctx
+}
 
-		if resp.StatusCode != http.StatusOK {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("ollama api returned non-200 status for sentence %d: %s", job.index, resp.Status)}
-			resp.Body.Close()
-			continue
+// withBatchDeadline returns the context for one embedding batch: ctx with the
+// OllamaBatchDeadline it carries, if any. batchErr translates the error of a batch run
+// under the returned context, reporting an expired batch deadline as
+// ErrBatchDeadlineExceeded.
+func withBatchDeadline(ctx context.Context) (batchCtx context.Context, cancel context.CancelFunc, batchErr func(error) error) {
+	d, _ := ctx.Value(batchDeadlineKey{}).(time.Duration)
+	if d <= 0 {
+		return ctx, func() {}, func(err error) error { return err }
+	}
+	batchCtx, cancel = context.WithTimeout(ctx, d)
+	return batchCtx, cancel, func(err error) error {
+		if err != nil && batchCtx.Err() != nil && ctx.Err() == nil {
+			return fmt.Errorf("%w (%s): %w", ErrBatchDeadlineExceeded, d, err)
 		}
+		return err
+	}
+}
 
-		var ollamaResp ollamaResponse
-		if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("failed to decode ollama response for sentence %d: %w", job.index, err)}
-			resp.Body.Close()
-			continue
-		}
-		resp.Body.Close()
+// ollamaHTTPClient returns Options.HTTPClient, or a client with a 60 second timeout.
+func ollamaHTTPClient(opts Options) *http.Client {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return &http.Client{Timeout: 60 * time.Second}
+}
 
-		if ollamaResp.Error != "" {
-			results <- ollamaResult{index: job.index, err: fmt.Errorf("ollama api returned error for sentence %d: %s", job.index, ollamaResp.Error)}
-			continue
+// ollamaEmbeddingsURL returns the embeddings endpoint for an Ollama base URL.
+func ollamaEmbeddingsURL(ollamaURL string) string {
+	return strings.TrimSuffix(ollamaURL, "/") + "/api/embeddings"
+}
+
+// ... (ollamaWorker, cosineSimilarityDense, etc. remain the same) ...
+// ollamaWorker embeds the sentences of jobs until the channel is closed. Once ctx is done,
+// remaining jobs fail immediately with the context error. If ctx carries an
+// EmbeddingLimiter, each request waits for a slot.
+func ollamaWorker(ctx context.Context, wg *sync.WaitGroup, provider EmbeddingProvider, jobs <-chan ollamaJob, results chan<- ollamaResult) {
+	defer wg.Done()
+	limiter := embeddingLimiterFrom(ctx)
+	for job := range jobs {
+		if limiter != nil {
+			if err := limiter.acquire(ctx); err != nil {
+				results <- ollamaResult{index: job.index, err: err}
+				continue
+			}
+		}
+		results <- embedJob(ctx, provider, job)
+		if limiter != nil {
+			limiter.release()
 		}
+	}
+}
 
-		results <- ollamaResult{index: job.index, embedding: ollamaResp.Embedding}
+// embedJob embeds the sentence of a single job with provider.
+func embedJob(ctx context.Context, provider EmbeddingProvider, job ollamaJob) ollamaResult {
+	if err := ctx.Err(); err != nil {
+		return ollamaResult{index: job.index, err: err}
+	}
+	embedding, err := provider.Embed(ctx, job.sentence)
+	if err != nil {
+		return ollamaResult{index: job.index, err: fmt.Errorf("sentence %d: %w", job.index, err)}
 	}
+	return ollamaResult{index: job.index, embedding: embedding}
 }
 
 func cosineSimilarityDense(v1, v2 []float64) float64 {
@@ -484,24 +2020,298 @@ func cosineSimilarityDense(v1, v2 []float64) float64 {
 	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
-func calculateCohesionDense(vectors [][]float64) []float64 {
+// calculateCohesionDense scores every sentence gap of dense vectors, comparing adjacent
+// sentences, or with blockSize > 1 the windows around each gap (see blockWindows), or with
+// lookback > 1 the next sentence with each of the previous lookback sentences (keeping the
+// highest similarity), or with decay > 0 the next sentence with the recency-weighted
+// centroid of the previous ones (see decayCentroid), restarted after every gap scoring
+// below resetBelow.
+func calculateCohesionDense(vectors [][]float64, blockSize, lookback int, decay, resetBelow float64) []float64 {
 	if len(vectors) < 2 {
 		return []float64{}
 	}
 	scores := make([]float64, len(vectors)-1)
+	var centroid []float64
 	for i := 0; i < len(vectors)-1; i++ {
-		scores[i] = cosineSimilarityDense(vectors[i], vectors[i+1])
+		if decay > 0 {
+			centroid = decayCentroid(centroid, vectors[i], decay)
+			scores[i] = cosineSimilarityDense(centroid, vectors[i+1])
+			if scores[i] < resetBelow {
+				centroid = nil
+			}
+			continue
+		}
+		if blockSize <= 1 {
+			scores[i] = cosineSimilarityDense(vectors[i], vectors[i+1])
+			for j := lookbackStart(i, lookback); j < i; j++ {
+				scores[i] = math.Max(scores[i], cosineSimilarityDense(vectors[j], vectors[i+1]))
+			}
+			continue
+		}
+		left, right := blockWindows(i, len(vectors), blockSize)
+		scores[i] = cosineSimilarityDense(sumDense(vectors[left:i+1]), sumDense(vectors[i+1:right]))
 	}
 	return scores
 }
 
+// decayResetThreshold returns the score below which a gap becomes a boundary on the dense
+// path, after which the RecencyDecay centroid restarts so the previous topic does not weigh
+// on the next one. validateOptions ensures that RecencyDecay comes with such a threshold.
+func decayResetThreshold(opts Options) float64 {
+	if opts.DenseMinSplitSimilarity > 0 {
+		return opts.DenseMinSplitSimilarity
+	}
+	return max(opts.MinSplitSimilarity, 0)
+}
+
+// decayCentroid folds vector into a recency-weighted centroid: the previous centroid is
+// scaled by decay and the vector, normalized so long vectors do not dominate, is added. A
+// sentence k steps back thus weighs decay^k. It returns a new slice; centroid may be nil.
+func decayCentroid(centroid, vector []float64, decay float64) []float64 {
+	norm := 0.0
+	for _, x := range vector {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		norm = 1
+	}
+	next := make([]float64, len(vector))
+	for j, x := range vector {
+		next[j] = x / norm
+		if j < len(centroid) {
+			next[j] += decay * centroid[j]
+		}
+	}
+	return next
+}
+
+// blockWindows returns the sentence windows compared at gap i (between sentences i and i+1)
+// in a text of n sentences: the left window is [left, i] and the right window is
+// [i+1, right), each up to size sentences long and truncated at the text edges.
+func blockWindows(i, n, size int) (left, right int) {
+	left, right = i-size+1, i+1+size
+	if left < 0 {
+		left = 0
+	}
+	if right > n {
+		right = n
+	}
+	return left, right
+}
+
+// lookbackStart returns the first sentence compared with sentence i+1 at gap i when looking
+// back over up to k sentences.
+func lookbackStart(i, k int) int {
+	if k < 1 {
+		k = 1
+	}
+	if start := i - k + 1; start > 0 {
+		return start
+	}
+	return 0
+}
+
+// sumDense returns the element-wise sum of vectors, i.e. their centroid up to scale,
+// which cosine similarity ignores.
+func sumDense(vectors [][]float64) []float64 {
+	sum := make([]float64, len(vectors[0]))
+	for _, v := range vectors {
+		for j := range sum {
+			if j < len(v) {
+				sum[j] += v[j]
+			}
+		}
+	}
+	return sum
+}
+
+// sumSparse returns the term-wise sum of sparse vectors (their centroid up to scale).
+func sumSparse(vectors []map[string]float64) map[string]float64 {
+	sum := make(map[string]float64)
+	for _, v := range vectors {
+		for term, weight := range v {
+			sum[term] += weight
+		}
+	}
+	return sum
+}
+
 func validateOptions(opts Options) error {
 	if opts.MaxTokens <= 0 {
 		return errors.New("MaxTokens must be a positive number")
 	}
+	if opts.BoundaryPercentile < 0 || opts.BoundaryPercentile > 100 {
+		return errors.New("BoundaryPercentile must be between 0 and 100")
+	}
+	switch opts.IDFFormula {
+	case "", IDFLogSmooth, IDFSklearn:
+	default:
+		return errors.New("unknown IDFFormula: " + opts.IDFFormula)
+	}
+	for _, l := range opts.CandidateLanguages {
+		if !lang.IsSupported(l) {
+			return errors.New("unsupported language in CandidateLanguages: " + l)
+		}
+	}
+	if opts.LanguageDetectionSkipFirst < 0 {
+		return errors.New("LanguageDetectionSkipFirst must not be negative")
+	}
+	if opts.StopWordWeight < 0 || opts.StopWordWeight > 1 {
+		return errors.New("StopWordWeight must be between 0 and 1")
+	}
+	if opts.IDFSmoothing != nil && *opts.IDFSmoothing <= 0 {
+		return errors.New("IDFSmoothing must be positive")
+	}
+	if opts.CoalesceSimilarChunks < 0 || opts.CoalesceSimilarChunks > 1 {
+		return errors.New("CoalesceSimilarChunks must be between 0 and 1")
+	}
+	if opts.DenseMinSplitSimilarity < 0 {
+		return errors.New("DenseMinSplitSimilarity must not be negative")
+	}
+	if opts.BoundaryTermCount < 0 {
+		return errors.New("BoundaryTermCount must not be negative")
+	}
+	if opts.MinDenseCohesionVariance < 0 {
+		return errors.New("MinDenseCohesionVariance must not be negative")
+	}
+	if opts.TfidfMaxVocab < 0 {
+		return errors.New("TfidfMaxVocab must not be negative")
+	}
+	for _, pair := range opts.QuotePairs {
+		if utf8.RuneCountInString(pair) != 2 {
+			return fmt.Errorf("QuotePairs entry %q must be exactly two characters (opening and closing mark)", pair)
+		}
+	}
+	switch opts.ChunkPooling {
+	case "", ChunkPoolingMean, ChunkPoolingLengthWeighted, ChunkPoolingMax:
+	default:
+		return errors.New("unknown ChunkPooling: " + opts.ChunkPooling)
+	}
+	if opts.ChunkPooling != "" && opts.EmbeddingMemoryBudget > 0 {
+		return errors.New("ChunkPooling cannot be combined with EmbeddingMemoryBudget")
+	}
+	switch opts.ChunkHash {
+	case "", ChunkHashNone, ChunkHashNormalized, ChunkHashRaw:
+	default:
+		return errors.New("unknown ChunkHash: " + opts.ChunkHash)
+	}
+	switch opts.ChunkOutput {
+	case "", ChunkOutputBoth, ChunkOutputText, ChunkOutputSentences:
+	default:
+		return errors.New("unknown ChunkOutput: " + opts.ChunkOutput)
+	}
+	switch opts.OutputFormat {
+	case "", OutputFormatOriginal, OutputFormatPlain:
+	default:
+		return errors.New("unknown OutputFormat: " + opts.OutputFormat)
+	}
+	switch opts.TokenCountMethod {
+	case "", TokenCountWords, TokenCountWordsAndPunctuation, TokenCountChars:
+	default:
+		return errors.New("unknown TokenCountMethod: " + opts.TokenCountMethod)
+	}
+	switch opts.CJKTokenMode {
+	case "", CJKTokenModeWords, CJKTokenModeChars:
+	default:
+		return errors.New("unknown CJKTokenMode: " + opts.CJKTokenMode)
+	}
+	switch opts.TokenSeparators {
+	case "", TokenSeparatorsJoin, TokenSeparatorsSplit, TokenSeparatorsKeep:
+	default:
+		return errors.New("unknown TokenSeparators: " + opts.TokenSeparators)
+	}
+	if opts.ContentType != "" && preprocessor(opts.ContentType) == nil {
+		return errors.New("unknown ContentType: " + opts.ContentType)
+	}
+	if opts.OllamaBatchDeadline < 0 {
+		return errors.New("OllamaBatchDeadline must not be negative")
+	}
+	if opts.MinSentenceTokensForEmbedding < 0 {
+		return errors.New("MinSentenceTokensForEmbedding must not be negative")
+	}
+	if opts.OllamaMaxWorkers < 0 {
+		return errors.New("OllamaMaxWorkers must not be negative")
+	}
+	if opts.MaxTokensSlack < 0 {
+		return errors.New("MaxTokensSlack must not be negative")
+	}
+	if (opts.CacheKeyMinNgram != 0 || opts.CacheKeyMaxNgram != 0) && (opts.CacheKeyMinNgram <= 0 || opts.CacheKeyMaxNgram < opts.CacheKeyMinNgram) {
+		return errors.New("CacheKeyMinNgram and CacheKeyMaxNgram must both be set, with 0 < CacheKeyMinNgram <= CacheKeyMaxNgram")
+	}
+	if opts.MaxChunkBytes < 0 {
+		return errors.New("MaxChunkBytes must not be negative")
+	}
+	if opts.OverlapSentences < 0 {
+		return errors.New("OverlapSentences must not be negative")
+	}
+	switch opts.OverlapPolicy {
+	case "", OverlapPolicyExceed, OverlapPolicyFit:
+	default:
+		return errors.New("unknown OverlapPolicy: " + opts.OverlapPolicy)
+	}
+	if opts.MaxInputBytes < 0 {
+		return errors.New("MaxInputBytes must not be negative")
+	}
+	if opts.IntraBatchDedupThreshold < 0 || opts.IntraBatchDedupThreshold > 1 {
+		return errors.New("IntraBatchDedupThreshold must be between 0 and 1")
+	}
+	if opts.StemmingMinLen < 0 {
+		return errors.New("StemmingMinLen must not be negative")
+	}
+	if opts.DropThreshold < 0 || opts.DropWindow < 0 {
+		return errors.New("DropThreshold and DropWindow must not be negative")
+	}
+	if opts.BlockComparisonSize < 0 {
+		return errors.New("BlockComparisonSize must not be negative")
+	}
+	if opts.AdaptiveDepthReference < 0 || opts.AdaptiveDepthExponent < 0 {
+		return errors.New("AdaptiveDepthReference and AdaptiveDepthExponent must not be negative")
+	}
+	if opts.MaxLookback < 0 {
+		return errors.New("MaxLookback must not be negative")
+	}
+	if opts.MaxLookback > 1 && opts.BlockComparisonSize > 1 {
+		return errors.New("MaxLookback cannot be combined with BlockComparisonSize")
+	}
+	if opts.RecencyDecay < 0 || opts.RecencyDecay >= 1 {
+		return errors.New("RecencyDecay must be at least 0 and below 1")
+	}
+	if opts.RecencyDecay > 0 && (opts.MaxLookback > 1 || opts.BlockComparisonSize > 1) {
+		return errors.New("RecencyDecay cannot be combined with BlockComparisonSize or MaxLookback")
+	}
+	if opts.RecencyDecay > 0 && opts.MinSplitSimilarity <= 0 && opts.DenseMinSplitSimilarity <= 0 {
+		return errors.New("RecencyDecay requires MinSplitSimilarity or DenseMinSplitSimilarity")
+	}
 	if opts.EmbeddingCacheMode != CacheModeDisable && opts.EmbeddingCacheMode != "" && opts.EmbeddingCache == nil {
 		return errors.New("EmbeddingCache must be provided when a cache mode is enabled")
 	}
+	if opts.MaxEmbeddingCalls < 0 {
+		return errors.New("MaxEmbeddingCalls must not be negative")
+	}
+	switch opts.EmbeddingCallLimitPolicy {
+	case "", EmbeddingCallLimitError, EmbeddingCallLimitFallback:
+	default:
+		return errors.New("unknown EmbeddingCallLimitPolicy: " + opts.EmbeddingCallLimitPolicy)
+	}
+	switch opts.DimensionMismatchPolicy {
+	case "", DimensionMismatchError, DimensionMismatchSkip, DimensionMismatchRetry:
+	default:
+		return errors.New("unknown DimensionMismatchPolicy: " + opts.DimensionMismatchPolicy)
+	}
+	switch opts.CacheErrorPolicy {
+	case "", CacheErrorFailOpen, CacheErrorFailClosed:
+	default:
+		return errors.New("unknown CacheErrorPolicy: " + opts.CacheErrorPolicy)
+	}
+	if ref := opts.TopicReference; ref != nil {
+		if ref.Text == "" && len(ref.Vector) == 0 {
+			return errors.New("TopicReference requires either Text or Vector")
+		}
+		if ref.Threshold < 0 || ref.Threshold > 1 {
+			return errors.New("TopicReference.Threshold must be between 0 and 1")
+		}
+	}
 	return nil
 }
 
@@ -514,14 +2324,43 @@ func setDefaultOptions(opts *Options) {
 		opts.DepthThreshold = 0.1
 	}
 
+	if opts.AdaptiveDepth {
+		if opts.AdaptiveDepthReference == 0 {
+			opts.AdaptiveDepthReference = 20
+		}
+		if opts.AdaptiveDepthExponent == 0 {
+			opts.AdaptiveDepthExponent = 0.5
+		}
+	}
+
 	if opts.Language == "" && opts.LanguageDetectionMode == "" {
 		opts.LanguageDetectionMode = LangDetectModeFirstSentence
 	}
 
+	if opts.DropThreshold > 0 && opts.DropWindow == 0 {
+		opts.DropWindow = 3
+	}
+
 	if opts.EmbeddingCacheMode != CacheModeDisable && opts.CacheSimilarityThreshold == 0 {
 		opts.CacheSimilarityThreshold = 0.9
 	}
 
+	if opts.BoundaryTermCount == 0 {
+		opts.BoundaryTermCount = 5
+	}
+
+	if opts.DimensionMismatchPolicy == "" {
+		opts.DimensionMismatchPolicy = DimensionMismatchError
+	}
+
+	if opts.EmbeddingCallLimitPolicy == "" {
+		opts.EmbeddingCallLimitPolicy = EmbeddingCallLimitError
+	}
+
+	if opts.CacheErrorPolicy == "" {
+		opts.CacheErrorPolicy = CacheErrorFailOpen
+	}
+
 	if opts.EmbeddingCacheMode == CacheModeAdaptive && opts.AdaptiveCacheActivationThreshold == 0 {
 		opts.AdaptiveCacheActivationThreshold = 100
 	}
@@ -534,101 +2373,509 @@ func setDefaultOptions(opts *Options) {
 		t := true
 		opts.EnableStemming = &t
 	}
+	if opts.IDFFormula == "" {
+		opts.IDFFormula = IDFLogSmooth
+	}
+	if opts.IDFSmoothing == nil {
+		k := tfidf.DefaultIDFSmoothing
+		opts.IDFSmoothing = &k
+	}
 	if opts.PreNormalizeAbbreviations == nil {
 		t := true
 		opts.PreNormalizeAbbreviations = &t
 	}
+	if opts.TFIDFSimilarity == nil {
+		opts.TFIDFSimilarity = CosineSimilarity
+	}
+	if opts.DepthFunc == nil {
+		opts.DepthFunc = AverageDepth
+	}
+
+	if opts.TopicReference != nil && opts.TopicReference.Threshold == 0 {
+		// Copy so the caller's reference is not mutated.
+		ref := *opts.TopicReference
+		ref.Threshold = defaultTopicThreshold
+		opts.TopicReference = &ref
+	}
 }
 
 // ... (calculateCohesion, findBoundaries, buildChunks, makeChunk remain the same) ...
-func calculateCohesion(vectors []map[string]float64) []float64 {
+// calculateCohesion scores every sentence gap of TF-IDF vectors like calculateCohesionDense,
+// comparing vectors with similarity.
+func calculateCohesion(vectors []map[string]float64, blockSize, lookback int, similarity SimilarityFunc) []float64 {
 	if len(vectors) < 2 {
 		return []float64{}
 	}
 	scores := make([]float64, len(vectors)-1)
 	for i := 0; i < len(vectors)-1; i++ {
-		scores[i] = tfidf.CosineSimilarity(vectors[i], vectors[i+1])
+		if blockSize <= 1 {
+			scores[i] = similarity(vectors[i], vectors[i+1])
+			for j := lookbackStart(i, lookback); j < i; j++ {
+				scores[i] = math.Max(scores[i], similarity(vectors[j], vectors[i+1]))
+			}
+			continue
+		}
+		left, right := blockWindows(i, len(vectors), blockSize)
+		scores[i] = similarity(sumSparse(vectors[left:i+1]), sumSparse(vectors[i+1:right]))
 	}
 	return scores
 }
 
-func findBoundaries(scores []float64, opts Options) map[int]bool {
+// findBoundaries returns the gaps chosen as semantic boundaries. When gaps is non-nil
+// (one entry per score), it also records the scoring decision for every gap.
+func findBoundaries(scores []float64, opts Options, gaps []GapDecision) map[int]bool {
 	boundaries := make(map[int]bool)
 	if len(scores) == 0 {
 		return boundaries
 	}
 
+	// Percentile method (only when no fixed threshold is set)
+	if opts.MinSplitSimilarity <= 0 && opts.BoundaryPercentile > 0 {
+		lowest := lowestScoreIndices(scores, opts.BoundaryPercentile)
+		for _, i := range lowest {
+			boundaries[i] = true
+		}
+		if gaps != nil {
+			cutoff := math.Inf(-1)
+			if len(lowest) > 0 {
+				cutoff = scores[lowest[len(lowest)-1]]
+			}
+			for i := range gaps {
+				gaps[i] = GapDecision{Index: i, Score: scores[i], Method: BoundaryMethodPercentile, Threshold: cutoff, SemanticBoundary: boundaries[i]}
+			}
+		}
+		return boundaries
+	}
+
+	depthThreshold := adaptiveDepthThreshold(opts, len(scores)+1)
 	for i := 0; i < len(scores); i++ {
 		// Fixed threshold method
 		if opts.MinSplitSimilarity > 0 {
 			if scores[i] < opts.MinSplitSimilarity {
 				boundaries[i] = true
 			}
+			if gaps != nil {
+				gaps[i] = GapDecision{Index: i, Score: scores[i], Method: BoundaryMethodThreshold, Threshold: opts.MinSplitSimilarity, SemanticBoundary: boundaries[i]}
+			}
 			continue
 		}
 
 		// Local minima detection method
+		var isLocalMinimum bool
+		var depth float64
 		if i > 0 && i < len(scores)-1 {
-			isLocalMinimum := scores[i] < scores[i-1] && scores[i] < scores[i+1]
+			isLocalMinimum = scores[i] < scores[i-1] && scores[i] < scores[i+1]
 			if isLocalMinimum {
 				// Calculate the "depth" of the dip
-				depth := (scores[i-1]+scores[i+1])/2 - scores[i]
-				if depth >= opts.DepthThreshold {
+				depth = opts.DepthFunc(scores[i-1], scores[i], scores[i+1])
+				if depth >= depthThreshold {
 					boundaries[i] = true
 				}
 			}
 		}
+
+		// Step-down detection: the score drops and stays low, without a V-shaped dip.
+		var sustainedDrop bool
+		if !boundaries[i] && opts.DropThreshold > 0 {
+			sustainedDrop = isSustainedDrop(scores, i, opts.DropThreshold, opts.DropWindow)
+			boundaries[i] = sustainedDrop
+		}
+
+		if gaps != nil {
+			gaps[i] = GapDecision{
+				Index: i, Score: scores[i], Method: BoundaryMethodDepth, Threshold: depthThreshold,
+				IsLocalMinimum: isLocalMinimum, Depth: depth, SustainedDrop: sustainedDrop, SemanticBoundary: boundaries[i],
+			}
+		}
 	}
 	return boundaries
 }
 
+// AverageDepth is the default Options.DepthFunc: the mean of the neighboring scores minus
+// the score of the dip, (left+right)/2 - center.
+func AverageDepth(left, center, right float64) float64 {
+	return (left+right)/2 - center
+}
+
+// adaptiveDepthThreshold returns the depth threshold for a document of n sentences:
+// DepthThreshold, scaled by (AdaptiveDepthReference/n)^AdaptiveDepthExponent with AdaptiveDepth.
+func adaptiveDepthThreshold(opts Options, n int) float64 {
+	if !opts.AdaptiveDepth || n <= 0 {
+		return opts.DepthThreshold
+	}
+	return opts.DepthThreshold * math.Pow(float64(opts.AdaptiveDepthReference)/float64(n), opts.AdaptiveDepthExponent)
+}
+
+// isSustainedDrop reports whether the score at gap i falls at least threshold below the
+// average of the preceding window scores and does not recover within the following window
+// scores (no later score regains half of the drop). Only the first gap of a drop qualifies,
+// and at least one following score is required as evidence that the score stays low.
+func isSustainedDrop(scores []float64, i int, threshold float64, window int) bool {
+	if i == 0 || i == len(scores)-1 || scores[i] >= scores[i-1] {
+		return false
+	}
+	start := i - window
+	if start < 0 {
+		start = 0
+	}
+	var trailing float64
+	for _, sc := range scores[start:i] {
+		trailing += sc
+	}
+	trailing /= float64(i - start)
+	if trailing-scores[i] < threshold {
+		return false
+	}
+
+	end := i + 1 + window
+	if end > len(scores) {
+		end = len(scores)
+	}
+	for _, sc := range scores[i+1 : end] {
+		if sc >= trailing-threshold/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// lowestScoreIndices returns the indices of the lowest percentile% of scores (rounded to
+// the nearest whole count). Ties are broken by position, earlier gaps first.
+func lowestScoreIndices(scores []float64, percentile float64) []int {
+	k := int(math.Round(percentile / 100 * float64(len(scores))))
+	if k <= 0 {
+		return nil
+	}
+	indices := make([]int, len(scores))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool { return scores[indices[a]] < scores[indices[b]] })
+	return indices[:k]
+}
+
 func buildChunks(
 	sentences []string,
 	tokenCounts []int,
 	boundaryIndices map[int]bool,
-	maxTokens int,
+	opts Options,
+	gaps []GapDecision,
 ) []Chunk {
+	maxTokens := opts.MaxTokens
+	maxBytes := opts.MaxChunkBytes
+	joiner := opts.ChunkJoiner
+	output := opts.ChunkOutput
 	var chunks []Chunk
 	currentChunkSentences := []string{}
+	// Without Chunk.Sentences, no chunk keeps the sentence list, so its buffer is reused.
+	nextChunkSentences := func() []string {
+		if output == ChunkOutputText {
+			return currentChunkSentences[:0]
+		}
+		return []string{}
+	}
 	currentChunkTokens := 0
+	currentChunkBytes := 0
+	currentChunkStart := 0
+	slackUntil := -1 // last sentence admitted to the current chunk by MaxTokensSlack
+	var cuts map[int]bool
+	if opts.Balanced {
+		cuts = balancedCuts(sentences, tokenCounts, boundaryIndices, opts)
+	}
 
 	for i, sentence := range sentences {
 		sentenceTokens := tokenCounts[i]
 
-		if sentenceTokens > maxTokens {
+		if sentenceTokens > maxTokens || (maxBytes > 0 && len(sentence) > maxBytes) {
 			if len(currentChunkSentences) > 0 {
-				chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkTokens))
+				chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkStart, currentChunkTokens, joiner, output))
+			}
+			if opts.SplitOversizedSentences {
+				pieces, pieceTokens := splitOversizedSentence(sentence, maxTokens, maxBytes, opts.TokenCountMethod, opts.CJKTokenMode == CJKTokenModeChars)
+				for j, piece := range pieces {
+					chunks = append(chunks, makeChunk([]string{piece}, i, pieceTokens[j], joiner, output))
+				}
+			} else {
+				chunks = append(chunks, makeChunk([]string{sentence}, i, sentenceTokens, joiner, output))
 			}
-			chunks = append(chunks, makeChunk([]string{sentence}, sentenceTokens))
-			currentChunkSentences = []string{}
+			currentChunkSentences = nextChunkSentences()
 			currentChunkTokens = 0
+			currentChunkBytes = 0
+			// An oversized sentence always stands alone: both of its gaps split.
+			recordSplit(gaps, i-1, SplitReasonOversizedSentence)
+			recordSplit(gaps, i, SplitReasonOversizedSentence)
 			continue
 		}
 
 		isSemanticBoundary := i > 0 && boundaryIndices[i-1]
-		tokenLimitExceeded := currentChunkTokens+sentenceTokens > maxTokens
+		chunkBytes := len(sentence)
+		if len(currentChunkSentences) > 0 {
+			chunkBytes += currentChunkBytes + separatorBytes(currentChunkSentences[len(currentChunkSentences)-1], sentence, joiner)
+		}
+		byteLimitExceeded := maxBytes > 0 && chunkBytes > maxBytes
+		tokenLimitExceeded := currentChunkTokens+sentenceTokens > maxTokens && i > slackUntil
+		if opts.Balanced {
+			tokenLimitExceeded = i > 0 && cuts[i-1]
+		} else if tokenLimitExceeded && !isSemanticBoundary && opts.MaxTokensSlack > 0 {
+			if last := slackExtension(tokenCounts, boundaryIndices, i, currentChunkTokens, maxTokens, opts.MaxTokensSlack); last >= 0 {
+				slackUntil = last
+				tokenLimitExceeded = false
+			}
+		}
 
-		if len(currentChunkSentences) > 0 && (isSemanticBoundary || tokenLimitExceeded) {
-			chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkTokens))
-			currentChunkSentences = []string{}
+		if len(currentChunkSentences) > 0 && (isSemanticBoundary || tokenLimitExceeded || byteLimitExceeded) {
+			switch {
+			case !isSemanticBoundary && !tokenLimitExceeded:
+				recordSplit(gaps, i-1, SplitReasonByteLimit)
+			case !isSemanticBoundary:
+				recordSplit(gaps, i-1, SplitReasonTokenLimit)
+			case gaps != nil && gaps[i-1].TopicBoundary:
+				recordSplit(gaps, i-1, SplitReasonTopic)
+			case gaps != nil && gaps[i-1].ScriptBoundary:
+				recordSplit(gaps, i-1, SplitReasonScriptChange)
+			default:
+				recordSplit(gaps, i-1, SplitReasonSemantic)
+			}
+			chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkStart, currentChunkTokens, joiner, output))
+			currentChunkSentences = nextChunkSentences()
 			currentChunkTokens = 0
+			chunkBytes = len(sentence)
+			slackUntil = -1
+		}
+
+		if len(currentChunkSentences) == 0 {
+			currentChunkStart = i
 		}
 
 		currentChunkSentences = append(currentChunkSentences, sentence)
 		currentChunkTokens += sentenceTokens
+		currentChunkBytes = chunkBytes
 	}
 
 	if len(currentChunkSentences) > 0 {
-		chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkTokens))
+		chunks = append(chunks, makeChunk(currentChunkSentences, currentChunkStart, currentChunkTokens, joiner, output))
 	}
 
 	return chunks
 }
 
-func makeChunk(sentences []string, numTokens int) Chunk {
-	return Chunk{
-		Text:      strings.Join(sentences, " "),
-		Sentences: sentences,
-		NumTokens: numTokens,
+// slackExtension looks ahead from sentence i, which does not fit into the current chunk of
+// chunkTokens tokens, for the next semantic boundary (or the end of the text). It returns the
+// index of the last sentence before that boundary if sentences i through it fit within
+// maxTokens+slack, or -1 otherwise.
+func slackExtension(tokenCounts []int, boundaryIndices map[int]bool, i, chunkTokens, maxTokens, slack int) int {
+	for j := i; j < len(tokenCounts); j++ {
+		if tokenCounts[j] > maxTokens {
+			return -1 // Oversized sentences always stand alone.
+		}
+		chunkTokens += tokenCounts[j]
+		if chunkTokens > maxTokens+slack {
+			return -1
+		}
+		if j == len(tokenCounts)-1 || boundaryIndices[j] {
+			return j
+		}
+	}
+	return -1
+}
+
+// splitOversizedSentence splits a sentence into consecutive pieces of at most maxTokens
+// tokens (counted with method) and, if maxBytes > 0, at most maxBytes bytes, cutting only at
+// whitespace so words stay intact, and, with cjkChars, also between CJK characters. It
+// returns the pieces and their token counts. A single word that exceeds either limit is
+// kept whole.
+func splitOversizedSentence(sentence string, maxTokens, maxBytes int, method string, cjkChars bool) ([]string, []int) {
+	var pieces []string
+	var pieceTokens []int
+	var current strings.Builder
+	currentTokens, currentRunes := 0, 0
+	for _, word := range sentenceWords(sentence, cjkChars) {
+		wordTokens := countTokens(word.text, method, cjkChars)
+		wordRunes := utf8.RuneCountInString(word.text)
+		// Character estimates are not additive: the joining space counts too.
+		nextTokens, nextRunes := currentTokens+wordTokens, currentRunes+wordRunes
+		nextBytes := current.Len() + len(word.text)
+		spaced := current.Len() > 0 && word.spaced
+		if spaced {
+			nextRunes++
+			nextBytes++
+		}
+		if method == TokenCountChars {
+			nextTokens = charTokenEstimate(nextRunes)
+		}
+		if current.Len() > 0 && (nextTokens > maxTokens || (maxBytes > 0 && nextBytes > maxBytes)) {
+			pieces = append(pieces, current.String())
+			pieceTokens = append(pieceTokens, currentTokens)
+			current.Reset()
+			spaced = false
+			nextTokens, nextRunes = wordTokens, wordRunes
+		}
+		if spaced {
+			current.WriteByte(' ')
+		}
+		current.WriteString(word.text)
+		currentTokens, currentRunes = nextTokens, nextRunes
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+		pieceTokens = append(pieceTokens, currentTokens)
+	}
+	return pieces, pieceTokens
+}
+
+// sentenceWord is a unit that splitOversizedSentence never cuts; spaced reports whether
+// whitespace preceded it in the sentence.
+type sentenceWord struct {
+	text   string
+	spaced bool
+}
+
+// sentenceWords returns the whitespace-separated words of sentence. With cjkChars, every
+// CJK character also forms a word of its own, together with any punctuation directly
+// following it.
+func sentenceWords(sentence string, cjkChars bool) []sentenceWord {
+	var words []sentenceWord
+	for _, field := range strings.Fields(sentence) {
+		if !cjkChars {
+			words = append(words, sentenceWord{text: field, spaced: true})
+			continue
+		}
+		spaced, start := true, 0
+		for i, r := range field {
+			if i == start {
+				continue
+			}
+			prev := lastRune(field[start:i])
+			if isUnspacedScript(r) || (isUnspacedScript(prev) && !unicode.IsPunct(r)) {
+				words = append(words, sentenceWord{text: field[start:i], spaced: spaced})
+				spaced, start = false, i
+			}
+		}
+		words = append(words, sentenceWord{text: field[start:], spaced: spaced})
+	}
+	return words
+}
+
+// lastRune returns the last rune of s.
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// countTokens counts the tokens of s with the given TokenCountMethod ("" means the default).
+// With cjkChars, the word-based methods count every CJK character as one token.
+func countTokens(s, method string, cjkChars bool) int {
+	if cjkChars && method != TokenCountChars && strings.IndexFunc(s, isUnspacedScript) >= 0 {
+		cjk := 0
+		rest := strings.Map(func(r rune) rune {
+			if isUnspacedScript(r) {
+				cjk++
+				return ' '
+			}
+			return r
+		}, s)
+		return cjk + countTokens(rest, method, false)
+	}
+	switch method {
+	case TokenCountWordsAndPunctuation:
+		n := len(text.Tokenize(s))
+		for _, r := range s {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				n++
+			}
+		}
+		return n
+	case TokenCountChars:
+		return charTokenEstimate(utf8.RuneCountInString(s))
+	default:
+		return len(text.Tokenize(s))
+	}
+}
+
+// charTokenEstimate converts a character count into tokens at four characters per token.
+func charTokenEstimate(runes int) int {
+	return (runes + 3) / 4
+}
+
+// makeChunk builds a chunk from consecutive sentences, the first of which sits at index
+// first in the document's sentence list, filling Text and Sentences as output selects.
+func makeChunk(sentences []string, first, numTokens int, joiner *string, output string) Chunk {
+	indices := make([]int, len(sentences))
+	for i := range indices {
+		indices[i] = first + i
+	}
+	chunk := Chunk{NumTokens: numTokens, SentenceIndices: indices}
+	if output != ChunkOutputSentences {
+		chunk.Text = joinSentences(sentences, joiner)
+	}
+	if output != ChunkOutputText {
+		chunk.Sentences = sentences
+	}
+	return chunk
+}
+
+// chunkText returns the text of chunk, joining its sentences if Text was left out (see
+// Options.ChunkOutput).
+func chunkText(chunk Chunk, joiner *string) string {
+	if chunk.Text == "" && chunk.Sentences != nil {
+		return joinSentences(chunk.Sentences, joiner)
+	}
+	return chunk.Text
+}
+
+// separatorBytes returns the number of bytes joinSentences puts between sentences prev and
+// next.
+func separatorBytes(prev, next string, joiner *string) int {
+	if joiner != nil {
+		return len(*joiner)
+	}
+	if isUnspacedScript(lastLetter(prev)) && isUnspacedScript(firstLetter(next)) {
+		return 0
+	}
+	return 1
+}
+
+// joinSentences joins chunk sentences with the configured joiner. Without an explicit
+// joiner, sentences are separated by a single space, except that two adjacent sentences
+// written in a script without word spacing (Han, Hiragana, Katakana) are concatenated directly.
+func joinSentences(sentences []string, joiner *string) string {
+	if joiner != nil {
+		return strings.Join(sentences, *joiner)
+	}
+
+	var b strings.Builder
+	for i, s := range sentences {
+		if i > 0 && !(isUnspacedScript(lastLetter(sentences[i-1])) && isUnspacedScript(firstLetter(s))) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// isUnspacedScript reports whether r belongs to a script that does not separate words with spaces.
+func isUnspacedScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// firstLetter returns the first letter or digit of s, or 0 if there is none.
+func firstLetter(s string) rune {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+	}
+	return 0
+}
+
+// lastLetter returns the last letter or digit of s, skipping trailing punctuation, or 0 if there is none.
+func lastLetter(s string) rune {
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		s = s[:len(s)-size]
 	}
+	return 0
 }
@@ -2,8 +2,24 @@
 package semseg
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmsdko/semseg/internal/text"
 )
 
 // TODO
@@ -51,6 +67,30 @@ func TestSegment(t *testing.T) {
 			expectedNumChunks: 1,
 			expectedTokens:    []int{21}, // CORRECT: The sentence has 21 tokens.
 		},
+		{
+			name: "Oversized single sentence split",
+			text: "This single sentence is deliberately made to be much longer than the " +
+				"maximum token limit to test the edge case handling.",
+			opts: Options{
+				MaxTokens:               15,
+				DepthThreshold:          0.0,
+				SplitOversizedSentences: true,
+			},
+			expectedNumChunks: 2,
+			expectedTokens:    []int{15, 6}, // The 21 tokens are cut at the limit.
+		},
+		{
+			name: "Oversized sentence split among others",
+			text: "Short one. This single sentence is deliberately made to be much longer than the " +
+				"maximum token limit to test the edge case handling. Short two.",
+			opts: Options{
+				MaxTokens:               15,
+				DepthThreshold:          0.0,
+				SplitOversizedSentences: true,
+			},
+			expectedNumChunks: 4,
+			expectedTokens:    []int{2, 15, 6, 2},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -93,3 +133,3434 @@ func TestSplitAndTokenizeIntegration(t *testing.T) {
 		t.Fatalf("Expected tokens in first chunk")
 	}
 }
+
+// TestChunkSentenceIndices verifies that every chunk carries contiguous indices into the
+// document's sentence list, matching its sentences, with oversized sentences and both
+// boundary kinds (token limit and semantic) covered.
+func TestChunkSentenceIndices(t *testing.T) {
+	doc := "Cats purr softly. Cats sleep all day. " +
+		"This sentence about stock markets is deliberately far longer than the token limit allows here. " +
+		"Markets rise and fall. Investors watch markets closely. Cats chase mice."
+	all := text.SplitSentences(doc)
+	chunks, err := Segment(doc, Options{MaxTokens: 8, BoundaryPercentile: 40})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+
+	next := 0
+	for i, ch := range chunks {
+		if len(ch.SentenceIndices) != len(ch.Sentences) {
+			t.Fatalf("Chunk %d: %d indices for %d sentences", i, len(ch.SentenceIndices), len(ch.Sentences))
+		}
+		for j, idx := range ch.SentenceIndices {
+			if idx != next {
+				t.Fatalf("Chunk %d: expected index %d, got %d", i, next, idx)
+			}
+			if all[idx] != ch.Sentences[j] {
+				t.Fatalf("Chunk %d: index %d points to %q, chunk has %q", i, idx, all[idx], ch.Sentences[j])
+			}
+			next++
+		}
+	}
+	if next != len(all) {
+		t.Fatalf("Expected indices to cover %d sentences, covered %d", len(all), next)
+	}
+
+	single, err := Segment("Just one sentence.", Options{MaxTokens: 8})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if !reflect.DeepEqual(single[0].SentenceIndices, []int{0}) {
+		t.Fatalf("Expected [0] for a single sentence, got %v", single[0].SentenceIndices)
+	}
+}
+
+// TestSegmentSentinelRunes verifies that input containing the internal placeholder runes
+// (used for ellipsis and decimal-dot masking) produces clean, correctly split output.
+func TestSegmentSentinelRunes(t *testing.T) {
+	text := "It costs 3.14 now\uE000ELLIPSIS\uE000. Then \uE001DECIMAL_DOT\uE001 appears... Done."
+	chunks, err := Segment(text, Options{MaxTokens: 3, DepthThreshold: 0.0})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	var sentences []string
+	for _, ch := range chunks {
+		sentences = append(sentences, ch.Sentences...)
+	}
+	expected := []string{"It costs 3.14 nowELLIPSIS.", "Then DECIMAL_DOT appears...", "Done."}
+	if strings.Join(sentences, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected sentences %q, got %q", expected, sentences)
+	}
+}
+
+// TestChunkJoiner verifies custom joiners, the empty joiner for Chinese text, and the
+// script-aware default (no space between CJK sentences, one space otherwise).
+func TestChunkJoiner(t *testing.T) {
+	newline, empty := "\n", ""
+	testCases := []struct {
+		name     string
+		text     string
+		joiner   *string
+		expected string
+	}{
+		{"Default space", "Mars is red. Venus is hot.", nil, "Mars is red. Venus is hot."},
+		{"Newline joiner", "Mars is red. Venus is hot.", &newline, "Mars is red.\nVenus is hot."},
+		{"Empty joiner for Chinese", "今天天气很好! 我们去公园吧.", &empty, "今天天气很好!我们去公园吧."},
+		{"Default for Chinese", "今天天气很好! 我们去公园吧.", nil, "今天天气很好!我们去公园吧."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks, err := Segment(tc.text, Options{MaxTokens: 100, DepthThreshold: 1, ChunkJoiner: tc.joiner})
+			if err != nil {
+				t.Fatalf("Segment() error: %v", err)
+			}
+			assertChunkTexts(t, chunks, []string{tc.expected})
+		})
+	}
+}
+
+// TestBoundaryPercentile verifies percentile-based boundaries on a known score distribution
+// and that MinSplitSimilarity takes precedence over the percentile.
+func TestBoundaryPercentile(t *testing.T) {
+	scores := []float64{0.9, 0.2, 0.8, 0.5, 0.1, 0.7, 0.6, 0.3, 0.95, 0.4}
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected []int
+	}{
+		{"Bottom 20%", Options{BoundaryPercentile: 20}, []int{1, 4}},
+		{"Bottom 30%", Options{BoundaryPercentile: 30}, []int{1, 4, 7}},
+		{"Rounds to nearest gap", Options{BoundaryPercentile: 4}, nil},
+		{"All gaps", Options{BoundaryPercentile: 100}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"MinSplitSimilarity wins", Options{BoundaryPercentile: 50, MinSplitSimilarity: 0.15}, []int{4}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			boundaries := findBoundaries(scores, tc.opts, nil)
+			var got []int
+			for i := range scores {
+				if boundaries[i] {
+					got = append(got, i)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected boundaries %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	if _, err := Segment("A. B.", Options{MaxTokens: 10, BoundaryPercentile: 120}); err == nil {
+		t.Errorf("Expected a validation error for BoundaryPercentile > 100")
+	}
+}
+
+// TestBlockComparisonSize verifies that block comparison finds the topic shift in a text with
+// interleaved topic sentences, where adjacent-pair scores are flat and yield no boundary.
+func TestBlockComparisonSize(t *testing.T) {
+	doc := "Cats purr softly. Cats nap daily. Cats chase mice. Stocks fell sharply. Cats groom often. " +
+		"Stocks rallied strongly. Stocks dipped again. Cats hunt birds. Stocks rose later. Stocks closed higher."
+	boundaries := func(blockSize int) []int {
+		_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 100, DepthThreshold: 0.03, BlockComparisonSize: blockSize})
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		var indices []int
+		for _, gap := range details.Gaps {
+			if gap.SemanticBoundary {
+				indices = append(indices, gap.Index)
+			}
+		}
+		return indices
+	}
+
+	if got := boundaries(0); len(got) != 0 {
+		t.Fatalf("Expected no adjacent-pair boundaries, got %v", got)
+	}
+	if got := boundaries(3); !reflect.DeepEqual(got, []int{2}) {
+		t.Fatalf("Expected a block boundary after sentence 2, got %v", got)
+	}
+
+	// Block scores compare window centroids, so the adjacent case is the K=1 special case.
+	vectors := []map[string]float64{{"a": 1}, {"b": 1}, {"a": 1}, {"b": 1}}
+	if !reflect.DeepEqual(calculateCohesion(vectors, 1, 0, CosineSimilarity), calculateCohesion(vectors, 0, 0, CosineSimilarity)) {
+		t.Fatalf("Expected BlockComparisonSize 1 to equal adjacent comparison")
+	}
+	if got := calculateCohesion(vectors, 2, 0, CosineSimilarity); math.Abs(got[1]-1) > 1e-9 {
+		t.Fatalf("Expected identical windows around the middle gap to score 1, got %v", got)
+	}
+}
+
+// TestAdaptiveDepth verifies that one DepthThreshold yields sensible chunk counts for a
+// 5-sentence and a 200-sentence document once scaled by the sentence count: a fixed
+// threshold splits the short document at a moderate dip and misses the shallower topic
+// shifts of the long one.
+func TestAdaptiveDepth(t *testing.T) {
+	// A sentence "Topic k note i." embeds as topic axis k plus a shared component, so
+	// scores are 1 within a topic and shared²/(1+shared²) across a topic shift.
+	document := func(topicSizes []int, shared float64) (string, EmbeddingProvider) {
+		var sentences []string
+		for k, size := range topicSizes {
+			for i := 0; i < size; i++ {
+				sentences = append(sentences, fmt.Sprintf("Topic %d note %d.", k, i))
+			}
+		}
+		provider := EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			var k, i int
+			if _, err := fmt.Sscanf(text, "Topic %d note %d.", &k, &i); err != nil {
+				return nil, err
+			}
+			vec := make([]float64, len(topicSizes)+1)
+			vec[k], vec[len(topicSizes)] = 1, shared
+			return vec, nil
+		})
+		return strings.Join(sentences, " "), provider
+	}
+	countChunks := func(text string, provider EmbeddingProvider, adaptive bool) int {
+		chunks, err := Segment(text, Options{MaxTokens: 10000, DepthThreshold: 0.3, AdaptiveDepth: adaptive, EmbeddingProvider: provider})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		return len(chunks)
+	}
+
+	// Short: one dip of depth 0.4; the threshold becomes 0.3 × (20/5)^0.5 = 0.6.
+	short, shortProvider := document([]int{2, 3}, math.Sqrt(1.5))
+	if got := countChunks(short, shortProvider, false); got != 2 {
+		t.Fatalf("Expected the fixed threshold to split the short document, got %d chunks", got)
+	}
+	if got := countChunks(short, shortProvider, true); got != 1 {
+		t.Fatalf("Expected one chunk for the short document, got %d", got)
+	}
+
+	// Long: ten topics with dips of depth 0.2; the threshold becomes 0.3 × (20/200)^0.5 ≈ 0.095.
+	sizes := make([]int, 10)
+	for i := range sizes {
+		sizes[i] = 20
+	}
+	long, longProvider := document(sizes, 2)
+	if got := countChunks(long, longProvider, false); got != 1 {
+		t.Fatalf("Expected the fixed threshold to miss the topic shifts, got %d chunks", got)
+	}
+	if got := countChunks(long, longProvider, true); got != 10 {
+		t.Fatalf("Expected one chunk per topic for the long document, got %d", got)
+	}
+
+	// A document of AdaptiveDepthReference sentences keeps DepthThreshold unchanged.
+	opts := Options{DepthThreshold: 0.3, AdaptiveDepth: true}
+	setDefaultOptions(&opts)
+	if got := adaptiveDepthThreshold(opts, 20); math.Abs(got-0.3) > 1e-12 {
+		t.Fatalf("Expected the reference length to keep the threshold, got %v", got)
+	}
+}
+
+// TestMaxLookback verifies that looking back over recent sentences keeps interleaved list
+// items together, where adjacent-pair scores drop to zero between every pair of items.
+func TestMaxLookback(t *testing.T) {
+	doc := "Pack the tent and the stove. Tent stakes hold the tent. Stove fuel feeds the stove. " +
+		"Tent poles support the tent. Stove burners heat the stove. Tent flaps close the tent. " +
+		"Stove valves regulate the stove. Invoices list billing charges. " +
+		"Billing reminders follow unpaid invoices. Invoices include billing dates."
+	boundaries := func(lookback int) []int {
+		_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 200, MinSplitSimilarity: 0.1, MaxLookback: lookback})
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		var indices []int
+		for _, gap := range details.Gaps {
+			if gap.SemanticBoundary {
+				indices = append(indices, gap.Index)
+			}
+		}
+		return indices
+	}
+
+	if got := boundaries(0); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5, 6}) {
+		t.Fatalf("Expected adjacent comparison to split between every list item, got %v", got)
+	}
+	if got := boundaries(2); !reflect.DeepEqual(got, []int{6}) {
+		t.Fatalf("Expected a single boundary before the invoice topic, got %v", got)
+	}
+
+	vectors := []map[string]float64{{"a": 1}, {"b": 1}, {"a": 1}, {"c": 1}}
+	if got := calculateCohesion(vectors, 0, 2, CosineSimilarity); !reflect.DeepEqual(got, []float64{0, 1, 0}) {
+		t.Fatalf("Expected the best of the previous two sentences, got %v", got)
+	}
+	if _, err := Segment(doc, Options{MaxTokens: 200, MaxLookback: 2, BlockComparisonSize: 2}); err == nil {
+		t.Error("Expected an error when combined with BlockComparisonSize")
+	}
+}
+
+// TestRecencyDecay streams a text whose topic strays for one sentence before changing:
+// adjacent comparison splits the ocean sentences around the stray one, while the
+// recency-weighted centroid keeps them in one chunk and restarts at the topic change.
+// Streamed scores must match the batch ones.
+func TestRecencyDecay(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	doc := "The ocean waves are high. The ocean is deep. The ocean waves are loud. The ocean is cold. " +
+		"Surfers love big waves. The ocean is calm. The ocean is wide. The ocean is old. " +
+		"The market fell. The market rose. The market closed."
+	embed := keywordEmbedding("ocean", "waves", "market")
+	segment := func(decay float64, streamed bool) ([]Chunk, []float64) {
+		opts := Options{
+			MaxTokens:          100,
+			MinSplitSimilarity: 0.2,
+			RecencyDecay:       decay,
+			EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+				return embed(text), nil
+			}),
+		}
+		var scores []float64
+		if streamed {
+			opts.OnCohesionScore = func(_ int, score float64) { scores = append(scores, score) }
+		}
+		chunks, details, err := SegmentWithDetails(doc, opts)
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		if !streamed {
+			for _, gap := range details.Gaps {
+				scores = append(scores, gap.Score)
+			}
+		}
+		return chunks, scores
+	}
+
+	if chunks, _ := segment(0, true); len(chunks) != 4 || len(chunks[1].Sentences) != 1 {
+		t.Fatalf("Expected adjacent comparison to split the ocean sentences around the stray one, got %d chunks", len(chunks))
+	}
+	chunks, streamedScores := segment(0.5, true)
+	if len(chunks) != 2 || len(chunks[0].Sentences) != 8 {
+		t.Fatalf("Expected the stray sentence not to split the ocean sentences, got %+v", chunks)
+	}
+	// Without the restart, the ocean sentences would still weigh on the market ones.
+	if score := streamedScores[8]; score < 0.999 {
+		t.Errorf("Expected the centroid to restart at the topic change, got score %v", score)
+	}
+	if _, batchScores := segment(0.5, false); !reflect.DeepEqual(streamedScores, batchScores) {
+		t.Errorf("Expected streamed scores %v to match batch scores %v", streamedScores, batchScores)
+	}
+
+	// ThresholdSweep rescores each threshold from the embeddings fetched once.
+	var calls atomic.Int64
+	sweepOpts := Options{
+		MaxTokens:    100,
+		RecencyDecay: 0.5,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			calls.Add(1)
+			return embed(text), nil
+		}),
+	}
+	results, err := ThresholdSweep(doc, sweepOpts, []float64{0.2, 0.8})
+	if err != nil {
+		t.Fatalf("ThresholdSweep() error: %v", err)
+	}
+	if calls.Load() != 11 {
+		t.Errorf("Expected ThresholdSweep to embed the 11 sentences once, got %d calls", calls.Load())
+	}
+	for _, r := range results {
+		sweepOpts.MinSplitSimilarity = r.Threshold
+		if chunks, err := Segment(doc, sweepOpts); err != nil || len(chunks) != r.NumChunks {
+			t.Errorf("Threshold %v: expected %d chunks as from Segment, got %d (error %v)", r.Threshold, len(chunks), r.NumChunks, err)
+		}
+	}
+
+	if _, err := Segment(doc, Options{MaxTokens: 100, MinSplitSimilarity: 0.2, RecencyDecay: 0.5}); !errors.Is(err, ErrRecencyDecayWithoutEmbeddingBackend) {
+		t.Errorf("Expected ErrRecencyDecayWithoutEmbeddingBackend on the TF-IDF path, got %v", err)
+	}
+	for _, opts := range []Options{
+		{MaxTokens: 100, MinSplitSimilarity: 0.2, RecencyDecay: 1},
+		{MaxTokens: 100, MinSplitSimilarity: 0.2, RecencyDecay: -0.5},
+		{MaxTokens: 100, MinSplitSimilarity: 0.2, RecencyDecay: 0.5, MaxLookback: 2},
+		{MaxTokens: 100, RecencyDecay: 0.5},
+	} {
+		if _, err := Segment(doc, opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+}
+
+// TestTFIDFSimilarity checks that Jensen-Shannon similarity places a boundary where cosine
+// does not. Cosine rates the first pair of sentences, which share one heavy term, above the
+// last pair, while JSD counts the shared probability mass and ranks them the other way.
+func TestTFIDFSimilarity(t *testing.T) {
+	vectors := []map[string]float64{
+		{"x": 10, "a": 1, "b": 1, "c": 1, "d": 1},
+		{"x": 10, "e": 1, "f": 1, "g": 1, "h": 1},
+		{"p": 1, "q": 1, "r": 1, "s": 1, "t": 1, "u": 1},
+		{"p": 1, "q": 1, "r": 1, "s": 1, "t": 1, "v": 1},
+	}
+	opts := Options{BoundaryPercentile: 67}
+	testCases := []struct {
+		name       string
+		similarity SimilarityFunc
+		expected   []int
+	}{
+		{"Cosine", CosineSimilarity, []int{1, 2}},
+		{"Jensen-Shannon", JensenShannonSimilarity, []int{0, 1}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scores := calculateCohesion(vectors, 0, 0, tc.similarity)
+			if got := sortedBoundaries(findBoundaries(scores, opts, nil)); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected boundaries %v, got %v (scores %v)", tc.expected, got, scores)
+			}
+		})
+	}
+
+	doc := "Cats purr softly. Cats sleep all day. Stocks fell today."
+	model, err := InspectTFIDF(doc, Options{MaxTokens: 100}, true)
+	if err != nil {
+		t.Fatalf("InspectTFIDF() error: %v", err)
+	}
+	_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 100, TFIDFSimilarity: JensenShannonSimilarity})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	for _, gap := range details.Gaps {
+		if expected := JensenShannonSimilarity(model.Vectors[gap.Index], model.Vectors[gap.Index+1]); math.Abs(gap.Score-expected) > 1e-12 {
+			t.Errorf("Gap %d: expected the Jensen-Shannon score %v, got %v", gap.Index, expected, gap.Score)
+		}
+	}
+}
+
+// TestIntraBatchDedup verifies that identical sentences in one batch cost a single
+// embedding call and all receive the same embedding.
+func TestIntraBatchDedup(t *testing.T) {
+	var calls atomic.Int64
+	embed := keywordEmbedding("ocean", "market")
+	newFakeOllama(t, func(prompt string) []float64 {
+		calls.Add(1)
+		return embed(prompt)
+	})
+	sentences := []string{
+		"Read our privacy policy.", "The ocean is deep.", "Read our privacy policy.",
+		"The market fell.", "Read our privacy policy.", "Read our privacy policy.",
+	}
+	opts := Options{MaxTokens: 100, EmbeddingCacheMode: CacheModeForce}
+
+	for _, threshold := range []float64{0.99, 0} {
+		calls.Store(0)
+		cache := NewInMemoryCache()
+		opts.EmbeddingCache = cache
+		opts.IntraBatchDedupThreshold = threshold
+		setDefaultOptions(&opts)
+		vectors, err := getOllamaEmbeddings(context.Background(), sentences, embeddingProvider(opts), opts)
+		cache.Close()
+		if err != nil {
+			t.Fatalf("getOllamaEmbeddings() error: %v", err)
+		}
+
+		// Without dedup, the first duplicates are still missing from the cache when looked up.
+		expectedCalls := int64(len(sentences))
+		if threshold > 0 {
+			expectedCalls = 3
+		}
+		if calls.Load() != expectedCalls {
+			t.Fatalf("threshold %v: expected %d embedding calls, got %d", threshold, expectedCalls, calls.Load())
+		}
+		for i, s := range sentences {
+			if !reflect.DeepEqual(vectors[i], embed(s)) {
+				t.Fatalf("threshold %v: sentence %d got embedding %v", threshold, i, vectors[i])
+			}
+		}
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	if _, err := Segment(strings.Join(sentences, " "), Options{MaxTokens: 100, IntraBatchDedupThreshold: 0.99}); !errors.Is(err, ErrIntraBatchDedupWithoutEmbeddingBackend) {
+		t.Errorf("Expected ErrIntraBatchDedupWithoutEmbeddingBackend on the TF-IDF path, got %v", err)
+	}
+}
+
+// activatedCacheManager is an adaptive cache manager that reports itself as activated from
+// the start, so tests do not wait for the activation ticker.
+type activatedCacheManager struct {
+	AdaptiveCacheManager
+}
+
+func (activatedCacheManager) IsActivated() bool { return true }
+
+// activatingCacheManager is an adaptive cache manager whose activation is controlled by
+// the test.
+type activatingCacheManager struct {
+	AdaptiveCacheManager
+	activated *atomic.Bool
+}
+
+func (m activatingCacheManager) IsActivated() bool { return m.activated.Load() }
+
+// TestAdaptiveCacheActivationDuringRequest checks that a batch fetched while the cache gets
+// activated is written synchronously, so the very next request is served from the cache.
+func TestAdaptiveCacheActivationDuringRequest(t *testing.T) {
+	sentences := []string{"The ocean is deep.", "The market fell."}
+	embed := keywordEmbedding("ocean", "market")
+	var activated atomic.Bool
+	var calls atomic.Int64
+	manager := activatingCacheManager{NewAdaptiveCacheManager(NewInMemoryCache()), &activated}
+	defer manager.Close()
+	opts := Options{
+		MaxTokens:          100,
+		EmbeddingCacheMode: CacheModeAdaptive,
+		EmbeddingCache:     manager,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			calls.Add(1)
+			activated.Store(true) // The activation boundary falls inside this request.
+			return embed(text), nil
+		}),
+	}
+	setDefaultOptions(&opts)
+
+	for run, want := range []int64{2, 0} {
+		calls.Store(0)
+		if _, err := getOllamaEmbeddings(context.Background(), sentences, opts.EmbeddingProvider, opts); err != nil {
+			t.Fatalf("run %d: getOllamaEmbeddings() error: %v", run, err)
+		}
+		if got := calls.Load(); got != want {
+			t.Errorf("run %d: expected %d provider calls, got %d", run, want, got)
+		}
+	}
+	if got := manager.Metrics().Enqueued; got != 0 {
+		t.Errorf("Expected no queued entries once activated, got %d", got)
+	}
+}
+
+// TestCacheModesWithFakeProvider runs the dense path with a deterministic EmbeddingProvider
+// in every cache mode: embedding the same sentences twice must cost one provider call per
+// sentence on each run without a usable cache, and no calls on the second run once cached.
+func TestCacheModesWithFakeProvider(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	sentences := []string{"The ocean is deep.", "Waves cross the ocean.", "The market fell.", "Traders left the market."}
+	embed := keywordEmbedding("ocean", "market")
+	n := int64(len(sentences))
+
+	testCases := []struct {
+		name      string
+		mode      string
+		cache     func() EmbeddingCache
+		wantCalls [2]int64 // provider calls on the first and second run
+	}{
+		{"disable", CacheModeDisable, func() EmbeddingCache { return nil }, [2]int64{n, n}},
+		{"force", CacheModeForce, func() EmbeddingCache { return NewInMemoryCache() }, [2]int64{n, 0}},
+		{"adaptive before activation", CacheModeAdaptive, func() EmbeddingCache {
+			return NewAdaptiveCacheManager(NewInMemoryCache())
+		}, [2]int64{n, n}},
+		{"adaptive after activation", CacheModeAdaptive, func() EmbeddingCache {
+			return activatedCacheManager{NewAdaptiveCacheManager(NewInMemoryCache())}
+		}, [2]int64{n, 0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int64
+			opts := Options{
+				MaxTokens:          100,
+				EmbeddingCacheMode: tc.mode,
+				EmbeddingCache:     tc.cache(),
+				EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+					calls.Add(1)
+					return embed(text), nil
+				}),
+			}
+			if opts.EmbeddingCache != nil {
+				defer opts.EmbeddingCache.Close()
+			}
+			setDefaultOptions(&opts)
+
+			for run, want := range tc.wantCalls {
+				calls.Store(0)
+				vectors, err := getOllamaEmbeddings(context.Background(), sentences, embeddingProvider(opts), opts)
+				if err != nil {
+					t.Fatalf("run %d: getOllamaEmbeddings() error: %v", run, err)
+				}
+				if calls.Load() != want {
+					t.Errorf("run %d: expected %d provider calls, got %d", run, want, calls.Load())
+				}
+				for i, s := range sentences {
+					if !reflect.DeepEqual(vectors[i], embed(s)) {
+						t.Errorf("run %d: sentence %d got embedding %v", run, i, vectors[i])
+					}
+				}
+				// Before activation, embeddings are queued for the cache in the background.
+				if manager, ok := opts.EmbeddingCache.(AdaptiveCacheManager); ok && !manager.IsActivated() {
+					want := uint64(run+1) * uint64(n)
+					deadline := time.Now().Add(time.Second)
+					for manager.Metrics().Enqueued < want && time.Now().Before(deadline) {
+						time.Sleep(time.Millisecond)
+					}
+					if got := manager.Metrics().Enqueued; got != want {
+						t.Errorf("run %d: expected %d queued cache entries, got %d", run, want, got)
+					}
+				}
+			}
+		})
+	}
+
+	// Without CHUNKER_OLLAMA_*, the provider alone selects the dense path.
+	chunks, err := Segment(strings.Join(sentences, " "), Options{
+		MaxTokens:         100,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) { return embed(text), nil }),
+	})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{"The ocean is deep. Waves cross the ocean.", "The market fell. Traders left the market."})
+}
+
+// TestCacheSkipsInvalidEmbeddings checks that zero-norm and wrong-dimension embeddings are
+// returned but never cached, in force mode and when queued before adaptive activation.
+func TestCacheSkipsInvalidEmbeddings(t *testing.T) {
+	sentences := []string{"The ocean is deep.", "Nothing to see here.", "The market fell.", "Short vector here."}
+	embed := func(text string) []float64 {
+		switch {
+		case strings.HasPrefix(text, "Nothing"):
+			return []float64{0, 0, 0} // zero norm
+		case strings.HasPrefix(text, "Short"):
+			return []float64{1} // wrong dimension
+		}
+		return keywordEmbedding("ocean", "market")(text)
+	}
+	var calls atomic.Int64
+	provider := EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+		calls.Add(1)
+		return embed(text), nil
+	})
+
+	opts := Options{MaxTokens: 100, EmbeddingCacheMode: CacheModeForce, EmbeddingCache: NewInMemoryCache(), EmbeddingProvider: provider}
+	defer opts.EmbeddingCache.Close()
+	setDefaultOptions(&opts)
+	for run, want := range []int64{4, 2} {
+		calls.Store(0)
+		vectors, err := getOllamaEmbeddings(context.Background(), sentences, provider, opts)
+		if err != nil {
+			t.Fatalf("run %d: getOllamaEmbeddings() error: %v", run, err)
+		}
+		if !reflect.DeepEqual(vectors[1], []float64{0, 0, 0}) {
+			t.Errorf("run %d: expected the zero embedding to be returned, got %v", run, vectors[1])
+		}
+		if got := calls.Load(); got != want {
+			t.Errorf("run %d: expected %d provider calls, got %d", run, want, got)
+		}
+	}
+
+	manager := NewAdaptiveCacheManager(NewInMemoryCache())
+	defer manager.Close()
+	opts.EmbeddingCacheMode = CacheModeAdaptive
+	opts.EmbeddingCache = manager
+	if _, err := getOllamaEmbeddings(context.Background(), sentences, provider, opts); err != nil {
+		t.Fatalf("adaptive getOllamaEmbeddings() error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for manager.Metrics().Enqueued < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := manager.Metrics().Enqueued; got != 2 {
+		t.Errorf("Expected only the 2 valid embeddings to be queued, got %d", got)
+	}
+}
+
+// TestOptionsClone checks that mutating the settings of a clone leaves the original
+// unchanged while shared resources stay shared.
+func TestOptionsClone(t *testing.T) {
+	stemming, joiner := true, " | "
+	cache := NewInMemoryCache()
+	defer cache.Close()
+	original := Options{
+		MaxTokens:          100,
+		EnableStemming:     &stemming,
+		ChunkJoiner:        &joiner,
+		CandidateLanguages: []string{"english", "russian"},
+		TopicReference:     &TopicReference{Vector: []float64{1, 0}},
+		EmbeddingCache:     cache,
+	}
+
+	clone := original.Clone()
+	*clone.EnableStemming = false
+	*clone.ChunkJoiner = "\n"
+	clone.CandidateLanguages[0] = "german"
+	clone.TopicReference.Vector[0] = 0
+	if !*original.EnableStemming || *original.ChunkJoiner != " | " || original.CandidateLanguages[0] != "english" || original.TopicReference.Vector[0] != 1 {
+		t.Errorf("Mutating the clone changed the original: %+v", original)
+	}
+	if clone.EmbeddingCache != original.EmbeddingCache {
+		t.Error("Expected the clone to share the EmbeddingCache")
+	}
+	if empty := (Options{}).Clone(); empty.EnableStemming != nil || empty.CandidateLanguages != nil || empty.TopicReference != nil {
+		t.Errorf("Expected unset fields to stay unset, got %+v", empty)
+	}
+}
+
+// unavailableCache is an EmbeddingCache whose lookups always fail, like a remote cache
+// whose backend is down.
+type unavailableCache struct {
+	*InMemoryCache
+	err error
+}
+
+func (c unavailableCache) Find(map[string]float64, float64) ([]float64, bool, error) {
+	return nil, false, c.err
+}
+
+// TestCacheErrorPolicy checks that failed cache lookups fall back to the provider when
+// failing open, and fail the call without embedding anything when failing closed.
+func TestCacheErrorPolicy(t *testing.T) {
+	text := "The ocean waves crash on the shore. The market prices fell sharply today."
+	cacheErr := errors.New("cache backend unreachable")
+	for _, tc := range []struct {
+		policy    string
+		wantCalls int64
+		wantErr   bool
+	}{
+		{"", 2, false},
+		{CacheErrorFailOpen, 2, false},
+		{CacheErrorFailClosed, 0, true},
+	} {
+		var calls atomic.Int64
+		embed := keywordEmbedding("ocean", "market")
+		cache := unavailableCache{NewInMemoryCache(), cacheErr}
+		opts := Options{
+			MaxTokens:          100,
+			EmbeddingCacheMode: CacheModeForce,
+			EmbeddingCache:     cache,
+			CacheErrorPolicy:   tc.policy,
+			EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+				calls.Add(1)
+				return embed(text), nil
+			}),
+		}
+		chunks, err := Segment(text, opts)
+		cache.Close()
+		if tc.wantErr {
+			if !errors.Is(err, ErrCacheLookup) || !errors.Is(err, cacheErr) {
+				t.Errorf("%q: expected an error wrapping ErrCacheLookup and the cache error, got %v", tc.policy, err)
+			}
+		} else if err != nil || len(chunks) == 0 {
+			t.Errorf("%q: expected the lookups to fail open, got %d chunks and error %v", tc.policy, len(chunks), err)
+		}
+		if got := calls.Load(); got != tc.wantCalls {
+			t.Errorf("%q: expected %d provider calls, got %d", tc.policy, tc.wantCalls, got)
+		}
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, CacheErrorPolicy: "retry"}); err == nil {
+		t.Error("Expected an error for an unknown CacheErrorPolicy")
+	}
+}
+
+// TestMaxEmbeddingCalls segments a four-sentence document with a limit of three calls under
+// each policy, and checks that 'force' mode only counts the cache misses.
+func TestMaxEmbeddingCalls(t *testing.T) {
+	warm := "The ocean waves crash on the shore. The ocean tide rises at night."
+	text := warm + " The market prices fell sharply today. The market traders sold shares."
+	var calls atomic.Int64
+	embed := keywordEmbedding("ocean", "market")
+	base := Options{
+		MaxTokens:         100,
+		MaxEmbeddingCalls: 3,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			calls.Add(1)
+			return embed(text), nil
+		}),
+	}
+
+	for _, streamed := range []bool{false, true} {
+		opts := base
+		if streamed {
+			opts.OnCohesionScore = func(int, float64) {}
+		}
+		calls.Store(0)
+		if _, err := Segment(text, opts); !errors.Is(err, ErrEmbeddingCallLimit) || calls.Load() != 0 {
+			t.Errorf("streamed=%v: expected ErrEmbeddingCallLimit before any call, got %v after %d calls", streamed, err, calls.Load())
+		}
+
+		opts.EmbeddingCallLimitPolicy = EmbeddingCallLimitFallback
+		chunks, details, err := SegmentWithDetails(text, opts)
+		if err != nil || len(chunks) == 0 || calls.Load() != 0 {
+			t.Fatalf("streamed=%v: expected a TF-IDF result without calls, got %d chunks, error %v and %d calls", streamed, len(chunks), err, calls.Load())
+		}
+		if !slices.Contains(details.Warnings, WarningEmbeddingCallLimit) || details.SentenceEmbeddings != nil {
+			t.Errorf("streamed=%v: expected the fallback to be reported, got %+v", streamed, details)
+		}
+	}
+
+	// The fallback drops the dense-only options rather than rejecting them.
+	denseOnly := base
+	denseOnly.EmbeddingCallLimitPolicy = EmbeddingCallLimitFallback
+	denseOnly.MinSplitSimilarity, denseOnly.RecencyDecay = 0.5, 0.5
+	denseOnly.ChunkPooling, denseOnly.MinSentenceTokensForEmbedding, denseOnly.IntraBatchDedupThreshold = ChunkPoolingMean, 2, 0.99
+	if chunks, err := Segment(text, denseOnly); err != nil || len(chunks) == 0 || chunks[0].Embedding != nil {
+		t.Errorf("Expected the fallback to drop the dense-only options, got %+v (error %v)", chunks, err)
+	}
+
+	// With two of the sentences cached, only two calls remain.
+	cache := NewInMemoryCache()
+	defer cache.Close()
+	opts := base
+	opts.EmbeddingCacheMode, opts.EmbeddingCache = CacheModeForce, cache
+	if _, err := Segment(warm, opts); err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	cache.Flush()
+	calls.Store(0)
+	if _, err := Segment(text, opts); err != nil || calls.Load() != 2 {
+		t.Errorf("Expected the cache misses to fit the limit, got error %v after %d calls", err, calls.Load())
+	}
+
+	for _, invalid := range []Options{
+		{MaxTokens: 100, MaxEmbeddingCalls: -1},
+		{MaxTokens: 100, EmbeddingCallLimitPolicy: "skip"},
+	} {
+		if _, err := Segment(text, invalid); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
+
+// TestBuildCache precomputes the embeddings of a corpus and checks that segmenting it with
+// the built cache, and with a copy saved and loaded again, never calls the provider.
+func TestBuildCache(t *testing.T) {
+	texts := []string{
+		"The ocean waves crash on the shore. The ocean tide rises at night.",
+		"The market prices fell sharply today. The ocean tide rises at night.",
+	}
+	var calls atomic.Int64
+	embed := keywordEmbedding("ocean", "market")
+	opts := Options{MaxTokens: 100, EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+		calls.Add(1)
+		return embed(text), nil
+	})}
+	cache, err := BuildCache(context.Background(), texts, opts)
+	if err != nil {
+		t.Fatalf("BuildCache() error: %v", err)
+	}
+	defer cache.Close()
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected the 3 distinct sentences to be embedded once, got %d calls", got)
+	}
+
+	var saved bytes.Buffer
+	if err := SaveInMemoryCache(cache, &saved); err != nil {
+		t.Fatalf("SaveInMemoryCache() error: %v", err)
+	}
+	loaded, err := LoadInMemoryCache(&saved, CacheOptions{})
+	if err != nil {
+		t.Fatalf("LoadInMemoryCache() error: %v", err)
+	}
+	defer loaded.Close()
+
+	failing := EmbeddingProviderFunc(func(context.Context, string) ([]float64, error) {
+		return nil, errors.New("provider called")
+	})
+	for name, c := range map[string]*InMemoryCache{"built": cache, "loaded": loaded} {
+		for _, text := range texts {
+			serve := Options{MaxTokens: 100, EmbeddingProvider: failing, EmbeddingCacheMode: CacheModeForce, EmbeddingCache: c}
+			if _, err := Segment(text, serve); err != nil {
+				t.Errorf("%s: Segment() should be served from the cache, got error: %v", name, err)
+			}
+		}
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	if _, err := BuildCache(context.Background(), texts, Options{MaxTokens: 100}); !errors.Is(err, ErrNoEmbeddingBackend) {
+		t.Errorf("Expected ErrNoEmbeddingBackend without a backend, got %v", err)
+	}
+}
+
+// TestSegmenter checks that a Segmenter reuses its cache across calls, waits for calls in
+// flight on Close and rejects calls afterwards.
+func TestSegmenter(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	text := "The ocean is deep. Waves cross the ocean. The market fell. Traders left the market."
+	embed := keywordEmbedding("ocean", "market")
+	var calls atomic.Int64
+	entered, release := make(chan struct{}), make(chan struct{})
+	provider := EmbeddingProviderFunc(func(ctx context.Context, text string) ([]float64, error) {
+		calls.Add(1)
+		if strings.HasPrefix(text, "Slow") {
+			close(entered)
+			<-release
+		}
+		return embed(text), nil
+	})
+
+	if _, err := NewSegmenter(Options{}); err == nil {
+		t.Fatal("Expected NewSegmenter() to validate the options")
+	}
+	s, err := NewSegmenter(Options{
+		MaxTokens:          100,
+		EmbeddingProvider:  provider,
+		EmbeddingCacheMode: CacheModeForce,
+		EmbeddingCache:     NewInMemoryCache(),
+	})
+	if err != nil {
+		t.Fatalf("NewSegmenter() error: %v", err)
+	}
+
+	for run, wantCalls := range []int64{4, 0} {
+		calls.Store(0)
+		chunks, err := s.Segment(context.Background(), text)
+		if err != nil {
+			t.Fatalf("run %d: Segment() error: %v", run, err)
+		}
+		if len(chunks) != 2 || calls.Load() != wantCalls {
+			t.Fatalf("run %d: expected 2 chunks and %d provider calls, got %d and %d", run, wantCalls, len(chunks), calls.Load())
+		}
+	}
+
+	// A call blocked in the provider delays Close until it completes.
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Segment(context.Background(), "Slow sentences block the provider. Nothing is cached here.")
+		done <- err
+	}()
+	<-entered
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the call in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Segment() in flight error: %v", err)
+	}
+	<-closed
+
+	if _, err := s.Segment(context.Background(), text); !errors.Is(err, ErrSegmenterClosed) {
+		t.Fatalf("Expected ErrSegmenterClosed after Close, got %v", err)
+	}
+	s.Close() // idempotent
+}
+
+// TestMaxInputBytes verifies that oversized inputs are rejected before any processing and
+// that inputs within the limit are segmented as usual.
+func TestMaxInputBytes(t *testing.T) {
+	doc := "Cats purr softly. Stocks fell today."
+	opts := Options{MaxTokens: 20, MaxInputBytes: len(doc)}
+
+	chunks, err := Segment(doc, opts)
+	if err != nil {
+		t.Fatalf("Segment() error within the limit: %v", err)
+	}
+	expected, _ := Segment(doc, Options{MaxTokens: 20})
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("Expected the limit not to change the result, got %+v", chunks)
+	}
+
+	huge := strings.Repeat("A pathological sentence... with 1.5 dots. ", 1<<16)
+	if _, err := Segment(huge, opts); !errors.Is(err, ErrInputTooLarge) {
+		t.Fatalf("Expected ErrInputTooLarge, got %v", err)
+	}
+	if _, err := SegmentTokenized([]string{doc, doc}, [][]string{{"a"}, {"b"}}, opts); !errors.Is(err, ErrInputTooLarge) {
+		t.Fatalf("Expected ErrInputTooLarge for pre-tokenized input, got %v", err)
+	}
+}
+
+// TestMaxTokensSlack checks that a token-limit cut moves to a nearby semantic boundary (or
+// the end of the text) when the extended chunk stays within MaxTokens+MaxTokensSlack.
+func TestMaxTokensSlack(t *testing.T) {
+	sentences := []string{"s0", "s1", "s2", "s3", "s4"}
+	testCases := []struct {
+		name        string
+		tokenCounts []int
+		boundaries  map[int]bool
+		slack       int
+		expected    [][]int
+	}{
+		{"No slack", []int{5, 5, 3, 4, 5}, map[int]bool{2: true}, 0, [][]int{{0, 1}, {2}, {3, 4}}},
+		{"Boundary within slack", []int{5, 5, 3, 4, 5}, map[int]bool{2: true}, 3, [][]int{{0, 1, 2}, {3, 4}}},
+		{"Boundary beyond slack", []int{5, 5, 3, 4, 5}, map[int]bool{2: true}, 2, [][]int{{0, 1}, {2}, {3, 4}}},
+		{"End of text within slack", []int{6, 3, 2, 4, 4}, map[int]bool{2: true}, 3, [][]int{{0, 1, 2}, {3, 4}}},
+		{"Oversized sentence", []int{5, 5, 11, 1, 1}, map[int]bool{2: true}, 5, [][]int{{0, 1}, {2}, {3, 4}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{MaxTokens: 10, MaxTokensSlack: tc.slack}
+			chunks := buildChunks(sentences, tc.tokenCounts, tc.boundaries, opts, nil)
+			var got [][]int
+			for _, c := range chunks {
+				got = append(got, c.SentenceIndices)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected chunks %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	if _, err := Segment("Mars is red.", Options{MaxTokens: 10, MaxTokensSlack: -1}); err == nil {
+		t.Error("Expected an error for a negative MaxTokensSlack")
+	}
+}
+
+// TestBalanced compares chunk-size variance between the greedy fill and Balanced on the
+// same sentences: balanced chunks are more even, as many, within MaxTokens, and never span
+// a semantic boundary or an oversized sentence.
+func TestBalanced(t *testing.T) {
+	variance := func(chunks []Chunk) float64 {
+		mean, sq := 0.0, 0.0
+		for _, c := range chunks {
+			mean += float64(c.NumTokens)
+			sq += float64(c.NumTokens * c.NumTokens)
+		}
+		mean /= float64(len(chunks))
+		return sq/float64(len(chunks)) - mean*mean
+	}
+	testCases := []struct {
+		name        string
+		tokenCounts []int
+		boundaries  map[int]bool
+	}{
+		{"Tiny last chunk", []int{3, 3, 3, 3, 3, 3, 3}, nil},
+		{"Tiny chunk before a boundary", []int{3, 3, 3, 3, 3, 3, 3, 5, 5}, map[int]bool{6: true}},
+		{"Uneven sentences", []int{7, 2, 2, 2, 2, 6, 1, 4, 4, 1}, nil},
+		{"Oversized sentence", []int{4, 4, 4, 12, 3, 3, 3, 3, 3, 3, 3}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sentences := make([]string, len(tc.tokenCounts))
+			for i := range sentences {
+				sentences[i] = fmt.Sprintf("s%d", i)
+			}
+			greedy := buildChunks(sentences, tc.tokenCounts, tc.boundaries, Options{MaxTokens: 10}, nil)
+			balanced := buildChunks(sentences, tc.tokenCounts, tc.boundaries, Options{MaxTokens: 10, Balanced: true}, nil)
+			if len(balanced) != len(greedy) {
+				t.Fatalf("Expected %d chunks as with the greedy fill, got %d", len(greedy), len(balanced))
+			}
+			if vg, vb := variance(greedy), variance(balanced); vb >= vg {
+				t.Errorf("Expected a lower size variance than greedy (%.2f), got %.2f", vg, vb)
+			}
+			for _, c := range balanced {
+				if c.NumTokens > 10 && len(c.SentenceIndices) > 1 {
+					t.Errorf("Chunk %v exceeds MaxTokens with %d tokens", c.SentenceIndices, c.NumTokens)
+				}
+				for _, i := range c.SentenceIndices[:len(c.SentenceIndices)-1] {
+					if tc.boundaries[i] || tc.tokenCounts[i] > 10 || tc.tokenCounts[i+1] > 10 {
+						t.Errorf("Chunk %v spans a boundary or an oversized sentence", c.SentenceIndices)
+					}
+				}
+			}
+		})
+	}
+
+	text := strings.Repeat("Cats purr softly here. ", 7)
+	sizes := func(opts Options) []int {
+		chunks, err := Segment(text, opts)
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		var got []int
+		for _, c := range chunks {
+			got = append(got, c.NumTokens)
+		}
+		return got
+	}
+	if got := sizes(Options{MaxTokens: 12}); !reflect.DeepEqual(got, []int{12, 12, 4}) {
+		t.Errorf("Expected greedy chunk sizes [12 12 4], got %v", got)
+	}
+	if got := sizes(Options{MaxTokens: 12, Balanced: true}); len(got) != 3 || slices.Min(got) != 8 || slices.Max(got) != 12 {
+		t.Errorf("Expected balanced chunk sizes of 8, 8 and 12 tokens, got %v", got)
+	}
+}
+
+// TestOverlapSentences checks that OverlapSentences repeats the tail of the previous
+// chunk without moving any boundary, that an overlap exceeding MaxTokens is kept with
+// OverlapPolicyExceed and trimmed from the front with OverlapPolicyFit, and that
+// ChunkRanges still locates overlapping chunks.
+func TestOverlapSentences(t *testing.T) {
+	testCases := []struct {
+		name        string
+		tokenCounts []int
+		overlap     int
+		policy      string
+		expected    [][]int
+	}{
+		{"No overlap", []int{4, 4, 4, 4, 4, 4}, 0, "", [][]int{{0, 1}, {2, 3}, {4, 5}}},
+		{"Exceeds MaxTokens", []int{4, 4, 4, 4, 4, 4}, 1, OverlapPolicyExceed, [][]int{{0, 1}, {1, 2, 3}, {3, 4, 5}}},
+		{"Bounded by the previous chunk", []int{4, 4, 4, 4, 4, 4}, 3, "", [][]int{{0, 1}, {0, 1, 2, 3}, {2, 3, 4, 5}}},
+		{"Fit drops all", []int{4, 4, 4, 4, 4, 4}, 1, OverlapPolicyFit, [][]int{{0, 1}, {2, 3}, {4, 5}}},
+		{"Fit drops the earliest", []int{2, 2, 3, 3, 3, 3}, 3, OverlapPolicyFit, [][]int{{0, 1, 2, 3}, {3, 4, 5}}},
+		{"Exceed keeps all", []int{2, 2, 3, 3, 3, 3}, 3, OverlapPolicyExceed, [][]int{{0, 1, 2, 3}, {1, 2, 3, 4, 5}}},
+		{"Oversized sentence", []int{4, 12, 4, 4}, 1, "", [][]int{{0}, {0, 1}, {2, 3}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sentences := make([]string, len(tc.tokenCounts))
+			for i := range sentences {
+				sentences[i] = fmt.Sprintf("s%d", i)
+			}
+			opts := Options{MaxTokens: 10, OverlapSentences: tc.overlap, OverlapPolicy: tc.policy}
+			plain := buildChunks(sentences, tc.tokenCounts, nil, opts, nil)
+			chunks := addOverlap(buildChunks(sentences, tc.tokenCounts, nil, opts, nil), sentences, tc.tokenCounts, opts)
+			var got [][]int
+			for i, c := range chunks {
+				got = append(got, c.SentenceIndices)
+				tokens := 0
+				for _, idx := range c.SentenceIndices {
+					tokens += tc.tokenCounts[idx]
+				}
+				if c.NumTokens != tokens {
+					t.Errorf("Chunk %v: expected %d tokens, got %d", c.SentenceIndices, tokens, c.NumTokens)
+				}
+				if want := len(c.SentenceIndices) - len(plain[i].SentenceIndices); c.OverlapSentences != want {
+					t.Errorf("Chunk %v: expected OverlapSentences %d, got %d", c.SentenceIndices, want, c.OverlapSentences)
+				}
+				if !reflect.DeepEqual(c.Sentences[c.OverlapSentences:], plain[i].Sentences) {
+					t.Errorf("Chunk %v: overlap changed the chunk's own sentences %v", c.SentenceIndices, c.Sentences)
+				}
+				if tc.policy == OverlapPolicyFit && c.NumTokens > 10 && c.OverlapSentences > 0 {
+					t.Errorf("Chunk %v exceeds MaxTokens with OverlapPolicyFit", c.SentenceIndices)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected chunks %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	input := "Cats purr softly here. Cats sleep all day. Dogs bark at night. Dogs dig in yards."
+	chunks, err := Segment(input, Options{MaxTokens: 8, OverlapSentences: 1})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 2 || chunks[1].OverlapSentences != 1 || chunks[1].NumTokens <= 8 {
+		t.Fatalf("Expected 2 chunks, the second exceeding MaxTokens with one overlap sentence, got %+v", chunks)
+	}
+	if !strings.HasPrefix(chunks[1].Text, chunks[0].Sentences[len(chunks[0].Sentences)-1]) {
+		t.Errorf("Expected chunk %q to start with the last sentence of %q", chunks[1].Text, chunks[0].Text)
+	}
+	ranges, err := ChunkRanges(input, chunks)
+	if err != nil {
+		t.Fatalf("ChunkRanges() error: %v", err)
+	}
+	for i, r := range ranges {
+		if got := input[r[0]:r[1]]; got != chunks[i].Text {
+			t.Errorf("Range %d holds %q, want %q", i, got, chunks[i].Text)
+		}
+	}
+	textOnly, err := Segment(input, Options{MaxTokens: 8, OverlapSentences: 1, ChunkOutput: ChunkOutputText})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if _, err := ChunkRanges(input, textOnly); !errors.Is(err, ErrRangesUnavailable) {
+		t.Errorf("Expected ErrRangesUnavailable without Chunk.Sentences, got %v", err)
+	}
+
+	for _, opts := range []Options{{MaxTokens: 8, OverlapSentences: -1}, {MaxTokens: 8, OverlapPolicy: "grow"}} {
+		if _, err := Segment(input, opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+}
+
+// TestTokenCountMethod compares the built-in token estimators on a punctuation-heavy
+// sentence and checks that the selected method drives NumTokens and oversized splitting.
+func TestTokenCountMethod(t *testing.T) {
+	sentence := `Wait... what?! (Really) — "yes", she said.`
+	testCases := []struct {
+		method   string
+		expected int
+	}{
+		{"", 6},
+		{TokenCountWords, 6},
+		{TokenCountWordsAndPunctuation, 18}, // 6 words + 12 punctuation marks
+		{TokenCountChars, 11},               // 42 characters / 4, rounded up
+	}
+
+	for _, tc := range testCases {
+		t.Run("method="+tc.method, func(t *testing.T) {
+			if got := countTokens(sentence, tc.method, false); got != tc.expected {
+				t.Errorf("countTokens() = %d, expected %d", got, tc.expected)
+			}
+			chunks, err := Segment(sentence, Options{MaxTokens: 100, TokenCountMethod: tc.method})
+			if err != nil {
+				t.Fatalf("Segment() returned an error: %v", err)
+			}
+			if len(chunks) != 1 || chunks[0].NumTokens != tc.expected {
+				t.Errorf("Expected one chunk of %d tokens, got %+v", tc.expected, chunks)
+			}
+		})
+	}
+
+	pieces, pieceTokens := splitOversizedSentence("aaaa bbbb cccc dddd", 3, 0, TokenCountChars, false)
+	if !reflect.DeepEqual(pieces, []string{"aaaa bbbb", "cccc dddd"}) || !reflect.DeepEqual(pieceTokens, []int{3, 3}) {
+		t.Errorf("Unexpected character-based split: %q %v", pieces, pieceTokens)
+	}
+
+	if _, err := Segment(sentence, Options{MaxTokens: 10, TokenCountMethod: "bytes"}); err == nil {
+		t.Error("Expected an error for an unknown TokenCountMethod")
+	}
+}
+
+// TestTokenSeparators checks that "TCP/IP" yields the similarity tokens of each policy.
+func TestTokenSeparators(t *testing.T) {
+	testCases := []struct {
+		policy   string
+		expected []string
+	}{
+		{"", []string{"tcpip", "stack"}},
+		{TokenSeparatorsJoin, []string{"tcpip", "stack"}},
+		{TokenSeparatorsSplit, []string{"tcp", "ip", "stack"}},
+		{TokenSeparatorsKeep, []string{"tcp/ip", "stack"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy, func(t *testing.T) {
+			opts := Options{MaxTokens: 100, Language: "english", TokenSeparators: tc.policy}
+			if err := validateOptions(opts); err != nil {
+				t.Fatalf("validateOptions() error: %v", err)
+			}
+			setDefaultOptions(&opts)
+			if got := similarityTokens("The TCP/IP stack.", "english", opts); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected tokens %v, got %v", tc.expected, got)
+			}
+		})
+	}
+	if _, err := Segment("The TCP/IP stack.", Options{MaxTokens: 100, TokenSeparators: "slash"}); err == nil {
+		t.Error("Expected an error for an unknown TokenSeparators")
+	}
+}
+
+// TestKeepEmoji checks that emoji become similarity tokens, so that emoji-only overlap
+// connects sentences and a change of emoji appears as a cohesion dip.
+func TestKeepEmoji(t *testing.T) {
+	text := "Match day ⚽🔥. Unbelievable goal ⚽⚽. Pizza night 🍕🍕. Cheesy slice 🍕."
+	scoresFor := func(keepEmoji bool) []float64 {
+		_, details, err := SegmentWithDetails(text, Options{MaxTokens: 100, Language: "english", KeepEmoji: keepEmoji})
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		scores := make([]float64, len(details.Gaps))
+		for i, gap := range details.Gaps {
+			scores[i] = gap.Score
+		}
+		return scores
+	}
+
+	if scores := scoresFor(false); !reflect.DeepEqual(scores, []float64{0, 0, 0}) {
+		t.Errorf("Expected no word overlap without emoji, got %v", scores)
+	}
+	scores := scoresFor(true)
+	if len(scores) != 3 || scores[0] <= 0 || scores[2] <= 0 || scores[1] != 0 {
+		t.Errorf("Expected emoji to connect the sentences of each topic only, got %v", scores)
+	}
+
+	opts := Options{MaxTokens: 100, KeepEmoji: true}
+	setDefaultOptions(&opts)
+	if got := similarityTokens("Love it 👍🏽", "english", opts); !reflect.DeepEqual(got, []string{"love", "👍🏽"}) {
+		t.Errorf("Expected a word and an emoji token, got %v", got)
+	}
+}
+
+// TestCJKTokenMode checks that counting CJK characters makes MaxTokens effective for a
+// Chinese paragraph, which the whitespace tokenizer sees as a single word.
+func TestCJKTokenMode(t *testing.T) {
+	paragraph := "今天天气很好，我们一起去公园散步。公园里有很多人在跑步和放风筝。" +
+		"中午我们在湖边的餐厅吃饭，菜的味道非常好。下午我们去博物馆参观了古代的瓷器和书画。" +
+		"晚上回家以后，大家都觉得很累，但是非常开心。"
+	chars := utf8.RuneCountInString(paragraph)
+	punctuation := strings.Count(paragraph, "，") + strings.Count(paragraph, "。")
+	opts := Options{MaxTokens: 20, SplitOversizedSentences: true}
+
+	chunks, err := Segment(paragraph, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 1 || chunks[0].NumTokens != 1 {
+		t.Fatalf("Expected one chunk of one word by default, got %+v", chunks)
+	}
+
+	opts.CJKTokenMode = CJKTokenModeChars
+	chunks, err = Segment(paragraph, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) < 4 {
+		t.Fatalf("Expected the paragraph to be split into several chunks, got %d", len(chunks))
+	}
+	var rebuilt strings.Builder
+	total := 0
+	for i, chunk := range chunks {
+		if chunk.NumTokens > opts.MaxTokens {
+			t.Errorf("Chunk %d has %d tokens, over MaxTokens", i, chunk.NumTokens)
+		}
+		rebuilt.WriteString(chunk.Text)
+		total += chunk.NumTokens
+	}
+	if rebuilt.String() != paragraph {
+		t.Errorf("Expected the chunks to concatenate to the paragraph, got %q", rebuilt.String())
+	}
+	if total != chars-punctuation {
+		t.Errorf("Expected one token per character, got %d tokens for %d characters", total, chars-punctuation)
+	}
+
+	// Other scripts keep their word counts.
+	if got := countTokens("GPT模型 is fast", TokenCountWords, true); got != 5 {
+		t.Errorf("Expected 5 tokens for mixed text, got %d", got)
+	}
+	if _, err := Segment(paragraph, Options{MaxTokens: 20, CJKTokenMode: "runes"}); err == nil {
+		t.Error("Expected an error for an unknown CJKTokenMode")
+	}
+}
+
+// TestCoalesceSimilarChunks checks that repeated boilerplate split into adjacent chunks is
+// coalesced when it fits MaxTokens and flagged as a duplicate otherwise.
+func TestCoalesceSimilarChunks(t *testing.T) {
+	boilerplate := "Returns are accepted within thirty days of delivery."
+	text := boilerplate + " " + boilerplate + " Solar panels convert sunlight into electricity."
+
+	// Every gap is a boundary, so each sentence starts as its own chunk.
+	opts := Options{MaxTokens: 100, BoundaryPercentile: 100, CoalesceSimilarChunks: 0.9}
+	chunks, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{boilerplate + " " + boilerplate, "Solar panels convert sunlight into electricity."})
+	if !reflect.DeepEqual(chunks[0].SentenceIndices, []int{0, 1}) || chunks[0].NumTokens != 16 {
+		t.Errorf("Expected the coalesced chunk to hold sentences [0 1] and 16 tokens, got %v and %d", chunks[0].SentenceIndices, chunks[0].NumTokens)
+	}
+	if details.Gaps[0].Split || !details.Gaps[1].Split {
+		t.Errorf("Expected only the second gap to remain split, got %+v", details.Gaps)
+	}
+
+	// The two copies do not fit in one chunk together.
+	opts.MaxTokens = 8
+	chunks, err = Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 3 || chunks[0].Duplicate || !chunks[1].Duplicate || chunks[2].Duplicate {
+		t.Errorf("Expected the second of 3 chunks flagged as a duplicate, got %+v", chunks)
+	}
+}
+
+// TestChunkSimilarity checks TF-IDF similarity for identical, disjoint and overlapping
+// chunks, and that stored embeddings take precedence.
+func TestChunkSimilarity(t *testing.T) {
+	opts := Options{MaxTokens: 100}
+	cats := Chunk{Text: "Cats purr on the warm sofa. Cats sleep all day."}
+	solar := Chunk{Text: "Solar panels convert sunlight into electricity."}
+	kittens := Chunk{Text: "Cats chase mice in the garden. Kittens sleep all night."}
+
+	if got := ChunkSimilarity(cats, cats, opts); math.Abs(got-1) > 1e-9 {
+		t.Errorf("Expected identical chunks to score 1, got %f", got)
+	}
+	if got := ChunkSimilarity(cats, solar, opts); got != 0 {
+		t.Errorf("Expected disjoint chunks to score 0, got %f", got)
+	}
+	overlap := ChunkSimilarity(cats, kittens, opts)
+	if overlap <= 0 || overlap >= 1 {
+		t.Errorf("Expected overlapping chunks to score between 0 and 1, got %f", overlap)
+	}
+	if got := ChunkSimilarity(kittens, cats, opts); math.Abs(got-overlap) > 1e-9 {
+		t.Errorf("Expected a symmetric score %f, got %f", overlap, got)
+	}
+
+	cats.Embedding, solar.Embedding = []float64{1, 0}, []float64{1, 1}
+	if got := ChunkSimilarity(cats, solar, opts); math.Abs(got-math.Sqrt(0.5)) > 1e-9 {
+		t.Errorf("Expected the embedding cosine %f, got %f", math.Sqrt(0.5), got)
+	}
+}
+
+// TestMaxChunkBytes checks that the byte limit splits Cyrillic text, two bytes per letter,
+// long before MaxTokens is reached, and cuts an oversized sentence at word boundaries.
+func TestMaxChunkBytes(t *testing.T) {
+	sentence := "Кошка спит на тёплом диване у окна."
+	text := strings.Repeat(sentence+" ", 4)
+	opts := Options{MaxTokens: 100, MaxChunkBytes: 2*len(sentence) + 1}
+
+	chunks, err := Segment(text, Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("Expected one chunk without a byte limit, got %d", len(chunks))
+	}
+
+	chunks, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("Expected two chunks of two sentences, got %+v", chunks)
+	}
+	for i, chunk := range chunks {
+		if len(chunk.Text) > opts.MaxChunkBytes {
+			t.Errorf("Chunk %d has %d bytes, over MaxChunkBytes", i, len(chunk.Text))
+		}
+		if chunk.NumTokens > opts.MaxTokens {
+			t.Errorf("Chunk %d has %d tokens, over MaxTokens", i, chunk.NumTokens)
+		}
+	}
+	if got := details.Gaps[1].SplitReason; got != SplitReasonByteLimit {
+		t.Errorf("Expected the split reason %q, got %q", SplitReasonByteLimit, got)
+	}
+
+	// A single sentence over the byte limit is cut like one over MaxTokens.
+	opts = Options{MaxTokens: 100, MaxChunkBytes: 30, SplitOversizedSentences: true}
+	chunks, err = Segment(sentence, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	var pieces []string
+	for i, chunk := range chunks {
+		if len(chunk.Text) > opts.MaxChunkBytes {
+			t.Errorf("Piece %d has %d bytes, over MaxChunkBytes", i, len(chunk.Text))
+		}
+		pieces = append(pieces, chunk.Text)
+	}
+	if len(pieces) < 2 || strings.Join(pieces, " ") != sentence {
+		t.Errorf("Expected the sentence to be cut at word boundaries, got %q", pieces)
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, MaxChunkBytes: -1}); err == nil {
+		t.Error("Expected an error for a negative MaxChunkBytes")
+	}
+}
+
+// TestSegmentTokenized verifies that caller-provided tokens drive both the similarity
+// vectors and the token counts.
+func TestSegmentTokenized(t *testing.T) {
+	sentences := []string{"猫が好き。", "猫はかわいい。", "株価が下落した。", "株価は回復した。"}
+	tokens := [][]string{
+		{"猫", "が", "好き"},
+		{"猫", "は", "かわいい"},
+		{"株価", "が", "下落", "した"},
+		{"株価", "は", "回復", "した"},
+	}
+	chunks, err := SegmentTokenized(sentences, tokens, Options{MaxTokens: 20, MinSplitSimilarity: 0.01})
+	if err != nil {
+		t.Fatalf("SegmentTokenized() error: %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{"猫が好き。猫はかわいい。", "株価が下落した。株価は回復した。"})
+	if chunks[0].NumTokens != 6 || chunks[1].NumTokens != 8 {
+		t.Fatalf("Expected token counts 6 and 8 from the given tokens, got %d and %d", chunks[0].NumTokens, chunks[1].NumTokens)
+	}
+
+	// Token counts from the given tokens also drive MaxTokens.
+	chunks, err = SegmentTokenized(sentences, tokens, Options{MaxTokens: 7, MinSplitSimilarity: 0.01})
+	if err != nil {
+		t.Fatalf("SegmentTokenized() error: %v", err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Expected the token limit to split the first topic, got %d chunks", len(chunks))
+	}
+
+	if _, err := SegmentTokenized(sentences, tokens[:2], Options{MaxTokens: 20}); err == nil {
+		t.Fatalf("Expected an error for mismatched sentences and tokens")
+	}
+}
+
+// TestDeduplicateCorpus verifies that corpus deduplication keeps every original sentence,
+// duplicates included, in the output.
+func TestDeduplicateCorpus(t *testing.T) {
+	doc := "Subscribe to our newsletter. Cats purr softly. Subscribe to our newsletter. " +
+		"Stocks fell today. Subscribe to our newsletter."
+	chunks, err := Segment(doc, Options{MaxTokens: 100, DeduplicateCorpus: true})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	var sentences []string
+	for _, ch := range chunks {
+		sentences = append(sentences, ch.Sentences...)
+	}
+	if !reflect.DeepEqual(sentences, text.SplitSentences(doc)) {
+		t.Fatalf("Expected all original sentences, got %q", sentences)
+	}
+}
+
+// TestDropThreshold verifies that staircase-shaped scores, which contain no local minimum,
+// get a boundary at each step down, while V-shaped dips are left to depth detection.
+func TestDropThreshold(t *testing.T) {
+	testCases := []struct {
+		name     string
+		scores   []float64
+		opts     Options
+		expected []int
+	}{
+		{"Staircase missed without drops", []float64{0.8, 0.8, 0.8, 0.3, 0.3, 0.3, 0.3}, Options{DepthThreshold: 0.1}, nil},
+		{"Staircase", []float64{0.8, 0.8, 0.8, 0.3, 0.3, 0.3, 0.3}, Options{DepthThreshold: 0.1, DropThreshold: 0.3}, []int{3}},
+		{"Two steps", []float64{0.9, 0.9, 0.9, 0.5, 0.5, 0.5, 0.1, 0.1, 0.1}, Options{DepthThreshold: 0.1, DropThreshold: 0.3}, []int{3, 6}},
+		{"Drop below threshold", []float64{0.8, 0.8, 0.8, 0.6, 0.6, 0.6}, Options{DepthThreshold: 0.1, DropThreshold: 0.3}, nil},
+		{"Recovering dip is a local minimum", []float64{0.8, 0.8, 0.8, 0.3, 0.8, 0.8}, Options{DepthThreshold: 0.1, DropThreshold: 0.3}, []int{3}},
+		{"Drop at the last gap", []float64{0.8, 0.8, 0.8, 0.3}, Options{DepthThreshold: 0.1, DropThreshold: 0.3}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setDefaultOptions(&tc.opts)
+			gaps := make([]GapDecision, len(tc.scores))
+			boundaries := findBoundaries(tc.scores, tc.opts, gaps)
+			var got []int
+			for i := range tc.scores {
+				if boundaries[i] {
+					got = append(got, i)
+					if gaps[i].IsLocalMinimum == gaps[i].SustainedDrop {
+						t.Errorf("Gap %d: expected exactly one of local minimum or sustained drop, got %+v", i, gaps[i])
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected boundaries %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestDepthFunc checks that a custom depth measure changes which dips become boundaries and
+// is reported in the gap decisions.
+func TestDepthFunc(t *testing.T) {
+	// The dip at gap 2 is deep on its left side only.
+	scores := []float64{0.9, 0.9, 0.5, 0.55, 0.6, 0.6}
+	maxSide := func(left, center, right float64) float64 { return math.Max(left, right) - center }
+	testCases := []struct {
+		name     string
+		depth    func(left, center, right float64) float64
+		expected []int
+		depthAt2 float64
+	}{
+		{"Average", nil, nil, 0.225},
+		{"Max side", maxSide, []int{2}, 0.4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{DepthThreshold: 0.3, DepthFunc: tc.depth}
+			setDefaultOptions(&opts)
+			gaps := make([]GapDecision, len(scores))
+			if got := sortedBoundaries(findBoundaries(scores, opts, gaps)); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected boundaries %v, got %v", tc.expected, got)
+			}
+			if math.Abs(gaps[2].Depth-tc.depthAt2) > 1e-9 {
+				t.Errorf("Expected depth %v at gap 2, got %v", tc.depthAt2, gaps[2].Depth)
+			}
+		})
+	}
+}
+
+// TestBoundaryFilters checks that filters run in order, each refining the boundaries left
+// by the previous one, and that a custom filter decides the chunk boundaries.
+func TestBoundaryFilters(t *testing.T) {
+	dips := []float64{0.9, 0.2, 0.8, 0.1, 0.7, 0.3, 0.9, 0.9, 0.9}
+	staircase := []float64{0.8, 0.8, 0.8, 0.3, 0.3, 0.3, 0.3}
+	testCases := []struct {
+		name       string
+		scores     []float64
+		boundaries []int
+		filters    []BoundaryFilter
+		expected   []int
+	}{
+		{"Min then max", dips, []int{1, 3, 5}, []BoundaryFilter{MinSegmentFilter(3), MaxSegmentFilter(4)}, []int{3, 5}},
+		{"Max then min", dips, []int{1, 3, 5}, []BoundaryFilter{MaxSegmentFilter(4), MinSegmentFilter(3)}, []int{3}},
+		{"Percentile", dips, []int{1, 3, 5}, []BoundaryFilter{PercentileFilter(20)}, []int{1, 3}},
+		{"Drop then min", staircase, []int{0}, []BoundaryFilter{DropFilter(0.3, 0), MinSegmentFilter(2)}, []int{3}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			boundaries := make(map[int]bool)
+			for _, i := range tc.boundaries {
+				boundaries[i] = true
+			}
+			got := sortedBoundaries(applyBoundaryFilters(tc.scores, boundaries, Options{BoundaryFilters: tc.filters}))
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected boundaries %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	doc := "Cats purr softly. Cats sleep all day. Cats chase mice. Cats love milk."
+	splitAfterFirst := func(scores []float64, _ map[int]bool, _ Options) map[int]bool {
+		return map[int]bool{0: true}
+	}
+	chunks, details, err := SegmentWithDetails(doc, Options{MaxTokens: 100, BoundaryFilters: []BoundaryFilter{splitAfterFirst}})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if len(chunks) != 2 || len(chunks[0].Sentences) != 1 {
+		t.Errorf("Expected the custom filter to split after the first sentence, got %+v", chunks)
+	}
+	for _, gap := range details.Gaps {
+		if gap.SemanticBoundary != (gap.Index == 0) {
+			t.Errorf("Gap %d: SemanticBoundary does not match the filtered boundaries", gap.Index)
+		}
+	}
+}
+
+// TestSimilarityMatrix verifies the matrix shape, symmetry and unit diagonal, and that
+// adjacent entries match the cohesion scores used for segmentation.
+func TestSimilarityMatrix(t *testing.T) {
+	doc := "Cats purr softly. Cats sleep all day. Stocks fell today. And so. Stocks rose later."
+	sentences, matrix, err := SimilarityMatrix(doc, Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("SimilarityMatrix() error: %v", err)
+	}
+	if len(sentences) != 5 || len(matrix) != len(sentences) {
+		t.Fatalf("Expected a 5x5 matrix for 5 sentences, got %d sentences and %d rows", len(sentences), len(matrix))
+	}
+	for i := range matrix {
+		if len(matrix[i]) != len(sentences) {
+			t.Fatalf("Row %d has %d columns", i, len(matrix[i]))
+		}
+		if matrix[i][i] != 1 {
+			t.Errorf("Expected unit diagonal, got matrix[%d][%d] = %v", i, i, matrix[i][i])
+		}
+		for j := range matrix[i] {
+			if matrix[i][j] != matrix[j][i] {
+				t.Errorf("Expected symmetry, got matrix[%d][%d] = %v and matrix[%d][%d] = %v", i, j, matrix[i][j], j, i, matrix[j][i])
+			}
+		}
+	}
+	if matrix[0][1] <= 0 || matrix[0][2] != 0 || matrix[2][4] <= 0 {
+		t.Errorf("Expected related sentences to be similar and unrelated ones not, got %v", matrix)
+	}
+
+	_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	for _, gap := range details.Gaps {
+		if math.Abs(gap.Score-matrix[gap.Index][gap.Index+1]) > 1e-12 {
+			t.Errorf("Gap %d: score %v differs from matrix entry %v", gap.Index, gap.Score, matrix[gap.Index][gap.Index+1])
+		}
+	}
+}
+
+// TestEmbeddingMemoryBudget verifies that streaming cohesion under a memory budget matches
+// the batch result on a large synthetic batch, and that an ample budget keeps the batch path.
+func TestEmbeddingMemoryBudget(t *testing.T) {
+	newFakeOllama(t, keywordEmbedding("alpha", "beta", "gamma", "delta", "epsilon"))
+	topics := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+	sentences := make([]string, 2000)
+	for i := range sentences {
+		sentences[i] = "Sentence " + topics[(i/7)%len(topics)] + " number " + topics[(i/3)%len(topics)] + "."
+	}
+	base := Options{MaxTokens: 100}
+	setDefaultOptions(&base)
+	provider := embeddingProvider(base)
+	batchScores, _, _, err := segmentWithOllama(context.Background(), sentences, provider, base)
+	if err != nil {
+		t.Fatalf("batch segmentWithOllama() error: %v", err)
+	}
+
+	for _, budget := range []int{1, 1 << 30} {
+		opts := base
+		opts.EmbeddingMemoryBudget = budget
+		scores, _, _, err := segmentWithOllama(context.Background(), sentences, provider, opts)
+		if err != nil {
+			t.Fatalf("budget %d: segmentWithOllama() error: %v", budget, err)
+		}
+		if !reflect.DeepEqual(scores, batchScores) {
+			t.Fatalf("budget %d: scores differ from the batch result", budget)
+		}
+	}
+
+	// Streaming block comparison must match the batch block scores, including the
+	// truncated windows at both ends of the text.
+	for _, blockSize := range []int{2, 5} {
+		opts := base
+		opts.BlockComparisonSize = blockSize
+		expected, _, _, err := segmentWithOllama(context.Background(), sentences, provider, opts)
+		if err != nil {
+			t.Fatalf("block %d: segmentWithOllama() error: %v", blockSize, err)
+		}
+		opts.EmbeddingMemoryBudget = 1
+		scores, _, _, err := segmentWithOllama(context.Background(), sentences, provider, opts)
+		if err != nil {
+			t.Fatalf("block %d: streaming segmentWithOllama() error: %v", blockSize, err)
+		}
+		if len(scores) != len(sentences)-1 || !reflect.DeepEqual(scores, expected) {
+			t.Fatalf("block %d: streaming scores differ from the batch result", blockSize)
+		}
+	}
+
+	// Streaming lookback must match the batch lookback scores.
+	opts := base
+	opts.MaxLookback = 3
+	expected, _, _, err := segmentWithOllama(context.Background(), sentences, provider, opts)
+	if err != nil {
+		t.Fatalf("lookback: segmentWithOllama() error: %v", err)
+	}
+	opts.EmbeddingMemoryBudget = 1
+	scores, _, _, err := segmentWithOllama(context.Background(), sentences, provider, opts)
+	if err != nil {
+		t.Fatalf("lookback: streaming segmentWithOllama() error: %v", err)
+	}
+	if !reflect.DeepEqual(scores, expected) {
+		t.Fatalf("lookback: streaming scores differ from the batch result")
+	}
+}
+
+// TestStreamOllamaEmbeddingsOrder verifies that streamed embeddings are emitted strictly in order.
+func TestStreamOllamaEmbeddingsOrder(t *testing.T) {
+	newFakeOllama(t, func(prompt string) []float64 { return []float64{float64(len(prompt))} })
+	sentences := make([]string, 300)
+	for i := range sentences {
+		sentences[i] = strings.Repeat("x", i+1)
+	}
+
+	next := 5
+	err := streamOllamaEmbeddings(context.Background(), sentences, 5, embeddingProvider(Options{}),
+		func(i int, embedding []float64) error {
+			if i != next || embedding[0] != float64(i+1) {
+				t.Fatalf("Expected sentence %d, got %d (%v)", next, i, embedding)
+			}
+			next++
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("streamOllamaEmbeddings() error: %v", err)
+	}
+	if next != len(sentences) {
+		t.Fatalf("Expected %d emitted embeddings, got %d", len(sentences)-5, next-5)
+	}
+}
+
+// TestOnCohesionScore verifies that scores reported incrementally match the batch result for
+// adjacent, block and lookback scoring, and that the first score is reported before the
+// last embedding has returned.
+func TestOnCohesionScore(t *testing.T) {
+	topics := []string{"alpha", "beta", "gamma"}
+	sentences := make([]string, 40)
+	for i := range sentences {
+		sentences[i] = fmt.Sprintf("Sentence %d is about %s.", i, topics[(i/4)%len(topics)])
+	}
+	last := sentences[len(sentences)-1]
+	firstScore := make(chan struct{})
+	embed := keywordEmbedding(topics...)
+	provider := EmbeddingProviderFunc(func(ctx context.Context, text string) ([]float64, error) {
+		if text == last {
+			select {
+			case <-firstScore:
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("no score was reported before the last embedding")
+			}
+		}
+		return embed(text), nil
+	})
+	doc := strings.Join(sentences, " ")
+
+	for _, opts := range []Options{
+		{MaxTokens: 1000},
+		{MaxTokens: 1000, BlockComparisonSize: 3},
+		{MaxTokens: 1000, MaxLookback: 2},
+	} {
+		opts.EmbeddingProvider = EmbeddingProviderFunc(func(ctx context.Context, text string) ([]float64, error) {
+			return embed(text), nil
+		})
+		_, batch, err := SegmentWithDetails(doc, opts)
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+
+		firstScore = make(chan struct{})
+		var scores []float64
+		opts.EmbeddingProvider = provider
+		opts.OnCohesionScore = func(gap int, score float64) {
+			if gap != len(scores) {
+				t.Fatalf("Expected gap %d, got %d", len(scores), gap)
+			}
+			if gap == 0 {
+				close(firstScore)
+			}
+			scores = append(scores, score)
+		}
+		if _, err := Segment(doc, opts); err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		if len(scores) != len(batch.Gaps) {
+			t.Fatalf("Expected %d scores, got %d", len(batch.Gaps), len(scores))
+		}
+		for i, gap := range batch.Gaps {
+			if scores[i] != gap.Score {
+				t.Errorf("Gap %d: incremental score %v differs from the batch score %v", i, scores[i], gap.Score)
+			}
+		}
+	}
+
+	// On the TF-IDF path, the scores are reported once computed.
+	var reported int
+	_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 1000, OnCohesionScore: func(gap int, score float64) { reported++ }})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if reported != len(details.Gaps) {
+		t.Errorf("Expected %d reported TF-IDF scores, got %d", len(details.Gaps), reported)
+	}
+}
+
+// newFakeOllama starts a stub Ollama server whose /api/embeddings endpoint answers with
+// embed(prompt), and points the CHUNKER_OLLAMA_* environment variables at it.
+func newFakeOllama(t *testing.T, embed func(prompt string) []float64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ollamaResponse{Embedding: embed(req.Prompt)})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("CHUNKER_OLLAMA_URL", srv.URL)
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "fake-model")
+	return srv
+}
+
+// keywordEmbedding is a deterministic fake embedding: one dimension per keyword,
+// set to 1 when the keyword occurs in the prompt.
+func keywordEmbedding(keywords ...string) func(string) []float64 {
+	return func(prompt string) []float64 {
+		lower := strings.ToLower(prompt)
+		vec := make([]float64, len(keywords)+1)
+		vec[len(keywords)] = 0.1 // keeps the norm non-zero for unrelated sentences
+		for i, kw := range keywords {
+			if strings.Contains(lower, kw) {
+				vec[i] = 1
+			}
+		}
+		return vec
+	}
+}
+
+// TestCacheWithoutEmbeddingBackend checks that enabling the cache without an Ollama backend
+// is reported instead of silently falling back to TF-IDF without caching.
+func TestCacheWithoutEmbeddingBackend(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	cache := NewInMemoryCache()
+	defer cache.Close()
+
+	text := "The ocean is deep. The market fell."
+	for _, mode := range []string{CacheModeForce, CacheModeAdaptive} {
+		opts := Options{MaxTokens: 100, EmbeddingCacheMode: mode, EmbeddingCache: cache}
+		if _, err := Segment(text, opts); !errors.Is(err, ErrCacheWithoutEmbeddingBackend) {
+			t.Errorf("mode %s: expected ErrCacheWithoutEmbeddingBackend, got %v", mode, err)
+		}
+		if _, _, err := SimilarityMatrix(text, opts); !errors.Is(err, ErrCacheWithoutEmbeddingBackend) {
+			t.Errorf("mode %s: expected ErrCacheWithoutEmbeddingBackend from SimilarityMatrix, got %v", mode, err)
+		}
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, EmbeddingCache: cache}); err != nil {
+		t.Errorf("Expected no error with the cache disabled, got %v", err)
+	}
+}
+
+// TestIDFOptions checks that the IDF options reach the corpus and are validated.
+func TestIDFOptions(t *testing.T) {
+	opts := Options{IDFFormula: IDFSklearn}
+	setDefaultOptions(&opts)
+	vectors, _, _ := tfidfVectors([][]string{{"sun", "hot"}, {"sun"}}, opts)
+	if expected := 0.5 * (math.Log(3.0/2) + 1); math.Abs(vectors[0]["hot"]-expected) > 1e-12 {
+		t.Errorf("Expected sklearn weight %f for 'hot', got %f", expected, vectors[0]["hot"])
+	}
+
+	zero := 0.0
+	for _, bad := range []Options{
+		{MaxTokens: 10, IDFFormula: "bm25"},
+		{MaxTokens: 10, IDFSmoothing: &zero},
+	} {
+		if _, err := Segment("Mars is red. Venus is hot.", bad); err == nil {
+			t.Errorf("Expected an error for %+v", bad)
+		}
+	}
+}
+
+// TestPingEmbeddingBackend checks the readiness probe against healthy and unhealthy stubs.
+func TestPingEmbeddingBackend(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	if err := PingEmbeddingBackend(ctx, Options{}); err != nil {
+		t.Errorf("Expected nil without a configured backend, got %v", err)
+	}
+
+	newFakeOllama(t, keywordEmbedding("ocean"))
+	if err := PingEmbeddingBackend(ctx, Options{}); err != nil {
+		t.Errorf("Expected a healthy backend, got %v", err)
+	}
+
+	missingModel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(ollamaResponse{Error: `model "fake-model" not found, try pulling it first`})
+	}))
+	defer missingModel.Close()
+	t.Setenv("CHUNKER_OLLAMA_URL", missingModel.URL)
+	if err := PingEmbeddingBackend(ctx, Options{}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected a missing-model error, got %v", err)
+	}
+
+	missingModel.Close()
+	if err := PingEmbeddingBackend(ctx, Options{}); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("Expected an unreachable error, got %v", err)
+	}
+}
+
+// TestChunkPooling compares the pooled vectors of a chunk with one long and one short
+// sentence: length weighting pulls the chunk vector towards the long sentence.
+func TestChunkPooling(t *testing.T) {
+	newFakeOllama(t, keywordEmbedding("deep", "calm"))
+	text := "The deep ocean hides countless strange creatures far below the waves. It is calm."
+	// Embeddings: long (11 tokens) = [1 0 0.1], short (3 tokens) = [0 1 0.1].
+	testCases := []struct {
+		pooling  string
+		expected []float64
+	}{
+		{"", nil},
+		{ChunkPoolingMean, []float64{0.5, 0.5, 0.1}},
+		{ChunkPoolingLengthWeighted, []float64{11.0 / 14, 3.0 / 14, 0.1}},
+		{ChunkPoolingMax, []float64{1, 1, 0.1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run("pooling="+tc.pooling, func(t *testing.T) {
+			chunks, err := Segment(text, Options{MaxTokens: 100, ChunkPooling: tc.pooling})
+			if err != nil {
+				t.Fatalf("Segment() returned an error: %v", err)
+			}
+			if len(chunks) != 1 {
+				t.Fatalf("Expected 1 chunk, got %d", len(chunks))
+			}
+			got := chunks[0].Embedding
+			if len(got) != len(tc.expected) {
+				t.Fatalf("Expected embedding %v, got %v", tc.expected, got)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tc.expected[i]) > 1e-9 {
+					t.Fatalf("Expected embedding %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+
+	chunks, err := Segment("It is calm.", Options{MaxTokens: 100, ChunkPooling: ChunkPoolingMean})
+	if err != nil || len(chunks) != 1 || !reflect.DeepEqual(chunks[0].Embedding, []float64{0, 1, 0.1}) {
+		t.Errorf("Expected a single sentence to be embedded for pooling, got %+v (err %v)", chunks, err)
+	}
+	if _, err := Segment(text, Options{MaxTokens: 100, ChunkPooling: "median"}); err == nil {
+		t.Error("Expected an error for an unknown ChunkPooling")
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	if _, err := Segment(text, Options{MaxTokens: 100, ChunkPooling: ChunkPoolingMean}); !errors.Is(err, ErrChunkPoolingWithoutEmbeddingBackend) {
+		t.Errorf("Expected ErrChunkPoolingWithoutEmbeddingBackend on the TF-IDF path, got %v", err)
+	}
+}
+
+// TestMinSentenceTokensForEmbedding intersperses interjections in a two-topic passage:
+// embedded on their own they break the topics apart, merged into their neighbors they cost
+// no embedding calls and only the topic change remains a boundary.
+func TestMinSentenceTokensForEmbedding(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	text := "The ocean is deep. Ok. Waves cross the ocean. Right! Fish swim in the ocean. " +
+		"The market fell. Sure. Traders left the market. Prices in the market dropped."
+	embed := keywordEmbedding("ocean", "market")
+	var calls atomic.Int64
+	opts := Options{
+		MaxTokens:          100,
+		MinSplitSimilarity: 0.5,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			calls.Add(1)
+			return embed(text), nil
+		}),
+	}
+
+	chunks, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) <= 2 || calls.Load() != 9 {
+		t.Fatalf("Expected interjections to add boundaries with 9 embedding calls, got %d chunks and %d calls", len(chunks), calls.Load())
+	}
+
+	calls.Store(0)
+	opts.MinSentenceTokensForEmbedding = 2
+	chunks, err = Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{
+		"The ocean is deep. Ok. Waves cross the ocean. Right! Fish swim in the ocean.",
+		"The market fell. Sure. Traders left the market. Prices in the market dropped.",
+	})
+	if calls.Load() != 6 {
+		t.Errorf("Expected 6 embedding calls, got %d", calls.Load())
+	}
+	if len(chunks[0].Sentences) != 5 {
+		t.Errorf("Expected merged sentences to stay separate in chunks, got %q", chunks[0].Sentences)
+	}
+
+	opts.EmbeddingProvider = nil
+	if _, err := Segment(text, opts); !errors.Is(err, ErrMinSentenceTokensWithoutEmbeddingBackend) {
+		t.Errorf("Expected ErrMinSentenceTokensWithoutEmbeddingBackend on the TF-IDF path, got %v", err)
+	}
+}
+
+// TestChunkOutput checks that each ChunkOutput mode leaves the unused field empty while the
+// filled fields and hashes match the default output.
+func TestChunkOutput(t *testing.T) {
+	text := "Cats purr softly. Cats sleep all day. Solar panels convert sunlight. Panels need sun."
+	base := Options{MaxTokens: 7, ChunkHash: ChunkHashRaw}
+	both, err := Segment(text, base)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(both) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(both))
+	}
+	for _, output := range []string{ChunkOutputText, ChunkOutputSentences} {
+		t.Run(output, func(t *testing.T) {
+			opts := base
+			opts.ChunkOutput = output
+			chunks, err := Segment(text, opts)
+			if err != nil {
+				t.Fatalf("Segment() error: %v", err)
+			}
+			if len(chunks) != len(both) {
+				t.Fatalf("Expected %d chunks, got %d", len(both), len(chunks))
+			}
+			for i, chunk := range chunks {
+				if output == ChunkOutputText && (chunk.Sentences != nil || chunk.Text != both[i].Text) {
+					t.Errorf("Chunk %d: expected only the text %q, got %+v", i, both[i].Text, chunk)
+				}
+				if output == ChunkOutputSentences && (chunk.Text != "" || !reflect.DeepEqual(chunk.Sentences, both[i].Sentences)) {
+					t.Errorf("Chunk %d: expected only the sentences %q, got %+v", i, both[i].Sentences, chunk)
+				}
+				if chunk.Hash != both[i].Hash || !reflect.DeepEqual(chunk.SentenceIndices, both[i].SentenceIndices) {
+					t.Errorf("Chunk %d: expected hash and indices as in the default output, got %+v", i, chunk)
+				}
+			}
+		})
+	}
+	if _, err := Segment(text, Options{MaxTokens: 7, ChunkOutput: "json"}); err == nil {
+		t.Error("Expected an error for an unknown ChunkOutput")
+	}
+}
+
+// BenchmarkChunkOutput compares the allocations of chunk building with both fields and with
+// only Chunk.Text or Chunk.Sentences.
+func BenchmarkChunkOutput(b *testing.B) {
+	sentences := make([]string, 10000)
+	tokenCounts := make([]int, len(sentences))
+	for i := range sentences {
+		sentences[i] = "Cats purr softly on the warm sofa."
+		tokenCounts[i] = 7
+	}
+	for _, output := range []string{ChunkOutputBoth, ChunkOutputText, ChunkOutputSentences} {
+		b.Run(output, func(b *testing.B) {
+			opts := Options{MaxTokens: 70, ChunkOutput: output}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buildChunks(sentences, tokenCounts, nil, opts, nil)
+			}
+		})
+	}
+}
+
+// TestChunkHash checks that hashes are stable across runs, that normalized hashes ignore
+// case, punctuation and whitespace while raw hashes do not, and that different content
+// yields different hashes.
+func TestChunkHash(t *testing.T) {
+	hashOf := func(text, mode string) string {
+		chunks, err := Segment(text, Options{MaxTokens: 100, ChunkHash: mode})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		if len(chunks) != 1 {
+			t.Fatalf("Expected a single chunk, got %d", len(chunks))
+		}
+		return chunks[0].Hash
+	}
+
+	original := "The ocean is deep. Waves cross the ocean."
+	cosmetic := "the OCEAN is deep!   Waves cross the ocean"
+	different := "The ocean is wide. Waves cross the ocean."
+
+	if h := hashOf(original, ""); h != "" {
+		t.Errorf("Expected no hash by default, got %q", h)
+	}
+	for _, mode := range []string{ChunkHashNormalized, ChunkHashRaw} {
+		h := hashOf(original, mode)
+		if len(h) != 64 || h != hashOf(original, mode) {
+			t.Errorf("%s: expected a stable SHA-256 hex hash, got %q", mode, h)
+		}
+		if h == hashOf(different, mode) {
+			t.Errorf("%s: expected different content to hash differently", mode)
+		}
+	}
+	if hashOf(original, ChunkHashNormalized) != hashOf(cosmetic, ChunkHashNormalized) {
+		t.Error("Expected cosmetic differences to share a normalized hash")
+	}
+	if hashOf(original, ChunkHashRaw) == hashOf(cosmetic, ChunkHashRaw) {
+		t.Error("Expected cosmetic differences to change the raw hash")
+	}
+	if _, err := Segment(original, Options{MaxTokens: 100, ChunkHash: "md5"}); err == nil {
+		t.Error("Expected an error for an unknown ChunkHash")
+	}
+}
+
+// TestAnalyzeDocument checks the document statistics of a two-topic text against Segment,
+// and the language distribution in per-sentence mode.
+func TestAnalyzeDocument(t *testing.T) {
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose. The ocean is calm. The ocean is wide."
+	newFakeOllama(t, keywordEmbedding("ocean", "market"))
+	opts := Options{MaxTokens: 100, DepthThreshold: 0.1}
+	stats, err := AnalyzeDocument(text, opts)
+	if err != nil {
+		t.Fatalf("AnalyzeDocument() error: %v", err)
+	}
+	chunks, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if stats.NumSentences != 6 || stats.NumTopics != len(chunks) || stats.NumTopics != 3 {
+		t.Fatalf("Expected 6 sentences and 3 topics, got %+v", stats)
+	}
+	// Gap scores are 1, low, 1, low, 1.
+	low := 0.01 / 1.01 // cosine of (1, 0, 0.1) and (0, 1, 0.1)
+	mean := (3 + 2*low) / 5
+	variance := (3*(1-mean)*(1-mean) + 2*(low-mean)*(low-mean)) / 5
+	if math.Abs(stats.AverageCohesion-mean) > 1e-9 || math.Abs(stats.CohesionVariance-variance) > 1e-9 {
+		t.Fatalf("Expected mean %v and variance %v, got %v and %v", mean, variance, stats.AverageCohesion, stats.CohesionVariance)
+	}
+	if stats.LanguageDistribution != nil {
+		t.Fatalf("Expected no language distribution outside per-sentence mode, got %v", stats.LanguageDistribution)
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	mixed := "Der Hund schläft im Garten und die Katze auch. The dog sleeps in the garden and the cat does too. Die Katze sitzt auf dem Dach."
+	stats, err = AnalyzeDocument(mixed, Options{MaxTokens: 100, LanguageDetectionMode: LangDetectModePerSentence})
+	if err != nil {
+		t.Fatalf("AnalyzeDocument() error: %v", err)
+	}
+	if expected := map[string]int{"german": 2, "english": 1}; !reflect.DeepEqual(stats.LanguageDistribution, expected) {
+		t.Fatalf("Expected language distribution %v, got %v", expected, stats.LanguageDistribution)
+	}
+
+	if stats, err := AnalyzeDocument("", Options{MaxTokens: 100}); err != nil || stats.NumTopics != 0 {
+		t.Fatalf("Expected no topics for an empty text, got %+v (%v)", stats, err)
+	}
+}
+
+// TestTfidfMaxVocab checks that the vocabulary of a large n-gram document is capped and that
+// the capped vectors still find the topic changes.
+func TestTfidfMaxVocab(t *testing.T) {
+	var sentences []string
+	for i := 0; i < 20; i++ {
+		sentences = append(sentences, fmt.Sprintf("Astronomers observed planet number %d orbiting a distant star.", i))
+	}
+	for i := 0; i < 20; i++ {
+		sentences = append(sentences, fmt.Sprintf("Bakers kneaded dough batch %d before the morning bread sale.", i))
+	}
+	text := strings.Join(sentences, " ")
+	opts := Options{MaxTokens: 1000, TfidfMinNgramSize: 3, TfidfMaxNgramSize: 5, MinSplitSimilarity: 0.2}
+
+	full, err := InspectTFIDF(text, opts, false)
+	if err != nil {
+		t.Fatalf("InspectTFIDF() error: %v", err)
+	}
+	opts.TfidfMaxVocab = 200
+	capped, err := InspectTFIDF(text, opts, false)
+	if err != nil {
+		t.Fatalf("InspectTFIDF() error: %v", err)
+	}
+	if len(full.Vocabulary) <= 200 || len(capped.Vocabulary) != 200 {
+		t.Fatalf("Expected the vocabulary of %d n-grams capped at 200, got %d", len(full.Vocabulary), len(capped.Vocabulary))
+	}
+
+	chunks, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 2 || len(chunks[0].Sentences) != 20 {
+		t.Errorf("Expected the two topics as chunks, got %d chunks", len(chunks))
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, TfidfMaxVocab: -1}); err == nil {
+		t.Error("Expected an error for a negative TfidfMaxVocab")
+	}
+}
+
+// TestInspectTFIDF checks the exposed vocabulary against the default IDF formula,
+// log(1 + N/(1+df)), and that the sentence vectors are the ones used for scoring.
+func TestInspectTFIDF(t *testing.T) {
+	text := "Cats purr loudly. Cats nap. Dogs bark loudly."
+	opts := Options{MaxTokens: 100}
+	model, err := InspectTFIDF(text, opts, false)
+	if err != nil {
+		t.Fatalf("InspectTFIDF() error: %v", err)
+	}
+	shared, single := math.Log(1+3.0/3), math.Log(1+3.0/2)
+	expected := map[string]float64{"cats": shared, "loudly": shared, "purr": single, "nap": single, "dogs": single, "bark": single}
+	if len(model.Vocabulary) != len(expected) {
+		t.Fatalf("Expected vocabulary %v, got %v", expected, model.Vocabulary)
+	}
+	for term, idf := range expected {
+		if math.Abs(model.Vocabulary[term]-idf) > 1e-9 {
+			t.Errorf("Term %q: expected IDF %f, got %f", term, idf, model.Vocabulary[term])
+		}
+	}
+	if len(model.Sentences) != 3 || model.Vectors != nil {
+		t.Fatalf("Expected 3 sentences and no vectors, got %+v", model)
+	}
+
+	model, err = InspectTFIDF(text, opts, true)
+	if err != nil {
+		t.Fatalf("InspectTFIDF() error: %v", err)
+	}
+	_, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	scores := calculateCohesion(model.Vectors, 0, 0, CosineSimilarity)
+	for i, gap := range details.Gaps {
+		if math.Abs(gap.Score-scores[i]) > 1e-9 {
+			t.Errorf("Gap %d: expected score %f from the inspected vectors, got %f", i, scores[i], gap.Score)
+		}
+	}
+}
+
+// TestOutputFormat checks that Markdown input keeps or loses its syntax in the chunk text
+// depending on OutputFormat, while the boundaries are computed on the stripped text in
+// both cases.
+func TestOutputFormat(t *testing.T) {
+	doc := "## Cats\nCats **purr** softly at night. Cats nap in the [sun](https://example.com/sun-cats). " +
+		"## Stocks\n- Stocks fell sharply on *Monday*. Stocks rallied again with `volume` rising."
+	segment := func(format string) []Chunk {
+		chunks, err := Segment(doc, Options{MaxTokens: 100, MinSplitSimilarity: 0.1, OutputFormat: format})
+		if err != nil {
+			t.Fatalf("Segment(%q) error: %v", format, err)
+		}
+		return chunks
+	}
+
+	original := segment(OutputFormatOriginal)
+	plain := segment(OutputFormatPlain)
+	if len(original) != 2 || len(plain) != 2 {
+		t.Fatalf("Expected two chunks in both formats, got %d and %d", len(original), len(plain))
+	}
+	for i := range original {
+		if !reflect.DeepEqual(original[i].SentenceIndices, plain[i].SentenceIndices) {
+			t.Errorf("Chunk %d: expected the same boundaries in both formats", i)
+		}
+		if !strings.Contains(original[i].Text, "## ") {
+			t.Errorf("Chunk %d: expected the original text to keep its Markdown, got %q", i, original[i].Text)
+		}
+		if strings.ContainsAny(plain[i].Text, "#*`[]()") {
+			t.Errorf("Chunk %d: expected plain text without Markdown, got %q", i, plain[i].Text)
+		}
+	}
+	if !strings.Contains(plain[0].Text, "Cats purr softly") || strings.Contains(plain[0].Text, "example.com") {
+		t.Errorf("Expected link targets dropped and link text kept, got %q", plain[0].Text)
+	}
+
+	if _, err := Segment(doc, Options{MaxTokens: 100, OutputFormat: "html"}); err == nil {
+		t.Error("Expected an error for an unknown OutputFormat")
+	}
+}
+
+// TestContentType checks that each content type routes the input through its cleaner, and
+// that registered preprocessors are found regardless of media type parameters.
+func TestContentType(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		input       string
+		expected    string
+	}{
+		{ContentTypePlain, "Cats **purr** <b>softly</b>.", "Cats **purr** <b>softly</b>."},
+		{ContentTypeHTML, "<p>Cats <b>purr</b> softly &amp; sleep.</p><script>x();</script>", "Cats purr softly & sleep."},
+		{ContentTypeMarkdown, "Cats **purr** [softly](https://example.com).", "Cats purr softly."},
+		{"text/html; charset=utf-8", "<div>Cats purr.</div>", "Cats purr."},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			chunks, err := Segment(tc.input, Options{MaxTokens: 100, ContentType: tc.contentType})
+			if err != nil {
+				t.Fatalf("Segment() error: %v", err)
+			}
+			if len(chunks) != 1 || chunks[0].Text != tc.expected {
+				t.Errorf("Expected a single chunk %q, got %+v", tc.expected, chunks)
+			}
+		})
+	}
+
+	RegisterPreprocessor("Text/X-Shout", strings.ToUpper)
+	defer RegisterPreprocessor("text/x-shout", nil)
+	chunks, err := Segment("Cats purr.", Options{MaxTokens: 100, ContentType: "text/x-shout; charset=utf-8"})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) != 1 || chunks[0].Text != "CATS PURR." {
+		t.Errorf("Expected the registered preprocessor to run, got %+v", chunks)
+	}
+
+	if _, err := Segment("Cats purr.", Options{MaxTokens: 100, ContentType: "application/pdf"}); err == nil {
+		t.Error("Expected an error for a content type without a preprocessor")
+	}
+}
+
+// TestSegmentContextPartialResults cancels the context while the fifth sentence is being
+// embedded. A single worker embeds sentences in order, so exactly the first four completed.
+func TestSegmentContextPartialResults(t *testing.T) {
+	t.Setenv(OllamaMaxWorkersEnvVar, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	embed := keywordEmbedding("ocean", "market")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if strings.Contains(req.Prompt, "late") {
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ollamaResponse{Embedding: embed(req.Prompt)})
+	}))
+	defer srv.Close()
+	t.Setenv("CHUNKER_OLLAMA_URL", srv.URL)
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "fake-model")
+
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose. " +
+		"This news is late. More late news."
+	chunks, err := SegmentContext(ctx, text, Options{MaxTokens: 100, PartialResultsOnCancel: true})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{
+		"The ocean is deep. The ocean is blue.",
+		"The market fell. The market rose.",
+	})
+
+	// The handler cancels whichever context cancel refers to.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	chunks, err = SegmentContext(ctx, text, Options{MaxTokens: 100})
+	if !errors.Is(err, context.Canceled) || chunks != nil {
+		t.Errorf("Expected no chunks and context.Canceled without partial results, got %v, %v", chunks, err)
+	}
+}
+
+// TestEmbeddingLimiter runs concurrent Segment calls, each with four workers, and checks
+// that the shared limiter (from Options or the environment) caps the requests in flight.
+func TestEmbeddingLimiter(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	embed := keywordEmbedding("ocean", "market")
+	newFakeOllama(t, func(prompt string) []float64 {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return embed(prompt)
+	})
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose. The ocean is calm."
+
+	run := func(opts Options) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := Segment(text, opts); err != nil {
+					t.Errorf("Segment() returned an error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	run(Options{MaxTokens: 100, EmbeddingLimiter: NewEmbeddingLimiter(2)})
+	if got := maxInFlight.Load(); got < 1 || got > 2 {
+		t.Errorf("Expected at most 2 requests in flight with the Options limiter, got %d", got)
+	}
+
+	maxInFlight.Store(0)
+	t.Setenv(OllamaGlobalMaxWorkersEnvVar, "3")
+	run(Options{MaxTokens: 100})
+	if got := maxInFlight.Load(); got < 1 || got > 3 {
+		t.Errorf("Expected at most 3 requests in flight with the environment limit, got %d", got)
+	}
+}
+
+// TestQuoteAwareSplitting checks that the option keeps dialogue in one sentence.
+func TestQuoteAwareSplitting(t *testing.T) {
+	text := `She said, "Go home. Now." and left. The door closed.`
+	chunks, err := Segment(text, Options{MaxTokens: 100, QuoteAwareSplitting: true})
+	if err != nil {
+		t.Fatalf("Segment() returned an error: %v", err)
+	}
+	expected := []string{`She said, "Go home. Now." and left.`, "The door closed."}
+	var sentences []string
+	for _, c := range chunks {
+		sentences = append(sentences, c.Sentences...)
+	}
+	if !reflect.DeepEqual(sentences, expected) {
+		t.Errorf("Expected sentences %q, got %q", expected, sentences)
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, QuoteAwareSplitting: true, QuotePairs: []string{`"`}}); err == nil {
+		t.Error("Expected an error for a one-character quote pair")
+	}
+}
+
+// TestSplitNumberDotCapital checks that the option splits an unspaced numbered list that
+// the default rules keep as one sentence.
+func TestSplitNumberDotCapital(t *testing.T) {
+	text := "1.Open the lid 2.Pour the water 3.Wait"
+	sentencesFor := func(opts Options) []string {
+		chunks, err := Segment(text, opts)
+		if err != nil {
+			t.Fatalf("Segment() returned an error: %v", err)
+		}
+		var sentences []string
+		for _, c := range chunks {
+			sentences = append(sentences, c.Sentences...)
+		}
+		return sentences
+	}
+
+	if got := sentencesFor(Options{MaxTokens: 100}); len(got) != 1 {
+		t.Errorf("Expected one sentence by default, got %q", got)
+	}
+	expected := []string{"1.Open the lid", "2.Pour the water", "3.Wait"}
+	if got := sentencesFor(Options{MaxTokens: 100, SplitNumberDotCapital: true}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected sentences %q, got %q", expected, got)
+	}
+}
+
+// TestAbbreviations checks that a custom abbreviation no longer causes a false sentence
+// split, while the default rules still split after it.
+func TestAbbreviations(t *testing.T) {
+	text := "The results are shown in Fig. 3 of the appendix. See Eq. 2 for the derivation."
+	sentencesFor := func(opts Options) []string {
+		setDefaultOptions(&opts)
+		_, sentences, _ := prepareSentences(context.Background(), segmentInput{text: text}, opts)
+		return sentences
+	}
+	opts := Options{MaxTokens: 100, Language: "english"}
+	if got := len(sentencesFor(opts)); got != 4 {
+		t.Fatalf("Expected the default rules to split after Fig. and Eq., got %d sentences", got)
+	}
+
+	opts.Abbreviations = []string{"Fig.", "Eq."}
+	expected := []string{"The results are shown in Fig 3 of the appendix.", "See Eq 2 for the derivation."}
+	if got := sentencesFor(opts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected sentences %q, got %q", expected, got)
+	}
+}
+
+// TestOllamaMaxWorkers checks the worker count precedence: Options, then the environment
+// variable, then DefaultOllamaWorkers, always capped at the number of jobs.
+func TestOllamaMaxWorkers(t *testing.T) {
+	workers := func(opts Options, numJobs int) int {
+		return ollamaWorkerCount(callContext(context.Background(), opts), numJobs)
+	}
+
+	t.Setenv(OllamaMaxWorkersEnvVar, "")
+	if got := workers(Options{}, 100); got != DefaultOllamaWorkers {
+		t.Errorf("Expected the default of %d workers, got %d", DefaultOllamaWorkers, got)
+	}
+	t.Setenv(OllamaMaxWorkersEnvVar, "2")
+	if got := workers(Options{}, 100); got != 2 {
+		t.Errorf("Expected 2 workers from the environment, got %d", got)
+	}
+	if got := workers(Options{OllamaMaxWorkers: 7}, 100); got != 7 {
+		t.Errorf("Expected Options to win with 7 workers, got %d", got)
+	}
+	if got := workers(Options{OllamaMaxWorkers: 7}, 3); got != 3 {
+		t.Errorf("Expected the worker count to be capped at 3 jobs, got %d", got)
+	}
+	if _, err := Segment("Mars is red.", Options{MaxTokens: 10, OllamaMaxWorkers: -1}); err == nil {
+		t.Error("Expected an error for a negative OllamaMaxWorkers")
+	}
+}
+
+// TestOllamaBatchDeadline checks that a slow provider is cut off once the batch deadline
+// passes instead of running every remaining request.
+func TestOllamaBatchDeadline(t *testing.T) {
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	sentences := make([]string, 20)
+	for i := range sentences {
+		sentences[i] = fmt.Sprintf("Sentence number %d is here.", i)
+	}
+	var calls atomic.Int64
+	opts := Options{
+		MaxTokens:           100,
+		OllamaMaxWorkers:    1,
+		OllamaBatchDeadline: 30 * time.Millisecond,
+		EmbeddingProvider: EmbeddingProviderFunc(func(ctx context.Context, _ string) ([]float64, error) {
+			calls.Add(1)
+			select {
+			case <-time.After(20 * time.Millisecond):
+				return []float64{1, 0}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}),
+	}
+
+	_, err := Segment(strings.Join(sentences, " "), opts)
+	if !errors.Is(err, ErrBatchDeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrBatchDeadlineExceeded, got %v", err)
+	}
+	if calls.Load() >= int64(len(sentences)/2) {
+		t.Errorf("Expected the remaining requests to be canceled, got %d provider calls", calls.Load())
+	}
+
+	opts.OllamaBatchDeadline = time.Minute
+	if _, err := Segment(strings.Join(sentences[:3], " "), opts); err != nil {
+		t.Errorf("Expected a batch within its deadline to succeed, got %v", err)
+	}
+}
+
+// TestStopWordWeight compares cohesion on short, stopword-heavy sentences: removing
+// stopwords leaves empty vectors that score zero everywhere, while down-weighting them keeps
+// the related sentences connected and the topic change as the lowest score.
+func TestStopWordWeight(t *testing.T) {
+	sentences := []string{"It is what it is.", "It was not over.", "Then it was over for them.", "Solar panels convert sunlight."}
+	text := strings.Join(sentences, " ")
+	scoresFor := func(weight float64) []float64 {
+		opts := Options{MaxTokens: 100, StopWordWeight: weight}
+		setDefaultOptions(&opts)
+		scores, _, _, err := segmentWithTFIDF(text, sentences, nil, opts, "english", nil)
+		if err != nil {
+			t.Fatalf("segmentWithTFIDF() error: %v", err)
+		}
+		return scores
+	}
+
+	removed := scoresFor(0)
+	if removed[0] != 0 || removed[1] != 0 {
+		t.Errorf("Expected zero cohesion with stopwords removed, got %v", removed)
+	}
+	weighted := scoresFor(0.3)
+	if weighted[0] <= 0 || weighted[1] <= 0 || weighted[2] >= weighted[0] || weighted[2] >= weighted[1] {
+		t.Errorf("Expected positive cohesion within the topic and the lowest score at the topic change, got %v", weighted)
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, StopWordWeight: 1.5}); err == nil {
+		t.Error("Expected an error for StopWordWeight > 1")
+	}
+}
+
+// TestOnProgress checks that progress is reported in increasing order up to the sentence
+// count, for TF-IDF vectors, direct embeddings, and cached embeddings with near-duplicates.
+func TestOnProgress(t *testing.T) {
+	sentences := []string{
+		"The ocean is deep.", "Waves cross the ocean.", "Read our privacy policy.",
+		"The market fell.", "Read our privacy policy.", "Traders left the market.",
+	}
+	text := strings.Join(sentences, " ")
+	check := func(name string, opts Options) {
+		t.Helper()
+		var calls [][2]int
+		opts.MaxTokens = 100
+		opts.OnProgress = func(done, total int) { calls = append(calls, [2]int{done, total}) }
+		if _, err := Segment(text, opts); err != nil {
+			t.Fatalf("%s: Segment() error: %v", name, err)
+		}
+		if len(calls) == 0 || calls[len(calls)-1] != [2]int{len(sentences), len(sentences)} {
+			t.Fatalf("%s: expected progress to end at %d/%d, got %v", name, len(sentences), len(sentences), calls)
+		}
+		for i := 1; i < len(calls); i++ {
+			if calls[i][0] <= calls[i-1][0] {
+				t.Fatalf("%s: expected increasing progress, got %v", name, calls)
+			}
+		}
+	}
+
+	check("tfidf", Options{})
+
+	newFakeOllama(t, keywordEmbedding("ocean", "market"))
+	check("dense", Options{})
+	cache := NewInMemoryCache()
+	defer cache.Close()
+	cached := Options{EmbeddingCacheMode: CacheModeForce, EmbeddingCache: cache, IntraBatchDedupThreshold: 0.99}
+	check("cache misses", cached)
+	check("cache hits", cached)
+}
+
+// TestDenseMinSplitSimilarity checks that the dense threshold applies in Ollama mode and
+// MinSplitSimilarity on the TF-IDF path.
+func TestDenseMinSplitSimilarity(t *testing.T) {
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose."
+	opts := Options{MaxTokens: 100, MinSplitSimilarity: 0.5, DenseMinSplitSimilarity: 0.9}
+	thresholds := func() []float64 {
+		_, details, err := SegmentWithDetails(text, opts)
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		var values []float64
+		for _, gap := range details.Gaps {
+			values = append(values, gap.Threshold)
+		}
+		return values
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	if got := thresholds(); !reflect.DeepEqual(got, []float64{0.5, 0.5, 0.5}) {
+		t.Errorf("Expected MinSplitSimilarity on the TF-IDF path, got thresholds %v", got)
+	}
+
+	// Related and unrelated sentences score 1 and 0.8, as dense cosines cluster high.
+	newFakeOllama(t, func(prompt string) []float64 {
+		if strings.Contains(prompt, "ocean") {
+			return []float64{1, 0.5}
+		}
+		return []float64{0.5, 1}
+	})
+	if got := thresholds(); !reflect.DeepEqual(got, []float64{0.9, 0.9, 0.9}) {
+		t.Errorf("Expected DenseMinSplitSimilarity in Ollama mode, got thresholds %v", got)
+	}
+	chunks, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	assertChunkTexts(t, chunks, []string{"The ocean is deep. The ocean is blue.", "The market fell. The market rose."})
+}
+
+// TestMinDenseCohesionVariance checks that a provider returning the same vector for every
+// sentence, whose cohesion scores are all 1, triggers the low-quality warning, while
+// embeddings that separate the topics do not.
+func TestMinDenseCohesionVariance(t *testing.T) {
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose."
+	warnings := func(embed func(string) []float64) (*Details, error) {
+		opts := Options{
+			MaxTokens:                100,
+			MinDenseCohesionVariance: 1e-4,
+			EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+				return embed(text), nil
+			}),
+		}
+		_, details, err := SegmentWithDetails(text, opts)
+		return details, err
+	}
+
+	details, err := warnings(func(string) []float64 { return []float64{0.3, 0.7, 0.1} })
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if details.CohesionVariance > 1e-9 || !reflect.DeepEqual(details.Warnings, []string{WarningLowCohesionVariance}) {
+		t.Errorf("Expected a low cohesion variance warning for constant vectors, got variance %v and warnings %v", details.CohesionVariance, details.Warnings)
+	}
+
+	details, err = warnings(keywordEmbedding("ocean", "market"))
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if details.CohesionVariance < 1e-4 || details.Warnings != nil {
+		t.Errorf("Expected no warning for separated topics, got variance %v and warnings %v", details.CohesionVariance, details.Warnings)
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, MinDenseCohesionVariance: -1}); err == nil {
+		t.Error("Expected an error for a negative MinDenseCohesionVariance")
+	}
+}
+
+// TestSentenceEmbeddings checks that every sentence of a dense run is reported with the
+// embedding the provider returned for it, and that the TF-IDF path reports none.
+func TestSentenceEmbeddings(t *testing.T) {
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose."
+	sentences := []string{"The ocean is deep.", "The ocean is blue.", "The market fell.", "The market rose."}
+	embed := func(text string) []float64 { return []float64{float64(len(text)), 1} }
+	opts := Options{MaxTokens: 100, EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+		return embed(text), nil
+	})}
+	_, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if len(details.SentenceEmbeddings) != len(sentences) {
+		t.Fatalf("Expected %d sentence embeddings, got %+v", len(sentences), details.SentenceEmbeddings)
+	}
+	bySentence := details.EmbeddingsBySentence()
+	for i, sentence := range sentences {
+		if got := details.SentenceEmbeddings[i]; got.Sentence != sentence || !reflect.DeepEqual(got.Embedding, embed(sentence)) {
+			t.Errorf("Entry %d: expected %q with %v, got %+v", i, sentence, embed(sentence), got)
+		}
+		if !reflect.DeepEqual(bySentence[sentence], embed(sentence)) {
+			t.Errorf("Expected %q to map to %v, got %v", sentence, embed(sentence), bySentence[sentence])
+		}
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	_, details, err = SegmentWithDetails(text, Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if details.SentenceEmbeddings != nil {
+		t.Errorf("Expected no sentence embeddings on the TF-IDF path, got %d", len(details.SentenceEmbeddings))
+	}
+}
+
+// TestDimensionMismatchPolicy uses a provider returning a short vector for one sentence,
+// the first time only or always, under each policy, on the batch and the streaming path.
+func TestDimensionMismatchPolicy(t *testing.T) {
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose."
+	embed := keywordEmbedding("ocean", "market")
+	testCases := []struct {
+		policy    string
+		shortOnce bool
+		wantErr   bool
+		want      []float64 // embedding reported for "The market fell."
+	}{
+		{"", true, true, nil},
+		{DimensionMismatchError, true, true, nil},
+		{DimensionMismatchSkip, false, false, embed("The ocean is blue.")},
+		{DimensionMismatchRetry, true, false, embed("The market fell.")},
+		{DimensionMismatchRetry, false, true, nil},
+	}
+	for _, streamed := range []bool{false, true} {
+		for _, tc := range testCases {
+			var shortCalls atomic.Int32
+			opts := Options{
+				MaxTokens:               100,
+				DimensionMismatchPolicy: tc.policy,
+				EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+					if text == "The market fell." && (!tc.shortOnce || shortCalls.Add(1) == 1) {
+						return []float64{1}, nil
+					}
+					return embed(text), nil
+				}),
+			}
+			if streamed {
+				opts.OnCohesionScore = func(int, float64) {}
+			}
+			_, details, err := SegmentWithDetails(text, opts)
+			name := fmt.Sprintf("policy %q (short once: %v, streamed: %v)", tc.policy, tc.shortOnce, streamed)
+			if tc.wantErr {
+				if !errors.Is(err, ErrDimensionMismatch) {
+					t.Errorf("%s: expected ErrDimensionMismatch, got %v", name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: SegmentWithDetails() error: %v", name, err)
+			}
+			if got := details.SentenceEmbeddings[2].Embedding; !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s: expected the embedding %v, got %v", name, tc.want, got)
+			}
+		}
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, DimensionMismatchPolicy: "pad"}); err == nil {
+		t.Error("Expected an error for an unknown DimensionMismatchPolicy")
+	}
+}
+
+// TestSegmentMulti checks that each threshold yields the same chunks as Segment while the
+// sentences are embedded only once for all thresholds.
+func TestSegmentMulti(t *testing.T) {
+	var requests atomic.Int32
+	embed := keywordEmbedding("ocean", "market")
+	newFakeOllama(t, func(prompt string) []float64 {
+		requests.Add(1)
+		return embed(prompt)
+	})
+
+	text := "The ocean is deep. The ocean is blue. The market fell. The market rose. The ocean is calm. The ocean is wide."
+	opts := Options{MaxTokens: 100}
+	thresholds := []float64{0.1, 1}
+	multi, err := SegmentMulti(text, opts, thresholds)
+	if err != nil {
+		t.Fatalf("SegmentMulti() returned an error: %v", err)
+	}
+	if got := requests.Load(); got != 6 {
+		t.Errorf("Expected 6 embedding requests for 6 sentences, got %d", got)
+	}
+	if len(multi[0.1]) != 3 || len(multi[1]) != 1 {
+		t.Errorf("Expected 3 fine and 1 coarse chunk, got %d and %d", len(multi[0.1]), len(multi[1]))
+	}
+
+	for _, threshold := range thresholds {
+		opts.DepthThreshold = threshold
+		chunks, err := Segment(text, opts)
+		if err != nil {
+			t.Fatalf("Segment() returned an error: %v", err)
+		}
+		if !reflect.DeepEqual(multi[threshold], chunks) {
+			t.Errorf("Threshold %v: expected %+v, got %+v", threshold, chunks, multi[threshold])
+		}
+	}
+
+	if _, err := SegmentMulti(text, Options{MaxTokens: 100, BoundaryPercentile: 20}, thresholds); err == nil {
+		t.Error("Expected an error when combined with BoundaryPercentile")
+	}
+}
+
+// TestThresholdSweep checks that chunk counts grow with the threshold and match Segment.
+func TestThresholdSweep(t *testing.T) {
+	text := "The ocean is deep and blue. Ocean waves crash on the shore. The stock market fell sharply. " +
+		"Market traders sold their shares. The ocean tide is rising. Cats purr on the warm sofa. Cats sleep all day."
+	opts := Options{MaxTokens: 100}
+	thresholds := []float64{0.05, 0.1, 0.2, 0.4, 0.6, 0.8, 1}
+	results, err := ThresholdSweep(text, opts, thresholds)
+	if err != nil {
+		t.Fatalf("ThresholdSweep() returned an error: %v", err)
+	}
+	if len(results) != len(thresholds) {
+		t.Fatalf("Expected %d results, got %d", len(thresholds), len(results))
+	}
+	for i, r := range results {
+		if r.Threshold != thresholds[i] {
+			t.Errorf("Result %d: expected threshold %v, got %v", i, thresholds[i], r.Threshold)
+		}
+		if i > 0 && r.NumChunks < results[i-1].NumChunks {
+			t.Errorf("Chunk count fell from %d to %d as the threshold rose to %v", results[i-1].NumChunks, r.NumChunks, r.Threshold)
+		}
+		opts.MinSplitSimilarity = r.Threshold
+		chunks, err := Segment(text, opts)
+		if err != nil {
+			t.Fatalf("Segment() returned an error: %v", err)
+		}
+		total := 0
+		for _, c := range chunks {
+			total += c.NumTokens
+		}
+		if r.NumChunks != len(chunks) || math.Abs(r.AverageChunkTokens*float64(len(chunks))-float64(total)) > 1e-9 {
+			t.Errorf("Threshold %v: expected %d chunks of %d tokens, got %+v", r.Threshold, len(chunks), total, r)
+		}
+	}
+	if results[0].NumChunks >= results[len(results)-1].NumChunks {
+		t.Errorf("Expected the sweep to span different chunk counts, got %+v", results)
+	}
+
+	if _, err := ThresholdSweep(text, Options{MaxTokens: 100}, []float64{0}); err == nil {
+		t.Error("Expected an error for a zero threshold")
+	}
+}
+
+// TestTopicReference checks that on-topic runs are isolated from off-topic ones.
+// A DepthThreshold of 1 disables cohesion-based boundaries so only the topic signal remains.
+func TestTopicReference(t *testing.T) {
+	text := "The ocean covers most of the planet. Waves crash on the ocean shore. " +
+		"The stock market fell sharply today. Investors sold shares in panic. " +
+		"Ocean currents carry warm water north."
+	expected := []string{
+		"The ocean covers most of the planet. Waves crash on the ocean shore.",
+		"The stock market fell sharply today. Investors sold shares in panic.",
+		"Ocean currents carry warm water north.",
+	}
+
+	t.Run("TF-IDF reference text", func(t *testing.T) {
+		chunks, err := Segment(text, Options{
+			MaxTokens:      100,
+			DepthThreshold: 1,
+			TopicReference: &TopicReference{Text: "ocean waves and currents"},
+		})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		assertChunkTexts(t, chunks, expected)
+	})
+
+	t.Run("Dense reference vector", func(t *testing.T) {
+		newFakeOllama(t, keywordEmbedding("ocean", "market", "shares"))
+		chunks, err := Segment(text, Options{
+			MaxTokens:      100,
+			DepthThreshold: 1,
+			TopicReference: &TopicReference{Vector: []float64{1, 0, 0, 0}, Threshold: 0.5},
+		})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		assertChunkTexts(t, chunks, expected)
+	})
+
+	t.Run("Vector without dense backend", func(t *testing.T) {
+		t.Setenv("CHUNKER_OLLAMA_URL", "")
+		_, err := Segment(text, Options{MaxTokens: 100, TopicReference: &TopicReference{Vector: []float64{1}}})
+		if err == nil {
+			t.Fatalf("Expected an error for a vector-only reference on the TF-IDF path")
+		}
+	})
+
+	t.Run("Empty reference", func(t *testing.T) {
+		_, err := Segment(text, Options{MaxTokens: 100, TopicReference: &TopicReference{}})
+		if err == nil {
+			t.Fatalf("Expected a validation error for an empty reference")
+		}
+	})
+}
+
+// TestSegmentWithDetails verifies that a split forced by the token limit is labeled
+// distinctly from a semantic one, and that unsplit gaps carry their score decision.
+func TestSegmentWithDetails(t *testing.T) {
+	doc := "Cats purr loudly. Cats sleep often. Cats eat fish. Stocks fell sharply. Stocks rose later."
+	opts := Options{MaxTokens: 6, MinSplitSimilarity: 0.05}
+	chunks, details, err := SegmentWithDetails(doc, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	plain, err := Segment(doc, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if !reflect.DeepEqual(chunks, plain) {
+		t.Fatalf("Expected the same chunks as Segment, got %+v vs %+v", chunks, plain)
+	}
+
+	expected := []struct {
+		split    bool
+		reason   string
+		semantic bool
+	}{
+		{false, SplitReasonNone, false},
+		{true, SplitReasonTokenLimit, false},
+		{true, SplitReasonSemantic, true},
+		{false, SplitReasonNone, false},
+	}
+	if len(details.Gaps) != len(expected) {
+		t.Fatalf("Expected %d gaps, got %d", len(expected), len(details.Gaps))
+	}
+	for i, gap := range details.Gaps {
+		e := expected[i]
+		if gap.Index != i || gap.Split != e.split || gap.SplitReason != e.reason || gap.SemanticBoundary != e.semantic {
+			t.Fatalf("Gap %d: expected split=%v reason=%q semantic=%v, got %+v", i, e.split, e.reason, e.semantic, gap)
+		}
+		if gap.Method != BoundaryMethodThreshold || gap.Threshold != 0.05 {
+			t.Fatalf("Gap %d: expected threshold method at 0.05, got %+v", i, gap)
+		}
+	}
+
+	t.Run("Depth method and oversized sentences", func(t *testing.T) {
+		doc := "Cats purr. This sentence is far too long for the tiny token limit. Cats sleep."
+		_, details, err := SegmentWithDetails(doc, Options{MaxTokens: 4})
+		if err != nil {
+			t.Fatalf("SegmentWithDetails() error: %v", err)
+		}
+		for i, gap := range details.Gaps {
+			if gap.Method != BoundaryMethodDepth || gap.SplitReason != SplitReasonOversizedSentence {
+				t.Fatalf("Gap %d: expected an oversized-sentence split under the depth method, got %+v", i, gap)
+			}
+		}
+	})
+}
+
+// TestDetailsDetectedLanguage verifies that the language actually used is reported for
+// explicit, auto-detected and per-sentence configurations.
+func TestDetailsDetectedLanguage(t *testing.T) {
+	german := "Der Hund schläft im Garten. Die Katze sitzt auf dem Dach und schaut nach unten."
+	bylined := "Publié par la rédaction de notre journal dans la section des nouvelles de la semaine et de la vie. " +
+		"The council met on Monday. It approved the new budget for the city. The mayor said it was a good day for all of us."
+	testCases := []struct {
+		name     string
+		text     string
+		opts     Options
+		expected string
+	}{
+		{"auto-detected", german, Options{MaxTokens: 50}, "german"},
+		{"single sentence", "Der Hund schläft im Garten und die Katze auch.", Options{MaxTokens: 50}, "german"},
+		{"explicit", german, Options{MaxTokens: 50, Language: "english"}, "english"},
+		{"per sentence", german, Options{MaxTokens: 50, LanguageDetectionMode: LangDetectModePerSentence}, ""},
+		// A French byline outweighs the short English sentences unless it is skipped.
+		{"byline not skipped", bylined, Options{MaxTokens: 100, LanguageDetectionMode: LangDetectModeFirstTenSentences}, "unknown"},
+		{"byline skipped", bylined, Options{MaxTokens: 100, LanguageDetectionMode: LangDetectModeFirstTenSentences, LanguageDetectionSkipFirst: 1}, "english"},
+		{"byline skipped in full text", bylined, Options{MaxTokens: 100, LanguageDetectionMode: LangDetectModeFullText, LanguageDetectionSkipFirst: 1}, "english"},
+		{"skip exceeds sentences", german, Options{MaxTokens: 50, LanguageDetectionMode: LangDetectModeFullText, LanguageDetectionSkipFirst: 5}, "german"},
+		// German and Dutch share "die", "in" and "der": a tie unless the candidates are narrowed.
+		{"tie without candidates", "Die Hand in der Nacht.", Options{MaxTokens: 50}, "unknown"},
+		{"tie resolved by candidates", "Die Hand in der Nacht.", Options{MaxTokens: 50, CandidateLanguages: []string{"english", "german"}}, "german"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, details, err := SegmentWithDetails(tc.text, tc.opts)
+			if err != nil {
+				t.Fatalf("SegmentWithDetails() error: %v", err)
+			}
+			if details.DetectedLanguage != tc.expected {
+				t.Fatalf("Expected detected language %q, got %q", tc.expected, details.DetectedLanguage)
+			}
+		})
+	}
+	if _, err := Segment(german, Options{MaxTokens: 50, CandidateLanguages: []string{"klingon"}}); err == nil {
+		t.Error("Expected an error for an unsupported language in CandidateLanguages")
+	}
+}
+
+// TestUnknownLanguageSentences checks that sentences per-sentence detection cannot assign a
+// language are reported, and that nothing is reported in document-level modes.
+func TestUnknownLanguageSentences(t *testing.T) {
+	text := "The cat is sleeping on the mat with its kitten. Xyzzy plugh 42. " +
+		"Le chat dort sur le tapis avec son chaton. Qwerty zxcvb. The dog is in the garden with the cat."
+	opts := Options{MaxTokens: 100, LanguageDetectionMode: LangDetectModePerSentence}
+	_, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if expected := []int{1, 3}; !reflect.DeepEqual(details.UnknownLanguageSentences, expected) {
+		t.Errorf("Expected unknown sentences %v, got %v", expected, details.UnknownLanguageSentences)
+	}
+
+	opts.LanguageDetectionMode = LangDetectModeFirstSentence
+	_, details, err = SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if details.UnknownLanguageSentences != nil {
+		t.Errorf("Expected no unknown sentences outside per-sentence mode, got %v", details.UnknownLanguageSentences)
+	}
+}
+
+// TestChunkCohesion compares a single-topic chunk with a chunk cut only by MaxTokens across
+// a topic change, and checks that a single-sentence chunk has a cohesion of 1.
+func TestChunkCohesion(t *testing.T) {
+	text := "Cats purr on the warm sofa. Cats sleep on the warm sofa all day. Cats nap on the warm sofa. " +
+		"Stock markets fell sharply today. Stock markets rose again on Friday. Stock markets closed mixed."
+
+	topical, err := Segment(text, Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(topical) != 2 || len(topical[0].Sentences) != 3 {
+		t.Fatalf("Expected one chunk per topic, got %+v", topical)
+	}
+
+	// No semantic boundary can clear this depth, so only the token limit cuts the text.
+	forced, err := Segment(text, Options{MaxTokens: 30, DepthThreshold: 10})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(forced[0].Sentences) < 4 {
+		t.Fatalf("Expected the first forced chunk to span both topics, got %+v", forced[0])
+	}
+	if topical[0].Cohesion <= forced[0].Cohesion {
+		t.Errorf("Expected the single-topic chunk to be more cohesive, got %.3f vs %.3f", topical[0].Cohesion, forced[0].Cohesion)
+	}
+
+	single, err := Segment("Cats purr.", Options{MaxTokens: 100})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if single[0].Cohesion != 1 {
+		t.Errorf("Expected a single-sentence chunk to have a cohesion of 1, got %v", single[0].Cohesion)
+	}
+}
+
+// TestSeparateTerminalPunctuation checks that the stripped sentences and punctuation
+// reconstruct the attached sentences, and that ChunkRanges still covers the marks.
+func TestSeparateTerminalPunctuation(t *testing.T) {
+	text := `Cats purr softly. Do cats sleep all day?! He said "they do." Cats nap on the sofa`
+	opts := Options{MaxTokens: 100}
+	attached, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	opts.SeparateTerminalPunctuation = true
+	separated, err := Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+
+	if len(separated) != len(attached) {
+		t.Fatalf("Expected %d chunks, got %d", len(attached), len(separated))
+	}
+	var punctuation []string
+	for i, chunk := range separated {
+		if chunk.Text != attached[i].Text {
+			t.Errorf("Expected chunk %d to keep its text %q, got %q", i, attached[i].Text, chunk.Text)
+		}
+		if len(chunk.SentencePunctuation) != len(chunk.Sentences) {
+			t.Fatalf("Expected one punctuation entry per sentence, got %+v", chunk)
+		}
+		for j, sentence := range chunk.Sentences {
+			if restored := sentence + chunk.SentencePunctuation[j]; restored != attached[i].Sentences[j] {
+				t.Errorf("Expected %q restored, got %q", attached[i].Sentences[j], restored)
+			}
+		}
+		punctuation = append(punctuation, chunk.SentencePunctuation...)
+	}
+	if expected := []string{".", "?!", `."`, ""}; !reflect.DeepEqual(punctuation, expected) {
+		t.Errorf("Expected punctuation %q, got %q", expected, punctuation)
+	}
+
+	// One sentence per chunk, so every range must end with its sentence's punctuation.
+	opts.MaxTokens = 5
+	separated, err = Segment(text, opts)
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	ranges, err := ChunkRanges(text, separated)
+	if err != nil {
+		t.Fatalf("ChunkRanges() error: %v", err)
+	}
+	for i, chunk := range separated {
+		if source := text[ranges[i][0]:ranges[i][1]]; source != chunk.Sentences[0]+chunk.SentencePunctuation[0] {
+			t.Errorf("Range %d selects %q, want %q", i, source, chunk.Sentences[0]+chunk.SentencePunctuation[0])
+		}
+	}
+}
+
+// TestCompareMethods uses a fake provider that embeds "garden" like "market", so the dense
+// side sees one topic where TF-IDF sees two, and checks the boundaries and agreement.
+func TestCompareMethods(t *testing.T) {
+	embed := keywordEmbedding("ocean", "market")
+	opts := Options{
+		MaxTokens: 100,
+		EmbeddingProvider: EmbeddingProviderFunc(func(_ context.Context, text string) ([]float64, error) {
+			return embed(strings.ReplaceAll(text, "Garden", "market")), nil
+		}),
+	}
+	oceanMarket := "The ocean waves crash. The ocean tides turn. The ocean currents flow. " +
+		"The market prices fell. The market traders sold. The market index dropped. "
+
+	testCases := []struct {
+		name              string
+		text              string
+		expectedTFIDF     []int
+		expectedDense     []int
+		expectedAgreement float64
+	}{
+		{"Agreeing", oceanMarket, []int{2}, []int{2}, 1},
+		{"Disagreeing", oceanMarket + "Garden prices fell. Garden traders sold. Garden index dropped.", []int{2, 5}, []int{2}, 0.5},
+		{"No boundaries", "The ocean waves crash.", nil, nil, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tfidfBoundaries, denseBoundaries, agreement, err := CompareMethods(tc.text, opts)
+			if err != nil {
+				t.Fatalf("CompareMethods() error: %v", err)
+			}
+			if !reflect.DeepEqual(tfidfBoundaries, tc.expectedTFIDF) || !reflect.DeepEqual(denseBoundaries, tc.expectedDense) {
+				t.Errorf("Expected boundaries %v (TF-IDF) and %v (dense), got %v and %v", tc.expectedTFIDF, tc.expectedDense, tfidfBoundaries, denseBoundaries)
+			}
+			if agreement != tc.expectedAgreement {
+				t.Errorf("Expected an agreement of %v, got %v", tc.expectedAgreement, agreement)
+			}
+		})
+	}
+
+	t.Setenv("CHUNKER_OLLAMA_URL", "")
+	t.Setenv("CHUNKER_OLLAMA_MODEL", "")
+	if _, _, _, err := CompareMethods(oceanMarket, Options{MaxTokens: 100}); !errors.Is(err, ErrNoEmbeddingBackend) {
+		t.Errorf("Expected ErrNoEmbeddingBackend without a backend, got %v", err)
+	}
+}
+
+// TestFormatChunks checks the exact rendering of a fixed chunk set with the default and a
+// custom delimiter, and that segmenting and formatting a text twice gives the same output.
+func TestFormatChunks(t *testing.T) {
+	chunks := []Chunk{
+		{Sentences: []string{"Cats purr softly.", "Cats sleep all day."}, NumTokens: 7, SentenceIndices: []int{0, 1}, Cohesion: 0.8714},
+		{Text: "Stocks fell.", NumTokens: 2, SentenceIndices: []int{2}, Cohesion: 1},
+		{Sentences: []string{"Bonds rose"}, SentencePunctuation: []string{"!"}, NumTokens: 2, SentenceIndices: []int{3}, Cohesion: 1},
+	}
+	testCases := []struct {
+		name     string
+		opts     FormatOptions
+		expected string
+	}{
+		{"Default", FormatOptions{}, "--- chunk ---\nCats purr softly.\nCats sleep all day.\n--- chunk ---\nStocks fell.\n--- chunk ---\nBonds rose!\n"},
+		{"Stats", FormatOptions{Delimiter: "####", Stats: true}, "#### tokens=7 sentences=2 cohesion=0.871\nCats purr softly.\nCats sleep all day.\n" +
+			"#### tokens=2 sentences=1 cohesion=1.000\nStocks fell.\n#### tokens=2 sentences=1 cohesion=1.000\nBonds rose!\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := FormatChunks(chunks, tc.opts); got != tc.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+	if got := FormatChunks(nil, FormatOptions{}); got != "" {
+		t.Errorf("Expected no output for no chunks, got %q", got)
+	}
+
+	text := "Cats purr softly. Cats sleep all day. Stock markets fell sharply. Stock markets closed mixed."
+	format := func() string {
+		chunks, err := Segment(text, Options{MaxTokens: 100})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		return FormatChunks(chunks, FormatOptions{Stats: true})
+	}
+	if first, second := format(), format(); first != second {
+		t.Errorf("Expected deterministic output, got:\n%s\nand:\n%s", first, second)
+	}
+}
+
+// TestSplitOnScriptChange embeds every sentence alike, so cohesion never drops, and checks
+// that a Latin to Cyrillic transition still forces a boundary, ignoring a letterless
+// sentence in between.
+func TestSplitOnScriptChange(t *testing.T) {
+	text := "The report covers sales. The report covers profit. 2024: 42%! Отчёт охватывает продажи. Отчёт охватывает прибыль."
+	opts := Options{
+		MaxTokens:             100,
+		LanguageDetectionMode: LangDetectModePerSentence,
+		EmbeddingProvider: EmbeddingProviderFunc(func(context.Context, string) ([]float64, error) {
+			return []float64{1, 0}, nil
+		}),
+	}
+	if chunks, err := Segment(text, opts); err != nil || len(chunks) != 1 {
+		t.Fatalf("Expected a single chunk without SplitOnScriptChange, got %d chunks and error %v", len(chunks), err)
+	}
+
+	opts.SplitOnScriptChange = true
+	chunks, details, err := SegmentWithDetails(text, opts)
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if len(chunks) != 2 || chunks[1].Sentences[0] != "Отчёт охватывает продажи." {
+		t.Fatalf("Expected a boundary before the Cyrillic text, got %+v", chunks)
+	}
+	if gap := details.Gaps[2]; !gap.ScriptBoundary || gap.SplitReason != SplitReasonScriptChange {
+		t.Errorf("Expected a script change split at gap 2, got %+v", gap)
+	}
+}
+
+// TestSingleChunkReason checks the reason reported for each way a text ends up in a single
+// chunk, and that none is reported for several chunks.
+func TestSingleChunkReason(t *testing.T) {
+	doc := "Cats purr softly. Cats sleep all day. Cats chase mice."
+	testCases := []struct {
+		name     string
+		text     string
+		opts     Options
+		expected string
+	}{
+		{"One sentence", "Cats purr softly.", Options{MaxTokens: 100}, SingleChunkReasonOneSentence},
+		{"Oversized sentence", "Cats purr softly.", Options{MaxTokens: 2}, SingleChunkReasonOneSentence},
+		{"No boundary", doc, Options{MaxTokens: 100}, SingleChunkReasonNoBoundary},
+		{"Slack", doc, Options{MaxTokens: 8, MaxTokensSlack: 5}, SingleChunkReasonMaxTokensSlack},
+		{"Several chunks", doc, Options{MaxTokens: 5}, SingleChunkReasonNone},
+		{"Empty text", "", Options{MaxTokens: 100}, SingleChunkReasonNone},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, details, err := SegmentWithDetails(tc.text, tc.opts)
+			if err != nil {
+				t.Fatalf("SegmentWithDetails() error: %v", err)
+			}
+			if details.SingleChunkReason != tc.expected {
+				t.Errorf("Expected reason %q, got %q", tc.expected, details.SingleChunkReason)
+			}
+		})
+	}
+}
+
+// TestDistinguishingTerms checks that the boundary of a two-topic document reports the
+// terms repeated on each side of it, and that other gaps report none. Block comparison
+// makes the sides the two topics rather than the adjacent sentences.
+func TestDistinguishingTerms(t *testing.T) {
+	text := "Cats purr softly. Cats chase mice. The stock market fell. Stock prices dropped."
+	_, details, err := SegmentWithDetails(text, Options{MaxTokens: 100, Language: "english", BlockComparisonSize: 2, BoundaryTermCount: 3})
+	if err != nil {
+		t.Fatalf("SegmentWithDetails() error: %v", err)
+	}
+	if len(details.Gaps) != 3 || !details.Gaps[1].SemanticBoundary {
+		t.Fatalf("Expected a semantic boundary between the topics, got %+v", details.Gaps)
+	}
+	for _, i := range []int{0, 2} {
+		if details.Gaps[i].DistinguishingTerms != nil {
+			t.Errorf("Gap %d is no boundary but reports terms %v", i, details.Gaps[i].DistinguishingTerms)
+		}
+	}
+	terms := details.Gaps[1].DistinguishingTerms
+	if len(terms) != 3 {
+		t.Fatalf("Expected 3 terms, got %v", terms)
+	}
+	sides := map[string]string{}
+	for _, term := range terms[:2] {
+		switch {
+		case term.Left > 0 && term.Right == 0:
+			sides[term.Term] = "left"
+		case term.Right > 0 && term.Left == 0:
+			sides[term.Term] = "right"
+		}
+	}
+	if !reflect.DeepEqual(sides, map[string]string{"cat": "left", "stock": "right"}) {
+		t.Errorf("Expected cat (left) and stock (right) to lead, got %+v", terms)
+	}
+
+	if _, err := Segment(text, Options{MaxTokens: 100, BoundaryTermCount: -1}); err == nil {
+		t.Error("Expected an error for a negative BoundaryTermCount")
+	}
+}
+
+// TestTerminalPunctuationRuns checks that runs of terminal punctuation end one sentence
+// through the whole pipeline, where abbreviation normalization masks ellipses first.
+func TestTerminalPunctuationRuns(t *testing.T) {
+	text := "Really?! Let's go. Wait... I think so. He said e.g... yes . . . Fine!"
+	expected := []string{"Really?!", "Let's go.", "Wait...", "I think so.", "He said e.g...", "yes . . .", "Fine!"}
+	chunks, err := Segment(text, Options{MaxTokens: 100, Language: "english"})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	var sentences []string
+	for _, chunk := range chunks {
+		sentences = append(sentences, chunk.Sentences...)
+	}
+	if !reflect.DeepEqual(sentences, expected) {
+		t.Errorf("Expected sentences %q, got %q", expected, sentences)
+	}
+}
+
+func TestChunkRanges(t *testing.T) {
+	input := "  The U.S.A. economy grew.\n\nThe U.S.A. exports rose, e.g. cars.   Cats purr softly.\tCats sleep all day...."
+	chunks, err := Segment(input, Options{MaxTokens: 6})
+	if err != nil {
+		t.Fatalf("Segment() error: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+	ranges, err := ChunkRanges(input, chunks)
+	if err != nil {
+		t.Fatalf("ChunkRanges() error: %v", err)
+	}
+	if len(ranges) != len(chunks) {
+		t.Fatalf("Expected %d ranges, got %d", len(chunks), len(ranges))
+	}
+	squash := func(s string) string {
+		return strings.Join(strings.Fields(strings.ReplaceAll(s, ".", "")), "")
+	}
+	prev := 0
+	for i, r := range ranges {
+		if r[0] < prev || r[1] < r[0] || r[1] > len(input) {
+			t.Fatalf("Range %d out of order: %v", i, r)
+		}
+		if strings.TrimSpace(strings.ReplaceAll(input[prev:r[0]], ".", "")) != "" {
+			t.Errorf("Text %q before range %d is not covered", input[prev:r[0]], i)
+		}
+		if got, want := squash(input[r[0]:r[1]]), squash(chunks[i].Text); got != want {
+			t.Errorf("Range %d holds %q, want the source of %q", i, input[r[0]:r[1]], chunks[i].Text)
+		}
+		prev = r[1]
+	}
+	if rest := strings.TrimSpace(input[prev:]); rest != "" {
+		t.Errorf("Trailing text %q is not covered", rest)
+	}
+	if !strings.HasPrefix(input[ranges[0][0]:], "The U.S.A.") {
+		t.Errorf("First range should start at the submitted text, got %q", input[ranges[0][0]:ranges[0][1]])
+	}
+
+	t.Run("Rewritten text", func(t *testing.T) {
+		html := "<p>Cats purr softly.</p><p>Cats sleep all day.</p>"
+		chunks, err := Segment(html, Options{MaxTokens: 100, ContentType: ContentTypeHTML})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+		if _, err := ChunkRanges(html, chunks); !errors.Is(err, ErrRangesUnavailable) {
+			t.Errorf("Expected ErrRangesUnavailable, got %v", err)
+		}
+	})
+}
+
+// recordingTracer is a Tracer that records finished spans with their parent span names.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	parent string
+	attrs  map[string]any
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordedSpan{tracer: r, name: name, attrs: map[string]any{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func (r *recordingTracer) find(name string) *recordedSpan {
+	for _, s := range r.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// TestTracer verifies the span tree and attributes for a dense run with a semantic cache.
+func TestTracer(t *testing.T) {
+	newFakeOllama(t, keywordEmbedding("ocean", "market"))
+	text := "The ocean is deep. Ocean waves are high. The market fell. Market shares dropped."
+	cache := NewInMemoryCache()
+	defer cache.Close()
+
+	for run, expectedHitRatio := range []float64{0, 1} {
+		tracer := &recordingTracer{}
+		_, err := Segment(text, Options{MaxTokens: 100, EmbeddingCacheMode: CacheModeForce, EmbeddingCache: cache, Tracer: tracer})
+		if err != nil {
+			t.Fatalf("Segment() error: %v", err)
+		}
+
+		expectedParents := map[string]string{
+			SpanSegment:        "",
+			SpanSplitSentences: SpanSegment,
+			SpanVectorize:      SpanSegment,
+			SpanCacheLookup:    SpanVectorize,
+			SpanBuildChunks:    SpanSegment,
+		}
+		if run == 0 {
+			expectedParents[SpanEmbeddingBatch] = SpanVectorize
+		}
+		for name, parent := range expectedParents {
+			span := tracer.find(name)
+			if span == nil {
+				t.Fatalf("Run %d: missing span %s", run, name)
+			}
+			if span.parent != parent {
+				t.Fatalf("Run %d: span %s has parent %q, expected %q", run, name, span.parent, parent)
+			}
+		}
+
+		if got := tracer.find(SpanSegment).attrs[AttrSentenceCount]; got != 4 {
+			t.Fatalf("Run %d: expected sentence count 4, got %v", run, got)
+		}
+		if got := tracer.find(SpanCacheLookup).attrs[AttrCacheHitRatio]; got != expectedHitRatio {
+			t.Fatalf("Run %d: expected cache hit ratio %v, got %v", run, expectedHitRatio, got)
+		}
+		if run == 0 {
+			batch := tracer.find(SpanEmbeddingBatch)
+			if _, ok := batch.attrs[AttrEmbeddingLatencyMs].(float64); !ok || batch.attrs[AttrBatchSize] != 4 {
+				t.Fatalf("Run %d: unexpected embedding batch attributes %v", run, batch.attrs)
+			}
+		}
+	}
+}
+
+func assertChunkTexts(t *testing.T, chunks []Chunk, expected []string) {
+	t.Helper()
+	if len(chunks) != len(expected) {
+		t.Fatalf("Expected %d chunks, got %d: %+v", len(expected), len(chunks), chunks)
+	}
+	for i, ch := range chunks {
+		if ch.Text != expected[i] {
+			t.Errorf("Chunk %d: expected %q, got %q", i, expected[i], ch.Text)
+		}
+	}
+}
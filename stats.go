@@ -0,0 +1,84 @@
+package semseg
+
+import (
+	"context"
+
+	"github.com/cmsdko/semseg/internal/lang"
+)
+
+// DocumentStats summarizes the structure of a document, returned by AnalyzeDocument.
+type DocumentStats struct {
+	// NumSentences is the number of sentences the text was split into.
+	NumSentences int
+
+	// NumTopics is the number of topical segments: the semantic (and TopicReference)
+	// boundaries plus one. Splits forced by MaxTokens are not counted. It is 0 for an empty
+	// text.
+	NumTopics int
+
+	// AverageCohesion and CohesionVariance are the mean and population variance of the
+	// cohesion scores of all sentence gaps. A low average suggests a loosely connected text,
+	// a high variance one with distinct topics. Both are 0 for texts with fewer than two
+	// sentences.
+	AverageCohesion  float64
+	CohesionVariance float64
+
+	// DetectedLanguage is the document language, as in Details.DetectedLanguage.
+	DetectedLanguage string
+
+	// LanguageDistribution counts the sentences detected in each language (LangUnknown
+	// included) in per-sentence detection mode. It is nil in the other modes and when
+	// Options.Language is set.
+	LanguageDistribution map[string]int
+}
+
+// AnalyzeDocument computes document-level statistics with the same sentence splitting,
+// vectorization and boundary detection as Segment, without building chunks. It is useful to
+// classify content or to decide whether a document is worth chunking at all.
+func AnalyzeDocument(text string, opts Options) (DocumentStats, error) {
+	if err := validateOptions(opts); err != nil {
+		return DocumentStats{}, err
+	}
+	setDefaultOptions(&opts)
+	in := segmentInput{text: text}
+	if err := checkInputSize(in, opts); err != nil {
+		return DocumentStats{}, err
+	}
+
+	ctx, span := startSpan(callContext(context.Background(), opts), SpanSegment)
+	defer span.End()
+
+	doc, err := scoreDocument(ctx, in, opts)
+	if err != nil {
+		return DocumentStats{}, err
+	}
+	span.SetAttributes(Attribute{Key: AttrSentenceCount, Value: len(doc.sentences)})
+
+	stats := DocumentStats{NumSentences: len(doc.sentences), DetectedLanguage: doc.language}
+	if len(doc.sentences) > 0 {
+		stats.NumTopics = 1
+		for _, boundary := range doc.boundaries(opts, nil) {
+			if boundary {
+				stats.NumTopics++
+			}
+		}
+	}
+	if len(doc.scores) > 0 {
+		for _, score := range doc.scores {
+			stats.AverageCohesion += score
+		}
+		stats.AverageCohesion /= float64(len(doc.scores))
+		for _, score := range doc.scores {
+			d := score - stats.AverageCohesion
+			stats.CohesionVariance += d * d
+		}
+		stats.CohesionVariance /= float64(len(doc.scores))
+	}
+	if opts.LanguageDetectionMode == LangDetectModePerSentence && opts.Language == "" {
+		stats.LanguageDistribution = make(map[string]int)
+		for _, s := range doc.sentences {
+			stats.LanguageDistribution[lang.DetectLanguageAmong(s, opts.CandidateLanguages)]++
+		}
+	}
+	return stats, nil
+}
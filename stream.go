@@ -0,0 +1,270 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// segmentWithOllamaBudget is the dense path used when EmbeddingMemoryBudget is set. It embeds
+// the first sentence to learn the model's dimension and estimates the memory needed for all
+// vectors. Within budget, it behaves like the regular batch path; over budget, it streams.
+func segmentWithOllamaBudget(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([]float64, []float64, error) {
+	first, err := runOllamaWorkers(ctx, []ollamaJob{{index: 0, sentence: sentences[0]}}, provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+	}
+	if len(first) == 0 {
+		return nil, nil, errors.New("failed to get ollama embeddings: no embedding returned")
+	}
+	firstVector := first[0].embedding
+	dims := &streamedDimensions{ctx: ctx, sentences: sentences, provider: provider, policy: opts.DimensionMismatchPolicy, previous: firstVector}
+
+	var refVector []float64
+	if opts.TopicReference != nil {
+		refVector, err = topicReferenceDense(ctx, opts.TopicReference, provider)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to embed topic reference: %w", err)
+		}
+	}
+
+	estimate := len(sentences) * len(firstVector) * 8
+	if estimate <= opts.EmbeddingMemoryBudget && opts.OnCohesionScore == nil {
+		rest, err := getOllamaEmbeddingsDirect(ctx, sentences[1:], provider)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+		}
+		vectors := append([][]float64{firstVector}, rest...)
+		if err := resolveDimensions(ctx, sentences, vectors, provider, opts); err != nil {
+			return nil, nil, err
+		}
+		var topicSims []float64
+		if refVector != nil {
+			topicSims = topicSimilaritiesDense(vectors, refVector)
+		}
+		return calculateCohesionDense(vectors, opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts)), topicSims, nil
+	}
+
+	cohesion := newStreamingCohesion(len(sentences), opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts))
+	cohesion.onScore = opts.OnCohesionScore
+	var topicSims []float64
+	cohesion.add(firstVector)
+	if refVector != nil {
+		topicSims = append(make([]float64, 0, len(sentences)), cosineSimilarityDense(firstVector, refVector))
+	}
+	emit := func(index int, embedding []float64) error {
+		embedding, err := dims.resolve(index, embedding)
+		if err != nil {
+			return err
+		}
+		cohesion.add(embedding)
+		if refVector != nil {
+			topicSims = append(topicSims, cosineSimilarityDense(embedding, refVector))
+		}
+		return nil
+	}
+	if err := streamOllamaEmbeddings(ctx, sentences, 1, provider, emit); err != nil {
+		return nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+	}
+	return cohesion.finish(), topicSims, nil
+}
+
+// segmentWithOllamaStreaming is the dense path used when OnCohesionScore is set: embeddings
+// are consumed in sentence order as they arrive, and every gap is scored and reported as
+// soon as its windows are complete. All embeddings are kept, as on the batch path.
+func segmentWithOllamaStreaming(ctx context.Context, sentences []string, provider EmbeddingProvider, opts Options) ([]float64, []float64, [][]float64, error) {
+	vectors := make([][]float64, len(sentences))
+	cohesion := newStreamingCohesion(len(sentences), opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts))
+	cohesion.onScore = opts.OnCohesionScore
+	dims := &streamedDimensions{ctx: ctx, sentences: sentences, provider: provider, policy: opts.DimensionMismatchPolicy}
+	err := streamOllamaEmbeddings(ctx, sentences, 0, provider, func(index int, embedding []float64) error {
+		embedding, err := dims.resolve(index, embedding)
+		if err != nil {
+			return err
+		}
+		vectors[index] = embedding
+		cohesion.add(embedding)
+		return nil
+	})
+	if err != nil {
+		if opts.PartialResultsOnCancel && ctx.Err() != nil {
+			prefix := completedPrefix(vectors)
+			return calculateCohesionDense(vectors[:prefix], opts.BlockComparisonSize, opts.MaxLookback, opts.RecencyDecay, decayResetThreshold(opts)), nil, vectors[:prefix], ctx.Err()
+		}
+		return nil, nil, nil, fmt.Errorf("failed to get ollama embeddings: %w", err)
+	}
+
+	var topicSims []float64
+	if opts.TopicReference != nil {
+		refVector, err := topicReferenceDense(ctx, opts.TopicReference, provider)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to embed topic reference: %w", err)
+		}
+		topicSims = topicSimilaritiesDense(vectors, refVector)
+	}
+	return cohesion.finish(), topicSims, vectors, nil
+}
+
+// streamingCohesion computes the same scores as calculateCohesionDense from vectors that
+// arrive one at a time, in order. It only retains the vectors still needed by an unscored
+// gap: the previous one for adjacent comparison, up to 2×blockSize for block comparison, or
+// up to lookback+1 when looking back. With decay, the recency-weighted centroid of the
+// vectors before the next one is kept instead, restarted after a gap below resetBelow.
+type streamingCohesion struct {
+	n          int // total number of vectors
+	blockSize  int
+	lookback   int
+	decay      float64
+	resetBelow float64
+	centroid   []float64   // decayCentroid of the vectors added before the last one
+	buf        [][]float64 // vectors bufStart, bufStart+1, ...
+	bufStart   int
+	scores     []float64
+	onScore    func(gap int, score float64) // called for every new score, if set
+}
+
+func newStreamingCohesion(n, blockSize, lookback int, decay, resetBelow float64) *streamingCohesion {
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	if lookback < 1 {
+		lookback = 1
+	}
+	return &streamingCohesion{n: n, blockSize: blockSize, lookback: lookback, decay: decay, resetBelow: resetBelow, scores: make([]float64, 0, n-1)}
+}
+
+// add appends the next vector and scores every gap whose right window is now complete.
+func (c *streamingCohesion) add(vector []float64) {
+	if c.decay > 0 && len(c.buf) > 0 {
+		c.centroid = decayCentroid(c.centroid, c.buf[len(c.buf)-1], c.decay)
+	}
+	c.buf = append(c.buf, vector)
+	if gap := c.bufStart + len(c.buf) - 1 - c.blockSize; gap >= 0 {
+		c.scoreGap(gap)
+		// The next gap's left window starts one vector later.
+		if drop := gap + 2 - max(c.blockSize, c.lookback) - c.bufStart; drop > 0 {
+			c.buf = append(c.buf[:0:0], c.buf[drop:]...)
+			c.bufStart += drop
+		}
+	}
+}
+
+// finish scores the trailing gaps, whose right windows are truncated by the end of the text.
+func (c *streamingCohesion) finish() []float64 {
+	for gap := len(c.scores); gap < c.n-1; gap++ {
+		c.scoreGap(gap)
+	}
+	return c.scores
+}
+
+func (c *streamingCohesion) scoreGap(gap int) {
+	c.computeGap(gap)
+	if c.onScore != nil {
+		c.onScore(gap, c.scores[gap])
+	}
+}
+
+func (c *streamingCohesion) computeGap(gap int) {
+	if c.decay > 0 {
+		c.scores = append(c.scores, cosineSimilarityDense(c.centroid, c.buf[gap+1-c.bufStart]))
+		if c.scores[gap] < c.resetBelow {
+			c.centroid = nil
+		}
+		return
+	}
+	if c.lookback > 1 {
+		next := c.buf[gap+1-c.bufStart]
+		score := cosineSimilarityDense(c.buf[gap-c.bufStart], next)
+		for j := lookbackStart(gap, c.lookback); j < gap; j++ {
+			score = math.Max(score, cosineSimilarityDense(c.buf[j-c.bufStart], next))
+		}
+		c.scores = append(c.scores, score)
+		return
+	}
+	left, right := blockWindows(gap, c.n, c.blockSize)
+	c.scores = append(c.scores, cosineSimilarityDense(
+		sumDense(c.buf[left-c.bufStart:gap+1-c.bufStart]),
+		sumDense(c.buf[gap+1-c.bufStart:right-c.bufStart]),
+	))
+}
+
+// streamOllamaEmbeddings fetches embeddings for sentences[from:] and passes them to emit
+// strictly in sentence order. Jobs are dispatched within a sliding window of twice the worker
+// count past the next index to emit, so the number of embeddings held (in flight, buffered or
+// waiting for an earlier sentence) stays bounded regardless of the number of sentences.
+// emit is always called from the calling goroutine; an error it returns stops the stream and
+// is returned.
+func streamOllamaEmbeddings(ctx context.Context, sentences []string, from int, provider EmbeddingProvider, emit func(index int, embedding []float64) error) error {
+	numJobs := len(sentences) - from
+	if numJobs <= 0 {
+		return nil
+	}
+	_, span := startSpan(ctx, SpanEmbeddingBatch)
+	defer span.End()
+	start := time.Now()
+	defer func() {
+		span.SetAttributes(Attribute{Key: AttrBatchSize, Value: numJobs}, latencyAttr(start))
+	}()
+
+	ctx, cancel, batchErr := withBatchDeadline(ctx)
+	defer cancel()
+	numWorkers := ollamaWorkerCount(ctx, numJobs)
+	window := 2 * numWorkers
+
+	// Both channels can hold a full window, so neither the dispatcher nor the workers
+	// ever block on a send.
+	jobs := make(chan ollamaJob, window)
+	results := make(chan ollamaResult, window)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go ollamaWorker(ctx, &wg, provider, jobs, results)
+	}
+
+	var err error
+	progress := progressFrom(ctx)
+	pending := make(map[int][]float64, window)
+	next, sent := from, from
+	for next < len(sentences) {
+		for sent < len(sentences) && sent-next < window {
+			jobs <- ollamaJob{index: sent, sentence: sentences[sent]}
+			sent++
+		}
+
+		result := <-results
+		if result.err != nil {
+			err = result.err // Fail fast
+			break
+		}
+		pending[result.index] = result.embedding
+		for {
+			embedding, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err = emit(next, embedding); err != nil {
+				break
+			}
+			progress.add(1)
+			next++
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	close(jobs)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for range results {
+		// Drain so workers finishing buffered jobs can exit.
+	}
+	return batchErr(err)
+}
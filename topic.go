@@ -0,0 +1,76 @@
+package semseg
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cmsdko/semseg/internal/tfidf"
+)
+
+// defaultTopicThreshold is used when TopicReference.Threshold is left at zero.
+const defaultTopicThreshold = 0.1
+
+// TopicReference describes a reference topic (for example, a search query) used for
+// topic-focused segmentation. Each sentence is compared with the reference; a sentence
+// whose similarity is at least Threshold is "on-topic". A boundary is placed at every gap
+// where the text switches between on-topic and off-topic sentences, isolating on-topic runs.
+//
+// Either Text or Vector must be provided.
+type TopicReference struct {
+	// Text is vectorized with the same method as the sentences: TF-IDF (using the document's
+	// corpus and preprocessing) or the configured dense embedding model.
+	Text string
+
+	// Vector is a precomputed dense embedding of the topic. It can only be used on the dense
+	// (Ollama) path, where it takes precedence over Text.
+	Vector []float64
+
+	// Threshold (range 0.0 to 1.0) is the minimal similarity for a sentence to be considered
+	// on-topic. Dense embeddings usually need a noticeably higher value than TF-IDF. Default: 0.1.
+	Threshold float64
+}
+
+// topicReferenceDense returns the dense reference vector, embedding ref.Text if no
+// precomputed Vector was supplied.
+func topicReferenceDense(ctx context.Context, ref *TopicReference, provider EmbeddingProvider) ([]float64, error) {
+	if len(ref.Vector) > 0 {
+		return ref.Vector, nil
+	}
+
+	results, err := runOllamaWorkers(withoutProgress(ctx), []ollamaJob{{index: 0, sentence: ref.Text}}, provider)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no embedding returned for topic reference")
+	}
+	return results[0].embedding, nil
+}
+
+// topicSimilaritiesSparse returns the similarity of each TF-IDF sentence vector to the reference.
+func topicSimilaritiesSparse(vectors []map[string]float64, ref map[string]float64) []float64 {
+	sims := make([]float64, len(vectors))
+	for i, v := range vectors {
+		sims[i] = tfidf.CosineSimilarity(v, ref)
+	}
+	return sims
+}
+
+// topicSimilaritiesDense returns the similarity of each dense sentence vector to the reference.
+func topicSimilaritiesDense(vectors [][]float64, ref []float64) []float64 {
+	sims := make([]float64, len(vectors))
+	for i, v := range vectors {
+		sims[i] = cosineSimilarityDense(v, ref)
+	}
+	return sims
+}
+
+// addTopicBoundaries marks a boundary at every gap where consecutive sentences fall on
+// different sides of the topic threshold (entering or leaving the topic).
+func addTopicBoundaries(boundaries map[int]bool, sims []float64, threshold float64) {
+	for i := 0; i < len(sims)-1; i++ {
+		if (sims[i] >= threshold) != (sims[i+1] >= threshold) {
+			boundaries[i] = true
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package semseg
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts spans around the phases of a segmentation run (sentence splitting,
+// vectorization, embedding batches, cache lookups and chunk building). It mirrors the shape
+// of an OpenTelemetry tracer, so an adapter is a few lines, while keeping this package free
+// of tracing dependencies. Set Options.Tracer to enable it; tracing is a no-op when unset.
+type Tracer interface {
+	// Start begins a span named name as a child of any span in ctx and returns a context
+	// carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	End()
+}
+
+// Attribute is a key/value pair attached to a span. Value is an int, float64, string or bool.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span names used by the segmenter.
+const (
+	SpanSegment        = "semseg.Segment"
+	SpanSplitSentences = "semseg.SplitSentences"
+	SpanVectorize      = "semseg.Vectorize"
+	SpanEmbeddingBatch = "semseg.EmbeddingBatch"
+	SpanCacheLookup    = "semseg.CacheLookup"
+	SpanBuildChunks    = "semseg.BuildChunks"
+)
+
+// Span attribute keys used by the segmenter.
+const (
+	AttrSentenceCount      = "semseg.sentence_count"
+	AttrChunkCount         = "semseg.chunk_count"
+	AttrMethod             = "semseg.method"
+	AttrBatchSize          = "semseg.embedding.batch_size"
+	AttrEmbeddingLatencyMs = "semseg.embedding.latency_ms"
+	AttrCacheLookups       = "semseg.cache.lookups"
+	AttrCacheHits          = "semseg.cache.hits"
+	AttrCacheHitRatio      = "semseg.cache.hit_ratio"
+	AttrCacheErrors        = "semseg.cache.errors"
+)
+
+type tracerKey struct{}
+
+// withTracer returns a context from which startSpan creates spans with t (nil disables tracing).
+func withTracer(ctx context.Context, t Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// startSpan starts a span with the tracer carried by ctx, or a no-op span if there is none.
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	t, ok := ctx.Value(tracerKey{}).(Tracer)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+// latencyAttr reports the time elapsed since start in milliseconds.
+func latencyAttr(start time.Time) Attribute {
+	return Attribute{Key: AttrEmbeddingLatencyMs, Value: float64(time.Since(start).Microseconds()) / 1000}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) End()                       {}